@@ -0,0 +1,651 @@
+package betalinkauth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// LoginMethodAdmin is the login method of the sessions opened by an
+// operator for a user, without its credentials
+const LoginMethodAdmin = "ADMIN"
+
+const (
+	// LoginHistorySize is the number of login events listed in the
+	// details of a user
+	LoginHistorySize = 50
+	// PasswordResetLifetime is how long a user forced to reset its
+	// password has to choose a new one
+	PasswordResetLifetime = 24 * time.Hour
+)
+
+// recoveryTokenSize is the size in bytes of the password recovery
+// tokens
+const recoveryTokenSize = 32
+
+const (
+	// DefaultUsersPageSize is the number of users listed per page when
+	// none is requested
+	DefaultUsersPageSize = 50
+	// MaxUsersPageSize is the maximum number of users listed per page
+	MaxUsersPageSize = 500
+)
+
+// UserAccountData is the account of a user along with its login data
+type UserAccountData struct {
+	UserID    string `json:"user_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	// Email is empty for the users logging in with an external provider
+	// only, along with EmailVerified and HashAlgorithm
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	// HashAlgorithm tells which credential backend verifies the password
+	// of the user
+	HashAlgorithm string    `json:"hash_algorithm"`
+	CreatedAt     time.Time `json:"created_at"`
+	// DisabledAt is when the user was disabled, nil if it is enabled
+	DisabledAt *time.Time `json:"disabled_at"`
+	// Roles are only set on the account of a single user
+	Roles []string `json:"roles,omitempty"`
+}
+
+// UserPage is a page of the users, the oldest first
+type UserPage struct {
+	Users []UserAccountData `json:"users"`
+	// NextCursor selects the next page, empty on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// UserQuery selects a page of the users
+type UserQuery struct {
+	// Search, if any, selects the users whose email or full name
+	// contains it, ignoring the case
+	Search string
+	// Role, if any, selects the users having the role
+	Role string
+	// Disabled, if any, selects the disabled or the enabled users
+	Disabled *bool
+	// Cursor is the NextCursor of the previous page, empty for the
+	// first page
+	Cursor string
+	// Limit is the number of users of the page, DefaultUsersPageSize
+	// when zero
+	Limit int32
+}
+
+// UserDetailsData is the account of a user along with its external
+// identities, active sessions and most recent login attempts
+type UserDetailsData struct {
+	UserAccountData
+	ExternalIdentities []ExternalIdentityData `json:"external_identities"`
+	Sessions           []SessionData          `json:"sessions"`
+	// LoginHistory is the last LoginHistorySize login attempts of the
+	// user, the most recent first
+	LoginHistory []LoginEventData `json:"login_history"`
+}
+
+// LoginEventData is a login attempt of a user
+type LoginEventData struct {
+	EventID     string    `json:"event_id"`
+	OccurredAt  time.Time `json:"occurred_at"`
+	LoginMethod string    `json:"login_method"`
+	Succeeded   bool      `json:"succeeded"`
+	// FailureReason tells why the attempt was rejected, empty when it
+	// succeeded
+	FailureReason string `json:"failure_reason,omitempty"`
+	IPAddress     string `json:"ip_address,omitempty"`
+	UserAgent     string `json:"user_agent"`
+}
+
+// PasswordResetData is the recovery token a user forced to reset its
+// password completes the reset with
+type PasswordResetData struct {
+	RecoveryToken string    `json:"recovery_token"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// UserAccountUpdate is the changes to the account of a user, the nil
+// fields are left unchanged
+type UserAccountUpdate struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	// Email changes the email the user logs in with, which is then no
+	// longer verified
+	Email *string `json:"email"`
+}
+
+// SessionData is a session of a user
+type SessionData struct {
+	SessionID         string    `json:"session_id"`
+	CreatedAt         time.Time `json:"created_at"`
+	LastUsedAt        time.Time `json:"last_used_at"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at"`
+	IPAddress         string    `json:"ip_address,omitempty"`
+	UserAgent         string    `json:"user_agent"`
+	Device            string    `json:"device"`
+	LoginMethod       string    `json:"login_method"`
+	RememberMe        bool      `json:"remember_me"`
+	// ClientID is the OAuth client the session was opened through,
+	// empty for the first-party apps
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// ListUsers returns a page of the users, ordered by creation
+func (u *Usecases) ListUsers(ctx context.Context, query UserQuery) (*UserPage, error) {
+	if query.Limit == 0 {
+		query.Limit = DefaultUsersPageSize
+	}
+	if query.Limit < 0 || query.Limit > MaxUsersPageSize {
+		return nil, &ValidationError{
+			Message: fmt.Sprintf("limit must be between 1 and %d", MaxUsersPageSize),
+		}
+	}
+	params := ListUsersParams{PageSize: query.Limit + 1}
+	if query.Search != "" {
+		params.Search = pgtype.Text{String: escapeLike(query.Search), Valid: true}
+	}
+	if query.Role != "" {
+		params.RoleName = pgtype.Text{String: query.Role, Valid: true}
+	}
+	if query.Disabled != nil {
+		params.Disabled = pgtype.Bool{Bool: *query.Disabled, Valid: true}
+	}
+	if query.Cursor != "" {
+		createdAt, userID, err := decodeUserCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		params.AfterCreatedAt = pgtype.Timestamp{Time: createdAt, Valid: true}
+		params.AfterUserID = userID
+	}
+
+	// one more user than requested tells whether there is a next page
+	users, err := u.store.ListUsers(ctx, params)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list users: %w", err).Error(),
+		}
+	}
+	page := &UserPage{Users: make([]UserAccountData, 0, len(users))}
+	if len(users) > int(query.Limit) {
+		users = users[:query.Limit]
+		last := users[len(users)-1]
+		page.NextCursor = encodeUserCursor(last.CreatedAt.Time, last.UserID)
+	}
+	for _, user := range users {
+		page.Users = append(page.Users, userAccountData(GetUserAccountRow(user)))
+	}
+	return page, nil
+}
+
+// likeEscaper escapes the wildcards of the LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike returns a string matching itself in a LIKE pattern
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// encodeUserCursor returns the cursor of the page following a user
+func encodeUserCursor(createdAt time.Time, userID pgtype.UUID) string {
+	cursor := createdAt.UTC().Format(time.RFC3339Nano) + "," + userID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+// decodeUserCursor returns the creation time and ID of the user an
+// encoded cursor follows
+func decodeUserCursor(cursor string) (time.Time, pgtype.UUID, error) {
+	invalidCursor := &ValidationError{Message: "invalid cursor"}
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, pgtype.UUID{}, invalidCursor
+	}
+	rawCreatedAt, rawUserID, ok := strings.Cut(string(decoded), ",")
+	if !ok {
+		return time.Time{}, pgtype.UUID{}, invalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, rawCreatedAt)
+	if err != nil {
+		return time.Time{}, pgtype.UUID{}, invalidCursor
+	}
+	userID, err := uuid.Parse(rawUserID)
+	if err != nil {
+		return time.Time{}, pgtype.UUID{}, invalidCursor
+	}
+	return createdAt, pgtype.UUID{Bytes: userID, Valid: true}, nil
+}
+
+// GetUserAccount returns the account of a user along with its roles
+func (u *Usecases) GetUserAccount(ctx context.Context, userID pgtype.UUID) (*UserAccountData, error) {
+	user, err := u.store.GetUserAccount(ctx, userID)
+	if err != nil {
+		return nil, userNotFound(err)
+	}
+	roles, err := u.store.GetUserRoles(ctx, userID)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not get user roles: %w", err).Error(),
+		}
+	}
+	account := userAccountData(user)
+	account.Roles = roleNames(roles)
+	return &account, nil
+}
+
+// GetUserDetails returns the account of a user along with its roles,
+// external identities, active sessions and login history. The users have no external
+// identity when the usecases run on a store without external logins.
+func (u *Usecases) GetUserDetails(ctx context.Context, userID pgtype.UUID) (*UserDetailsData, error) {
+	account, err := u.GetUserAccount(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := u.ListExternalIdentities(ctx, account.UserID)
+	var unsupportedErr *UnsupportedError
+	if errors.As(err, &unsupportedErr) {
+		identities, err = []ExternalIdentityData{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := u.ListUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	loginHistory, err := u.ListUserLoginEvents(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &UserDetailsData{
+		UserAccountData:    *account,
+		ExternalIdentities: identities,
+		Sessions:           sessions,
+		LoginHistory:       loginHistory,
+	}, nil
+}
+
+// ListUserLoginEvents returns the last LoginHistorySize login attempts
+// of a user, the most recent first
+func (u *Usecases) ListUserLoginEvents(ctx context.Context, userID pgtype.UUID) ([]LoginEventData, error) {
+	if _, err := u.store.GetUserById(ctx, userID); err != nil {
+		return nil, userNotFound(err)
+	}
+	events, err := u.store.ListUserLoginEvents(ctx, ListUserLoginEventsParams{
+		UserID: userID,
+		Limit:  LoginHistorySize,
+	})
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list login events: %w", err).Error(),
+		}
+	}
+	data := make([]LoginEventData, 0, len(events))
+	for _, event := range events {
+		eventData := LoginEventData{
+			EventID:       event.EventID.String(),
+			OccurredAt:    event.OccurredAt.Time,
+			LoginMethod:   event.LoginMethod,
+			Succeeded:     event.Succeeded,
+			FailureReason: event.FailureReason,
+			UserAgent:     event.UserAgent,
+		}
+		if event.IpAddress != nil {
+			eventData.IPAddress = event.IpAddress.String()
+		}
+		data = append(data, eventData)
+	}
+	return data, nil
+}
+
+// UpdateUserAccount changes the names or email of a user, and returns
+// its updated account
+func (u *Usecases) UpdateUserAccount(ctx context.Context, userID pgtype.UUID, update UserAccountUpdate) (*UserAccountData, error) {
+	if update.FirstName != nil && strings.TrimSpace(*update.FirstName) == "" {
+		return nil, &ValidationError{Message: "first name cannot be empty"}
+	}
+	if update.LastName != nil && strings.TrimSpace(*update.LastName) == "" {
+		return nil, &ValidationError{Message: "last name cannot be empty"}
+	}
+	if update.Email != nil {
+		if ok, err := ValidateEmail(*update.Email); !ok {
+			return nil, &ValidationError{
+				Message: fmt.Errorf("could not validate email: %w", err).Error(),
+			}
+		}
+	}
+	user, err := u.store.GetUserAccount(ctx, userID)
+	if err != nil {
+		return nil, userNotFound(err)
+	}
+	if update.Email != nil && !user.Email.Valid {
+		return nil, &ValidationError{Message: "user has no email, it logs in with external providers only"}
+	}
+
+	err = u.store.InTx(ctx, func(store Store) error {
+		if update.FirstName != nil || update.LastName != nil {
+			params := UpdateUserNamesParams{
+				UserID:    userID,
+				FirstName: user.FirstName,
+				LastName:  user.LastName,
+			}
+			if update.FirstName != nil {
+				params.FirstName = *update.FirstName
+			}
+			if update.LastName != nil {
+				params.LastName = *update.LastName
+			}
+			if err := store.UpdateUserNames(ctx, params); err != nil {
+				return fmt.Errorf("could not update names: %w", err)
+			}
+		}
+		if update.Email != nil && *update.Email != user.Email.String {
+			_, err := store.UpdateUserEmail(ctx, UpdateUserEmailParams{
+				UserID: userID,
+				Email:  *update.Email,
+			})
+			if err != nil {
+				return fmt.Errorf("could not update email: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if pgErrorCode(err) == pgUniqueViolation {
+			return nil, &ValidationError{
+				Message: fmt.Sprintf("email [%s] is not available", *update.Email),
+			}
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not update user: %w", err).Error(),
+		}
+	}
+	return u.GetUserAccount(ctx, userID)
+}
+
+// DisableUser disables the account of a user and revokes its sessions,
+// the user can no longer log in until it is enabled again. Disabling a
+// disabled user keeps the time it was first disabled at.
+func (u *Usecases) DisableUser(ctx context.Context, userID pgtype.UUID) error {
+	user, err := u.store.GetUserAccount(ctx, userID)
+	if err != nil {
+		return userNotFound(err)
+	}
+	disabledAt := user.DisabledAt
+	if !disabledAt.Valid {
+		disabledAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	}
+
+	err = u.store.InTx(ctx, func(store Store) error {
+		updated, err := store.SetUserDisabledAt(ctx, SetUserDisabledAtParams{
+			UserID:     userID,
+			DisabledAt: disabledAt,
+		})
+		if err != nil {
+			return fmt.Errorf("could not disable user: %w", err)
+		}
+		if updated == 0 {
+			return pgx.ErrNoRows
+		}
+		if _, err := store.DeleteUserSessions(ctx, userID); err != nil {
+			return fmt.Errorf("could not revoke sessions: %w", err)
+		}
+		return nil
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &NotFoundError{Message: "user not found"}
+	}
+	if err != nil {
+		return &ServerError{Message: err.Error()}
+	}
+	return nil
+}
+
+// EnableUser enables the account of a disabled user, enabling an
+// enabled user does nothing
+func (u *Usecases) EnableUser(ctx context.Context, userID pgtype.UUID) error {
+	updated, err := u.store.SetUserDisabledAt(ctx, SetUserDisabledAtParams{UserID: userID})
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not enable user: %w", err).Error(),
+		}
+	}
+	if updated == 0 {
+		return &NotFoundError{Message: "user not found"}
+	}
+	return nil
+}
+
+// FindUserByEmail returns the ID of the user logging in with an email
+func (u *Usecases) FindUserByEmail(ctx context.Context, email string) (pgtype.UUID, error) {
+	loginData, err := u.store.GetLoginDataByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pgtype.UUID{}, &NotFoundError{Message: fmt.Sprintf("no user with email [%s]", email)}
+		}
+		return pgtype.UUID{}, &ServerError{
+			Message: fmt.Errorf("could not get login data: %w", err).Error(),
+		}
+	}
+	return loginData.UserID, nil
+}
+
+// userAccountData returns the account of a user
+func userAccountData(user GetUserAccountRow) UserAccountData {
+	account := UserAccountData{
+		UserID:        user.UserID.String(),
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Email:         user.Email.String,
+		EmailVerified: user.EmailVerified.Bool,
+		HashAlgorithm: user.Hashalgorithm.String,
+		CreatedAt:     user.CreatedAt.Time,
+	}
+	if user.DisabledAt.Valid {
+		disabledAt := user.DisabledAt.Time
+		account.DisabledAt = &disabledAt
+	}
+	return account
+}
+
+// ForcePasswordReset forces a user to choose a new password: its
+// password is cleared, so that it no longer logs it in, and its sessions
+// are revoked, whoever opened them. The returned recovery token, handed
+// to the user, completes the reset with CompletePasswordReset within
+// PasswordResetLifetime. Forcing a reset again replaces the token. The
+// passwords verified by another backend than bcrypt, e.g. LDAP, cannot
+// be reset.
+func (u *Usecases) ForcePasswordReset(ctx context.Context, userID pgtype.UUID) (*PasswordResetData, error) {
+	user, err := u.store.GetUserAccount(ctx, userID)
+	if err != nil {
+		return nil, userNotFound(err)
+	}
+	if !user.Hashalgorithm.Valid {
+		return nil, &ValidationError{Message: "user has no password, it logs in with external providers only"}
+	}
+	if user.Hashalgorithm.String != HashAlgorithmBcrypt {
+		return nil, &ValidationError{
+			Message: fmt.Sprintf("password of the user is verified by the [%s] backend", user.Hashalgorithm.String),
+		}
+	}
+	recoveryToken, err := randomString(recoveryTokenSize)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not generate password reset token: %w", err).Error(),
+		}
+	}
+
+	now := time.Now()
+	err = u.store.InTx(ctx, func(store Store) error {
+		err := store.UpdateUserPassword(ctx, UpdateUserPasswordParams{
+			UserID:       userID,
+			Passwordhash: "",
+			Passwordsalt: "",
+		})
+		if err != nil {
+			return fmt.Errorf("could not clear password: %w", err)
+		}
+		err = store.UpsertPasswordRecovery(ctx, UpsertPasswordRecoveryParams{
+			UserID:        userID,
+			RecoveryToken: hashSecret(recoveryToken),
+		})
+		if err != nil {
+			return fmt.Errorf("could not store password reset token: %w", err)
+		}
+		if _, err := store.DeleteUserSessions(ctx, userID); err != nil {
+			return fmt.Errorf("could not revoke sessions: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not force password reset: %w", err).Error(),
+		}
+	}
+	return &PasswordResetData{
+		RecoveryToken: recoveryToken,
+		ExpiresAt:     now.Add(PasswordResetLifetime),
+	}, nil
+}
+
+// CompletePasswordReset sets the new password chosen by a user forced
+// to reset it, with the recovery token of the reset. A token is used
+// once.
+func (u *Usecases) CompletePasswordReset(ctx context.Context, recoveryToken, password string) error {
+	if ok, err := ValidatePassword(password); !ok {
+		return &ValidationError{
+			Message: fmt.Errorf("could not validate password: %w", err).Error(),
+		}
+	}
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not hash password: %w", err).Error(),
+		}
+	}
+
+	err = u.store.InTx(ctx, func(store Store) error {
+		userID, err := store.ConsumePasswordRecovery(ctx, ConsumePasswordRecoveryParams{
+			RecoveryToken: hashSecret(recoveryToken),
+			MaxAgeSeconds: int32(PasswordResetLifetime / time.Second),
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return InvalidPasswordResetError
+			}
+			return &ServerError{
+				Message: fmt.Errorf("could not consume password reset token: %w", err).Error(),
+			}
+		}
+		err = store.UpdateUserPassword(ctx, UpdateUserPasswordParams{
+			UserID:       userID,
+			Passwordhash: passwordHash,
+			Passwordsalt: "",
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not update password: %w", err).Error(),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		switch err.(type) {
+		case *ValidationError, *ServerError:
+			return err
+		default:
+			return &ServerError{
+				Message: fmt.Errorf("could not reset password: %w", err).Error(),
+			}
+		}
+	}
+	return nil
+}
+
+// ListUserSessions returns the active sessions of a user, the oldest
+// first
+func (u *Usecases) ListUserSessions(ctx context.Context, userID pgtype.UUID) ([]SessionData, error) {
+	if _, err := u.store.GetUserById(ctx, userID); err != nil {
+		return nil, userNotFound(err)
+	}
+	sessions, err := u.store.ListUserSessions(ctx, userID)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list sessions: %w", err).Error(),
+		}
+	}
+	data := make([]SessionData, 0, len(sessions))
+	for _, session := range sessions {
+		sessionData := SessionData{
+			SessionID:         session.SessionID.String(),
+			CreatedAt:         session.CreatedAt.Time,
+			LastUsedAt:        session.UpdatedAt.Time,
+			ExpiresAt:         session.ExpiresAt.Time,
+			AbsoluteExpiresAt: session.AbsoluteExpiresAt.Time,
+			UserAgent:         session.UserAgent,
+			Device:            session.Device,
+			LoginMethod:       session.LoginMethod,
+			RememberMe:        session.RememberMe,
+			ClientID:          session.ClientID.String,
+		}
+		if session.IpAddress != nil {
+			sessionData.IPAddress = session.IpAddress.String()
+		}
+		data = append(data, sessionData)
+	}
+	return data, nil
+}
+
+// RevokeSession revokes a session, its refresh token and access tokens
+// can no longer be used
+func (u *Usecases) RevokeSession(ctx context.Context, sessionID pgtype.UUID) error {
+	if _, err := u.store.GetSessionById(ctx, sessionID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &NotFoundError{Message: "session not found"}
+		}
+		return &ServerError{
+			Message: fmt.Errorf("could not get session by ID: %w", err).Error(),
+		}
+	}
+	if err := u.store.DeleteSession(ctx, sessionID); err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not delete session: %w", err).Error(),
+		}
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every session of a user, and returns how
+// many were revoked
+func (u *Usecases) RevokeUserSessions(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	if _, err := u.store.GetUserById(ctx, userID); err != nil {
+		return 0, userNotFound(err)
+	}
+	revoked, err := u.store.DeleteUserSessions(ctx, userID)
+	if err != nil {
+		return 0, &ServerError{
+			Message: fmt.Errorf("could not delete sessions: %w", err).Error(),
+		}
+	}
+	return revoked, nil
+}
+
+// IssueUserTokens opens a session for a user without its credentials
+// and issues its tokens, for the operators testing the services relying
+// on the auth service. The session counts towards the session limit of
+// the user.
+func (u *Usecases) IssueUserTokens(ctx context.Context, userID pgtype.UUID, metadata SessionMetadata) (*IDTokens, error) {
+	if _, err := u.store.GetUserById(ctx, userID); err != nil {
+		return nil, userNotFound(err)
+	}
+	params := u.newCreateSessionParams(userID, LoginMethodAdmin, false, metadata)
+	return u.openSession(ctx, params, "")
+}
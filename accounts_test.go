@@ -0,0 +1,282 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"fmt"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsecases_UserAccounts(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+
+	testPassword := "AccountPassword123!"
+	var userIDs []pgtype.UUID
+	for i := range 5 {
+		email := fmt.Sprintf("account.user%d@example.com", i)
+		require.NoError(t, usecases.RegisterUser(testCtx, "Account", fmt.Sprintf("User%d", i), email, testPassword))
+		userID, err := usecases.FindUserByEmail(testCtx, email)
+		require.NoError(t, err)
+		userIDs = append(userIDs, userID)
+	}
+	userID := userIDs[0]
+	testEmail := "account.user0@example.com"
+
+	t.Run("find a user by email", func(t *testing.T) {
+		_, err := usecases.FindUserByEmail(testCtx, "nobody@example.com")
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+	})
+
+	t.Run("list users by page", func(t *testing.T) {
+		var listed []string
+		query := betalinkauth.UserQuery{Limit: 2}
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 3)
+			page, err := usecases.ListUsers(testCtx, query)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page.Users), 2)
+			for _, user := range page.Users {
+				listed = append(listed, user.UserID)
+				require.Equal(t, betalinkauth.HashAlgorithmBcrypt, user.HashAlgorithm)
+				require.Empty(t, user.Roles)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		expected := make([]string, 0, len(userIDs))
+		for _, userID := range userIDs {
+			expected = append(expected, userID.String())
+		}
+		require.ElementsMatch(t, expected, listed)
+	})
+
+	t.Run("list users with an invalid query", func(t *testing.T) {
+		_, err := usecases.ListUsers(testCtx, betalinkauth.UserQuery{Cursor: "not a cursor"})
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		_, err = usecases.ListUsers(testCtx, betalinkauth.UserQuery{Limit: betalinkauth.MaxUsersPageSize + 1})
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+	})
+
+	t.Run("list users with filters", func(t *testing.T) {
+		page, err := usecases.ListUsers(testCtx, betalinkauth.UserQuery{Search: "ACCOUNT.USER1@"})
+		require.NoError(t, err)
+		require.Len(t, page.Users, 1)
+		require.Equal(t, userIDs[1].String(), page.Users[0].UserID)
+
+		page, err = usecases.ListUsers(testCtx, betalinkauth.UserQuery{Search: "account user3"})
+		require.NoError(t, err)
+		require.Len(t, page.Users, 1)
+		require.Equal(t, userIDs[3].String(), page.Users[0].UserID)
+
+		// the wildcards of the search are matched literally
+		page, err = usecases.ListUsers(testCtx, betalinkauth.UserQuery{Search: "account_user"})
+		require.NoError(t, err)
+		require.Empty(t, page.Users)
+
+		require.NoError(t, usecases.AssignRole(testCtx, userIDs[4], betalinkauth.AdminRole))
+		page, err = usecases.ListUsers(testCtx, betalinkauth.UserQuery{Role: betalinkauth.AdminRole})
+		require.NoError(t, err)
+		require.Len(t, page.Users, 1)
+		require.Equal(t, userIDs[4].String(), page.Users[0].UserID)
+
+		disabled := true
+		page, err = usecases.ListUsers(testCtx, betalinkauth.UserQuery{Disabled: &disabled})
+		require.NoError(t, err)
+		require.Empty(t, page.Users)
+		disabled = false
+		page, err = usecases.ListUsers(testCtx, betalinkauth.UserQuery{Disabled: &disabled})
+		require.NoError(t, err)
+		require.Len(t, page.Users, len(userIDs))
+	})
+
+	t.Run("get the account of a user", func(t *testing.T) {
+		account, err := usecases.GetUserAccount(testCtx, userID)
+		require.NoError(t, err)
+		require.Equal(t, userID.String(), account.UserID)
+		require.Equal(t, testEmail, account.Email)
+		require.Equal(t, "User0", account.LastName)
+		require.Equal(t, []string{betalinkauth.DefaultRole}, account.Roles)
+		require.False(t, account.CreatedAt.IsZero())
+
+		_, err = usecases.GetUserAccount(testCtx, pgtype.UUID{Bytes: uuid.New(), Valid: true})
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+	})
+
+	t.Run("list and revoke the sessions of a user", func(t *testing.T) {
+		first, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+		second, err := usecases.LoginUser(testCtx, testEmail, testPassword, true, testSessionMetadata)
+		require.NoError(t, err)
+
+		sessions, err := usecases.ListUserSessions(testCtx, userID)
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		require.Equal(t, betalinkauth.LoginMethodPassword, sessions[0].LoginMethod)
+		require.Equal(t, testSessionMetadata.IPAddress, sessions[0].IPAddress)
+		require.True(t, sessions[1].RememberMe)
+
+		sessionID, err := uuid.Parse(sessions[0].SessionID)
+		require.NoError(t, err)
+		require.NoError(t, usecases.RevokeSession(testCtx, pgtype.UUID{Bytes: sessionID, Valid: true}))
+		_, err = usecases.ValidateAccessToken(testCtx, first.AccessToken)
+		require.Equal(t, betalinkauth.RevokedTokenError, err)
+		err = usecases.RevokeSession(testCtx, pgtype.UUID{Bytes: sessionID, Valid: true})
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+
+		revoked, err := usecases.RevokeUserSessions(testCtx, userID)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, revoked)
+		_, err = usecases.RefreshAccessToken(testCtx, second.RefreshToken)
+		require.Equal(t, betalinkauth.RevokedTokenError, err)
+	})
+
+	t.Run("issue the tokens of a user", func(t *testing.T) {
+		tokens, err := usecases.IssueUserTokens(testCtx, userIDs[1], testSessionMetadata)
+		require.NoError(t, err)
+		userData, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, userIDs[1], userData.UserID)
+
+		sessions, err := usecases.ListUserSessions(testCtx, userIDs[1])
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, betalinkauth.LoginMethodAdmin, sessions[0].LoginMethod)
+	})
+
+	t.Run("force a password reset", func(t *testing.T) {
+		userEmail := "account.user2@example.com"
+		tokens, err := usecases.LoginUser(testCtx, userEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+
+		reset, err := usecases.ForcePasswordReset(testCtx, userIDs[2])
+		require.NoError(t, err)
+		_, err = usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.Equal(t, betalinkauth.RevokedTokenError, err)
+		_, err = usecases.LoginUser(testCtx, userEmail, testPassword, false, testSessionMetadata)
+		require.Equal(t, betalinkauth.PasswordResetRequiredError, err)
+
+		err = usecases.CompletePasswordReset(testCtx, reset.RecoveryToken, "short")
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		newPassword := "ResetPassword456!"
+		require.NoError(t, usecases.CompletePasswordReset(testCtx, reset.RecoveryToken, newPassword))
+		err = usecases.CompletePasswordReset(testCtx, reset.RecoveryToken, newPassword)
+		require.Equal(t, betalinkauth.InvalidPasswordResetError, err)
+		_, err = usecases.LoginUser(testCtx, userEmail, newPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+
+		// a second reset replaces the recovery token
+		first, err := usecases.ForcePasswordReset(testCtx, userIDs[2])
+		require.NoError(t, err)
+		second, err := usecases.ForcePasswordReset(testCtx, userIDs[2])
+		require.NoError(t, err)
+		err = usecases.CompletePasswordReset(testCtx, first.RecoveryToken, newPassword)
+		require.Equal(t, betalinkauth.InvalidPasswordResetError, err)
+		require.NoError(t, usecases.CompletePasswordReset(testCtx, second.RecoveryToken, newPassword))
+	})
+	t.Run("get the details of a user", func(t *testing.T) {
+		_, err := usecases.LoginUser(testCtx, "account.user1@example.com", testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+
+		details, err := usecases.GetUserDetails(testCtx, userIDs[1])
+		require.NoError(t, err)
+		require.Equal(t, userIDs[1].String(), details.UserID)
+		require.Equal(t, []string{betalinkauth.DefaultRole}, details.Roles)
+		require.Empty(t, details.ExternalIdentities)
+		require.NotEmpty(t, details.Sessions)
+		require.NotEmpty(t, details.LoginHistory)
+		require.True(t, details.LoginHistory[0].Succeeded)
+		require.Equal(t, betalinkauth.LoginMethodPassword, details.LoginHistory[0].LoginMethod)
+
+		_, err = usecases.GetUserDetails(testCtx, pgtype.UUID{Bytes: uuid.New(), Valid: true})
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+	})
+
+	t.Run("disable and enable a user", func(t *testing.T) {
+		userEmail := "account.user3@example.com"
+		tokens, err := usecases.LoginUser(testCtx, userEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+
+		require.NoError(t, usecases.DisableUser(testCtx, userIDs[3]))
+		_, err = usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.Equal(t, betalinkauth.RevokedTokenError, err)
+		_, err = usecases.LoginUser(testCtx, userEmail, testPassword, false, testSessionMetadata)
+		require.Equal(t, betalinkauth.AccountDisabledError, err)
+		_, err = usecases.IssueUserTokens(testCtx, userIDs[3], testSessionMetadata)
+		require.Equal(t, betalinkauth.AccountDisabledError, err)
+
+		account, err := usecases.GetUserAccount(testCtx, userIDs[3])
+		require.NoError(t, err)
+		require.NotNil(t, account.DisabledAt)
+		disabled := true
+		page, err := usecases.ListUsers(testCtx, betalinkauth.UserQuery{Disabled: &disabled})
+		require.NoError(t, err)
+		require.Len(t, page.Users, 1)
+		require.Equal(t, userIDs[3].String(), page.Users[0].UserID)
+
+		// disabling again keeps the time the user was disabled at
+		require.NoError(t, usecases.DisableUser(testCtx, userIDs[3]))
+		again, err := usecases.GetUserAccount(testCtx, userIDs[3])
+		require.NoError(t, err)
+		require.True(t, account.DisabledAt.Equal(*again.DisabledAt))
+
+		require.NoError(t, usecases.EnableUser(testCtx, userIDs[3]))
+		account, err = usecases.GetUserAccount(testCtx, userIDs[3])
+		require.NoError(t, err)
+		require.Nil(t, account.DisabledAt)
+		_, err = usecases.LoginUser(testCtx, userEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+
+		unknown := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+		require.IsType(t, &betalinkauth.NotFoundError{}, usecases.DisableUser(testCtx, unknown))
+		require.IsType(t, &betalinkauth.NotFoundError{}, usecases.EnableUser(testCtx, unknown))
+	})
+
+	t.Run("update the account of a user", func(t *testing.T) {
+		firstName := "Renamed"
+		account, err := usecases.UpdateUserAccount(testCtx, userIDs[4], betalinkauth.UserAccountUpdate{FirstName: &firstName})
+		require.NoError(t, err)
+		require.Equal(t, "Renamed", account.FirstName)
+		require.Equal(t, "User4", account.LastName)
+
+		empty := " "
+		_, err = usecases.UpdateUserAccount(testCtx, userIDs[4], betalinkauth.UserAccountUpdate{LastName: &empty})
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		invalid := "not an email"
+		_, err = usecases.UpdateUserAccount(testCtx, userIDs[4], betalinkauth.UserAccountUpdate{Email: &invalid})
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		taken := "account.user1@example.com"
+		_, err = usecases.UpdateUserAccount(testCtx, userIDs[4], betalinkauth.UserAccountUpdate{Email: &taken})
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+
+		newEmail := "renamed.user4@example.com"
+		account, err = usecases.UpdateUserAccount(testCtx, userIDs[4], betalinkauth.UserAccountUpdate{Email: &newEmail})
+		require.NoError(t, err)
+		require.Equal(t, newEmail, account.Email)
+		require.False(t, account.EmailVerified)
+		_, err = usecases.LoginUser(testCtx, newEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+		_, err = usecases.LoginUser(testCtx, "account.user4@example.com", testPassword, false, testSessionMetadata)
+		require.Error(t, err)
+
+		_, err = usecases.UpdateUserAccount(testCtx, pgtype.UUID{Bytes: uuid.New(), Valid: true}, betalinkauth.UserAccountUpdate{FirstName: &firstName})
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+	})
+}
@@ -0,0 +1,345 @@
+package betalinkauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/BragdonD/betalink-auth/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// createRoleDto is the data transfer object for creating a role
+type createRoleDto struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	MaxSessions *int32 `json:"max_sessions"`
+}
+
+// createLDAPUserDto is the data transfer object for creating the
+// account of a user of the LDAP directory
+type createLDAPUserDto struct {
+	Email string `json:"email"`
+}
+
+// createPermissionDto is the data transfer object for creating a permission
+type createPermissionDto struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// listRoles handles the http request to list the roles
+func (r *Router) listRoles(ctx *gin.Context) {
+	roles, err := r.usecases.ListRoles(ctx)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not list roles: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, roles, nil)
+}
+
+// createRole handles the http request to create a role
+func (r *Router) createRole(ctx *gin.Context) {
+	var dto createRoleDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	if err := r.usecases.CreateRole(ctx, dto.Name, dto.Description, dto.MaxSessions); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not create role: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusCreated, true, nil, nil)
+}
+
+// deleteRole handles the http request to delete a role
+func (r *Router) deleteRole(ctx *gin.Context) {
+	if err := r.usecases.DeleteRole(ctx, ctx.Param("role")); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not delete role: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// grantPermission handles the http request to grant a permission to a role
+func (r *Router) grantPermission(ctx *gin.Context) {
+	if err := r.usecases.GrantPermission(ctx, ctx.Param("role"), ctx.Param("permission")); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not grant permission: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// revokePermission handles the http request to revoke a permission from a role
+func (r *Router) revokePermission(ctx *gin.Context) {
+	if err := r.usecases.RevokePermission(ctx, ctx.Param("role"), ctx.Param("permission")); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not revoke permission: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// listPermissions handles the http request to list the permissions
+func (r *Router) listPermissions(ctx *gin.Context) {
+	permissions, err := r.usecases.ListPermissions(ctx)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not list permissions: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, permissions, nil)
+}
+
+// createPermission handles the http request to create a permission
+func (r *Router) createPermission(ctx *gin.Context) {
+	var dto createPermissionDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	if err := r.usecases.CreatePermission(ctx, dto.Name, dto.Description); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not create permission: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusCreated, true, nil, nil)
+}
+
+// deletePermission handles the http request to delete a permission
+func (r *Router) deletePermission(ctx *gin.Context) {
+	if err := r.usecases.DeletePermission(ctx, ctx.Param("permission")); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not delete permission: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// getUserRoles handles the http request to get the roles of a user
+func (r *Router) getUserRoles(ctx *gin.Context) {
+	userID, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	roles, err := r.usecases.GetUserRoles(ctx, userID)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not get user roles: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, roles, nil)
+}
+
+// assignRole handles the http request to assign a role to a user
+func (r *Router) assignRole(ctx *gin.Context) {
+	userID, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	if err := r.usecases.AssignRole(ctx, userID, ctx.Param("role")); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not assign role: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// unassignRole handles the http request to remove a role from a user
+func (r *Router) unassignRole(ctx *gin.Context) {
+	userID, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	if err := r.usecases.UnassignRole(ctx, userID, ctx.Param("role")); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not unassign role: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// createLDAPUser handles the http request to create the account of a
+// user of the LDAP directory
+func (r *Router) createLDAPUser(ctx *gin.Context) {
+	var dto createLDAPUserDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	if err := r.usecases.CreateLDAPUser(ctx, dto.Email); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not create LDAP user: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusCreated, true, nil, nil)
+}
+
+// listUsers handles the http request to list the users, filtered by
+// the q, role and status query parameters
+func (r *Router) listUsers(ctx *gin.Context) {
+	query := UserQuery{
+		Search: ctx.Query("q"),
+		Role:   ctx.Query("role"),
+		Cursor: ctx.Query("cursor"),
+	}
+	switch status := ctx.Query("status"); status {
+	case "":
+	case "active", "disabled":
+		disabled := status == "disabled"
+		query.Disabled = &disabled
+	default:
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("invalid status [%s]: must be active or disabled", status))
+		return
+	}
+	if rawLimit := ctx.Query("limit"); rawLimit != "" {
+		limit, err := strconv.ParseInt(rawLimit, 10, 32)
+		if err != nil || limit <= 0 {
+			writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("invalid limit [%s]", rawLimit))
+			return
+		}
+		query.Limit = int32(limit)
+	}
+	page, err := r.usecases.ListUsers(ctx, query)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not list users: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, page, nil)
+}
+
+// getUser handles the http request to get the account of a user along
+// with its external identities and sessions
+func (r *Router) getUser(ctx *gin.Context) {
+	userID, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	user, err := r.usecases.GetUserDetails(ctx, userID)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not get user: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, user, nil)
+}
+
+// updateUser handles the http request to change the names or email of
+// a user
+func (r *Router) updateUser(ctx *gin.Context) {
+	userID, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	var dto UserAccountUpdate
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	user, err := r.usecases.UpdateUserAccount(ctx, userID, dto)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not update user: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, user, nil)
+}
+
+// disableUser handles the http request to disable a user. The admins
+// cannot disable themselves, so they cannot lock every admin out.
+func (r *Router) disableUser(ctx *gin.Context) {
+	userID, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	if admin, _ := middleware.FromContext(ctx.Request.Context()); admin.UserID == userID.String() {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, errors.New("could not disable user: admins cannot disable themselves"))
+		return
+	}
+	if err := r.usecases.DisableUser(ctx, userID); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not disable user: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// enableUser handles the http request to enable a disabled user
+func (r *Router) enableUser(ctx *gin.Context) {
+	userID, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	if err := r.usecases.EnableUser(ctx, userID); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not enable user: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// forcePasswordReset handles the http request to force a user to reset
+// its password, which revokes its sessions. The response holds the token
+// handed to the user to complete the reset.
+func (r *Router) forcePasswordReset(ctx *gin.Context) {
+	userID, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	reset, err := r.usecases.ForcePasswordReset(ctx, userID)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not force password reset: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusCreated, true, reset, nil)
+}
+
+// userIDParam parses the user_id path parameter. If it is not a valid
+// UUID, it writes a 400 Bad Request response and returns false.
+func userIDParam(ctx *gin.Context) (pgtype.UUID, bool) {
+	userID, err := uuid.Parse(ctx.Param("user_id"))
+	if err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("invalid user ID: %w", err))
+		return pgtype.UUID{}, false
+	}
+	return pgtype.UUID{Bytes: userID, Valid: true}, true
+}
+
+// listPolicies handles the http request to list the access policies
+func (r *Router) listPolicies(ctx *gin.Context) {
+	policies, err := r.usecases.ListPolicies(ctx)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not list policies: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, policies, nil)
+}
+
+// createPolicy handles the http request to create an access policy
+func (r *Router) createPolicy(ctx *gin.Context) {
+	var dto PolicyData
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	if err := r.usecases.CreatePolicy(ctx, dto); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not create policy: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusCreated, true, nil, nil)
+}
+
+// updatePolicy handles the http request to replace an access policy
+func (r *Router) updatePolicy(ctx *gin.Context) {
+	var dto PolicyData
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	dto.Name = ctx.Param("policy")
+	if err := r.usecases.UpdatePolicy(ctx, dto); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not update policy: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// deletePolicy handles the http request to delete an access policy
+func (r *Router) deletePolicy(ctx *gin.Context) {
+	if err := r.usecases.DeletePolicy(ctx, ctx.Param("policy")); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not delete policy: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
@@ -0,0 +1,302 @@
+package betalinkauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// ResponseTypeCode is the response type of the authorization code
+	// flow (RFC 6749 section 4.1.1)
+	ResponseTypeCode = "code"
+	// GrantTypeAuthorizationCode is the grant type exchanging an
+	// authorization code for tokens (RFC 6749 section 4.1.3)
+	GrantTypeAuthorizationCode = "authorization_code"
+	// GrantTypeRefreshToken is the grant type refreshing an access token
+	// (RFC 6749 section 6)
+	GrantTypeRefreshToken = "refresh_token"
+	// GrantTypeClientCredentials is the grant type of the machine
+	// clients obtaining tokens on their own behalf (RFC 6749 section 4.4)
+	GrantTypeClientCredentials = "client_credentials"
+	// CodeChallengeMethodS256 is the only PKCE code challenge method
+	// supported, plain challenges are rejected (RFC 7636 section 4.2)
+	CodeChallengeMethodS256 = "S256"
+
+	// authorizationCodeValidity is how long an authorization code can be
+	// exchanged for tokens
+	authorizationCodeValidity = time.Minute * 5
+	// authorizationCodeSize is the size in bytes of authorization codes
+	authorizationCodeSize = 32
+)
+
+// supportedGrantTypes are the grant types a client can be registered for
+var supportedGrantTypes = []string{
+	GrantTypeAuthorizationCode,
+	GrantTypeRefreshToken,
+	GrantTypeClientCredentials,
+	GrantTypeDeviceCode,
+}
+
+// AuthorizationRequest is the request of an OAuth client for an
+// authorization code (RFC 6749 section 4.1.1 and RFC 7636 section 4.3)
+type AuthorizationRequest struct {
+	ResponseType string
+	ClientID     string
+	// RedirectURI may be omitted by clients with a single registered
+	// redirect URI
+	RedirectURI string
+	// Scope is the space separated list of the requested scopes, those
+	// allowed for the client by default
+	Scope string
+	State string
+	// Nonce is echoed in the ID token (OpenID Connect Core section 3.1.2.1)
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// Authorization is a validated authorization request
+type Authorization struct {
+	Client      ClientData
+	RedirectURI string
+	// RedirectURISupplied tells whether the client sent the redirect
+	// URI, which it must then send again when exchanging the code
+	RedirectURISupplied bool
+	Scopes              []string
+	State               string
+	Nonce               string
+	// CodeChallenge is the PKCE S256 code challenge
+	CodeChallenge string
+}
+
+// ValidateAuthorizationRequest validates the authorization request of
+// an OAuth client. A ValidationError means the client or its redirect
+// URI cannot be trusted and the user must not be redirected to it. An
+// OAuthError must be sent back to the client through its redirect URI.
+func (u *Usecases) ValidateAuthorizationRequest(ctx context.Context, request AuthorizationRequest) (*Authorization, error) {
+	client, err := u.getClient(ctx, request.ClientID)
+	if err != nil {
+		if err == InvalidClientError {
+			return nil, &ValidationError{Message: "unknown client"}
+		}
+		return nil, err
+	}
+
+	redirectURI := request.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, &ValidationError{Message: "redirect URI is not registered for the client"}
+	}
+
+	authorization := &Authorization{
+		Client:              *client,
+		RedirectURI:         redirectURI,
+		RedirectURISupplied: request.RedirectURI != "",
+		Scopes:              strings.Fields(request.Scope),
+		State:               request.State,
+		Nonce:               request.Nonce,
+		CodeChallenge:       request.CodeChallenge,
+	}
+	if !slices.Contains(client.GrantTypes, GrantTypeAuthorizationCode) {
+		return authorization, &OAuthError{
+			Code:        OAuthErrorUnauthorizedClient,
+			Description: "the client may not use the authorization code grant",
+		}
+	}
+	if request.ResponseType != ResponseTypeCode {
+		return authorization, &OAuthError{
+			Code:        OAuthErrorUnsupportedResponseType,
+			Description: "only the code response type is supported",
+		}
+	}
+	if len(authorization.Scopes) == 0 {
+		authorization.Scopes = client.Scopes
+	}
+	for _, scope := range authorization.Scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return authorization, &OAuthError{
+				Code:        OAuthErrorInvalidScope,
+				Description: fmt.Sprintf("scope [%s] is not allowed for the client", scope),
+			}
+		}
+	}
+	// PKCE is required from every client, confidential clients included
+	if request.CodeChallengeMethod != CodeChallengeMethodS256 {
+		return authorization, &OAuthError{
+			Code:        OAuthErrorInvalidRequest,
+			Description: "code_challenge_method must be S256",
+		}
+	}
+	if !validPKCEValue(request.CodeChallenge) {
+		return authorization, &OAuthError{
+			Code:        OAuthErrorInvalidRequest,
+			Description: "code_challenge is missing or malformed",
+		}
+	}
+	return authorization, nil
+}
+
+// Authorize checks the credentials of the user consenting to a
+// validated authorization request, and returns the authorization code
+// sent to the client
+func (u *Usecases) Authorize(ctx context.Context, authorization *Authorization, email, password string, metadata SessionMetadata) (string, error) {
+	queries, err := u.postgresQueries("OAuth authorizations")
+	if err != nil {
+		return "", err
+	}
+	userID, err := u.checkPassword(ctx, email, password, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := randomString(authorizationCodeSize)
+	if err != nil {
+		return "", &ServerError{
+			Message: fmt.Errorf("could not generate authorization code: %w", err).Error(),
+		}
+	}
+	now := time.Now()
+	params := u.newCreateSessionParams(userID, LoginMethodPassword, false, metadata)
+	err = queries.CreateAuthorizationCode(ctx, CreateAuthorizationCodeParams{
+		CodeHash:      hashSecret(code),
+		ClientID:      authorization.Client.ClientID,
+		UserID:        userID,
+		RedirectUri:   authorization.RedirectURI,
+		Scope:         strings.Join(authorization.Scopes, " "),
+		Nonce:         authorization.Nonce,
+		CodeChallenge: authorization.CodeChallenge,
+		IpAddress:     params.IpAddress,
+		UserAgent:     params.UserAgent,
+		AuthTime: pgtype.Timestamptz{
+			Time:  now,
+			Valid: true,
+		},
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  now.Add(authorizationCodeValidity),
+			Valid: true,
+		},
+		RedirectUriSupplied: authorization.RedirectURISupplied,
+	})
+	if err != nil {
+		return "", &ServerError{
+			Message: fmt.Errorf("could not create authorization code: %w", err).Error(),
+		}
+	}
+	return code, nil
+}
+
+// ExchangeAuthorizationCode exchanges an authorization code issued to
+// a client for tokens, opening a session bound to the client. The
+// code can only be used once, even if the exchange fails.
+func (u *Usecases) ExchangeAuthorizationCode(ctx context.Context, client *ClientData, code, redirectURI, codeVerifier string) (*IDTokens, error) {
+	queries, err := u.postgresQueries("OAuth authorizations")
+	if err != nil {
+		return nil, err
+	}
+	invalidGrant := func(description string) error {
+		return &OAuthError{Code: OAuthErrorInvalidGrant, Description: description}
+	}
+	if !slices.Contains(client.GrantTypes, GrantTypeAuthorizationCode) {
+		return nil, &OAuthError{
+			Code:        OAuthErrorUnauthorizedClient,
+			Description: "the client may not use the authorization code grant",
+		}
+	}
+	if code == "" {
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "code is required"}
+	}
+	if !validPKCEValue(codeVerifier) {
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "code_verifier is missing or malformed"}
+	}
+
+	authorizationCode, err := queries.ConsumeAuthorizationCode(ctx, hashSecret(code))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, invalidGrant("authorization code is invalid or already used")
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not consume authorization code: %w", err).Error(),
+		}
+	}
+	if authorizationCode.ExpiresAt.Time.Before(time.Now()) {
+		return nil, invalidGrant("authorization code has expired")
+	}
+	if authorizationCode.ClientID != client.ClientID {
+		return nil, invalidGrant("authorization code was not issued to this client")
+	}
+	// the redirect URI is only required if the authorization request
+	// included it, but must match whenever it is sent (RFC 6749 section
+	// 4.1.3)
+	if (authorizationCode.RedirectUriSupplied || redirectURI != "") && authorizationCode.RedirectUri != redirectURI {
+		return nil, invalidGrant("redirect_uri does not match the authorization request")
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	encodedChallenge := base64.RawURLEncoding.EncodeToString(challenge[:])
+	if subtle.ConstantTimeCompare([]byte(encodedChallenge), []byte(authorizationCode.CodeChallenge)) != 1 {
+		return nil, invalidGrant("code_verifier does not match the code challenge")
+	}
+
+	metadata := SessionMetadata{UserAgent: authorizationCode.UserAgent}
+	if authorizationCode.IpAddress != nil {
+		metadata.IPAddress = authorizationCode.IpAddress.String()
+	}
+	params := u.newCreateSessionParams(authorizationCode.UserID, LoginMethodPassword, false, metadata)
+	params.ClientID = pgtype.Text{String: client.ClientID, Valid: true}
+	params.Scope = authorizationCode.Scope
+	// the user authenticated when consenting, not when the code is
+	// exchanged
+	params.CreatedAt = authorizationCode.AuthTime
+	return u.openSession(ctx, params, authorizationCode.Nonce)
+}
+
+// RefreshClientToken refreshes the session of a refresh token issued to
+// an OAuth client
+func (u *Usecases) RefreshClientToken(ctx context.Context, client *ClientData, refreshToken string) (*IDTokens, error) {
+	if !slices.Contains(client.GrantTypes, GrantTypeRefreshToken) {
+		return nil, &OAuthError{
+			Code:        OAuthErrorUnauthorizedClient,
+			Description: "the client may not use the refresh token grant",
+		}
+	}
+	if refreshToken == "" {
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "refresh_token is required"}
+	}
+	tokens, err := u.refreshSession(ctx, refreshToken, client.ClientID)
+	if err != nil {
+		if validationErr, ok := err.(*ValidationError); ok {
+			return nil, &OAuthError{Code: OAuthErrorInvalidGrant, Description: validationErr.Message}
+		}
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// validPKCEValue reports whether a PKCE code verifier or S256 code
+// challenge is well-formed: 43 to 128 characters of the unreserved URI
+// characters (RFC 7636 section 4.1)
+func validPKCEValue(value string) bool {
+	if len(value) < 43 || len(value) > 128 {
+		return false
+	}
+	for _, c := range value {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package betalinkauth
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+// authorizeTemplate is the login and consent page of the authorization
+// code flow
+var authorizeTemplate = template.Must(
+	template.New("authorize.html").
+		Funcs(template.FuncMap{
+			"join": func(values []string) string { return strings.Join(values, " ") },
+		}).
+		ParseFS(templates, "templates/authorize.html"),
+)
+
+// authorizePage is the data of the login and consent page. Without
+// authorization, only the error is shown.
+type authorizePage struct {
+	Authorization *Authorization
+	Email         string
+	Error         string
+}
+
+// tokenResponse is the successful response of the token endpoint
+// (RFC 6749 section 5.1)
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// authorize handles the authorization request of an OAuth client by
+// showing the login and consent page to the user
+func (r *Router) authorize(ctx *gin.Context) {
+	authorization, err := r.usecases.ValidateAuthorizationRequest(ctx, authorizationRequest(ctx.Query))
+	if !r.checkAuthorizationRequest(ctx, authorization, err) {
+		return
+	}
+	r.renderAuthorizePage(ctx, http.StatusOK, authorizePage{Authorization: authorization})
+}
+
+// authorizeDecision handles the login and consent form, redirecting the
+// user to the client with an authorization code if they allowed it
+func (r *Router) authorizeDecision(ctx *gin.Context) {
+	authorization, err := r.usecases.ValidateAuthorizationRequest(ctx, authorizationRequest(ctx.PostForm))
+	if !r.checkAuthorizationRequest(ctx, authorization, err) {
+		return
+	}
+	if ctx.PostForm("decision") != "allow" {
+		redirectAuthorization(ctx, authorization, url.Values{
+			"error":             {OAuthErrorAccessDenied},
+			"error_description": {"the user denied the request"},
+		})
+		return
+	}
+
+	email := ctx.PostForm("email")
+	metadata := SessionMetadata{
+		IPAddress: ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+	code, err := r.usecases.Authorize(ctx, authorization, email, ctx.PostForm("password"), metadata)
+	if err != nil {
+		if _, ok := err.(*ValidationError); ok {
+			r.renderAuthorizePage(ctx, http.StatusUnauthorized, authorizePage{
+				Authorization: authorization,
+				Email:         email,
+				Error:         InvalidCredentialsError.Message,
+			})
+			return
+		}
+		r.logger.Error(fmt.Errorf("could not authorize client: %w", err))
+		r.renderAuthorizePage(ctx, http.StatusInternalServerError, authorizePage{
+			Error: "Could not process the authorization request",
+		})
+		return
+	}
+	redirectAuthorization(ctx, authorization, url.Values{"code": {code}})
+}
+
+// token handles the token request of an OAuth client, exchanging an
+// authorization code, a device code or a refresh token for tokens, or
+// issuing a token to a machine client (RFC 6749 section 3.2)
+func (r *Router) token(ctx *gin.Context) {
+	client, ok := r.identifyClient(ctx)
+	if !ok {
+		return
+	}
+
+	var tokens *IDTokens
+	var err error
+	switch ctx.PostForm("grant_type") {
+	case GrantTypeAuthorizationCode:
+		tokens, err = r.usecases.ExchangeAuthorizationCode(
+			ctx, client, ctx.PostForm("code"), ctx.PostForm("redirect_uri"), ctx.PostForm("code_verifier"))
+	case GrantTypeRefreshToken:
+		tokens, err = r.usecases.RefreshClientToken(ctx, client, ctx.PostForm("refresh_token"))
+	case GrantTypeClientCredentials:
+		tokens, err = r.usecases.IssueClientToken(ctx, client, ctx.PostForm("scope"), ctx.PostForm("audience"))
+	case GrantTypeDeviceCode:
+		tokens, err = r.usecases.ExchangeDeviceCode(ctx, client, ctx.PostForm("device_code"))
+	default:
+		writeOAuthError(ctx, http.StatusBadRequest, OAuthErrorUnsupportedGrantType, "grant_type is not supported")
+		return
+	}
+	if err != nil {
+		switch err := err.(type) {
+		case *OAuthError:
+			writeOAuthError(ctx, http.StatusBadRequest, err.Code, err.Description)
+		case *ValidationError, *SessionLimitError:
+			// such as the session limit of the user being reached, the
+			// token endpoint reports every error as 400 (RFC 6749 section 5.2)
+			writeOAuthError(ctx, http.StatusBadRequest, OAuthErrorInvalidGrant, err.Error())
+		default:
+			r.logger.Error(fmt.Errorf("could not issue tokens: %w", err))
+			writeOAuthError(ctx, http.StatusInternalServerError, OAuthErrorServerError, "could not issue tokens")
+		}
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+	ctx.JSON(http.StatusOK, tokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenValidity.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		IDToken:      tokens.IDToken,
+		Scope:        strings.Join(tokens.Scopes, " "),
+	})
+}
+
+// checkAuthorizationRequest handles the error of an authorization
+// request validation, and reports whether the request is valid. Errors
+// are sent back to the client, unless it cannot be trusted.
+func (r *Router) checkAuthorizationRequest(ctx *gin.Context, authorization *Authorization, err error) bool {
+	switch err := err.(type) {
+	case nil:
+		return true
+	case *OAuthError:
+		redirectAuthorization(ctx, authorization, url.Values{
+			"error":             {err.Code},
+			"error_description": {err.Description},
+		})
+	case *ValidationError:
+		r.renderAuthorizePage(ctx, http.StatusBadRequest, authorizePage{Error: err.Message})
+	default:
+		r.logger.Error(fmt.Errorf("could not validate authorization request: %w", err))
+		r.renderAuthorizePage(ctx, http.StatusInternalServerError, authorizePage{
+			Error: "Could not process the authorization request",
+		})
+	}
+	return false
+}
+
+// renderAuthorizePage renders the login and consent page
+func (r *Router) renderAuthorizePage(ctx *gin.Context, status int, page authorizePage) {
+	r.renderPage(ctx, authorizeTemplate, status, page)
+}
+
+// renderPage renders a page of the auth service, which must not be
+// framed by other sites (clickjacking)
+func (r *Router) renderPage(ctx *gin.Context, page *template.Template, status int, data interface{}) {
+	var body bytes.Buffer
+	if err := page.Execute(&body, data); err != nil {
+		r.logger.Error(fmt.Errorf("could not render %s page: %w", page.Name(), err))
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("X-Frame-Options", "DENY")
+	ctx.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	ctx.Data(status, "text/html; charset=utf-8", body.Bytes())
+}
+
+// authorizationRequest reads an authorization request from the query or
+// form parameters
+func authorizationRequest(param func(key string) string) AuthorizationRequest {
+	return AuthorizationRequest{
+		ResponseType:        param("response_type"),
+		ClientID:            param("client_id"),
+		RedirectURI:         param("redirect_uri"),
+		Scope:               param("scope"),
+		State:               param("state"),
+		Nonce:               param("nonce"),
+		CodeChallenge:       param("code_challenge"),
+		CodeChallengeMethod: param("code_challenge_method"),
+	}
+}
+
+// redirectAuthorization redirects the user to the client with the
+// authorization response (RFC 6749 section 4.1.2)
+func redirectAuthorization(ctx *gin.Context, authorization *Authorization, values url.Values) {
+	// registered redirect URIs have been validated to be absolute URIs
+	redirectURI, _ := url.Parse(authorization.RedirectURI)
+	query := redirectURI.Query()
+	for key, value := range values {
+		query[key] = value
+	}
+	if authorization.State != "" {
+		query.Set("state", authorization.State)
+	}
+	redirectURI.RawQuery = query.Encode()
+	ctx.Redirect(http.StatusFound, redirectURI.String())
+}
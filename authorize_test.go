@@ -0,0 +1,226 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRedirectURI  = "com.example.app:/oauth/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func testCodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func TestUsecases_AuthorizationCodeFlow(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	signingKey, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	keys := betalinkauth.NewKeySet(signingKey)
+	usecases, err := betalinkauth.NewUsecase(logger, queries, betalinkauth.WithKeySet(keys))
+	require.NoError(t, err)
+
+	testEmail := "oauth.user@example.com"
+	testPassword := "OAuthPassword123!"
+	err = usecases.RegisterUser(testCtx, "OAuth", "User", testEmail, testPassword)
+	require.NoError(t, err)
+
+	client, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{
+		Name:         "mobile-app",
+		Type:         betalinkauth.ClientTypePublic,
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{betalinkauth.ScopeOpenID, betalinkauth.ScopeProfile},
+	})
+	require.NoError(t, err)
+	require.Empty(t, client.ClientSecret)
+
+	request := betalinkauth.AuthorizationRequest{
+		ResponseType:        betalinkauth.ResponseTypeCode,
+		ClientID:            client.ClientID,
+		Scope:               "openid profile",
+		State:               "state-value",
+		Nonce:               "nonce-value",
+		CodeChallenge:       testCodeChallenge(testCodeVerifier),
+		CodeChallengeMethod: betalinkauth.CodeChallengeMethodS256,
+	}
+
+	t.Run("client registration", func(t *testing.T) {
+		_, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{
+			Name:         "insecure-app",
+			RedirectURIs: []string{"http://client.example.com/callback"},
+		})
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		_, err = usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{
+			Name:   "greedy-app",
+			Scopes: []string{"admin"},
+		})
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+
+		_, err = usecases.IdentifyClient(testCtx, client.ClientID, "")
+		require.NoError(t, err)
+		_, err = usecases.AuthenticateClient(testCtx, client.ClientID, "")
+		require.Equal(t, betalinkauth.InvalidClientError, err)
+	})
+
+	t.Run("untrusted authorization requests", func(t *testing.T) {
+		unknownClient := request
+		unknownClient.ClientID = "unknown"
+		_, err := usecases.ValidateAuthorizationRequest(testCtx, unknownClient)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+
+		unregisteredURI := request
+		unregisteredURI.RedirectURI = "https://attacker.example.com/callback"
+		_, err = usecases.ValidateAuthorizationRequest(testCtx, unregisteredURI)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+	})
+
+	t.Run("invalid authorization requests", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			modify func(request *betalinkauth.AuthorizationRequest)
+			code   string
+		}{
+			{
+				name:   "unsupported response type",
+				modify: func(request *betalinkauth.AuthorizationRequest) { request.ResponseType = "token" },
+				code:   betalinkauth.OAuthErrorUnsupportedResponseType,
+			},
+			{
+				name:   "scope not allowed",
+				modify: func(request *betalinkauth.AuthorizationRequest) { request.Scope = "openid email" },
+				code:   betalinkauth.OAuthErrorInvalidScope,
+			},
+			{
+				name:   "plain code challenge",
+				modify: func(request *betalinkauth.AuthorizationRequest) { request.CodeChallengeMethod = "plain" },
+				code:   betalinkauth.OAuthErrorInvalidRequest,
+			},
+			{
+				name:   "missing code challenge",
+				modify: func(request *betalinkauth.AuthorizationRequest) { request.CodeChallenge = "" },
+				code:   betalinkauth.OAuthErrorInvalidRequest,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				invalidRequest := request
+				tt.modify(&invalidRequest)
+				authorization, err := usecases.ValidateAuthorizationRequest(testCtx, invalidRequest)
+				require.IsType(t, &betalinkauth.OAuthError{}, err)
+				require.Equal(t, tt.code, err.(*betalinkauth.OAuthError).Code)
+				// the error is sent back to the registered redirect URI
+				require.Equal(t, testRedirectURI, authorization.RedirectURI)
+			})
+		}
+	})
+
+	authorization, err := usecases.ValidateAuthorizationRequest(testCtx, request)
+	require.NoError(t, err)
+	require.Equal(t, testRedirectURI, authorization.RedirectURI)
+
+	t.Run("wrong password", func(t *testing.T) {
+		_, err := usecases.Authorize(testCtx, authorization, testEmail, "wrong-password", testSessionMetadata)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		_, err = usecases.Authorize(testCtx, authorization, "unknown@example.com", testPassword, testSessionMetadata)
+		require.Equal(t, betalinkauth.InvalidCredentialsError, err)
+	})
+
+	t.Run("code verifier mismatch burns the code", func(t *testing.T) {
+		code, err := usecases.Authorize(testCtx, authorization, testEmail, testPassword, testSessionMetadata)
+		require.NoError(t, err)
+
+		clientData := &client.ClientData
+		_, err = usecases.ExchangeAuthorizationCode(testCtx, clientData, code, testRedirectURI, strings.Repeat("a", 43))
+		require.Equal(t, betalinkauth.OAuthErrorInvalidGrant, err.(*betalinkauth.OAuthError).Code)
+		_, err = usecases.ExchangeAuthorizationCode(testCtx, clientData, code, testRedirectURI, testCodeVerifier)
+		require.Equal(t, betalinkauth.OAuthErrorInvalidGrant, err.(*betalinkauth.OAuthError).Code)
+	})
+
+	t.Run("redirect URI of the code exchange", func(t *testing.T) {
+		// omitted from the authorization request, it may be omitted from
+		// the token request
+		require.False(t, authorization.RedirectURISupplied)
+		code, err := usecases.Authorize(testCtx, authorization, testEmail, testPassword, testSessionMetadata)
+		require.NoError(t, err)
+		_, err = usecases.ExchangeAuthorizationCode(testCtx, &client.ClientData, code, "", testCodeVerifier)
+		require.NoError(t, err)
+
+		// included in the authorization request, it is required
+		suppliedRequest := request
+		suppliedRequest.RedirectURI = testRedirectURI
+		supplied, err := usecases.ValidateAuthorizationRequest(testCtx, suppliedRequest)
+		require.NoError(t, err)
+		require.True(t, supplied.RedirectURISupplied)
+		code, err = usecases.Authorize(testCtx, supplied, testEmail, testPassword, testSessionMetadata)
+		require.NoError(t, err)
+		_, err = usecases.ExchangeAuthorizationCode(testCtx, &client.ClientData, code, "", testCodeVerifier)
+		require.Equal(t, betalinkauth.OAuthErrorInvalidGrant, err.(*betalinkauth.OAuthError).Code)
+	})
+
+	t.Run("code exchange", func(t *testing.T) {
+		code, err := usecases.Authorize(testCtx, authorization, testEmail, testPassword, testSessionMetadata)
+		require.NoError(t, err)
+
+		tokens, err := usecases.ExchangeAuthorizationCode(testCtx, &client.ClientData, code, testRedirectURI, testCodeVerifier)
+		require.NoError(t, err)
+		require.Equal(t, []string{"openid", "profile"}, tokens.Scopes)
+
+		idClaims, err := keys.ParseJWT(tokens.IDToken, jwt.WithAudience(client.ClientID))
+		require.NoError(t, err)
+		require.Equal(t, "nonce-value", idClaims["nonce"])
+		require.Equal(t, "OAuth", idClaims["given_name"])
+		require.NotContains(t, idClaims, "email")
+
+		user, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, []string{"openid", "profile"}, user.Scopes)
+		userInfo, err := usecases.GetUserInfo(testCtx, user.UserID.String(), user.ClientID, user.Scopes)
+		require.NoError(t, err)
+		require.Empty(t, userInfo.Email)
+		require.Equal(t, "User", userInfo.FamilyName)
+
+		introspection, err := usecases.IntrospectToken(testCtx, tokens.AccessToken, "")
+		require.NoError(t, err)
+		require.Equal(t, client.ClientID, introspection.ClientID)
+		require.Equal(t, "openid profile", introspection.Scope)
+
+		_, err = usecases.ExchangeAuthorizationCode(testCtx, &client.ClientData, code, testRedirectURI, testCodeVerifier)
+		require.Equal(t, betalinkauth.OAuthErrorInvalidGrant, err.(*betalinkauth.OAuthError).Code)
+
+		t.Run("refresh tokens are bound to the client", func(t *testing.T) {
+			refreshed, err := usecases.RefreshClientToken(testCtx, &client.ClientData, tokens.RefreshToken)
+			require.NoError(t, err)
+			require.NotEmpty(t, refreshed.IDToken)
+
+			_, err = usecases.RefreshAccessToken(testCtx, tokens.RefreshToken)
+			require.IsType(t, &betalinkauth.ValidationError{}, err)
+		})
+
+		t.Run("the client revokes its refresh token", func(t *testing.T) {
+			err := usecases.RevokeToken(testCtx, &client.ClientData, tokens.RefreshToken, betalinkauth.TokenTypeHintRefreshToken)
+			require.NoError(t, err)
+			_, err = usecases.RefreshClientToken(testCtx, &client.ClientData, tokens.RefreshToken)
+			require.Equal(t, betalinkauth.OAuthErrorInvalidGrant, err.(*betalinkauth.OAuthError).Code)
+		})
+	})
+}
@@ -0,0 +1,248 @@
+package betalinkauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BragdonD/betalink-auth/internal/claim"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// ClientAssertionTypeJWTBearer is the client assertion type of the
+	// clients authenticating with private_key_jwt (RFC 7523 section 2.2)
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// clientAssertionMaxLifetime is the maximum lifetime of a client
+	// assertion, so the IDs of the used ones are not kept for long
+	clientAssertionMaxLifetime = time.Minute * 5
+)
+
+// ServiceData is the machine client a client credentials token was
+// issued to
+type ServiceData struct {
+	ClientID string
+	Audience string
+	Scopes   []string
+}
+
+// IssueClientToken issues an access token to a machine client for one
+// of its audiences, through the client credentials grant. The audience
+// may be omitted by clients with a single audience, the scope defaults
+// to the scopes allowed for the client.
+func (u *Usecases) IssueClientToken(ctx context.Context, client *ClientData, scope, audience string) (*IDTokens, error) {
+	if !slices.Contains(client.GrantTypes, GrantTypeClientCredentials) {
+		return nil, &OAuthError{
+			Code:        OAuthErrorUnauthorizedClient,
+			Description: "the client may not use the client credentials grant",
+		}
+	}
+	if audience == "" && len(client.Audiences) == 1 {
+		audience = client.Audiences[0]
+	}
+	if !slices.Contains(client.Audiences, audience) {
+		return nil, &OAuthError{
+			Code:        OAuthErrorInvalidTarget,
+			Description: fmt.Sprintf("audience [%s] is not allowed for the client", audience),
+		}
+	}
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, &OAuthError{
+				Code:        OAuthErrorInvalidScope,
+				Description: fmt.Sprintf("scope [%s] is not allowed for the client", scope),
+			}
+		}
+	}
+
+	accessToken, err := u.keys.GenerateClientToken(ClientTokenClaims{
+		ClientID: client.ClientID,
+		Audience: audience,
+		Scopes:   scopes,
+	}, accessTokenValidity)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not generate access token: %w", err).Error(),
+		}
+	}
+	return &IDTokens{
+		AccessToken: accessToken,
+		Scopes:      scopes,
+	}, nil
+}
+
+// ValidateClientToken validates an access token issued to a machine
+// client for the given audience
+func (u *Usecases) ValidateClientToken(ctx context.Context, accessToken, audience string) (*ServiceData, error) {
+	claims, err := u.keys.ParseAccessToken(accessToken)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ExpiredTokenError
+		}
+		return nil, &ValidationError{
+			Message: fmt.Errorf("could not validate access token: %w", err).Error(),
+		}
+	}
+	if !isClientToken(claims) {
+		return nil, &ValidationError{Message: "access token was not issued to a machine client"}
+	}
+	audiences, err := claims.GetAudience()
+	if err != nil || audience == "" || !slices.Contains(audiences, audience) {
+		return nil, &ValidationError{
+			Message: fmt.Sprintf("access token was not issued for audience [%s]", audience),
+		}
+	}
+	if err := u.checkAccessTokenRevocation(ctx, claims); err != nil {
+		return nil, err
+	}
+
+	clientID, _ := claims["client_id"].(string)
+	return &ServiceData{
+		ClientID: clientID,
+		Audience: audience,
+		Scopes:   claim.Scope(claims),
+	}, nil
+}
+
+// AuthenticateClientAssertion authenticates an OAuth client with a JWT
+// signed by its private key (private_key_jwt, RFC 7523). The client ID
+// may be omitted, it is then read from the assertion. Assertions are
+// single use.
+func (u *Usecases) AuthenticateClientAssertion(ctx context.Context, clientID, assertion string) (*ClientData, error) {
+	queries, err := u.postgresQueries("OAuth clients")
+	if err != nil {
+		return nil, err
+	}
+	if clientID == "" {
+		unverifiedClaims := jwt.MapClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(assertion, unverifiedClaims); err != nil {
+			return nil, InvalidClientError
+		}
+		clientID, _ = unverifiedClaims["iss"].(string)
+	}
+	if clientID == "" {
+		return nil, InvalidClientError
+	}
+	client, err := queries.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, InvalidClientError
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not get client: %w", err).Error(),
+		}
+	}
+	if client.PublicKey == "" {
+		return nil, InvalidClientError
+	}
+	publicKey, err := parseClientPublicKey(client.PublicKey)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not parse client public key: %w", err).Error(),
+		}
+	}
+
+	parsedToken, err := jwt.Parse(assertion, func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(clientID),
+		jwt.WithSubject(clientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, InvalidClientError
+	}
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, InvalidClientError
+	}
+	// the audience identifies the auth service, by its issuer or the
+	// URL of its token endpoint
+	audiences, err := claims.GetAudience()
+	if err != nil || (!slices.Contains(audiences, u.issuer) && !slices.Contains(audiences, u.issuer+"/oauth/token")) {
+		return nil, InvalidClientError
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || time.Until(expiresAt.Time) > clientAssertionMaxLifetime {
+		return nil, InvalidClientError
+	}
+	assertionID, _ := claims["jti"].(string)
+	if assertionID == "" {
+		return nil, InvalidClientError
+	}
+	recorded, err := queries.RecordClientAssertion(ctx, RecordClientAssertionParams{
+		ClientID:    clientID,
+		AssertionID: assertionID,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  expiresAt.Time,
+			Valid: true,
+		},
+	})
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not record client assertion: %w", err).Error(),
+		}
+	}
+	if recorded == 0 {
+		// the assertion has already been used
+		return nil, InvalidClientError
+	}
+
+	data := clientData(client)
+	return &data, nil
+}
+
+// isClientAccessToken reports whether an access token, whose signature
+// is not verified, claims to be issued to a machine client
+func isClientAccessToken(token string) bool {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return false
+	}
+	return isClientToken(claims)
+}
+
+// parseClientPublicKey parses the PEM encoded RSA public key of a client
+func parseClientPublicKey(publicKey string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("only RSA keys are supported")
+	}
+	return rsaKey, nil
+}
+
+// validScopeToken reports whether a scope or audience is made of the
+// characters allowed in scope tokens (RFC 6749 section 3.3)
+func validScopeToken(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
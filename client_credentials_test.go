@@ -0,0 +1,147 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsecases_ClientCredentialsGrant(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+
+	client, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{
+		Name:       "orders-worker",
+		GrantTypes: []string{betalinkauth.GrantTypeClientCredentials},
+		Scopes:     []string{"billing:read", "billing:write"},
+		Audiences:  []string{"billing"},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, client.ClientSecret)
+
+	t.Run("client registration", func(t *testing.T) {
+		_, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{
+			Name:       "no-audience",
+			GrantTypes: []string{betalinkauth.GrantTypeClientCredentials},
+		})
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		_, err = usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{
+			Name:       "public-worker",
+			Type:       betalinkauth.ClientTypePublic,
+			GrantTypes: []string{betalinkauth.GrantTypeClientCredentials},
+			Audiences:  []string{"billing"},
+		})
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+	})
+
+	t.Run("machine clients cannot use the authorization code grant", func(t *testing.T) {
+		_, err := usecases.ExchangeAuthorizationCode(testCtx, &client.ClientData, "code", testRedirectURI, testCodeVerifier)
+		require.Equal(t, betalinkauth.OAuthErrorUnauthorizedClient, err.(*betalinkauth.OAuthError).Code)
+	})
+
+	t.Run("token request", func(t *testing.T) {
+		_, err := usecases.IssueClientToken(testCtx, &client.ClientData, "", "shipping")
+		require.Equal(t, betalinkauth.OAuthErrorInvalidTarget, err.(*betalinkauth.OAuthError).Code)
+		_, err = usecases.IssueClientToken(testCtx, &client.ClientData, "billing:admin", "")
+		require.Equal(t, betalinkauth.OAuthErrorInvalidScope, err.(*betalinkauth.OAuthError).Code)
+
+		tokens, err := usecases.IssueClientToken(testCtx, &client.ClientData, "billing:read", "")
+		require.NoError(t, err)
+		require.Empty(t, tokens.RefreshToken)
+		require.Equal(t, []string{"billing:read"}, tokens.Scopes)
+
+		service, err := usecases.ValidateClientToken(testCtx, tokens.AccessToken, "billing")
+		require.NoError(t, err)
+		require.Equal(t, client.ClientID, service.ClientID)
+		require.Equal(t, []string{"billing:read"}, service.Scopes)
+		_, err = usecases.ValidateClientToken(testCtx, tokens.AccessToken, "shipping")
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		_, err = usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.Error(t, err)
+
+		introspection, err := usecases.IntrospectToken(testCtx, tokens.AccessToken, "")
+		require.NoError(t, err)
+		require.True(t, introspection.Active)
+		require.Equal(t, client.ClientID, introspection.Subject)
+		require.Equal(t, "billing", introspection.Audience)
+
+		require.NoError(t, usecases.RevokeToken(testCtx, &client.ClientData, tokens.AccessToken, ""))
+		_, err = usecases.ValidateClientToken(testCtx, tokens.AccessToken, "billing")
+		require.Error(t, err)
+	})
+
+	t.Run("private key JWT authentication", func(t *testing.T) {
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+		require.NoError(t, err)
+
+		keyClient, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{
+			Name:       "signing-worker",
+			GrantTypes: []string{betalinkauth.GrantTypeClientCredentials},
+			Audiences:  []string{"billing"},
+			PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		})
+		require.NoError(t, err)
+		require.Empty(t, keyClient.ClientSecret)
+
+		sign := func(claims jwt.MapClaims) string {
+			assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+			require.NoError(t, err)
+			return assertion
+		}
+		assertionClaims := func(id string) jwt.MapClaims {
+			return jwt.MapClaims{
+				"iss": keyClient.ClientID,
+				"sub": keyClient.ClientID,
+				"aud": betalinkauth.DefaultIssuer + "/oauth/token",
+				"jti": id,
+				"exp": time.Now().Add(time.Minute).Unix(),
+			}
+		}
+
+		assertion := sign(assertionClaims("assertion-1"))
+		authenticated, err := usecases.AuthenticateClientAssertion(testCtx, "", assertion)
+		require.NoError(t, err)
+		require.Equal(t, keyClient.ClientID, authenticated.ClientID)
+
+		// assertions are single use
+		_, err = usecases.AuthenticateClientAssertion(testCtx, keyClient.ClientID, assertion)
+		require.Equal(t, betalinkauth.InvalidClientError, err)
+
+		longLived := assertionClaims("assertion-2")
+		longLived["exp"] = time.Now().Add(time.Hour).Unix()
+		_, err = usecases.AuthenticateClientAssertion(testCtx, keyClient.ClientID, sign(longLived))
+		require.Equal(t, betalinkauth.InvalidClientError, err)
+
+		wrongAudience := assertionClaims("assertion-3")
+		wrongAudience["aud"] = "https://attacker.example.com"
+		_, err = usecases.AuthenticateClientAssertion(testCtx, keyClient.ClientID, sign(wrongAudience))
+		require.Equal(t, betalinkauth.InvalidClientError, err)
+
+		// clients with a secret cannot authenticate with an assertion
+		secretClaims := assertionClaims("assertion-4")
+		secretClaims["iss"], secretClaims["sub"] = client.ClientID, client.ClientID
+		_, err = usecases.AuthenticateClientAssertion(testCtx, client.ClientID, sign(secretClaims))
+		require.Equal(t, betalinkauth.InvalidClientError, err)
+	})
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	betalinklogger "github.com/BragdonD/betalink-logger"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const adminUsage = `usage: betalink-auth admin [-json] <resource> <command> [arguments]
+
+Operates the auth service through its database, the SQLite file of
+BETALINK_AUTH_SQLITE_PATH if set or else postgres. The users are given
+by ID or by email, the passwords are read from the standard input.
+The results are printed as tables, or as JSON with -json.
+
+users:
+  list [-q text] [-role r] [-status active|disabled] [-limit n] [-cursor c]
+                               list the users, the oldest first
+  show <user>                  show a user with its roles
+  logins <user>                list the last login attempts of a user
+  create [-admin] <email> <first-name> <last-name>
+                               create a user, an administrator with -admin
+  reset-password <user>        clear the password, revoke the sessions and print the
+                               recovery token the user chooses a new password with
+  disable <user>               disable a user and revoke its sessions
+  enable <user>                enable a disabled user
+
+sessions:
+  list <user>                  list the active sessions of a user
+  revoke <session-id>          revoke a session
+  revoke-all <user>            revoke every session of a user
+
+roles:
+  list                         list the roles and the permissions they grant
+  assign <user> <role>         grant a role to a user
+  unassign <user> <role>       remove a role from a user
+
+keys:
+  list                         list the signing keys configured in the environment
+  generate <file>              write a new signing key to a PEM file
+
+tokens:
+  issue <user>                 open a session for a user and print its tokens
+  inspect <token>              print the state of an access or refresh token
+  revoke <token>               revoke an access or refresh token
+
+The tokens are signed and verified with the keys configured in the
+environment, as the service does.
+`
+
+// errAdminUsage is returned by the admin commands called with invalid
+// arguments
+var errAdminUsage = errors.New("invalid arguments")
+
+// admin runs the admin commands, printing their results to out
+type admin struct {
+	stdin io.Reader
+	out   adminOutput
+	// usecases are opened on the first command needing them
+	usecases *betalinkauth.Usecases
+	pool     *pgxpool.Pool
+	sqliteDB *sql.DB
+}
+
+// adminCommand runs the admin subcommand with its arguments, and
+// returns the exit code of the process
+func adminCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, adminUsage) }
+	jsonOutput := flags.Bool("json", false, "print the results as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 2
+	}
+
+	a := &admin{
+		stdin: stdin,
+		out:   adminOutput{w: stdout, json: *jsonOutput},
+	}
+	defer a.close()
+
+	var run func(context.Context, string, []string) error
+	switch resource := flags.Arg(0); resource {
+	case "users":
+		run = a.users
+	case "sessions":
+		run = a.sessions
+	case "roles":
+		run = a.roles
+	case "keys":
+		run = a.keys
+	case "tokens":
+		run = a.tokens
+	default:
+		fmt.Fprintf(stderr, "unknown admin resource %q\n", resource)
+		flags.Usage()
+		return 2
+	}
+	err := run(context.Background(), flags.Arg(1), flags.Args()[2:])
+	if errors.Is(err, errAdminUsage) {
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// open returns the usecases of the service, connecting to the database
+// on the first call
+func (a *admin) open(ctx context.Context) (*betalinkauth.Usecases, error) {
+	if a.usecases != nil {
+		return a.usecases, nil
+	}
+	options := []betalinkauth.UsecaseOption{}
+	sessions, err := sessionConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not load session configuration: %w", err)
+	}
+	options = append(options, betalinkauth.WithSessionConfig(sessions))
+	keys, err := keySet()
+	if err != nil {
+		return nil, fmt.Errorf("could not load signing keys: %w", err)
+	}
+	if keys != nil {
+		options = append(options, betalinkauth.WithKeySet(keys))
+	}
+	if issuer := os.Getenv(issuerEnv); issuer != "" {
+		options = append(options, betalinkauth.WithIssuer(issuer))
+	}
+
+	var queries *betalinkauth.Queries
+	if sqlitePath := os.Getenv(sqlitePathEnv); sqlitePath != "" {
+		db, err := betalinkauth.OpenSQLite(sqlitePath)
+		if err != nil {
+			return nil, err
+		}
+		a.sqliteDB = db
+		options = append(options, betalinkauth.WithStore(betalinkauth.NewSQLiteStore(db)))
+	} else {
+		pool, err := pgxpool.New(ctx, databaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to database: %w", err)
+		}
+		a.pool = pool
+		queries = betalinkauth.New(pool)
+	}
+	// the errors are reported by the commands, the usecases only log
+	// the warnings about the missing keys
+	logger := betalinklogger.NewLogger("betalink-auth", false, false, io.Discard)
+	usecases, err := betalinkauth.NewUsecase(logger, queries, options...)
+	if err != nil {
+		return nil, err
+	}
+	a.usecases = usecases
+	return usecases, nil
+}
+
+// close closes the database connection, if any
+func (a *admin) close() {
+	if a.pool != nil {
+		a.pool.Close()
+	}
+	if a.sqliteDB != nil {
+		a.sqliteDB.Close()
+	}
+}
+
+// users runs the users commands
+func (a *admin) users(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "list":
+		flags := flag.NewFlagSet("users list", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		search := flags.String("q", "", "text in the email or name")
+		role := flags.String("role", "", "role of the users")
+		status := flags.String("status", "", "active or disabled")
+		limit := flags.Int("limit", betalinkauth.DefaultUsersPageSize, "number of users")
+		cursor := flags.String("cursor", "", "next cursor of the previous page")
+		if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+			return errAdminUsage
+		}
+		query := betalinkauth.UserQuery{Search: *search, Role: *role, Cursor: *cursor, Limit: int32(*limit)}
+		switch *status {
+		case "":
+		case "active", "disabled":
+			disabled := *status == "disabled"
+			query.Disabled = &disabled
+		default:
+			return errAdminUsage
+		}
+		usecases, err := a.open(ctx)
+		if err != nil {
+			return err
+		}
+		page, err := usecases.ListUsers(ctx, query)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(page.Users))
+		for _, user := range page.Users {
+			rows = append(rows, []string{
+				user.UserID, user.Email, user.FirstName, user.LastName,
+				user.HashAlgorithm, formatTime(user.CreatedAt), formatDisabledAt(user.DisabledAt),
+			})
+		}
+		if err := a.out.table(page, []string{"ID", "EMAIL", "FIRST NAME", "LAST NAME", "PASSWORD", "CREATED AT", "DISABLED AT"}, rows); err != nil {
+			return err
+		}
+		if page.NextCursor != "" && !a.out.json {
+			fmt.Fprintf(a.out.w, "\nnext page: -cursor %s\n", page.NextCursor)
+		}
+		return nil
+
+	case "show":
+		if len(args) != 1 {
+			return errAdminUsage
+		}
+		usecases, userID, err := a.openUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		account, err := usecases.GetUserAccount(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return a.printAccount(account)
+
+	case "logins":
+		if len(args) != 1 {
+			return errAdminUsage
+		}
+		usecases, userID, err := a.openUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		events, err := usecases.ListUserLoginEvents(ctx, userID)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(events))
+		for _, event := range events {
+			result := "succeeded"
+			if !event.Succeeded {
+				result = "failed: " + event.FailureReason
+			}
+			rows = append(rows, []string{
+				formatTime(event.OccurredAt), event.LoginMethod, event.IPAddress, result,
+			})
+		}
+		return a.out.table(events, []string{"OCCURRED AT", "LOGIN", "IP ADDRESS", "RESULT"}, rows)
+
+	case "create":
+		flags := flag.NewFlagSet("users create", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		isAdmin := flags.Bool("admin", false, "grant the admin role")
+		if err := flags.Parse(args); err != nil || flags.NArg() != 3 {
+			return errAdminUsage
+		}
+		email, firstName, lastName := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+		password, err := readPassword(a.stdin)
+		if err != nil {
+			return err
+		}
+		usecases, err := a.open(ctx)
+		if err != nil {
+			return err
+		}
+		var roles []string
+		if *isAdmin {
+			roles = append(roles, betalinkauth.AdminRole)
+		}
+		// the admin role is granted in the transaction creating the user,
+		// a failure does not leave an account without it
+		if err := usecases.RegisterUser(ctx, firstName, lastName, email, password, roles...); err != nil {
+			return err
+		}
+		userID, err := usecases.FindUserByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+		account, err := usecases.GetUserAccount(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return a.printAccount(account)
+
+	case "reset-password":
+		if len(args) != 1 {
+			return errAdminUsage
+		}
+		usecases, userID, err := a.openUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		reset, err := usecases.ForcePasswordReset(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return a.out.message(reset,
+			"forced user %s to reset its password, its sessions are revoked\nrecovery token, valid until %s: %s",
+			userID, formatTime(reset.ExpiresAt), reset.RecoveryToken)
+
+	case "disable", "enable":
+		if len(args) != 1 {
+			return errAdminUsage
+		}
+		usecases, userID, err := a.openUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if command == "enable" {
+			if err := usecases.EnableUser(ctx, userID); err != nil {
+				return err
+			}
+			return a.out.message(map[string]any{"user_id": userID.String(), "disabled": false},
+				"enabled user %s", userID)
+		}
+		if err := usecases.DisableUser(ctx, userID); err != nil {
+			return err
+		}
+		return a.out.message(map[string]any{"user_id": userID.String(), "disabled": true},
+			"disabled user %s, its sessions are revoked", userID)
+
+	default:
+		return errAdminUsage
+	}
+}
+
+// printAccount prints the account of a user
+func (a *admin) printAccount(account *betalinkauth.UserAccountData) error {
+	return a.out.table(account, []string{"ID", "EMAIL", "VERIFIED", "FIRST NAME", "LAST NAME", "PASSWORD", "ROLES", "CREATED AT", "DISABLED AT"}, [][]string{{
+		account.UserID, account.Email, strconv.FormatBool(account.EmailVerified), account.FirstName, account.LastName,
+		account.HashAlgorithm, strings.Join(account.Roles, ","), formatTime(account.CreatedAt), formatDisabledAt(account.DisabledAt),
+	}})
+}
+
+// sessions runs the sessions commands
+func (a *admin) sessions(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "list":
+		if len(args) != 1 {
+			return errAdminUsage
+		}
+		usecases, userID, err := a.openUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		sessions, err := usecases.ListUserSessions(ctx, userID)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(sessions))
+		for _, session := range sessions {
+			rows = append(rows, []string{
+				session.SessionID, session.LoginMethod, session.Device, session.IPAddress, session.ClientID,
+				formatTime(session.CreatedAt), formatTime(session.LastUsedAt), formatTime(session.ExpiresAt),
+			})
+		}
+		return a.out.table(sessions, []string{"ID", "LOGIN", "DEVICE", "IP ADDRESS", "CLIENT", "CREATED AT", "LAST USED AT", "EXPIRES AT"}, rows)
+
+	case "revoke":
+		if len(args) != 1 {
+			return errAdminUsage
+		}
+		sessionID, err := uuid.Parse(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid session ID %q", args[0])
+		}
+		usecases, err := a.open(ctx)
+		if err != nil {
+			return err
+		}
+		if err := usecases.RevokeSession(ctx, pgtype.UUID{Bytes: sessionID, Valid: true}); err != nil {
+			return err
+		}
+		return a.out.message(map[string]any{"session_id": sessionID.String(), "revoked": true},
+			"revoked session %s", sessionID)
+
+	case "revoke-all":
+		if len(args) != 1 {
+			return errAdminUsage
+		}
+		usecases, userID, err := a.openUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		revoked, err := usecases.RevokeUserSessions(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return a.out.message(map[string]any{"user_id": userID.String(), "revoked": revoked},
+			"revoked %d sessions of user %s", revoked, userID)
+
+	default:
+		return errAdminUsage
+	}
+}
+
+// roles runs the roles commands
+func (a *admin) roles(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "list":
+		if len(args) != 0 {
+			return errAdminUsage
+		}
+		usecases, err := a.open(ctx)
+		if err != nil {
+			return err
+		}
+		roles, err := usecases.ListRoles(ctx)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(roles))
+		for _, role := range roles {
+			maxSessions := "-"
+			if role.MaxSessions != nil {
+				maxSessions = strconv.Itoa(int(*role.MaxSessions))
+			}
+			rows = append(rows, []string{role.Name, role.Description, maxSessions, strings.Join(role.Permissions, ",")})
+		}
+		return a.out.table(roles, []string{"NAME", "DESCRIPTION", "MAX SESSIONS", "PERMISSIONS"}, rows)
+
+	case "assign", "unassign":
+		if len(args) != 2 {
+			return errAdminUsage
+		}
+		usecases, userID, err := a.openUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		role := args[1]
+		if command == "unassign" {
+			if err := usecases.UnassignRole(ctx, userID, role); err != nil {
+				return err
+			}
+			return a.out.message(map[string]any{"user_id": userID.String(), "role": role, "assigned": false},
+				"unassigned role %s from user %s", role, userID)
+		}
+		if err := usecases.AssignRole(ctx, userID, role); err != nil {
+			return err
+		}
+		return a.out.message(map[string]any{"user_id": userID.String(), "role": role, "assigned": true},
+			"assigned role %s to user %s", role, userID)
+
+	default:
+		return errAdminUsage
+	}
+}
+
+// keys runs the keys commands, which do not need the database
+func (a *admin) keys(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "list":
+		if len(args) != 0 {
+			return errAdminUsage
+		}
+		type signingKey struct {
+			ID     string `json:"kid"`
+			File   string `json:"file"`
+			Active bool   `json:"active"`
+		}
+		keys := []signingKey{}
+		files := []string{os.Getenv(signingKeyFileEnv)}
+		files = append(files, strings.Split(os.Getenv(previousSigningKeyFilesEnv), ",")...)
+		for i, file := range files {
+			if file = strings.TrimSpace(file); file == "" {
+				continue
+			}
+			key, err := betalinkauth.LoadSigningKey(file)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, signingKey{ID: key.ID, File: file, Active: i == 0})
+		}
+		rows := make([][]string, 0, len(keys))
+		for _, key := range keys {
+			state := "previous"
+			if key.Active {
+				state = "active"
+			}
+			rows = append(rows, []string{key.ID, state, key.File})
+		}
+		return a.out.table(keys, []string{"KID", "STATE", "FILE"}, rows)
+
+	case "generate":
+		if len(args) != 1 {
+			return errAdminUsage
+		}
+		key, err := betalinkauth.GenerateSigningKey()
+		if err != nil {
+			return err
+		}
+		encoded, err := key.EncodePEM()
+		if err != nil {
+			return err
+		}
+		// never overwrite a key, the tokens it signed could no longer
+		// be verified
+		file, err := os.OpenFile(args[0], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("could not create key file: %w", err)
+		}
+		if _, err := file.Write(encoded); err != nil {
+			file.Close()
+			return fmt.Errorf("could not write key file: %w", err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("could not write key file: %w", err)
+		}
+		return a.out.message(map[string]any{"kid": key.ID, "file": args[0]},
+			"wrote signing key %s to %s", key.ID, args[0])
+
+	default:
+		return errAdminUsage
+	}
+}
+
+// issuedTokens are the tokens issued by the tokens issue command
+type issuedTokens struct {
+	AccessToken      string    `json:"access_token"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// tokens runs the tokens commands
+func (a *admin) tokens(ctx context.Context, command string, args []string) error {
+	if len(args) != 1 {
+		return errAdminUsage
+	}
+	switch command {
+	case "issue":
+		usecases, userID, err := a.openUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		tokens, err := usecases.IssueUserTokens(ctx, userID, betalinkauth.SessionMetadata{UserAgent: "betalink-auth admin"})
+		if err != nil {
+			return err
+		}
+		issued := issuedTokens{
+			AccessToken:      tokens.AccessToken,
+			RefreshToken:     tokens.RefreshToken,
+			RefreshExpiresAt: tokens.RefreshExpiresAt,
+		}
+		return a.out.table(issued, []string{"TOKEN", "VALUE"}, [][]string{
+			{"access", issued.AccessToken},
+			{"refresh", issued.RefreshToken},
+		})
+
+	case "inspect":
+		usecases, err := a.open(ctx)
+		if err != nil {
+			return err
+		}
+		introspection, err := usecases.IntrospectToken(ctx, args[0], "")
+		if err != nil {
+			return err
+		}
+		rows := [][]string{{"active", strconv.FormatBool(introspection.Active)}}
+		if introspection.Active {
+			rows = append(rows,
+				[]string{"type", introspection.TokenType},
+				[]string{"subject", introspection.Subject},
+				[]string{"issued at", formatTime(time.Unix(introspection.IssuedAt, 0))},
+				[]string{"expires at", formatTime(time.Unix(introspection.ExpiresAt, 0))},
+				[]string{"client", introspection.ClientID},
+				[]string{"scope", introspection.Scope},
+				[]string{"roles", strings.Join(introspection.Roles, ",")},
+				[]string{"permissions", strings.Join(introspection.Permissions, ",")},
+			)
+		}
+		return a.out.table(introspection, []string{"CLAIM", "VALUE"}, rows)
+
+	case "revoke":
+		usecases, err := a.open(ctx)
+		if err != nil {
+			return err
+		}
+		if err := usecases.RevokeToken(ctx, nil, args[0], ""); err != nil {
+			return err
+		}
+		return a.out.message(map[string]any{"revoked": true}, "revoked token")
+
+	default:
+		return errAdminUsage
+	}
+}
+
+// openUser opens the usecases and returns the ID of a user given by ID
+// or by email
+func (a *admin) openUser(ctx context.Context, user string) (*betalinkauth.Usecases, pgtype.UUID, error) {
+	usecases, err := a.open(ctx)
+	if err != nil {
+		return nil, pgtype.UUID{}, err
+	}
+	if userID, err := uuid.Parse(user); err == nil {
+		return usecases, pgtype.UUID{Bytes: userID, Valid: true}, nil
+	}
+	userID, err := usecases.FindUserByEmail(ctx, user)
+	if err != nil {
+		return nil, pgtype.UUID{}, err
+	}
+	return usecases, userID, nil
+}
+
+// readPassword reads a password from the first line of the standard
+// input, so that it does not show in the process list
+func readPassword(stdin io.Reader) (string, error) {
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("could not read password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("no password on the standard input")
+	}
+	return password, nil
+}
+
+// formatTime formats the times printed in the tables
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatDisabledAt formats the time a user was disabled at, if it is
+func formatDisabledAt(disabledAt *time.Time) string {
+	if disabledAt == nil {
+		return "-"
+	}
+	return formatTime(*disabledAt)
+}
+
+// adminOutput prints the results of the admin commands, as tables or
+// as JSON
+type adminOutput struct {
+	w    io.Writer
+	json bool
+}
+
+// table prints value as JSON, or the given rows as a table
+func (o adminOutput) table(value any, header []string, rows [][]string) error {
+	if o.json {
+		return o.printJSON(value)
+	}
+	table := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	return table.Flush()
+}
+
+// message prints value as JSON, or a message
+func (o adminOutput) message(value any, format string, args ...any) error {
+	if o.json {
+		return o.printJSON(value)
+	}
+	_, err := fmt.Fprintf(o.w, format+"\n", args...)
+	return err
+}
+
+// printJSON prints an indented JSON value
+func (o adminOutput) printJSON(value any) error {
+	encoder := json.NewEncoder(o.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const keyringUsage = `usage: betalink-auth keyring [-file path] <command>
+
+Manages the keyring encrypting the secrets stored in the database.
+
+commands:
+  generate         create a keyring with a new primary key
+  list             list the keys of the keyring
+  rotate           add a new primary key, the previous keys still decrypt
+  reencrypt        encrypt the stored secrets with the primary key
+  retire <key-id>  remove a key, once the secrets have been re-encrypted
+
+The running services must be restarted to use the updated keyring
+before reencrypt is run, and after a key is retired.
+`
+
+// keyringCommand runs the keyring subcommand with its arguments, and
+// returns the exit code of the process
+func keyringCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("keyring", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, keyringUsage) }
+	path := flags.String("file", os.Getenv(keyringFileEnv), "keyring file, defaults to $"+keyringFileEnv)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var err error
+	switch command := flags.Arg(0); command {
+	case "generate":
+		err = generateKeyring(*path, stdout)
+	case "list":
+		err = listKeyring(*path, stdout)
+	case "rotate":
+		err = rotateKeyring(*path, stdout)
+	case "reencrypt":
+		err = reencryptSecrets(*path, stdout)
+	case "retire":
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return 2
+		}
+		err = retireKey(*path, flags.Arg(1), stdout)
+	default:
+		fmt.Fprintf(stderr, "unknown keyring command %q\n", command)
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// generateKeyring creates a new keyring file, never overwriting one as
+// the secrets it encrypted would be lost
+func generateKeyring(path string, stdout io.Writer) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("keyring %s already exists", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not check keyring: %w", err)
+	}
+	key, err := betalinkauth.GenerateEncryptionKey()
+	if err != nil {
+		return err
+	}
+	if err := betalinkauth.NewKeyring(key).Save(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "created keyring %s with primary key %s\n", path, key.ID)
+	return nil
+}
+
+// listKeyring prints the keys of a keyring, oldest first
+func listKeyring(path string, stdout io.Writer) error {
+	keyring, err := betalinkauth.LoadKeyring(path)
+	if err != nil {
+		return err
+	}
+	for _, keyID := range keyring.KeyIDs() {
+		if keyID == keyring.PrimaryKeyID() {
+			fmt.Fprintf(stdout, "%s (primary)\n", keyID)
+		} else {
+			fmt.Fprintln(stdout, keyID)
+		}
+	}
+	return nil
+}
+
+// rotateKeyring adds a new primary key to a keyring
+func rotateKeyring(path string, stdout io.Writer) error {
+	keyring, err := betalinkauth.LoadKeyring(path)
+	if err != nil {
+		return err
+	}
+	key, err := betalinkauth.GenerateEncryptionKey()
+	if err != nil {
+		return err
+	}
+	keyring.Rotate(key)
+	if err := keyring.Save(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "rotated keyring %s, new primary key %s\n", path, key.ID)
+	return nil
+}
+
+// retireKey removes a key from a keyring
+func retireKey(path, keyID string, stdout io.Writer) error {
+	keyring, err := betalinkauth.LoadKeyring(path)
+	if err != nil {
+		return err
+	}
+	if err := keyring.Retire(keyID); err != nil {
+		return err
+	}
+	if err := keyring.Save(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "retired key %s from keyring %s\n", keyID, path)
+	return nil
+}
+
+// reencryptSecrets encrypts the secrets stored in the database with the
+// primary key of a keyring
+func reencryptSecrets(path string, stdout io.Writer) error {
+	keyring, err := betalinkauth.LoadKeyring(path)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return fmt.Errorf("could not connect to database: %w", err)
+	}
+	defer pool.Close()
+
+	report, err := betalinkauth.ReencryptSecrets(ctx, betalinkauth.New(pool), keyring)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "re-encrypted with key %s: %d provider tokens, %d provider secrets, %d code verifiers\n",
+		keyring.PrimaryKeyID(), report.ProviderTokens, report.ProviderSecrets, report.CodeVerifiers)
+	if report.ConcurrentlyChanged > 0 {
+		fmt.Fprintf(stdout, "%d secrets changed meanwhile, run reencrypt again to check them\n", report.ConcurrentlyChanged)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	betalinklogger "github.com/BragdonD/betalink-logger"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pressly/goose/v3"
+)
+
+const migrateUsage = `usage: betalink-auth migrate [-sqlite path] <command>
+
+Applies the database migrations embedded in the binary, to the postgres
+database or to the SQLite one if a path is given.
+
+commands:
+  up      apply all the pending migrations
+  down    roll back the last applied migration
+  status  list the migrations and whether they are applied
+  redo    roll back the last applied migration and apply it again
+`
+
+// migrateCommand runs the migrate subcommand with its arguments, and
+// returns the exit code of the process
+func migrateCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, migrateUsage) }
+	sqlitePath := flags.String("sqlite", os.Getenv(sqlitePathEnv), "SQLite database file, defaults to $"+sqlitePathEnv)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var run func(context.Context, *goose.Provider, io.Writer) error
+	switch command := flags.Arg(0); command {
+	case "up":
+		run = migrateUp
+	case "down":
+		run = migrateDown
+	case "status":
+		run = migrateStatus
+	case "redo":
+		run = migrateRedo
+	default:
+		fmt.Fprintf(stderr, "unknown migrate command %q\n", command)
+		flags.Usage()
+		return 2
+	}
+
+	ctx := context.Background()
+	migrations, err := openMigrations(*sqlitePath)
+	if err == nil {
+		err = run(ctx, migrations, stdout)
+		migrations.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// openMigrations returns the migrations of the SQLite database if a
+// path is given, and of the postgres one otherwise. Closing them closes
+// the database.
+func openMigrations(sqlitePath string) (*goose.Provider, error) {
+	if sqlitePath != "" {
+		db, err := betalinkauth.OpenSQLite(sqlitePath)
+		if err != nil {
+			return nil, err
+		}
+		migrations, err := betalinkauth.NewSQLiteMigrations(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return migrations, nil
+	}
+
+	config, err := pgx.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database URL: %w", err)
+	}
+	db := stdlib.OpenDB(*config)
+	migrations, err := betalinkauth.NewPostgresMigrations(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return migrations, nil
+}
+
+// applyMigrations applies the pending migrations on start
+func applyMigrations(logger *betalinklogger.Logger, migrations *goose.Provider) error {
+	logger.Info("Applying database migrations")
+	results, err := migrations.Up(context.Background())
+	for _, result := range results {
+		logger.Info(result.String())
+	}
+	if err != nil {
+		return fmt.Errorf("could not apply migrations: %w", err)
+	}
+	return nil
+}
+
+// migrateUp applies the pending migrations
+func migrateUp(ctx context.Context, migrations *goose.Provider, stdout io.Writer) error {
+	results, err := migrations.Up(ctx)
+	for _, result := range results {
+		fmt.Fprintln(stdout, result)
+	}
+	if err != nil {
+		return fmt.Errorf("could not apply migrations: %w", err)
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(stdout, "no pending migration")
+	}
+	return nil
+}
+
+// migrateDown rolls back the last applied migration
+func migrateDown(ctx context.Context, migrations *goose.Provider, stdout io.Writer) error {
+	result, err := migrations.Down(ctx)
+	if errors.Is(err, goose.ErrNoNextVersion) {
+		fmt.Fprintln(stdout, "no applied migration")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not roll back migration: %w", err)
+	}
+	fmt.Fprintln(stdout, result)
+	return nil
+}
+
+// migrateStatus prints the migrations, oldest first
+func migrateStatus(ctx context.Context, migrations *goose.Provider, stdout io.Writer) error {
+	statuses, err := migrations.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get migration status: %w", err)
+	}
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "MIGRATION\tSTATE\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if status.State == goose.StateApplied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\n", status.Source.Path, status.State, appliedAt)
+	}
+	return table.Flush()
+}
+
+// migrateRedo rolls back the last applied migration and applies it
+// again
+func migrateRedo(ctx context.Context, migrations *goose.Provider, stdout io.Writer) error {
+	down, err := migrations.Down(ctx)
+	if errors.Is(err, goose.ErrNoNextVersion) {
+		fmt.Fprintln(stdout, "no applied migration")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not roll back migration: %w", err)
+	}
+	fmt.Fprintln(stdout, down)
+	up, err := migrations.ApplyVersion(ctx, down.Source.Version, true)
+	if err != nil {
+		return fmt.Errorf("could not apply migration: %w", err)
+	}
+	fmt.Fprintln(stdout, up)
+	return nil
+}
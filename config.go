@@ -0,0 +1,179 @@
+package betalinkauth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionPolicy defines how long a session stays valid
+type SessionPolicy struct {
+	// IdleTimeout is how long a session stays valid without being used.
+	// Each use of the session extends it by this duration.
+	IdleTimeout time.Duration
+	// AbsoluteLifetime is the maximum lifetime of a session, after which
+	// the user has to login again no matter how active the session is
+	AbsoluteLifetime time.Duration
+}
+
+// SessionLimitPolicy defines what happens when a user opens a session
+// while already holding the maximum number of active sessions
+type SessionLimitPolicy string
+
+const (
+	// SessionLimitReject rejects the new login
+	SessionLimitReject SessionLimitPolicy = "reject"
+	// SessionLimitEvictOldest closes the oldest active sessions to make
+	// room for the new one
+	SessionLimitEvictOldest SessionLimitPolicy = "evict_oldest"
+)
+
+// SessionConfig holds the session policies of the auth service
+type SessionConfig struct {
+	// Default is the policy applied to regular sessions
+	Default SessionPolicy
+	// RememberMe is the policy applied to sessions opened with
+	// the "remember me" option
+	RememberMe SessionPolicy
+	// MaxSessions is the maximum number of active sessions a user may
+	// hold, unless the user or one of its roles has its own limit.
+	// Zero means no limit.
+	MaxSessions int32
+	// LimitPolicy is applied when a user reaches its session limit
+	LimitPolicy SessionLimitPolicy
+}
+
+// DefaultSessionConfig is the session configuration used when
+// none is provided
+var DefaultSessionConfig = SessionConfig{
+	Default: SessionPolicy{
+		IdleTimeout:      time.Hour * 24,
+		AbsoluteLifetime: time.Hour * 24 * 7,
+	},
+	RememberMe: SessionPolicy{
+		IdleTimeout:      time.Hour * 24 * 14,
+		AbsoluteLifetime: time.Hour * 24 * 30,
+	},
+	MaxSessions: 0,
+	LimitPolicy: SessionLimitReject,
+}
+
+// policy returns the session policy to apply to a session
+func (c SessionConfig) policy(rememberMe bool) SessionPolicy {
+	if rememberMe {
+		return c.RememberMe
+	}
+	return c.Default
+}
+
+// UsecaseOption configures a Usecases instance
+type UsecaseOption func(*Usecases)
+
+// WithSessionConfig sets the session policies used by the usecases
+func WithSessionConfig(config SessionConfig) UsecaseOption {
+	return func(u *Usecases) {
+		u.sessions = config
+	}
+}
+
+// WithKeySet sets the keys signing and verifying access tokens
+func WithKeySet(keys *KeySet) UsecaseOption {
+	return func(u *Usecases) {
+		u.keys = keys
+	}
+}
+
+// WithKeyring sets the keyring encrypting the secrets stored in the
+// database, such as the tokens and client secrets of external providers
+func WithKeyring(keyring *Keyring) UsecaseOption {
+	return func(u *Usecases) {
+		u.keyring = keyring
+	}
+}
+
+// WithHTTPClient sets the client of the requests sent to the external
+// providers users log in with
+func WithHTTPClient(client *http.Client) UsecaseOption {
+	return func(u *Usecases) {
+		u.relyingParty = newRelyingParty(client)
+	}
+}
+
+// WithCredentialBackend sets the backend verifying the passwords of the
+// accounts whose login data is flagged with a hash algorithm, such as an
+// LDAP directory for HashAlgorithmLDAP
+func WithCredentialBackend(hashAlgorithm string, backend CredentialBackend) UsecaseOption {
+	return func(u *Usecases) {
+		u.credentialBackends[hashAlgorithm] = backend
+	}
+}
+
+// WithStore sets the store of the users, login data, roles, sessions
+// and revoked access tokens, the queries by default. The features outside
+// the Store interface keep using the queries, they fail with an
+// UnsupportedError when there are none.
+func WithStore(store Store) UsecaseOption {
+	return func(u *Usecases) {
+		u.store = store
+	}
+}
+
+// DefaultIssuer is the OpenID Connect issuer used when none is provided
+const DefaultIssuer = "http://localhost:8080"
+
+// WithIssuer sets the OpenID Connect issuer, the public base URL of the
+// auth service. It identifies the issuer of the ID tokens and prefixes
+// the endpoints advertised by the discovery document.
+func WithIssuer(issuer string) UsecaseOption {
+	return func(u *Usecases) {
+		u.issuer = strings.TrimSuffix(issuer, "/")
+	}
+}
+
+// DefaultPolicyReloadInterval is the default time between two checks
+// for changed access policies
+const DefaultPolicyReloadInterval = time.Second * 30
+
+// JanitorConfig defines how the janitor cleans up the database
+type JanitorConfig struct {
+	// Interval is the time between two cleanups
+	Interval time.Duration
+	// BatchSize is the maximum number of rows deleted per statement,
+	// keeping each statement short so it never holds locks for long
+	BatchSize int32
+	// TokenMaxAge is how long an unused email verification or
+	// password recovery token is kept
+	TokenMaxAge time.Duration
+	// LoginEventMaxAge is how long the login attempts of the users are
+	// kept in their login history, forever when zero
+	LoginEventMaxAge time.Duration
+}
+
+// validate checks that the janitor can run with the configuration: the
+// ticker of Run panics without a positive interval and the cleanup never
+// ends without a positive batch size
+func (c JanitorConfig) validate() error {
+	if c.Interval <= 0 {
+		return fmt.Errorf("janitor interval must be positive, got %s", c.Interval)
+	}
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("janitor batch size must be positive, got %d", c.BatchSize)
+	}
+	if c.TokenMaxAge < 0 {
+		return fmt.Errorf("janitor token max age must not be negative, got %s", c.TokenMaxAge)
+	}
+	if c.LoginEventMaxAge < 0 {
+		return fmt.Errorf("janitor login event max age must not be negative, got %s", c.LoginEventMaxAge)
+	}
+	return nil
+}
+
+// DefaultJanitorConfig is the janitor configuration used when
+// none is provided
+var DefaultJanitorConfig = JanitorConfig{
+	Interval:         time.Minute * 20,
+	BatchSize:        1000,
+	TokenMaxAge:      time.Hour * 24,
+	LoginEventMaxAge: time.Hour * 24 * 90,
+}
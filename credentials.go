@@ -0,0 +1,125 @@
+package betalinkauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Hash algorithms of the login data, naming the credential backend
+// verifying the passwords of the accounts
+const (
+	// HashAlgorithmBcrypt flags the accounts whose bcrypt password hash
+	// is stored by the service
+	HashAlgorithmBcrypt = "BCRYPT"
+	// HashAlgorithmLDAP flags the accounts whose password is verified by
+	// an LDAP directory, the service stores no hash
+	HashAlgorithmLDAP = "LDAP"
+)
+
+// ErrInvalidPassword is returned by the credential backends when the
+// password of an account is wrong
+var ErrInvalidPassword = errors.New("invalid password")
+
+// CredentialProfile is the profile of a user known to a credential
+// backend, such as the entry of a directory
+type CredentialProfile struct {
+	FirstName string
+	LastName  string
+}
+
+// CredentialBackend verifies the passwords of the accounts flagged with
+// its hash algorithm
+type CredentialBackend interface {
+	// Authenticate verifies the password of the account of the login
+	// data. It returns an error wrapping ErrInvalidPassword when the
+	// password is wrong, and may return the profile of the user.
+	Authenticate(ctx context.Context, loginData Userslogindatum, password string) (*CredentialProfile, error)
+}
+
+// credentialDirectory is a credential backend whose users can be looked
+// up by email, to create their accounts before they log in
+type credentialDirectory interface {
+	CredentialBackend
+	// Lookup returns the profile of the user of an email, or an error
+	// wrapping ErrDirectoryUserNotFound
+	Lookup(ctx context.Context, email string) (*CredentialProfile, error)
+}
+
+// ErrDirectoryUserNotFound is returned by the directories when no user
+// has an email
+var ErrDirectoryUserNotFound = errors.New("user not found in directory")
+
+// bcryptBackend verifies the passwords against the bcrypt hashes stored
+// in the login data
+type bcryptBackend struct{}
+
+// Authenticate implements CredentialBackend
+func (bcryptBackend) Authenticate(_ context.Context, loginData Userslogindatum, password string) (*CredentialProfile, error) {
+	if err := ComparePassword(password, loginData.Passwordhash); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPassword, err)
+	}
+	return nil, nil
+}
+
+// CreateLDAPUser creates the account of a user of the LDAP directory,
+// named after its entry. The user logs in with the password of the
+// directory.
+func (u *Usecases) CreateLDAPUser(ctx context.Context, email string) error {
+	directory, ok := u.credentialBackends[HashAlgorithmLDAP].(credentialDirectory)
+	if !ok {
+		return &NotFoundError{Message: "no LDAP directory is configured"}
+	}
+	if ok, err := ValidateEmail(email); !ok {
+		return &ValidationError{
+			Message: fmt.Errorf("could not validate email: %w", err).Error(),
+		}
+	}
+	if err := checkEmailUniqueness(ctx, u.store, email); err != nil {
+		return err
+	}
+	profile, err := directory.Lookup(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrDirectoryUserNotFound) {
+			return &NotFoundError{
+				Message: fmt.Sprintf("no user with email [%s] in the LDAP directory", email),
+			}
+		}
+		return &ServerError{
+			Message: fmt.Errorf("could not look up LDAP user: %w", err).Error(),
+		}
+	}
+
+	return u.store.InTx(ctx, func(store Store) error {
+		userID, err := store.CreateUser(ctx, CreateUserParams{
+			FirstName: profile.FirstName,
+			LastName:  profile.LastName,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not create user: %w", err).Error(),
+			}
+		}
+		// the directory keeps the password, no hash is stored
+		err = store.CreateUserLoginData(ctx, CreateUserLoginDataParams{
+			UserID:        userID,
+			Email:         email,
+			Hashalgorithm: HashAlgorithmLDAP,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not create login data: %w", err).Error(),
+			}
+		}
+		err = store.AssignUserRole(ctx, AssignUserRoleParams{
+			UserID:   userID,
+			RoleName: DefaultRole,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not assign default role: %w", err).Error(),
+			}
+		}
+		return nil
+	})
+}
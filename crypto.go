@@ -1,6 +1,7 @@
 package betalinkauth
 
 import (
+	"encoding/base64"
 	"fmt"
 	"time"
 
@@ -83,41 +84,24 @@ func ValidateAccessToken(token, secret string) (jwt.MapClaims, error) {
 	return claims, nil
 }
 
-// GenerateRefreshToken generates a refresh token
-func GenerateRefreshToken(sessionID string, createdAt, expiresAt time.Time, secret string) (string, error) {
-	// Define claims
-	claims := map[string]interface{}{
-		"session_id": sessionID,
-		"exp":        expiresAt.Unix(),
-		"iat":        createdAt.Unix(),
-		"iss":        "betalink-auth",
-		"aud":        "betalink",
-	}
+// refreshTokenSize is the number of random bytes of a refresh token
+const refreshTokenSize = 32
 
-	// Generate the JWT using the helper function
-	return GenerateJWT(claims, secret)
+// GenerateRefreshToken generates an opaque refresh token. The session it
+// is issued for only stores its hash, see HashRefreshToken.
+func GenerateRefreshToken() (string, error) {
+	return randomString(refreshTokenSize)
 }
 
-// ValidateRefreshToken validates a refresh token
-func ValidateRefreshToken(token, secret string) (jwt.MapClaims, error) {
-	// Parse the token
-	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("could not parse token: %w", err)
-	}
-
-	// Check if the token is valid
-	if !parsedToken.Valid {
-		return nil, fmt.Errorf("token is invalid")
-	}
-
-	// Extract the claims
-	claims, ok := parsedToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("could not extract claims from token")
-	}
+// HashRefreshToken returns the hash of a refresh token, as stored on the
+// session it was issued for
+func HashRefreshToken(token string) string {
+	return hashSecret(token)
+}
 
-	return claims, nil
+// isRefreshToken reports whether a token has the shape of the refresh
+// tokens of GenerateRefreshToken
+func isRefreshToken(token string) bool {
+	bytes, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(bytes) == refreshTokenSize
 }
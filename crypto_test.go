@@ -33,7 +33,7 @@ func TestGenerateJWT(t *testing.T) {
 		"user_id": "12345",
 		"roles":   []string{"admin", "user"},
 	}
-	secret := "mysecret"
+	secret := "test-secret"
 
 	token, err := betalinkauth.GenerateJWT(data, secret)
 	assert.NoError(t, err)
@@ -43,7 +43,7 @@ func TestGenerateJWT(t *testing.T) {
 func TestGenerateAccessToken(t *testing.T) {
 	userID := "12345"
 	roles := []string{"admin", "user"}
-	secret := "mysecret"
+	secret := "test-secret"
 
 	token, err := betalinkauth.GenerateAccessToken(userID, roles, secret, time.Hour)
 	assert.NoError(t, err)
@@ -69,7 +69,7 @@ func TestGenerateAccessToken(t *testing.T) {
 func TestValidateAccessToken(t *testing.T) {
 	userID := "12345"
 	roles := []string{"admin", "user"}
-	secret := "mysecret"
+	secret := "test-secret"
 
 	token, err := betalinkauth.GenerateAccessToken(userID, roles, secret, time.Hour)
 	assert.NoError(t, err)
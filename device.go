@@ -0,0 +1,47 @@
+package betalinkauth
+
+import (
+	"strings"
+
+	"github.com/mssola/useragent"
+)
+
+// unknownDevice is the label used when a user agent cannot be parsed
+const unknownDevice = "Unknown device"
+
+// ParseDeviceLabel parses a user agent and returns a short human readable
+// label describing the client device, e.g. "Chrome on Windows 10" or
+// "Mobile Safari on iPhone OS 17.2".
+func ParseDeviceLabel(userAgent string) string {
+	userAgent = strings.TrimSpace(userAgent)
+	if userAgent == "" {
+		return unknownDevice
+	}
+
+	ua := useragent.New(userAgent)
+	browser, _ := ua.Browser()
+	if ua.Bot() {
+		if browser == "" {
+			return "Bot"
+		}
+		return "Bot (" + browser + ")"
+	}
+
+	osInfo := ua.OSInfo()
+	osName := strings.TrimSpace(osInfo.Name + " " + osInfo.Version)
+	if browser == "" && osName == "" {
+		return unknownDevice
+	}
+	if ua.Mobile() && browser != "" && !strings.HasPrefix(browser, "Mobile") {
+		browser = "Mobile " + browser
+	}
+
+	switch {
+	case browser == "":
+		return osName
+	case osName == "":
+		return browser
+	default:
+		return browser + " on " + osName
+	}
+}
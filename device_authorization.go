@@ -0,0 +1,349 @@
+package betalinkauth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// GrantTypeDeviceCode is the grant type of the clients polling for
+	// the tokens of a device authorization (RFC 8628 section 3.4)
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// deviceAuthorizationValidity is how long the user has to approve a
+	// device authorization request
+	deviceAuthorizationValidity = time.Minute * 10
+	// devicePollingInterval is the initial minimum interval between two
+	// polls of the client, raised by slowDownIncrement each time it
+	// polls too fast (RFC 8628 section 3.5)
+	devicePollingInterval = time.Second * 5
+	slowDownIncrement     = time.Second * 5
+	// deviceCodeSize is the size in bytes of device codes
+	deviceCodeSize = 32
+
+	// userCodeCharset are the characters of user codes: upper case
+	// consonants, easy to type and unlikely to spell words (RFC 8628
+	// section 6.1). A user code of 8 of them has about 34 bits of entropy.
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength  = 8
+
+	deviceAuthorizationPending  = "pending"
+	deviceAuthorizationApproved = "approved"
+	deviceAuthorizationDenied   = "denied"
+)
+
+// DeviceAuthorization is the response to a device authorization
+// request, the user code being shown to the user by the device (RFC 8628
+// section 3.2)
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceRequest is a pending device authorization request, as shown to
+// the user approving it
+type DeviceRequest struct {
+	UserCode string     `json:"user_code"`
+	Client   ClientData `json:"client"`
+	Scopes   []string   `json:"scopes"`
+}
+
+// RequestDeviceAuthorization starts the device authorization of a
+// client, returning the device code it polls the token endpoint with
+// and the user code the user approves it with. The scope defaults to
+// the scopes allowed for the client.
+func (u *Usecases) RequestDeviceAuthorization(ctx context.Context, client *ClientData, scope string, metadata SessionMetadata) (*DeviceAuthorization, error) {
+	queries, err := u.postgresQueries("Device authorizations")
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(client.GrantTypes, GrantTypeDeviceCode) {
+		return nil, &OAuthError{
+			Code:        OAuthErrorUnauthorizedClient,
+			Description: "the client may not use the device authorization grant",
+		}
+	}
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, &OAuthError{
+				Code:        OAuthErrorInvalidScope,
+				Description: fmt.Sprintf("scope [%s] is not allowed for the client", scope),
+			}
+		}
+	}
+
+	deviceCode, err := randomString(deviceCodeSize)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not generate device code: %w", err).Error(),
+		}
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not generate user code: %w", err).Error(),
+		}
+	}
+	// the device metadata is recorded on the session opened once the
+	// request is approved
+	sessionParams := u.newCreateSessionParams(pgtype.UUID{}, LoginMethodPassword, false, metadata)
+	err = queries.CreateDeviceAuthorization(ctx, CreateDeviceAuthorizationParams{
+		DeviceCodeHash:  hashSecret(deviceCode),
+		UserCode:        userCode,
+		ClientID:        client.ClientID,
+		Scope:           strings.Join(scopes, " "),
+		PollingInterval: int32(devicePollingInterval.Seconds()),
+		IpAddress:       sessionParams.IpAddress,
+		UserAgent:       sessionParams.UserAgent,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(deviceAuthorizationValidity),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not create device authorization: %w", err).Error(),
+		}
+	}
+
+	verificationURI := u.issuer + "/oauth/device"
+	displayedCode := formatUserCode(userCode)
+	return &DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                displayedCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + displayedCode,
+		ExpiresIn:               int(deviceAuthorizationValidity.Seconds()),
+		Interval:                int(devicePollingInterval.Seconds()),
+	}, nil
+}
+
+// GetDeviceRequest returns the pending device authorization request of
+// a user code, typed with or without separator and in any case
+func (u *Usecases) GetDeviceRequest(ctx context.Context, userCode string) (*DeviceRequest, error) {
+	queries, err := u.postgresQueries("Device authorizations")
+	if err != nil {
+		return nil, err
+	}
+	authorization, err := queries.GetPendingDeviceAuthorization(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, InvalidUserCodeError
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not get device authorization: %w", err).Error(),
+		}
+	}
+	client, err := u.getClient(ctx, authorization.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceRequest{
+		UserCode: formatUserCode(authorization.UserCode),
+		Client:   *client,
+		Scopes:   strings.Fields(authorization.Scope),
+	}, nil
+}
+
+// ApproveDevice checks the credentials of the user approving a device
+// authorization request, the tokens being issued to the user. The
+// metadata is the one of the browser of the user, recorded with its
+// wrong passwords.
+func (u *Usecases) ApproveDevice(ctx context.Context, userCode, email, password string, metadata SessionMetadata) error {
+	userID, err := u.checkPassword(ctx, email, password, metadata)
+	if err != nil {
+		return err
+	}
+	return u.decideDeviceAuthorization(ctx, userCode, userID, true)
+}
+
+// DenyDevice denies a device authorization request with the credentials
+// of the user, so that guessing a user code is not enough to cancel the
+// login of someone else
+func (u *Usecases) DenyDevice(ctx context.Context, userCode, email, password string, metadata SessionMetadata) error {
+	userID, err := u.checkPassword(ctx, email, password, metadata)
+	if err != nil {
+		return err
+	}
+	return u.decideDeviceAuthorization(ctx, userCode, userID, false)
+}
+
+// DecideDeviceAuthorization approves or denies a device authorization
+// request on behalf of an authenticated user
+func (u *Usecases) DecideDeviceAuthorization(ctx context.Context, userCode, userID string, allow bool) error {
+	parsedUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return &ValidationError{Message: "invalid UUID format"}
+	}
+	return u.decideDeviceAuthorization(ctx, userCode, pgtype.UUID{Bytes: parsedUUID, Valid: true}, allow)
+}
+
+// decideDeviceAuthorization records the decision of a user on a pending
+// device authorization request
+func (u *Usecases) decideDeviceAuthorization(ctx context.Context, userCode string, userID pgtype.UUID, allow bool) error {
+	queries, err := u.postgresQueries("Device authorizations")
+	if err != nil {
+		return err
+	}
+	status := deviceAuthorizationDenied
+	if allow {
+		status = deviceAuthorizationApproved
+	}
+	decided, err := queries.DecideDeviceAuthorization(ctx, DecideDeviceAuthorizationParams{
+		UserCode: normalizeUserCode(userCode),
+		Status:   status,
+		UserID:   userID,
+		AuthTime: pgtype.Timestamptz{
+			Time:  time.Now(),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not decide device authorization: %w", err).Error(),
+		}
+	}
+	if decided == 0 {
+		return InvalidUserCodeError
+	}
+	return nil
+}
+
+// ExchangeDeviceCode exchanges the device code of an approved device
+// authorization for tokens, opening a session bound to the client.
+// Until the user decides, the client is told to keep polling, and to
+// slow down if it polls faster than the interval.
+func (u *Usecases) ExchangeDeviceCode(ctx context.Context, client *ClientData, deviceCode string) (*IDTokens, error) {
+	queries, err := u.postgresQueries("Device authorizations")
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(client.GrantTypes, GrantTypeDeviceCode) {
+		return nil, &OAuthError{
+			Code:        OAuthErrorUnauthorizedClient,
+			Description: "the client may not use the device authorization grant",
+		}
+	}
+	if deviceCode == "" {
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "device_code is required"}
+	}
+
+	deviceCodeHash := hashSecret(deviceCode)
+	authorization, err := queries.PollDeviceAuthorization(ctx, deviceCodeHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, &OAuthError{Code: OAuthErrorInvalidGrant, Description: "device code is invalid or already used"}
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not poll device authorization: %w", err).Error(),
+		}
+	}
+	if authorization.ClientID != client.ClientID {
+		return nil, &OAuthError{Code: OAuthErrorInvalidGrant, Description: "device code was not issued to this client"}
+	}
+	now := time.Now()
+	if authorization.ExpiresAt.Time.Before(now) {
+		return nil, &OAuthError{Code: OAuthErrorExpiredToken, Description: "device code has expired"}
+	}
+	interval := time.Duration(authorization.PollingInterval) * time.Second
+	if authorization.LastPolledAt.Valid && now.Sub(authorization.LastPolledAt.Time) < interval {
+		err := queries.SlowDownDeviceAuthorization(ctx, SlowDownDeviceAuthorizationParams{
+			DeviceCodeHash:  deviceCodeHash,
+			PollingInterval: int32(slowDownIncrement.Seconds()),
+		})
+		if err != nil {
+			return nil, &ServerError{
+				Message: fmt.Errorf("could not slow down device authorization: %w", err).Error(),
+			}
+		}
+		return nil, &OAuthError{Code: OAuthErrorSlowDown, Description: "the client is polling too fast"}
+	}
+
+	switch authorization.Status {
+	case deviceAuthorizationPending:
+		return nil, &OAuthError{Code: OAuthErrorAuthorizationPending, Description: "the user has not decided yet"}
+	case deviceAuthorizationDenied:
+		if _, err := queries.DeleteDeviceAuthorization(ctx, deviceCodeHash); err != nil {
+			return nil, &ServerError{
+				Message: fmt.Errorf("could not delete device authorization: %w", err).Error(),
+			}
+		}
+		return nil, &OAuthError{Code: OAuthErrorAccessDenied, Description: "the user denied the request"}
+	}
+
+	// the device code can only be exchanged once, by the first of
+	// concurrent polls deleting it
+	deleted, err := queries.DeleteDeviceAuthorization(ctx, deviceCodeHash)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not delete device authorization: %w", err).Error(),
+		}
+	}
+	if deleted == 0 {
+		return nil, &OAuthError{Code: OAuthErrorInvalidGrant, Description: "device code is invalid or already used"}
+	}
+
+	metadata := SessionMetadata{UserAgent: authorization.UserAgent}
+	if authorization.IpAddress != nil {
+		metadata.IPAddress = authorization.IpAddress.String()
+	}
+	params := u.newCreateSessionParams(authorization.UserID, LoginMethodPassword, false, metadata)
+	params.ClientID = pgtype.Text{String: client.ClientID, Valid: true}
+	params.Scope = authorization.Scope
+	// the user authenticated when approving the request, not when the
+	// tokens are issued
+	params.CreatedAt = authorization.AuthTime
+	return u.openSession(ctx, params, "")
+}
+
+// generateUserCode returns a random user code, without separator
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeCharset)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeCharset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// formatUserCode splits a user code in two groups for readability, such
+// as WDJB-MJHT
+func formatUserCode(userCode string) string {
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// normalizeUserCode removes the separators and spaces the user may have
+// typed in a user code, and upper cases it
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
@@ -0,0 +1,183 @@
+package betalinkauth
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/BragdonD/betalink-auth/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// deviceTemplate is the verification page of the device authorization
+// grant, where the user types the code shown by the device
+var deviceTemplate = template.Must(template.ParseFS(templates, "templates/device.html"))
+
+// devicePage is the data of the verification page. Without request, the
+// user is asked for the user code. Once the user decided, only the
+// outcome is shown.
+type devicePage struct {
+	Request  *DeviceRequest
+	UserCode string
+	Email    string
+	Error    string
+	Done     string
+}
+
+// deviceDecisionDto is the data transfer object for deciding on a device
+// authorization request
+type deviceDecisionDto struct {
+	Decision string `json:"decision"`
+}
+
+// deviceAuthorization handles the device authorization request of an
+// OAuth client (RFC 8628 section 3.1)
+func (r *Router) deviceAuthorization(ctx *gin.Context) {
+	client, ok := r.identifyClient(ctx)
+	if !ok {
+		return
+	}
+	metadata := SessionMetadata{
+		IPAddress: ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+	authorization, err := r.usecases.RequestDeviceAuthorization(ctx, client, ctx.PostForm("scope"), metadata)
+	if err != nil {
+		if err, ok := err.(*OAuthError); ok {
+			writeOAuthError(ctx, http.StatusBadRequest, err.Code, err.Description)
+			return
+		}
+		r.logger.Error(fmt.Errorf("could not request device authorization: %w", err))
+		writeOAuthError(ctx, http.StatusInternalServerError, OAuthErrorServerError, "could not request device authorization")
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, authorization)
+}
+
+// devicePage shows the verification page, asking for the user code, or
+// for the credentials of the user once a valid code is given
+func (r *Router) devicePage(ctx *gin.Context) {
+	userCode := ctx.Query("user_code")
+	if userCode == "" {
+		r.renderPage(ctx, deviceTemplate, http.StatusOK, devicePage{})
+		return
+	}
+	request, ok := r.getDeviceRequest(ctx, userCode)
+	if !ok {
+		return
+	}
+	r.renderPage(ctx, deviceTemplate, http.StatusOK, devicePage{Request: request})
+}
+
+// deviceDecision handles the verification form, approving or denying the
+// device authorization request with the credentials of the user
+func (r *Router) deviceDecision(ctx *gin.Context) {
+	userCode := ctx.PostForm("user_code")
+	request, ok := r.getDeviceRequest(ctx, userCode)
+	if !ok {
+		return
+	}
+
+	email, password := ctx.PostForm("email"), ctx.PostForm("password")
+	decide, done := r.usecases.ApproveDevice, "Device connected"
+	if ctx.PostForm("decision") != "allow" {
+		decide, done = r.usecases.DenyDevice, "Request denied"
+	}
+	metadata := SessionMetadata{
+		IPAddress: ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+	if err := decide(ctx, userCode, email, password, metadata); err != nil {
+		if _, ok := err.(*ValidationError); ok && err != InvalidUserCodeError {
+			r.renderPage(ctx, deviceTemplate, http.StatusUnauthorized, devicePage{
+				Request: request,
+				Email:   email,
+				Error:   InvalidCredentialsError.Message,
+			})
+			return
+		}
+		r.renderDeviceError(ctx, userCode, err)
+		return
+	}
+	r.renderPage(ctx, deviceTemplate, http.StatusOK, devicePage{Done: done})
+}
+
+// getDeviceRequest gets the pending device authorization request of a
+// user code, and reports whether it exists. The user is asked for the
+// code again if it does not.
+func (r *Router) getDeviceRequest(ctx *gin.Context, userCode string) (*DeviceRequest, bool) {
+	request, err := r.usecases.GetDeviceRequest(ctx, userCode)
+	if err != nil {
+		r.renderDeviceError(ctx, userCode, err)
+		return nil, false
+	}
+	return request, true
+}
+
+// renderDeviceError renders the verification page with the error of a
+// device authorization request
+func (r *Router) renderDeviceError(ctx *gin.Context, userCode string, err error) {
+	if err == InvalidUserCodeError {
+		r.renderPage(ctx, deviceTemplate, http.StatusBadRequest, devicePage{
+			UserCode: userCode,
+			Error:    InvalidUserCodeError.Message,
+		})
+		return
+	}
+	r.logger.Error(fmt.Errorf("could not process device authorization: %w", err))
+	r.renderPage(ctx, deviceTemplate, http.StatusInternalServerError, devicePage{
+		Error: "Could not process the device authorization",
+	})
+}
+
+// getDeviceRequestOfUser handles the http request of a logged-in user
+// to get the device authorization request of a user code
+func (r *Router) getDeviceRequestOfUser(ctx *gin.Context) {
+	if _, ok := firstPartyUser(ctx); !ok {
+		return
+	}
+	request, err := r.usecases.GetDeviceRequest(ctx, ctx.Param("user_code"))
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not get device request: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, request, nil)
+}
+
+// decideDeviceRequest handles the http request of a logged-in user to
+// approve or deny the device authorization request of a user code
+func (r *Router) decideDeviceRequest(ctx *gin.Context) {
+	user, ok := firstPartyUser(ctx)
+	if !ok {
+		return
+	}
+	var dto deviceDecisionDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	if dto.Decision != "allow" && dto.Decision != "deny" {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, errors.New("decision must be allow or deny"))
+		return
+	}
+	err := r.usecases.DecideDeviceAuthorization(ctx, ctx.Param("user_code"), user.UserID, dto.Decision == "allow")
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not decide device request: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// firstPartyUser returns the user authenticated by a first-party access
+// token. OAuth clients and services acting on behalf of a user must not
+// approve other clients, the response is written if it is not one.
+func firstPartyUser(ctx *gin.Context) (*middleware.UserData, bool) {
+	user, _ := middleware.FromContext(ctx.Request.Context())
+	if user == nil || user.Service || user.ClientID != "" {
+		writeResponse(ctx, http.StatusForbidden, false, nil, errors.New("reserved to first-party applications"))
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,133 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"strings"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsecases_DeviceAuthorizationGrant(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+
+	testEmail := "device.user@example.com"
+	testPassword := "DevicePassword123!"
+	err = usecases.RegisterUser(testCtx, "Device", "User", testEmail, testPassword)
+	require.NoError(t, err)
+
+	client, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{
+		Name:       "betalink-cli",
+		Type:       betalinkauth.ClientTypePublic,
+		GrantTypes: []string{betalinkauth.GrantTypeDeviceCode, betalinkauth.GrantTypeRefreshToken},
+	})
+	require.NoError(t, err)
+
+	oauthErrorCode := func(err error) string {
+		require.IsType(t, &betalinkauth.OAuthError{}, err)
+		return err.(*betalinkauth.OAuthError).Code
+	}
+
+	t.Run("clients must be allowed the grant", func(t *testing.T) {
+		webClient, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{Name: "web-app"})
+		require.NoError(t, err)
+		_, err = usecases.RequestDeviceAuthorization(testCtx, &webClient.ClientData, "", testSessionMetadata)
+		require.Equal(t, betalinkauth.OAuthErrorUnauthorizedClient, oauthErrorCode(err))
+		_, err = usecases.RequestDeviceAuthorization(testCtx, &client.ClientData, "admin", testSessionMetadata)
+		require.Equal(t, betalinkauth.OAuthErrorInvalidScope, oauthErrorCode(err))
+	})
+
+	t.Run("polling until the user decides", func(t *testing.T) {
+		authorization, err := usecases.RequestDeviceAuthorization(testCtx, &client.ClientData, "openid profile", testSessionMetadata)
+		require.NoError(t, err)
+		require.Regexp(t, `^[B-Z]{4}-[B-Z]{4}$`, authorization.UserCode)
+		require.Equal(t, betalinkauth.DefaultIssuer+"/oauth/device", authorization.VerificationURI)
+		require.Equal(t, 5, authorization.Interval)
+
+		_, err = usecases.ExchangeDeviceCode(testCtx, &client.ClientData, authorization.DeviceCode)
+		require.Equal(t, betalinkauth.OAuthErrorAuthorizationPending, oauthErrorCode(err))
+		_, err = usecases.ExchangeDeviceCode(testCtx, &client.ClientData, authorization.DeviceCode)
+		require.Equal(t, betalinkauth.OAuthErrorSlowDown, oauthErrorCode(err))
+
+		// the user may type the code without separator and in lower case
+		typedCode := strings.ToLower(strings.ReplaceAll(authorization.UserCode, "-", ""))
+		request, err := usecases.GetDeviceRequest(testCtx, typedCode)
+		require.NoError(t, err)
+		require.Equal(t, "betalink-cli", request.Client.Name)
+		require.Equal(t, []string{"openid", "profile"}, request.Scopes)
+
+		// denying takes the credentials of the user too
+		err = usecases.DenyDevice(testCtx, typedCode, "", "", testSessionMetadata)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		_, err = usecases.GetDeviceRequest(testCtx, typedCode)
+		require.NoError(t, err)
+
+		require.NoError(t, usecases.DenyDevice(testCtx, typedCode, testEmail, testPassword, testSessionMetadata))
+		_, err = usecases.GetDeviceRequest(testCtx, typedCode)
+		require.Equal(t, betalinkauth.InvalidUserCodeError, err)
+		require.Equal(t, betalinkauth.InvalidUserCodeError, usecases.DenyDevice(testCtx, typedCode, testEmail, testPassword, testSessionMetadata))
+	})
+
+	t.Run("approved device", func(t *testing.T) {
+		authorization, err := usecases.RequestDeviceAuthorization(testCtx, &client.ClientData, "", testSessionMetadata)
+		require.NoError(t, err)
+
+		err = usecases.ApproveDevice(testCtx, authorization.UserCode, testEmail, "wrong-password", testSessionMetadata)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		require.NoError(t, usecases.ApproveDevice(testCtx, authorization.UserCode, testEmail, testPassword, testSessionMetadata))
+
+		otherClient, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{
+			Name:       "other-cli",
+			Type:       betalinkauth.ClientTypePublic,
+			GrantTypes: []string{betalinkauth.GrantTypeDeviceCode},
+		})
+		require.NoError(t, err)
+		_, err = usecases.ExchangeDeviceCode(testCtx, &otherClient.ClientData, authorization.DeviceCode)
+		require.Equal(t, betalinkauth.OAuthErrorInvalidGrant, oauthErrorCode(err))
+
+		tokens, err := usecases.ExchangeDeviceCode(testCtx, &client.ClientData, "unknown")
+		require.Equal(t, betalinkauth.OAuthErrorInvalidGrant, oauthErrorCode(err))
+		require.Nil(t, tokens)
+	})
+
+	t.Run("approved by a logged-in user", func(t *testing.T) {
+		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+		user, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.NoError(t, err)
+
+		authorization, err := usecases.RequestDeviceAuthorization(testCtx, &client.ClientData, "openid", testSessionMetadata)
+		require.NoError(t, err)
+		err = usecases.DecideDeviceAuthorization(testCtx, authorization.UserCode, user.UserID.String(), true)
+		require.NoError(t, err)
+
+		deviceTokens, err := usecases.ExchangeDeviceCode(testCtx, &client.ClientData, authorization.DeviceCode)
+		require.NoError(t, err)
+		require.NotEmpty(t, deviceTokens.IDToken)
+		require.Equal(t, []string{"openid"}, deviceTokens.Scopes)
+		deviceUser, err := usecases.ValidateAccessToken(testCtx, deviceTokens.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, user.UserID, deviceUser.UserID)
+		require.Equal(t, client.ClientID, deviceUser.ClientID)
+
+		// the device code can only be exchanged once
+		_, err = usecases.ExchangeDeviceCode(testCtx, &client.ClientData, authorization.DeviceCode)
+		require.Equal(t, betalinkauth.OAuthErrorInvalidGrant, oauthErrorCode(err))
+
+		_, err = usecases.RefreshClientToken(testCtx, &client.ClientData, deviceTokens.RefreshToken)
+		require.NoError(t, err)
+	})
+}
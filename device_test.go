@@ -0,0 +1,48 @@
+package betalinkauth_test
+
+import (
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDeviceLabel(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		expected  string
+	}{
+		{
+			name:      "Desktop browser",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			expected:  "Chrome on Windows 10",
+		},
+		{
+			name:      "Mobile browser",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			expected:  "Mobile Chrome on Android 14",
+		},
+		{
+			name:      "Bot",
+			userAgent: "Googlebot/2.1 (+http://www.google.com/bot.html)",
+			expected:  "Bot (Googlebot)",
+		},
+		{
+			name:      "Command line client",
+			userAgent: "curl/8.4.0",
+			expected:  "curl",
+		},
+		{
+			name:      "Empty user agent",
+			userAgent: "",
+			expected:  "Unknown device",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, betalinkauth.ParseDeviceLabel(tt.userAgent))
+		})
+	}
+}
@@ -22,9 +22,133 @@ func (e *ValidationError) Error() string {
 	return e.Message
 }
 
+// NotFoundError is an error type that represents
+// a missing resource
+type NotFoundError struct {
+	Message string
+}
+
+// Error returns the error message
+func (e *NotFoundError) Error() string {
+	return e.Message
+}
+
+// SessionLimitError is an error type that represents a login
+// rejected because the user holds too many active sessions
+type SessionLimitError struct {
+	Message string
+}
+
+// Error returns the error message
+func (e *SessionLimitError) Error() string {
+	return e.Message
+}
+
+// UnsupportedError is an error type that represents a feature the
+// store of the usecases does not hold, such as the OAuth clients when
+// the usecases run on a SQLite store
+type UnsupportedError struct {
+	Message string
+}
+
+// Error returns the error message
+func (e *UnsupportedError) Error() string {
+	return e.Message
+}
+
+// OAuthError is an error type that represents an error of the OAuth
+// protocol, reported to the client with its error code (RFC 6749
+// section 4.1.2.1 and section 5.2)
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+// Error returns the error message
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// OAuth error codes (RFC 6749 section 4.1.2.1 and section 5.2)
+const (
+	OAuthErrorInvalidRequest          = "invalid_request"
+	OAuthErrorInvalidClient           = "invalid_client"
+	OAuthErrorInvalidGrant            = "invalid_grant"
+	OAuthErrorInvalidScope            = "invalid_scope"
+	OAuthErrorUnauthorizedClient      = "unauthorized_client"
+	OAuthErrorAccessDenied            = "access_denied"
+	OAuthErrorUnsupportedResponseType = "unsupported_response_type"
+	OAuthErrorUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthErrorServerError             = "server_error"
+	// OAuthErrorInvalidTarget reports an audience the client may not
+	// get tokens for (RFC 8707 section 2)
+	OAuthErrorInvalidTarget = "invalid_target"
+)
+
+// OAuth error codes of the device authorization grant, polling the token
+// endpoint (RFC 8628 section 3.5)
+const (
+	OAuthErrorAuthorizationPending = "authorization_pending"
+	OAuthErrorSlowDown             = "slow_down"
+	OAuthErrorExpiredToken         = "expired_token"
+)
+
 var (
 	// ExpiredTokenError is an error that represents an expired token
 	ExpiredTokenError = &ValidationError{
 		Message: "Token has expired",
 	}
+	// RevokedTokenError is an error that represents a token revoked
+	// before its expiry, or whose session has ended
+	RevokedTokenError = &ValidationError{
+		Message: "Token has been revoked",
+	}
+	// SessionLimitReachedError is an error that represents a login
+	// rejected because the user holds too many active sessions
+	SessionLimitReachedError = &SessionLimitError{
+		Message: "Maximum number of active sessions reached",
+	}
+	// InvalidCredentialsError is an error that represents a login with
+	// an unknown email
+	InvalidCredentialsError = &ValidationError{
+		Message: "Invalid email or password",
+	}
+	// InvalidUserCodeError is an error that represents an unknown or
+	// expired device authorization user code, or one already decided on
+	InvalidUserCodeError = &ValidationError{
+		Message: "Invalid or expired code",
+	}
+	// InvalidExternalLoginError is an error that represents a login with
+	// an external provider which was not started by the browser, has
+	// expired or has already been completed
+	InvalidExternalLoginError = &ValidationError{
+		Message: "Invalid or expired external login",
+	}
+	// InvalidSAMLLoginError is an error that represents a SAML response
+	// which does not answer a request sent for the browser, whose request
+	// has expired or has already been answered
+	InvalidSAMLLoginError = &ValidationError{
+		Message: "Invalid or expired SAML login",
+	}
+	// AccountDisabledError is an error that represents a login of a
+	// user whose account has been disabled by an administrator
+	AccountDisabledError = &ValidationError{
+		Message: "Account is disabled",
+	}
+	// PasswordResetRequiredError is an error that represents a login of
+	// a user forced by an administrator to reset its password, who has
+	// not chosen a new one yet
+	PasswordResetRequiredError = &ValidationError{
+		Message: "Password must be reset",
+	}
+	// InvalidPasswordResetError is an error that represents an unknown
+	// or expired password reset token, or one already used
+	InvalidPasswordResetError = &ValidationError{
+		Message: "Invalid or expired password reset token",
+	}
+	// LastLoginMethodError is an error that represents the removal of
+	// the only way a user has left to log in
+	LastLoginMethodError = &ValidationError{
+		Message: "Cannot remove the last login method",
+	}
 )
@@ -0,0 +1,207 @@
+package betalinkauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ExternalIdentityData is an identity of an external provider linked to
+// the account of a user
+type ExternalIdentityData struct {
+	Provider     string    `json:"provider"`
+	ProviderName string    `json:"provider_name"`
+	Subject      string    `json:"subject"`
+	LinkedAt     time.Time `json:"linked_at"`
+}
+
+// ListExternalIdentities lists the identities of external providers
+// linked to the account of a user
+func (u *Usecases) ListExternalIdentities(ctx context.Context, userID string) ([]ExternalIdentityData, error) {
+	queries, err := u.postgresQueries("External logins")
+	if err != nil {
+		return nil, err
+	}
+	parsedUUID, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := queries.ListUserLoginExternals(ctx, parsedUUID)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list external identities: %w", err).Error(),
+		}
+	}
+	data := make([]ExternalIdentityData, 0, len(identities))
+	for _, identity := range identities {
+		data = append(data, ExternalIdentityData{
+			Provider:     identity.Slug,
+			ProviderName: identity.ProviderName,
+			Subject:      identity.ProviderSubject,
+			LinkedAt:     identity.LinkedAt.Time,
+		})
+	}
+	return data, nil
+}
+
+// StartExternalLink starts the authorization of a logged-in user with an
+// external provider, to link the identity of the user at the provider to
+// its account. The identity is linked once the login is completed.
+func (u *Usecases) StartExternalLink(ctx context.Context, userID, providerSlug string) (*ExternalLogin, error) {
+	queries, err := u.postgresQueries("External logins")
+	if err != nil {
+		return nil, err
+	}
+	parsedUUID, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := queries.GetUserById(ctx, parsedUUID); err != nil {
+		return nil, userNotFound(err)
+	}
+	return u.startExternalAuthorization(ctx, providerSlug, false, parsedUUID)
+}
+
+// LinkExternalToken links the identity of an ID token issued by an
+// external provider to the apps to the account of a logged-in user
+func (u *Usecases) LinkExternalToken(ctx context.Context, userID, providerSlug, idToken string) error {
+	parsedUUID, err := parseUserID(userID)
+	if err != nil {
+		return err
+	}
+	provider, claims, err := u.verifyExternalToken(ctx, providerSlug, idToken)
+	if err != nil {
+		return err
+	}
+	return u.linkExternalIdentity(ctx, parsedUUID, provider, claims, nil)
+}
+
+// UnlinkExternalIdentity removes the identity of an external provider
+// from the account of a user. The last login method of a user cannot be
+// removed, the user would be locked out of its account.
+func (u *Usecases) UnlinkExternalIdentity(ctx context.Context, userID, providerSlug string) error {
+	queries, err := u.postgresQueries("External logins")
+	if err != nil {
+		return err
+	}
+	parsedUUID, err := parseUserID(userID)
+	if err != nil {
+		return err
+	}
+	// the identity can be removed even if the provider is not configured
+	// anymore, its users having to fall back on another login method
+	provider, err := queries.GetExternalLoginProvider(ctx, providerSlug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &NotFoundError{
+				Message: fmt.Sprintf("provider [%s] not found", providerSlug),
+			}
+		}
+		return &ServerError{
+			Message: fmt.Errorf("could not get provider: %w", err).Error(),
+		}
+	}
+
+	return queries.ExecTx(ctx, func(queries *Queries) error {
+		// the user is locked so concurrent removals of its login methods
+		// cannot each see another method left
+		if _, err := queries.LockUser(ctx, parsedUUID); err != nil {
+			return userNotFound(err)
+		}
+		deleted, err := queries.DeleteUserLoginExternal(ctx, DeleteUserLoginExternalParams{
+			UserID:     parsedUUID,
+			ProviderID: provider.ProviderID,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not delete external identity: %w", err).Error(),
+			}
+		}
+		if deleted == 0 {
+			return &NotFoundError{
+				Message: fmt.Sprintf("no identity of provider [%s] is linked", provider.Slug),
+			}
+		}
+		loginMethods, err := queries.CountUserLoginMethods(ctx, parsedUUID)
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not count login methods: %w", err).Error(),
+			}
+		}
+		if loginMethods == 0 {
+			return LastLoginMethodError
+		}
+		return nil
+	})
+}
+
+// linkExternalIdentity links the subject of an ID token to the account
+// of a user. A user links at most one identity of each provider, and an
+// identity belongs to a single user.
+func (u *Usecases) linkExternalIdentity(ctx context.Context, userID pgtype.UUID, provider Externalloginprovider, claims jwt.MapClaims, tokens *providerTokens) error {
+	queries, err := u.postgresQueries("External logins")
+	if err != nil {
+		return err
+	}
+	subject, _ := claims.GetSubject()
+	login, err := queries.GetUserLoginExternal(ctx, GetUserLoginExternalParams{
+		ProviderID:      provider.ProviderID,
+		ProviderSubject: subject,
+	})
+	if err == nil {
+		if login.UserID == userID {
+			return &ValidationError{
+				Message: fmt.Sprintf("identity of provider [%s] is already linked", provider.Slug),
+			}
+		}
+		return &ValidationError{
+			Message: fmt.Sprintf("identity of provider [%s] is linked to another account", provider.Slug),
+		}
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return &ServerError{
+			Message: fmt.Errorf("could not get external login: %w", err).Error(),
+		}
+	}
+
+	accessToken, refreshToken, err := u.encryptProviderTokens(tokens)
+	if err != nil {
+		return err
+	}
+	err = queries.CreateUserLoginExternal(ctx, CreateUserLoginExternalParams{
+		UserID:               userID,
+		ProviderID:           provider.ProviderID,
+		ProviderSubject:      subject,
+		ProviderAccessToken:  accessToken,
+		ProviderRefreshToken: refreshToken,
+	})
+	if err != nil {
+		switch pgErrorCode(err) {
+		case pgUniqueViolation:
+			return &ValidationError{
+				Message: fmt.Sprintf("another identity of provider [%s] is already linked", provider.Slug),
+			}
+		case pgForeignKeyViolation:
+			return &NotFoundError{Message: "user not found"}
+		}
+		return &ServerError{
+			Message: fmt.Errorf("could not link external identity: %w", err).Error(),
+		}
+	}
+	return nil
+}
+
+// parseUserID parses the ID of a user
+func parseUserID(userID string) (pgtype.UUID, error) {
+	parsedUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return pgtype.UUID{}, &ValidationError{Message: "invalid UUID format"}
+	}
+	return pgtype.UUID{Bytes: parsedUUID, Valid: true}, nil
+}
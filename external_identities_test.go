@@ -0,0 +1,133 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsecases_ExternalIdentities(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	idps := map[string]*mockIdP{}
+	for _, slug := range []string{"mock", "other"} {
+		idp := newMockIdP(t)
+		defer idp.Close()
+		_, err = conn.Exec(testCtx, `INSERT INTO ExternalLoginProviders
+			(provider_name, provider_endpoint, slug, issuer, client_id, client_secret)
+			VALUES ($1, $2, $1, $3, $4, $5)`,
+			slug, idp.URL+"/authorize", idp.URL, testProviderClientID, testProviderClientSecret)
+		require.NoError(t, err)
+		idps[slug] = idp
+	}
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+
+	err = usecases.RegisterUser(testCtx, "Dana", "Scully", "dana@example.com", "DanaPassword123!")
+	require.NoError(t, err)
+	tokens, err := usecases.LoginUser(testCtx, "dana@example.com", "DanaPassword123!", false, testSessionMetadata)
+	require.NoError(t, err)
+	dana, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+	require.NoError(t, err)
+	danaID := dana.UserID.String()
+
+	t.Run("link providers", func(t *testing.T) {
+		link, err := usecases.StartExternalLink(testCtx, danaID, "mock")
+		require.NoError(t, err)
+		code, state := idps["mock"].authorize(t, link.AuthorizationURL, jwt.MapClaims{"sub": "dana-mock"})
+		result, err := usecases.CompleteExternalLogin(testCtx, "mock", state, code, testSessionMetadata)
+		require.NoError(t, err)
+		require.True(t, result.Linked)
+		require.Nil(t, result.Tokens)
+
+		err = usecases.LinkExternalToken(testCtx, danaID, "other",
+			idps["other"].idToken(t, jwt.MapClaims{"sub": "dana-other"}))
+		require.NoError(t, err)
+
+		identities, err := usecases.ListExternalIdentities(testCtx, danaID)
+		require.NoError(t, err)
+		require.Len(t, identities, 2)
+		require.Equal(t, "mock", identities[0].Provider)
+		require.Equal(t, "dana-mock", identities[0].Subject)
+		require.Equal(t, "other", identities[1].Provider)
+
+		// the linked identities log in to the account
+		tokens, err := usecases.LoginWithExternalToken(testCtx, "mock",
+			idps["mock"].idToken(t, jwt.MapClaims{"sub": "dana-mock"}), false, testSessionMetadata)
+		require.NoError(t, err)
+		user, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, danaID, user.UserID.String())
+	})
+
+	t.Run("one identity of each provider", func(t *testing.T) {
+		err := usecases.LinkExternalToken(testCtx, danaID, "mock",
+			idps["mock"].idToken(t, jwt.MapClaims{"sub": "dana-mock"}))
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		err = usecases.LinkExternalToken(testCtx, danaID, "mock",
+			idps["mock"].idToken(t, jwt.MapClaims{"sub": "dana-second-mock"}))
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+	})
+
+	var eveID string
+	t.Run("identity linked to another account", func(t *testing.T) {
+		eveToken := idps["other"].idToken(t, jwt.MapClaims{"sub": "eve-other", "name": "Eve Moneypenny"})
+		require.NoError(t, usecases.RegisterWithExternalToken(testCtx, "other", eveToken))
+		tokens, err := usecases.LoginWithExternalToken(testCtx, "other", eveToken, false, testSessionMetadata)
+		require.NoError(t, err)
+		eve, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.NoError(t, err)
+		eveID = eve.UserID.String()
+
+		err = usecases.LinkExternalToken(testCtx, eveID, "mock",
+			idps["mock"].idToken(t, jwt.MapClaims{"sub": "dana-mock"}))
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+	})
+
+	t.Run("last login method", func(t *testing.T) {
+		err := usecases.UnlinkExternalIdentity(testCtx, eveID, "other")
+		require.Equal(t, betalinkauth.LastLoginMethodError, err)
+
+		err = usecases.LinkExternalToken(testCtx, eveID, "mock",
+			idps["mock"].idToken(t, jwt.MapClaims{"sub": "eve-mock"}))
+		require.NoError(t, err)
+		require.NoError(t, usecases.UnlinkExternalIdentity(testCtx, eveID, "other"))
+		err = usecases.UnlinkExternalIdentity(testCtx, eveID, "mock")
+		require.Equal(t, betalinkauth.LastLoginMethodError, err)
+
+		identities, err := usecases.ListExternalIdentities(testCtx, eveID)
+		require.NoError(t, err)
+		require.Len(t, identities, 1)
+	})
+
+	t.Run("unlink providers", func(t *testing.T) {
+		require.NoError(t, usecases.UnlinkExternalIdentity(testCtx, danaID, "mock"))
+		err := usecases.UnlinkExternalIdentity(testCtx, danaID, "mock")
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+		err = usecases.UnlinkExternalIdentity(testCtx, danaID, "unknown")
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+
+		// the password is left to log in
+		require.NoError(t, usecases.UnlinkExternalIdentity(testCtx, danaID, "other"))
+		identities, err := usecases.ListExternalIdentities(testCtx, danaID)
+		require.NoError(t, err)
+		require.Empty(t, identities)
+
+		_, err = usecases.LoginWithExternalToken(testCtx, "mock",
+			idps["mock"].idToken(t, jwt.MapClaims{"sub": "dana-mock"}), false, testSessionMetadata)
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+	})
+}
@@ -0,0 +1,434 @@
+package betalinkauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// LoginMethodExternal is the login method of sessions opened through
+	// an external provider
+	LoginMethodExternal = "EXTERNAL"
+
+	// externalLoginValidity is how long the user has to log in with the
+	// external provider
+	externalLoginValidity = time.Minute * 10
+	// externalLoginSecretSize is the size in bytes of the state, nonce
+	// and PKCE verifier of the requests sent to external providers
+	externalLoginSecretSize = 32
+	// providerRequestTimeout is the timeout of the requests sent to
+	// external providers
+	providerRequestTimeout = time.Second * 10
+)
+
+// ExternalLogin is a login started with an external provider. The user
+// is redirected to the authorization URL, the state must be kept by the
+// browser to complete the login.
+type ExternalLogin struct {
+	AuthorizationURL string
+	State            string
+}
+
+// ExternalLoginResult is the outcome of the redirection of a user back
+// from an external provider. The user is either logged in, or has linked
+// the identity to the account which started the authorization.
+type ExternalLoginResult struct {
+	Tokens *IDTokens
+	Linked bool
+}
+
+// StartExternalLogin starts the login of a user with an external OpenID
+// Connect provider, identified by its slug, through the authorization
+// code flow with PKCE
+func (u *Usecases) StartExternalLogin(ctx context.Context, providerSlug string, rememberMe bool) (*ExternalLogin, error) {
+	return u.startExternalAuthorization(ctx, providerSlug, rememberMe, pgtype.UUID{})
+}
+
+// startExternalAuthorization sends the user to the authorization endpoint
+// of an external provider, to log in or, when userID is valid, to link an
+// identity to the account of the user
+func (u *Usecases) startExternalAuthorization(ctx context.Context, providerSlug string, rememberMe bool, userID pgtype.UUID) (*ExternalLogin, error) {
+	queries, err := u.postgresQueries("External logins")
+	if err != nil {
+		return nil, err
+	}
+	provider, err := u.getExternalProvider(ctx, providerSlug)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := u.relyingParty.discover(ctx, provider.Issuer)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not discover provider [%s]: %w", provider.Slug, err).Error(),
+		}
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		secrets[i], err = randomString(externalLoginSecretSize)
+		if err != nil {
+			return nil, &ServerError{
+				Message: fmt.Errorf("could not generate external login secret: %w", err).Error(),
+			}
+		}
+	}
+	state, nonce, codeVerifier := secrets[0], secrets[1], secrets[2]
+	encryptedVerifier, err := u.keyring.Encrypt(codeVerifier, secretCodeVerifier)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not encrypt code verifier: %w", err).Error(),
+		}
+	}
+	err = queries.CreateExternalLoginState(ctx, CreateExternalLoginStateParams{
+		StateHash:    hashSecret(state),
+		ProviderID:   provider.ProviderID,
+		Nonce:        nonce,
+		CodeVerifier: encryptedVerifier,
+		RememberMe:   rememberMe,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(externalLoginValidity),
+			Valid: true,
+		},
+		UserID: userID,
+	})
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not create external login state: %w", err).Error(),
+		}
+	}
+
+	authorizationURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("invalid authorization endpoint: %w", err).Error(),
+		}
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := authorizationURL.Query()
+	query.Set("response_type", ResponseTypeCode)
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", u.externalLoginRedirectURI(provider))
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", CodeChallengeMethodS256)
+	authorizationURL.RawQuery = query.Encode()
+	return &ExternalLogin{
+		AuthorizationURL: authorizationURL.String(),
+		State:            state,
+	}, nil
+}
+
+// CompleteExternalLogin completes the login of a user redirected back
+// from an external provider with an authorization code. Users logging in
+// with the provider for the first time are registered. The identity is
+// linked instead when the authorization was started to link it.
+func (u *Usecases) CompleteExternalLogin(ctx context.Context, providerSlug, state, code string, metadata SessionMetadata) (*ExternalLoginResult, error) {
+	queries, err := u.postgresQueries("External logins")
+	if err != nil {
+		return nil, err
+	}
+	provider, err := u.getExternalProvider(ctx, providerSlug)
+	if err != nil {
+		return nil, err
+	}
+	// the state can only be used once, even if the login fails
+	loginState, err := queries.ConsumeExternalLoginState(ctx, hashSecret(state))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, InvalidExternalLoginError
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not consume external login state: %w", err).Error(),
+		}
+	}
+	if loginState.ExpiresAt.Time.Before(time.Now()) || loginState.ProviderID != provider.ProviderID {
+		return nil, InvalidExternalLoginError
+	}
+	if code == "" {
+		return nil, &ValidationError{Message: "authorization code is required"}
+	}
+
+	codeVerifier, err := u.keyring.Decrypt(loginState.CodeVerifier, secretCodeVerifier)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not decrypt code verifier: %w", err).Error(),
+		}
+	}
+	tokens, err := u.relyingParty.exchangeCode(ctx, provider, code, u.externalLoginRedirectURI(provider), codeVerifier)
+	if err != nil {
+		return nil, &ValidationError{
+			Message: fmt.Errorf("could not log in with provider [%s]: %w", provider.Slug, err).Error(),
+		}
+	}
+	claims, err := u.relyingParty.verifyIDToken(ctx, provider, tokens.IDToken, loginState.Nonce)
+	if err != nil {
+		return nil, &ValidationError{
+			Message: fmt.Errorf("could not verify ID token: %w", err).Error(),
+		}
+	}
+
+	if loginState.UserID.Valid {
+		if err := u.linkExternalIdentity(ctx, loginState.UserID, provider, claims, tokens); err != nil {
+			return nil, err
+		}
+		return &ExternalLoginResult{Linked: true}, nil
+	}
+
+	userID, err := u.externalUser(ctx, provider, claims, tokens)
+	if errors.Is(err, pgx.ErrNoRows) {
+		userID, err = u.registerExternalUser(ctx, provider, claims, tokens)
+	}
+	if err != nil {
+		return nil, err
+	}
+	params := u.newCreateSessionParams(userID, LoginMethodExternal, loginState.RememberMe, metadata)
+	idTokens, err := u.openSession(ctx, params, "")
+	if err != nil {
+		return nil, err
+	}
+	return &ExternalLoginResult{Tokens: idTokens}, nil
+}
+
+// LoginWithExternalToken logs in a user with an ID token issued by an
+// external provider to the apps, such as the token of a mobile sign-in
+// SDK. The user must have registered with the provider.
+func (u *Usecases) LoginWithExternalToken(ctx context.Context, providerSlug, idToken string, rememberMe bool, metadata SessionMetadata) (*IDTokens, error) {
+	provider, claims, err := u.verifyExternalToken(ctx, providerSlug, idToken)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := u.externalUser(ctx, provider, claims, nil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, &NotFoundError{
+				Message: fmt.Sprintf("no user is registered with provider [%s]", provider.Slug),
+			}
+		}
+		return nil, err
+	}
+	params := u.newCreateSessionParams(userID, LoginMethodExternal, rememberMe, metadata)
+	return u.openSession(ctx, params, "")
+}
+
+// RegisterWithExternalToken registers a user with an ID token issued by
+// an external provider to the apps
+func (u *Usecases) RegisterWithExternalToken(ctx context.Context, providerSlug, idToken string) error {
+	provider, claims, err := u.verifyExternalToken(ctx, providerSlug, idToken)
+	if err != nil {
+		return err
+	}
+	_, err = u.externalUser(ctx, provider, claims, nil)
+	if err == nil {
+		return &ValidationError{
+			Message: fmt.Sprintf("user is already registered with provider [%s]", provider.Slug),
+		}
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	_, err = u.registerExternalUser(ctx, provider, claims, nil)
+	return err
+}
+
+// verifyExternalToken verifies an ID token issued by an external
+// provider to the apps, without nonce as the service did not request it
+func (u *Usecases) verifyExternalToken(ctx context.Context, providerSlug, idToken string) (Externalloginprovider, jwt.MapClaims, error) {
+	provider, err := u.getExternalProvider(ctx, providerSlug)
+	if err != nil {
+		return Externalloginprovider{}, nil, err
+	}
+	if idToken == "" {
+		return Externalloginprovider{}, nil, &ValidationError{Message: "token is required"}
+	}
+	claims, err := u.relyingParty.verifyIDToken(ctx, provider, idToken, "")
+	if err != nil {
+		return Externalloginprovider{}, nil, &ValidationError{
+			Message: fmt.Errorf("could not verify ID token: %w", err).Error(),
+		}
+	}
+	return provider, claims, nil
+}
+
+// getExternalProvider returns a configured external provider, with its
+// client secret decrypted
+func (u *Usecases) getExternalProvider(ctx context.Context, providerSlug string) (Externalloginprovider, error) {
+	queries, err := u.postgresQueries("External logins")
+	if err != nil {
+		return Externalloginprovider{}, err
+	}
+	provider, err := queries.GetExternalLoginProvider(ctx, providerSlug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Externalloginprovider{}, &NotFoundError{
+				Message: fmt.Sprintf("provider [%s] not found", providerSlug),
+			}
+		}
+		return Externalloginprovider{}, &ServerError{
+			Message: fmt.Errorf("could not get provider: %w", err).Error(),
+		}
+	}
+	if provider.Issuer == "" || provider.ClientID == "" {
+		return Externalloginprovider{}, &NotFoundError{
+			Message: fmt.Sprintf("provider [%s] is not configured", providerSlug),
+		}
+	}
+	provider.ClientSecret, err = u.keyring.Decrypt(provider.ClientSecret, secretProviderClientSecret)
+	if err != nil {
+		return Externalloginprovider{}, &ServerError{
+			Message: fmt.Errorf("could not decrypt client secret of provider [%s]: %w", providerSlug, err).Error(),
+		}
+	}
+	return provider, nil
+}
+
+// externalUser returns the user of the subject of an ID token, updating
+// the provider tokens when given. pgx.ErrNoRows is returned as is when
+// the subject has no user.
+func (u *Usecases) externalUser(ctx context.Context, provider Externalloginprovider, claims jwt.MapClaims, tokens *providerTokens) (pgtype.UUID, error) {
+	queries, err := u.postgresQueries("External logins")
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	subject, _ := claims.GetSubject()
+	login, err := queries.GetUserLoginExternal(ctx, GetUserLoginExternalParams{
+		ProviderID:      provider.ProviderID,
+		ProviderSubject: subject,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pgtype.UUID{}, err
+		}
+		return pgtype.UUID{}, &ServerError{
+			Message: fmt.Errorf("could not get external login: %w", err).Error(),
+		}
+	}
+	if tokens != nil {
+		accessToken, refreshToken, err := u.encryptProviderTokens(tokens)
+		if err != nil {
+			return pgtype.UUID{}, err
+		}
+		err = queries.UpdateUserLoginExternalTokens(ctx, UpdateUserLoginExternalTokensParams{
+			ProviderID:           provider.ProviderID,
+			ProviderSubject:      subject,
+			ProviderAccessToken:  accessToken,
+			ProviderRefreshToken: refreshToken,
+		})
+		if err != nil {
+			return pgtype.UUID{}, &ServerError{
+				Message: fmt.Errorf("could not update external login: %w", err).Error(),
+			}
+		}
+	}
+	return login.UserID, nil
+}
+
+// registerExternalUser registers the user of the subject of an ID token,
+// named after its profile claims. A verified email of an existing
+// account is rejected: the user must log in and link the provider
+// instead, so the provider cannot take the account over.
+func (u *Usecases) registerExternalUser(ctx context.Context, provider Externalloginprovider, claims jwt.MapClaims, tokens *providerTokens) (pgtype.UUID, error) {
+	queries, err := u.postgresQueries("External logins")
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	if email, verified := emailClaim(claims); verified {
+		if err := checkEmailUniqueness(ctx, queries, email); err != nil {
+			return pgtype.UUID{}, err
+		}
+	}
+	accessToken, refreshToken, err := u.encryptProviderTokens(tokens)
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	subject, _ := claims.GetSubject()
+	firstName, lastName := nameClaims(claims)
+
+	var userID pgtype.UUID
+	err = queries.ExecTx(ctx, func(queries *Queries) error {
+		var err error
+		userID, err = queries.CreateUser(ctx, CreateUserParams{
+			FirstName: firstName,
+			LastName:  lastName,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not create user: %w", err).Error(),
+			}
+		}
+		err = queries.CreateUserLoginExternal(ctx, CreateUserLoginExternalParams{
+			UserID:               userID,
+			ProviderID:           provider.ProviderID,
+			ProviderSubject:      subject,
+			ProviderAccessToken:  accessToken,
+			ProviderRefreshToken: refreshToken,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not create external login: %w", err).Error(),
+			}
+		}
+		err = queries.AssignUserRole(ctx, AssignUserRoleParams{
+			UserID:   userID,
+			RoleName: DefaultRole,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not assign default role: %w", err).Error(),
+			}
+		}
+		return nil
+	})
+	return userID, err
+}
+
+// encryptProviderTokens encrypts the tokens of a provider before they are
+// stored. Nil tokens, those of ID tokens sent by the apps, are empty.
+func (u *Usecases) encryptProviderTokens(tokens *providerTokens) (string, string, error) {
+	if tokens == nil {
+		return "", "", nil
+	}
+	accessToken, err := u.keyring.Encrypt(tokens.AccessToken, secretProviderAccessToken)
+	if err != nil {
+		return "", "", &ServerError{
+			Message: fmt.Errorf("could not encrypt provider access token: %w", err).Error(),
+		}
+	}
+	refreshToken, err := u.keyring.Encrypt(tokens.RefreshToken, secretProviderRefreshToken)
+	if err != nil {
+		return "", "", &ServerError{
+			Message: fmt.Errorf("could not encrypt provider refresh token: %w", err).Error(),
+		}
+	}
+	return accessToken, refreshToken, nil
+}
+
+// externalLoginRedirectURI returns the URI external providers redirect
+// the users to, which must be registered with the provider
+func (u *Usecases) externalLoginRedirectURI(provider Externalloginprovider) string {
+	return u.issuer + "/login/external/" + provider.Slug + "/callback"
+}
+
+// nameClaims returns the first and last names of the user of an ID
+// token, splitting the full name when the given and family names are
+// not provided
+func nameClaims(claims jwt.MapClaims) (string, string) {
+	firstName, _ := claims["given_name"].(string)
+	lastName, _ := claims["family_name"].(string)
+	if firstName == "" && lastName == "" {
+		name, _ := claims["name"].(string)
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(name), " ")
+	}
+	return firstName, strings.TrimSpace(lastName)
+}
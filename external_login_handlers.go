@@ -0,0 +1,187 @@
+package betalinkauth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// externalLoginCookie is the cookie binding a login with an external
+// provider to the browser which started it, holding the state sent to
+// the provider
+const externalLoginCookie = "external_login_state"
+
+// externalLoginDto is the data transfer object for logging in or
+// registering with an ID token of an external provider
+type externalLoginDto struct {
+	Token      string `json:"token"`
+	RememberMe bool   `json:"remember_me"`
+}
+
+// setExternalLoginCookie binds a login with an external provider to the
+// browser, the cookie being sent back when the provider redirects the
+// user, a top-level navigation allowed by the lax same-site policy
+func setExternalLoginCookie(ctx *gin.Context, state string) {
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(externalLoginCookie, state, int(externalLoginValidity.Seconds()),
+		"/login/external", "localhost", false, true)
+}
+
+// startExternalLogin handles the http request to log in with an
+// external provider, redirecting the user to the provider
+func (r *Router) startExternalLogin(ctx *gin.Context) {
+	login, err := r.usecases.StartExternalLogin(ctx, ctx.Param("provider"), ctx.Query("remember_me") == "true")
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not start external login: %w", err))
+		return
+	}
+	setExternalLoginCookie(ctx, login.State)
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Redirect(http.StatusFound, login.AuthorizationURL)
+}
+
+// completeExternalLogin handles the redirection of the user back from an
+// external provider, logging the user in
+func (r *Router) completeExternalLogin(ctx *gin.Context) {
+	state, _ := ctx.Cookie(externalLoginCookie)
+	ctx.SetCookie(externalLoginCookie, "", -1, "/login/external", "localhost", false, true)
+	if providerError := ctx.Query("error"); providerError != "" {
+		writeResponse(ctx, http.StatusUnauthorized, false, nil,
+			fmt.Errorf("external provider refused the login: %s", providerError))
+		return
+	}
+	// the state must be the one of the login started by this browser,
+	// otherwise a user could be logged in the account of an attacker
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(ctx.Query("state"))) != 1 {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, errors.New(InvalidExternalLoginError.Message))
+		return
+	}
+
+	metadata := SessionMetadata{
+		IPAddress: ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+	result, err := r.usecases.CompleteExternalLogin(ctx, ctx.Param("provider"), state, ctx.Query("code"), metadata)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not login the user: %w", err))
+		return
+	}
+	if result.Linked {
+		writeResponse(ctx, http.StatusOK, true, nil, nil)
+		return
+	}
+	writeLoginResponse(ctx, result.Tokens, result.Tokens.RememberMe)
+}
+
+// loginWithExternalToken handles the http request to log in with an ID
+// token of an external provider
+func (r *Router) loginWithExternalToken(ctx *gin.Context) {
+	var dto externalLoginDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	metadata := SessionMetadata{
+		IPAddress: ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+	tokens, err := r.usecases.LoginWithExternalToken(ctx, ctx.Param("provider"), dto.Token, dto.RememberMe, metadata)
+	if err != nil {
+		statusCode := getErrorStatusCode(err)
+		if _, ok := err.(*ValidationError); ok {
+			statusCode = http.StatusUnauthorized
+		}
+		writeResponse(ctx, statusCode, false, nil, fmt.Errorf("could not login the user: %w", err))
+		return
+	}
+	writeLoginResponse(ctx, tokens, dto.RememberMe)
+}
+
+// registerWithExternalToken handles the http request to register with
+// an ID token of an external provider
+func (r *Router) registerWithExternalToken(ctx *gin.Context) {
+	var dto externalLoginDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	if err := r.usecases.RegisterWithExternalToken(ctx, ctx.Param("provider"), dto.Token); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not register the user: %w", err))
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"message": "user registered"})
+}
+
+// listExternalIdentities handles the http request of a logged-in user to
+// list the identities of external providers linked to its account
+func (r *Router) listExternalIdentities(ctx *gin.Context) {
+	user, ok := firstPartyUser(ctx)
+	if !ok {
+		return
+	}
+	identities, err := r.usecases.ListExternalIdentities(ctx, user.UserID)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not list external identities: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, identities, nil)
+}
+
+// startExternalLink handles the http request of a logged-in user to link
+// its identity at an external provider. The app sends the user to the
+// returned authorization URL, the browser keeping the state cookie.
+func (r *Router) startExternalLink(ctx *gin.Context) {
+	user, ok := firstPartyUser(ctx)
+	if !ok {
+		return
+	}
+	login, err := r.usecases.StartExternalLink(ctx, user.UserID, ctx.Param("provider"))
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not start external link: %w", err))
+		return
+	}
+	setExternalLoginCookie(ctx, login.State)
+	ctx.Header("Cache-Control", "no-store")
+	writeResponse(ctx, http.StatusOK, true, gin.H{"authorization_url": login.AuthorizationURL}, nil)
+}
+
+// linkExternalToken handles the http request of a logged-in user to link
+// the identity of an ID token of an external provider
+func (r *Router) linkExternalToken(ctx *gin.Context) {
+	user, ok := firstPartyUser(ctx)
+	if !ok {
+		return
+	}
+	var dto externalLoginDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	if err := r.usecases.LinkExternalToken(ctx, user.UserID, ctx.Param("provider"), dto.Token); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not link external identity: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusCreated, true, nil, nil)
+}
+
+// unlinkExternalIdentity handles the http request of a logged-in user to
+// remove the identity of an external provider from its account
+func (r *Router) unlinkExternalIdentity(ctx *gin.Context) {
+	user, ok := firstPartyUser(ctx)
+	if !ok {
+		return
+	}
+	err := r.usecases.UnlinkExternalIdentity(ctx, user.UserID, ctx.Param("provider"))
+	if err != nil {
+		statusCode := getErrorStatusCode(err)
+		if err == LastLoginMethodError {
+			statusCode = http.StatusConflict
+		}
+		writeResponse(ctx, statusCode, false, nil, fmt.Errorf("could not unlink external identity: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
@@ -0,0 +1,239 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testProviderClientID     = "betalink-auth"
+	testProviderClientSecret = "provider-secret"
+)
+
+// mockIdP is a local OpenID Connect provider issuing the ID tokens of
+// the authorization codes registered by the tests
+type mockIdP struct {
+	*httptest.Server
+	key   *betalinkauth.KeySet
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+// mockAuthorization is an authorization code issued by the mock IdP
+type mockAuthorization struct {
+	claims        jwt.MapClaims
+	codeChallenge string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	signingKey, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	idp := &mockIdP{
+		key:   betalinkauth.NewKeySet(signingKey),
+		codes: map[string]mockAuthorization{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(idp.key.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		authorization, ok := idp.codes[r.PostFormValue("code")]
+		delete(idp.codes, r.PostFormValue("code"))
+		idp.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || r.PostFormValue("client_secret") != testProviderClientSecret ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"id_token":     idp.idToken(t, authorization.claims),
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+// authorize registers the authorization code the IdP redirects the user
+// back with, for the authorization request of the service
+func (idp *mockIdP) authorize(t *testing.T, authorizationURL string, claims jwt.MapClaims) (code, state string) {
+	parsedURL, err := url.Parse(authorizationURL)
+	require.NoError(t, err)
+	query := parsedURL.Query()
+	require.Equal(t, idp.URL+"/authorize", parsedURL.Scheme+"://"+parsedURL.Host+parsedURL.Path)
+	require.Equal(t, testProviderClientID, query.Get("client_id"))
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+	require.Equal(t, "openid email profile", query.Get("scope"))
+
+	claims["nonce"] = query.Get("nonce")
+	code = "code-" + query.Get("state")
+	idp.mu.Lock()
+	idp.codes[code] = mockAuthorization{claims: claims, codeChallenge: query.Get("code_challenge")}
+	idp.mu.Unlock()
+	return code, query.Get("state")
+}
+
+// idToken signs an ID token for the service
+func (idp *mockIdP) idToken(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.MapClaims{
+		"iss": idp.URL,
+		"aud": testProviderClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		token[name] = value
+	}
+	signed, err := idp.key.SignJWT(token)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestUsecases_ExternalLogin(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	idp := newMockIdP(t)
+	defer idp.Close()
+	_, err = conn.Exec(testCtx, `INSERT INTO ExternalLoginProviders
+		(provider_name, provider_endpoint, slug, issuer, client_id, client_secret)
+		VALUES ('Mock', $1, 'mock', $2, $3, $4)`,
+		idp.URL+"/authorize", idp.URL, testProviderClientID, testProviderClientSecret)
+	require.NoError(t, err)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+
+	aliceClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":            "alice-subject",
+			"email":          "alice@example.com",
+			"email_verified": true,
+			"given_name":     "Alice",
+			"family_name":    "Liddell",
+		}
+	}
+
+	t.Run("unknown and unconfigured providers", func(t *testing.T) {
+		_, err := usecases.StartExternalLogin(testCtx, "unknown", false)
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+		// the seeded Google provider has no client ID until configured
+		_, err = usecases.StartExternalLogin(testCtx, "google", false)
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+	})
+
+	var aliceID string
+	t.Run("first login registers the user", func(t *testing.T) {
+		login, err := usecases.StartExternalLogin(testCtx, "mock", true)
+		require.NoError(t, err)
+		code, state := idp.authorize(t, login.AuthorizationURL, aliceClaims())
+		require.Equal(t, login.State, state)
+
+		result, err := usecases.CompleteExternalLogin(testCtx, "mock", state, code, testSessionMetadata)
+		require.NoError(t, err)
+		require.False(t, result.Linked)
+		require.True(t, result.Tokens.RememberMe)
+		user, err := usecases.ValidateAccessToken(testCtx, result.Tokens.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, "Alice", user.FirstName)
+		require.Equal(t, "Liddell", user.LastName)
+		aliceID = user.UserID.String()
+
+		// the state can only be used once
+		_, err = usecases.CompleteExternalLogin(testCtx, "mock", state, code, testSessionMetadata)
+		require.Equal(t, betalinkauth.InvalidExternalLoginError, err)
+	})
+
+	t.Run("next logins find the user", func(t *testing.T) {
+		login, err := usecases.StartExternalLogin(testCtx, "mock", false)
+		require.NoError(t, err)
+		code, state := idp.authorize(t, login.AuthorizationURL, aliceClaims())
+
+		result, err := usecases.CompleteExternalLogin(testCtx, "mock", state, code, testSessionMetadata)
+		require.NoError(t, err)
+		user, err := usecases.ValidateAccessToken(testCtx, result.Tokens.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, aliceID, user.UserID.String())
+	})
+
+	t.Run("ID token replayed with another nonce", func(t *testing.T) {
+		login, err := usecases.StartExternalLogin(testCtx, "mock", false)
+		require.NoError(t, err)
+		code, state := idp.authorize(t, login.AuthorizationURL, aliceClaims())
+		idp.mu.Lock()
+		idp.codes[code].claims["nonce"] = "stolen-nonce"
+		idp.mu.Unlock()
+
+		_, err = usecases.CompleteExternalLogin(testCtx, "mock", state, code, testSessionMetadata)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+	})
+
+	t.Run("verified email of an existing account", func(t *testing.T) {
+		err := usecases.RegisterUser(testCtx, "Bob", "Password", "bob@example.com", "BobPassword123!")
+		require.NoError(t, err)
+
+		login, err := usecases.StartExternalLogin(testCtx, "mock", false)
+		require.NoError(t, err)
+		code, state := idp.authorize(t, login.AuthorizationURL, jwt.MapClaims{
+			"sub":            "bob-subject",
+			"email":          "bob@example.com",
+			"email_verified": true,
+		})
+		_, err = usecases.CompleteExternalLogin(testCtx, "mock", state, code, testSessionMetadata)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+	})
+
+	t.Run("ID token of a mobile sign-in", func(t *testing.T) {
+		claims := jwt.MapClaims{"sub": "carol-subject", "name": "Carol Ann Smith"}
+		_, err := usecases.LoginWithExternalToken(testCtx, "mock", idp.idToken(t, claims), false, testSessionMetadata)
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+
+		require.NoError(t, usecases.RegisterWithExternalToken(testCtx, "mock", idp.idToken(t, claims)))
+		err = usecases.RegisterWithExternalToken(testCtx, "mock", idp.idToken(t, claims))
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+
+		tokens, err := usecases.LoginWithExternalToken(testCtx, "mock", idp.idToken(t, claims), false, testSessionMetadata)
+		require.NoError(t, err)
+		user, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, "Carol", user.FirstName)
+		require.Equal(t, "Ann Smith", user.LastName)
+
+		otherClient := jwt.MapClaims{"sub": "carol-subject", "aud": "another-client"}
+		_, err = usecases.LoginWithExternalToken(testCtx, "mock", idp.idToken(t, otherClient), false, testSessionMetadata)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+	})
+}
@@ -1,9 +1,11 @@
 package betalinkauth
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/BragdonD/betalink-auth/middleware"
 	betalinklogger "github.com/BragdonD/betalink-logger"
@@ -18,9 +20,23 @@ type registerUserDto struct {
 	Password  string `json:"password"`
 }
 
+// checkAccessDto is the data transfer object for checking an access
+type checkAccessDto struct {
+	Subject  map[string]interface{} `json:"subject"`
+	Action   string                 `json:"action"`
+	Resource map[string]interface{} `json:"resource"`
+}
+
 // loginUserDto is the data transfer object for logging in a user
 type loginUserDto struct {
-	Email    string `json:"email"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	RememberMe bool   `json:"remember_me"`
+}
+
+// userPasswordDto is the data transfer object for choosing a new
+// password
+type userPasswordDto struct {
 	Password string `json:"password"`
 }
 
@@ -41,12 +57,128 @@ func NewRouter(logger *betalinklogger.Logger, ginRouter *gin.Engine, usecases *U
 
 	ginRouter.POST("/register", router.registerUser)
 	ginRouter.POST("/login", router.loginUser)
+	ginRouter.PATCH("/recovery/password", router.recoverPassword)
 	ginRouter.GET("/token/validate", router.validateAccessToken)
 	ginRouter.GET("/token/refresh", router.refreshToken)
+	ginRouter.GET("/.well-known/jwks.json", router.jwks)
+	ginRouter.GET("/.well-known/openid-configuration", router.openIDConfiguration)
+	ginRouter.GET("/userinfo", router.authRequired(), router.userInfo)
+	ginRouter.POST("/userinfo", router.authRequired(), router.userInfo)
+
+	admin := ginRouter.Group("/admin", router.authRequired(), middleware.RequireRoles(AdminRole))
+	admin.GET("/roles", router.listRoles)
+	admin.POST("/roles", router.createRole)
+	admin.DELETE("/roles/:role", router.deleteRole)
+	admin.PUT("/roles/:role/permissions/:permission", router.grantPermission)
+	admin.DELETE("/roles/:role/permissions/:permission", router.revokePermission)
+	admin.GET("/permissions", router.listPermissions)
+	admin.POST("/permissions", router.createPermission)
+	admin.DELETE("/permissions/:permission", router.deletePermission)
+	admin.GET("/users", router.listUsers)
+	admin.POST("/users/ldap", router.createLDAPUser)
+	admin.GET("/users/:user_id", router.getUser)
+	admin.PATCH("/users/:user_id", router.updateUser)
+	admin.POST("/users/:user_id/disable", router.disableUser)
+	admin.POST("/users/:user_id/enable", router.enableUser)
+	admin.POST("/users/:user_id/password-reset", router.forcePasswordReset)
+	admin.GET("/users/:user_id/roles", router.getUserRoles)
+	admin.PUT("/users/:user_id/roles/:role", router.assignRole)
+	admin.DELETE("/users/:user_id/roles/:role", router.unassignRole)
+
+	// the other features are stored in postgres only, they answer 501 Not
+	// Implemented when the usecases run on another store
+	postgres := ginRouter.Group("", router.postgresOnly())
+	postgres.GET("/login/external/:provider", router.startExternalLogin)
+	postgres.GET("/login/external/:provider/callback", router.completeExternalLogin)
+	postgres.POST("/login/external/:provider", router.loginWithExternalToken)
+	postgres.POST("/register/external/:provider", router.registerWithExternalToken)
+	postgres.GET("/saml/:organization/metadata", router.samlMetadata)
+	postgres.GET("/saml/:organization/login", router.startSAMLLogin)
+	postgres.POST("/saml/:organization/acs", router.completeSAMLLogin)
+	postgres.GET("/account/external-identities", router.authRequired(), router.listExternalIdentities)
+	postgres.POST("/account/external-identities/:provider", router.authRequired(), router.linkExternalToken)
+	postgres.POST("/account/external-identities/:provider/authorize", router.authRequired(), router.startExternalLink)
+	postgres.DELETE("/account/external-identities/:provider", router.authRequired(), router.unlinkExternalIdentity)
+	postgres.POST("/authz/check", router.authRequired(), router.checkAccess)
+	postgres.GET("/oauth/authorize", router.authorize)
+	postgres.POST("/oauth/authorize", router.authorizeDecision)
+	postgres.POST("/oauth/token", router.token)
+	postgres.POST("/oauth/introspect", router.introspectToken)
+	postgres.POST("/oauth/revoke", router.revokeToken)
+	postgres.POST("/oauth/device_authorization", router.deviceAuthorization)
+	postgres.GET("/oauth/device", router.devicePage)
+	postgres.POST("/oauth/device", router.deviceDecision)
+	postgres.GET("/oauth/device/:user_code", router.authRequired(), router.getDeviceRequestOfUser)
+	postgres.POST("/oauth/device/:user_code", router.authRequired(), router.decideDeviceRequest)
+
+	postgresAdmin := admin.Group("", router.postgresOnly())
+	postgresAdmin.GET("/policies", router.listPolicies)
+	postgresAdmin.POST("/policies", router.createPolicy)
+	postgresAdmin.PUT("/policies/:policy", router.updatePolicy)
+	postgresAdmin.DELETE("/policies/:policy", router.deletePolicy)
+	postgresAdmin.GET("/clients", router.listClients)
+	postgresAdmin.POST("/clients", router.createClient)
+	postgresAdmin.DELETE("/clients/:client_id", router.deleteClient)
+	postgresAdmin.GET("/organizations", router.listOrganizations)
+	postgresAdmin.POST("/organizations", router.createOrganization)
+	postgresAdmin.GET("/organizations/:organization/saml", router.getSAMLIdentityProvider)
+	postgresAdmin.PUT("/organizations/:organization/saml", router.configureSAMLIdentityProvider)
+	postgresAdmin.DELETE("/organizations/:organization/saml", router.deleteSAMLIdentityProvider)
 
 	return router
 }
 
+// postgresOnly returns a middleware rejecting the requests to the
+// features stored in postgres only when the usecases run on another store
+func (r *Router) postgresOnly() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if r.usecases.queries == nil {
+			err := &UnsupportedError{Message: "this feature requires the Postgres store"}
+			writeResponse(ctx, getErrorStatusCode(err), false, nil, err)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// authRequired returns a middleware authenticating the requests with
+// the access tokens issued by the auth service itself
+func (r *Router) authRequired() gin.HandlerFunc {
+	return middleware.Gin(middleware.NewChecker(accessTokenVerifier{usecases: r.usecases}))
+}
+
+// accessTokenVerifier verifies access tokens with the usecases, so the
+// routes of the auth service are protected like those of other services
+type accessTokenVerifier struct {
+	usecases *Usecases
+}
+
+// Verify implements middleware.TokenVerifier
+func (v accessTokenVerifier) Verify(ctx context.Context, token string) (*middleware.UserData, error) {
+	user, err := v.usecases.ValidateAccessToken(ctx, token)
+	if err != nil {
+		switch err.(type) {
+		case *ValidationError:
+			if err == ExpiredTokenError {
+				return nil, middleware.ErrTokenExpired
+			}
+			return nil, fmt.Errorf("%w: %v", middleware.ErrInvalidToken, err)
+		default:
+			return nil, err
+		}
+	}
+	return &middleware.UserData{
+		UserID:      user.UserID.String(),
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Roles:       user.Roles,
+		Permissions: user.Permissions,
+		Scopes:      user.Scopes,
+		ClientID:    user.ClientID,
+	}, nil
+}
+
 // registerUser handles the http request to register a
 // new user in the database
 func (r *Router) registerUser(ctx *gin.Context) {
@@ -77,6 +209,27 @@ func (r *Router) registerUser(ctx *gin.Context) {
 	ctx.JSON(http.StatusCreated, gin.H{"message": "user registered"})
 }
 
+// recoverPassword handles the http request of a user choosing a new
+// password with the recovery token of the reset forced by an
+// administrator
+func (r *Router) recoverPassword(ctx *gin.Context) {
+	var dto userPasswordDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	err := r.usecases.CompletePasswordReset(ctx, ctx.Query("recovery_token"), dto.Password)
+	if err != nil {
+		statusCode := getErrorStatusCode(err)
+		if err == InvalidPasswordResetError {
+			statusCode = http.StatusUnauthorized
+		}
+		writeResponse(ctx, statusCode, false, nil, fmt.Errorf("could not reset password: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
 // loginUser handles the http request to login a user
 func (r *Router) loginUser(ctx *gin.Context) {
 	r.logger.Info("Logging in user")
@@ -91,7 +244,11 @@ func (r *Router) loginUser(ctx *gin.Context) {
 		)
 		return
 	}
-	tokens, err := r.usecases.LoginUser(ctx, dto.Email, dto.Password)
+	metadata := SessionMetadata{
+		IPAddress: ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+	tokens, err := r.usecases.LoginUser(ctx, dto.Email, dto.Password, dto.RememberMe, metadata)
 	if err != nil {
 		statusCode := getErrorStatusCode(err)
 		writeResponse(
@@ -104,11 +261,24 @@ func (r *Router) loginUser(ctx *gin.Context) {
 		return
 	}
 
+	writeLoginResponse(ctx, tokens, dto.RememberMe)
+}
+
+// writeLoginResponse writes the tokens of a new session: the access
+// token in the Authorization header, the refresh token in a cookie and
+// the ID token in the body
+func writeLoginResponse(ctx *gin.Context, tokens *IDTokens, rememberMe bool) {
 	if ctx.Writer.Header().Get("Authorization") == "" {
 		ctx.Writer.Header().Add("Authorization", "Bearer "+tokens.AccessToken)
 	}
-	ctx.SetCookie("refresh_token", tokens.RefreshToken, 3600, "/", "localhost", false, true)
-	writeResponse(ctx, http.StatusOK, true, nil, nil)
+	// without "remember me" the refresh token is a browser session cookie,
+	// otherwise it is kept until the session reaches its maximum lifetime
+	maxAge := 0
+	if rememberMe {
+		maxAge = int(time.Until(tokens.RefreshExpiresAt).Seconds())
+	}
+	ctx.SetCookie("refresh_token", tokens.RefreshToken, maxAge, "/", "localhost", false, true)
+	writeResponse(ctx, http.StatusOK, true, gin.H{"id_token": tokens.IDToken}, nil)
 }
 
 // validateAccessToken handles the http request to validate an access token
@@ -139,6 +309,13 @@ func (r *Router) validateAccessToken(ctx *gin.Context) {
 	}
 	accessToken := parts[1]
 
+	// services validating the tokens of machine clients tell which
+	// audience they are
+	if audience := ctx.Query("audience"); audience != "" && isClientAccessToken(accessToken) {
+		r.validateClientToken(ctx, accessToken, audience)
+		return
+	}
+
 	user, err := r.usecases.ValidateAccessToken(ctx, accessToken)
 	if err != nil {
 		if err == ExpiredTokenError {
@@ -159,14 +336,37 @@ func (r *Router) validateAccessToken(ctx *gin.Context) {
 	}
 
 	userdata := middleware.UserData{
-		UserID:    user.UserID.String(),
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
+		UserID:      user.UserID.String(),
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Roles:       user.Roles,
+		Permissions: user.Permissions,
+		Scopes:      user.Scopes,
+		ClientID:    user.ClientID,
 	}
 
 	writeResponse(ctx, http.StatusOK, true, userdata, nil)
 }
 
+// validateClientToken handles the validation of an access token issued
+// to a machine client for the given audience
+func (r *Router) validateClientToken(ctx *gin.Context, accessToken, audience string) {
+	service, err := r.usecases.ValidateClientToken(ctx, accessToken, audience)
+	if err != nil {
+		statusCode := getErrorStatusCode(err)
+		if err == ExpiredTokenError {
+			statusCode = http.StatusUnauthorized
+		}
+		writeResponse(ctx, statusCode, false, nil, fmt.Errorf("could not validate access token: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, middleware.UserData{
+		Scopes:   service.Scopes,
+		ClientID: service.ClientID,
+		Service:  true,
+	}, nil)
+}
+
 // refreshToken handles the http request to refresh an access token
 func (r *Router) refreshToken(ctx *gin.Context) {
 	r.logger.Info("Refreshing access token")
@@ -204,7 +404,65 @@ func (r *Router) refreshToken(ctx *gin.Context) {
 	}
 
 	ctx.Writer.Header().Add("Authorization", "Bearer "+tokens.AccessToken)
-	writeResponse(ctx, http.StatusOK, true, nil, nil)
+	writeResponse(ctx, http.StatusOK, true, gin.H{"id_token": tokens.IDToken}, nil)
+}
+
+// checkAccess handles the http request to decide whether the
+// authenticated user may perform an action on a resource
+func (r *Router) checkAccess(ctx *gin.Context) {
+	var dto checkAccessDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(
+			ctx,
+			http.StatusBadRequest,
+			false,
+			nil,
+			fmt.Errorf("could not bind json: %w", err),
+		)
+		return
+	}
+	user, _ := middleware.FromContext(ctx.Request.Context())
+
+	decision, err := r.usecases.CheckAccess(user, dto.Subject, dto.Action, dto.Resource)
+	if err != nil {
+		statusCode := getErrorStatusCode(err)
+		writeResponse(
+			ctx,
+			statusCode,
+			false,
+			nil,
+			fmt.Errorf("could not check access: %w", err),
+		)
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, decision, nil)
+}
+
+// jwks handles the http request to get the public keys verifying
+// the access tokens, allowing other services to verify them locally
+func (r *Router) jwks(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, r.usecases.JWKS())
+}
+
+// openIDConfiguration handles the http request to get the OpenID
+// Connect discovery document
+func (r *Router) openIDConfiguration(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=3600")
+	ctx.JSON(http.StatusOK, r.usecases.OpenIDConfiguration())
+}
+
+// userInfo handles the http request to get the standard claims of
+// the authenticated user
+func (r *Router) userInfo(ctx *gin.Context) {
+	user, _ := middleware.FromContext(ctx.Request.Context())
+	userInfo, err := r.usecases.GetUserInfo(ctx, user.UserID, user.ClientID, user.Scopes)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not get user info: %w", err))
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, userInfo)
 }
 
 // getErrorStatusCode returns the status code for an error
@@ -212,6 +470,12 @@ func getErrorStatusCode(err error) int {
 	switch err.(type) {
 	case *ValidationError:
 		return http.StatusBadRequest
+	case *NotFoundError:
+		return http.StatusNotFound
+	case *SessionLimitError:
+		return http.StatusConflict
+	case *UnsupportedError:
+		return http.StatusNotImplemented
 	case *ServerError:
 		return http.StatusInternalServerError
 	default:
@@ -0,0 +1,32 @@
+// Package claim parses the claims of the access tokens issued by the
+// auth service, shared by the service and its middleware
+package claim
+
+import (
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Strings returns a claim holding a list of strings, such as the roles
+// of an access token. Missing or malformed claims are empty.
+func Strings(claims jwt.MapClaims, name string) []string {
+	values, _ := claims[name].([]interface{})
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
+
+// Scope returns the scopes of the space separated scope claim of an
+// OAuth access token, nil for the tokens of first-party apps
+func Scope(claims jwt.MapClaims) []string {
+	scope, ok := claims["scope"].(string)
+	if !ok {
+		return nil
+	}
+	return strings.Fields(scope)
+}
@@ -0,0 +1,255 @@
+package betalinkauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	betalinklogger "github.com/BragdonD/betalink-logger"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// JanitorLockID is the postgres advisory lock held by the replica
+// currently running a cleanup
+const JanitorLockID int64 = 0x62657461_6a616e69 // "betajani"
+
+// Janitor periodically deletes expired sessions, expired revoked access
+// tokens, stale email verification and password recovery tokens and old
+// login events. Several replicas may run a janitor against the same
+// database, an advisory lock ensures only one of them cleans up at a
+// time.
+type Janitor struct {
+	logger *betalinklogger.Logger
+	pool   *pgxpool.Pool
+	// store is cleaned up instead of the database of pool, by the
+	// janitors of NewStoreJanitor
+	store  Store
+	config JanitorConfig
+}
+
+// NewJanitor creates a new Janitor instance
+func NewJanitor(logger *betalinklogger.Logger, pool *pgxpool.Pool, config JanitorConfig) (*Janitor, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &Janitor{
+		logger: logger,
+		pool:   pool,
+		config: config,
+	}, nil
+}
+
+// NewStoreJanitor creates a new Janitor instance deleting the expired
+// sessions and revoked access tokens, the stale password recovery tokens
+// and the old login events of a store, for the deployments
+// without PostgreSQL. The store must not be shared between processes,
+// no lock is taken.
+func NewStoreJanitor(logger *betalinklogger.Logger, store Store, config JanitorConfig) (*Janitor, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &Janitor{
+		logger: logger,
+		store:  store,
+		config: config,
+	}, nil
+}
+
+// Run cleans up the database every configured interval until the
+// context is cancelled. It returns once the running cleanup, if any,
+// has been interrupted.
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.Cleanup(ctx); err != nil && ctx.Err() == nil {
+			j.logger.Error(fmt.Errorf("could not clean up the database: %w", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Cleanup runs a single cleanup, unless another replica is already
+// running one
+func (j *Janitor) Cleanup(ctx context.Context) error {
+	maxAgeSeconds := int32(j.config.TokenMaxAge / time.Second)
+	if j.pool == nil {
+		return j.runTasks(ctx, append([]janitorTask{
+			{
+				name: "expired sessions",
+				delete: func() (int64, error) {
+					return j.store.DeleteExpiredSessions(ctx, j.config.BatchSize)
+				},
+			},
+			{
+				name: "expired revoked access tokens",
+				delete: func() (int64, error) {
+					return j.store.DeleteExpiredRevokedAccessTokens(ctx, j.config.BatchSize)
+				},
+			},
+			{
+				name: "stale password recoveries",
+				delete: func() (int64, error) {
+					return j.store.DeleteStalePasswordRecoveries(ctx, DeleteStalePasswordRecoveriesParams{
+						MaxAgeSeconds: maxAgeSeconds,
+						BatchSize:     j.config.BatchSize,
+					})
+				},
+			},
+		}, j.loginEventTasks(ctx, j.store)...))
+	}
+
+	// advisory locks belong to a database session, so the lock and
+	// unlock must go through the same connection
+	conn, err := j.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("could not acquire connection: %w", err)
+	}
+	defer conn.Release()
+	queries := New(conn)
+
+	locked, err := queries.TryAdvisoryLock(ctx, JanitorLockID)
+	if err != nil {
+		return fmt.Errorf("could not acquire janitor lock: %w", err)
+	}
+	if !locked {
+		j.logger.Info("Skipping cleanup, another janitor is running")
+		return nil
+	}
+	defer func() {
+		// the cleanup context may be cancelled already
+		if _, err := queries.AdvisoryUnlock(context.Background(), JanitorLockID); err != nil {
+			j.logger.Error(fmt.Errorf("could not release janitor lock: %w", err))
+		}
+	}()
+
+	return j.runTasks(ctx, append([]janitorTask{
+		{
+			name: "expired sessions",
+			delete: func() (int64, error) {
+				return queries.DeleteExpiredSessions(ctx, j.config.BatchSize)
+			},
+		},
+		{
+			name: "expired revoked access tokens",
+			delete: func() (int64, error) {
+				return queries.DeleteExpiredRevokedAccessTokens(ctx, j.config.BatchSize)
+			},
+		},
+		{
+			name: "expired authorization codes",
+			delete: func() (int64, error) {
+				return queries.DeleteExpiredAuthorizationCodes(ctx, j.config.BatchSize)
+			},
+		},
+		{
+			name: "expired client assertions",
+			delete: func() (int64, error) {
+				return queries.DeleteExpiredClientAssertions(ctx, j.config.BatchSize)
+			},
+		},
+		{
+			name: "expired device authorizations",
+			delete: func() (int64, error) {
+				return queries.DeleteExpiredDeviceAuthorizations(ctx, j.config.BatchSize)
+			},
+		},
+		{
+			name: "expired external login states",
+			delete: func() (int64, error) {
+				return queries.DeleteExpiredExternalLoginStates(ctx, j.config.BatchSize)
+			},
+		},
+		{
+			name: "expired SAML requests",
+			delete: func() (int64, error) {
+				return queries.DeleteExpiredSAMLRequests(ctx, j.config.BatchSize)
+			},
+		},
+		{
+			name: "stale email verifications",
+			delete: func() (int64, error) {
+				return queries.DeleteStaleEmailVerifications(ctx, DeleteStaleEmailVerificationsParams{
+					MaxAgeSeconds: maxAgeSeconds,
+					BatchSize:     j.config.BatchSize,
+				})
+			},
+		},
+		{
+			name: "stale password recoveries",
+			delete: func() (int64, error) {
+				return queries.DeleteStalePasswordRecoveries(ctx, DeleteStalePasswordRecoveriesParams{
+					MaxAgeSeconds: maxAgeSeconds,
+					BatchSize:     j.config.BatchSize,
+				})
+			},
+		},
+	}, j.loginEventTasks(ctx, queries)...))
+}
+
+// loginEventTasks returns the task deleting the login events older than
+// the configured max age from a store, none when they are kept forever
+func (j *Janitor) loginEventTasks(ctx context.Context, store Store) []janitorTask {
+	if j.config.LoginEventMaxAge == 0 {
+		return nil
+	}
+	occurredBefore := pgtype.Timestamptz{Time: time.Now().Add(-j.config.LoginEventMaxAge), Valid: true}
+	return []janitorTask{
+		{
+			name: "old login events",
+			delete: func() (int64, error) {
+				return store.DeleteOldLoginEvents(ctx, DeleteOldLoginEventsParams{
+					OccurredBefore: occurredBefore,
+					BatchSize:      j.config.BatchSize,
+				})
+			},
+		},
+	}
+}
+
+// janitorTask deletes a kind of rows, a batch at a time
+type janitorTask struct {
+	name   string
+	delete func() (int64, error)
+}
+
+// runTasks deletes the rows of each task in turn, stopping at the first
+// failure
+func (j *Janitor) runTasks(ctx context.Context, tasks []janitorTask) error {
+	for _, task := range tasks {
+		deleted, err := deleteInBatches(ctx, j.config.BatchSize, task.delete)
+		if deleted > 0 {
+			j.logger.Infof("Deleted %d %s", deleted, task.name)
+		}
+		if err != nil {
+			return fmt.Errorf("could not delete %s: %w", task.name, err)
+		}
+	}
+	return nil
+}
+
+// deleteInBatches calls deleteBatch until it deletes less than a full
+// batch or the context is cancelled, and returns the number of deleted rows
+func deleteInBatches(ctx context.Context, batchSize int32, deleteBatch func() (int64, error)) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		deleted, err := deleteBatch()
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,168 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+func createPgxPool() (*pgxpool.Pool, error) {
+	dbURL, err := dbContainer.ConnectionString(testCtx)
+	if err != nil {
+		return nil, err
+	}
+	return pgxpool.New(testCtx, dbURL)
+}
+
+func TestJanitor_Cleanup(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	pool, err := createPgxPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	queries := betalinkauth.New(pool)
+	logger, err := createLogger()
+	require.NoError(t, err)
+
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+	janitor, err := betalinkauth.NewJanitor(logger, pool, betalinkauth.JanitorConfig{
+		Interval:    time.Minute,
+		BatchSize:   2,
+		TokenMaxAge: time.Hour,
+	})
+	require.NoError(t, err)
+
+	testEmail := "janitor.test@example.com"
+	testPassword := "JanitorTest123!"
+	err = usecases.RegisterUser(testCtx, "Janitor", "Test", testEmail, testPassword)
+	require.NoError(t, err)
+	loginData, err := queries.GetLoginDataByEmail(testCtx, testEmail)
+	require.NoError(t, err)
+
+	// open more expired sessions than a single batch deletes
+	var expiredSessions []betalinkauth.Session
+	for i := 0; i < 5; i++ {
+		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+		session := getTestSession(t, queries, tokens.RefreshToken)
+		err = queries.Test_UpdateSessionExpiresAt(testCtx, betalinkauth.Test_UpdateSessionExpiresAtParams{
+			ExpiresAt: pgtype.Timestamptz{
+				Time:  time.Now().Add(-1 * time.Hour),
+				Valid: true,
+			},
+			SessionID: session.SessionID,
+		})
+		require.NoError(t, err)
+		expiredSessions = append(expiredSessions, session)
+	}
+	tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+	require.NoError(t, err)
+	activeSession := getTestSession(t, queries, tokens.RefreshToken)
+
+	err = queries.CreateEmailVerification(testCtx, betalinkauth.CreateEmailVerificationParams{
+		UserID:            loginData.UserID,
+		VerificationToken: "used-verification-token",
+	})
+	require.NoError(t, err)
+	_, err = pool.Exec(testCtx, "UPDATE EmailVerification SET used = TRUE WHERE user_id = $1", loginData.UserID)
+	require.NoError(t, err)
+	err = queries.CreatePasswordRecovery(testCtx, betalinkauth.CreatePasswordRecoveryParams{
+		UserID:        loginData.UserID,
+		RecoveryToken: "fresh-recovery-token",
+	})
+	require.NoError(t, err)
+	for i, expiresAt := range []time.Time{time.Now().Add(-time.Minute), time.Now().Add(time.Hour)} {
+		err = queries.RevokeAccessToken(testCtx, betalinkauth.RevokeAccessTokenParams{
+			TokenID:   pgtype.UUID{Bytes: [16]byte{byte(i + 1)}, Valid: true},
+			ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
+		})
+		require.NoError(t, err)
+	}
+	client, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{Name: "janitor-client"})
+	require.NoError(t, err)
+	for i, expiresAt := range []time.Time{time.Now().Add(-time.Minute), time.Now().Add(time.Minute)} {
+		err = queries.CreateAuthorizationCode(testCtx, betalinkauth.CreateAuthorizationCodeParams{
+			CodeHash:    fmt.Sprintf("authorization-code-%d", i),
+			ClientID:    client.ClientID,
+			UserID:      loginData.UserID,
+			RedirectUri: "https://client.example.com/callback",
+			AuthTime:    pgtype.Timestamptz{Time: time.Now(), Valid: true},
+			ExpiresAt:   pgtype.Timestamptz{Time: expiresAt, Valid: true},
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("skips cleanup while another replica holds the lock", func(t *testing.T) {
+		conn, err := pool.Acquire(testCtx)
+		require.NoError(t, err)
+		defer conn.Release()
+		otherReplica := betalinkauth.New(conn)
+		locked, err := otherReplica.TryAdvisoryLock(testCtx, betalinkauth.JanitorLockID)
+		require.NoError(t, err)
+		require.True(t, locked)
+		defer otherReplica.AdvisoryUnlock(context.Background(), betalinkauth.JanitorLockID)
+
+		err = janitor.Cleanup(testCtx)
+		require.NoError(t, err)
+		_, err = queries.GetSessionById(testCtx, expiredSessions[0].SessionID)
+		require.NoError(t, err)
+	})
+
+	t.Run("deletes expired and used rows", func(t *testing.T) {
+		err := janitor.Cleanup(testCtx)
+		require.NoError(t, err)
+
+		for _, session := range expiredSessions {
+			_, err = queries.GetSessionById(testCtx, session.SessionID)
+			require.ErrorIs(t, err, pgx.ErrNoRows)
+		}
+		_, err = queries.GetSessionById(testCtx, activeSession.SessionID)
+		require.NoError(t, err)
+
+		var verifications, recoveries int
+		err = pool.QueryRow(testCtx, "SELECT COUNT(*) FROM EmailVerification").Scan(&verifications)
+		require.NoError(t, err)
+		require.Zero(t, verifications)
+		err = pool.QueryRow(testCtx, "SELECT COUNT(*) FROM PasswordRecovery").Scan(&recoveries)
+		require.NoError(t, err)
+		require.Equal(t, 1, recoveries)
+
+		var revokedTokens int
+		err = pool.QueryRow(testCtx, "SELECT COUNT(*) FROM RevokedAccessTokens").Scan(&revokedTokens)
+		require.NoError(t, err)
+		require.Equal(t, 1, revokedTokens)
+
+		var authorizationCodes int
+		err = pool.QueryRow(testCtx, "SELECT COUNT(*) FROM AuthorizationCodes").Scan(&authorizationCodes)
+		require.NoError(t, err)
+		require.Equal(t, 1, authorizationCodes)
+	})
+
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testCtx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			janitor.Run(ctx)
+		}()
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("janitor did not stop")
+		}
+	})
+}
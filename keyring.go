@@ -0,0 +1,351 @@
+package betalinkauth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// encryptionKeySize is the size in bytes of the AES-256 keys
+	encryptionKeySize = 32
+	// encryptionKeyIDSize is the size in bytes of the random key IDs
+	encryptionKeyIDSize = 8
+	// encryptedSecretPrefix prefixes the secrets encrypted by a keyring,
+	// followed by the key ID, the wrapped data key and the ciphertext
+	encryptedSecretPrefix = "enc:v1:"
+)
+
+// associated data binding the encrypted secrets to the column storing
+// them, so a ciphertext cannot be moved to another column
+const (
+	secretProviderAccessToken  = "UserLoginExternal.provider_access_token"
+	secretProviderRefreshToken = "UserLoginExternal.provider_refresh_token"
+	secretProviderClientSecret = "ExternalLoginProviders.client_secret"
+	secretCodeVerifier         = "ExternalLoginStates.code_verifier"
+)
+
+// EncryptionKey is an AES-256 key encrypting the data keys of the
+// secrets persisted by the auth service
+type EncryptionKey struct {
+	// ID identifies the key in the secrets it encrypts
+	ID        string
+	Key       []byte
+	CreatedAt time.Time
+}
+
+// GenerateEncryptionKey generates a new random encryption key
+func GenerateEncryptionKey() (*EncryptionKey, error) {
+	key := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate encryption key: %w", err)
+	}
+	id, err := randomString(encryptionKeyIDSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate encryption key ID: %w", err)
+	}
+	return &EncryptionKey{
+		ID:        id,
+		Key:       key,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}, nil
+}
+
+// Keyring encrypts the secrets persisted by the auth service with
+// envelope encryption: each secret is encrypted with its own data key,
+// itself encrypted with the primary key of the keyring. The previous
+// keys are kept to decrypt the secrets encrypted before a rotation,
+// until they are re-encrypted.
+type Keyring struct {
+	mu      sync.RWMutex
+	primary *EncryptionKey
+	keys    map[string]*EncryptionKey
+}
+
+// NewKeyring creates a keyring encrypting secrets with the primary key
+// and decrypting them with any of the given keys
+func NewKeyring(primary *EncryptionKey, previous ...*EncryptionKey) *Keyring {
+	keyring := &Keyring{
+		keys: make(map[string]*EncryptionKey),
+	}
+	for _, key := range previous {
+		keyring.keys[key.ID] = key
+	}
+	keyring.Rotate(primary)
+	return keyring
+}
+
+// keyringFile is the JSON form of a keyring file
+type keyringFile struct {
+	Primary string           `json:"primary"`
+	Keys    []keyringFileKey `json:"keys"`
+}
+
+// keyringFileKey is the JSON form of a key of a keyring file
+type keyringFileKey struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoadKeyring loads a keyring from a JSON keyring file
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyring: %w", err)
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not decode keyring: %w", err)
+	}
+
+	var primary *EncryptionKey
+	previous := make([]*EncryptionKey, 0, len(file.Keys))
+	for _, fileKey := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(fileKey.Key)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode key [%s]: %w", fileKey.ID, err)
+		}
+		if len(key) != encryptionKeySize || fileKey.ID == "" {
+			return nil, fmt.Errorf("invalid key [%s]: keys must have an ID and %d bytes", fileKey.ID, encryptionKeySize)
+		}
+		encryptionKey := &EncryptionKey{
+			ID:        fileKey.ID,
+			Key:       key,
+			CreatedAt: fileKey.CreatedAt,
+		}
+		if fileKey.ID == file.Primary {
+			primary = encryptionKey
+		} else {
+			previous = append(previous, encryptionKey)
+		}
+	}
+	if primary == nil {
+		return nil, fmt.Errorf("primary key [%s] not found in %s", file.Primary, path)
+	}
+	return NewKeyring(primary, previous...), nil
+}
+
+// Save writes the keyring to a JSON keyring file, readable by its owner
+// only. The file is replaced atomically so a crash cannot lose keys.
+func (k *Keyring) Save(path string) error {
+	k.mu.RLock()
+	file := keyringFile{Primary: k.primary.ID}
+	for _, key := range k.keys {
+		file.Keys = append(file.Keys, keyringFileKey{
+			ID:        key.ID,
+			Key:       base64.StdEncoding.EncodeToString(key.Key),
+			CreatedAt: key.CreatedAt,
+		})
+	}
+	k.mu.RUnlock()
+	sort.Slice(file.Keys, func(i, j int) bool {
+		return file.Keys[i].CreatedAt.Before(file.Keys[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode keyring: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create keyring file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write keyring file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write keyring file: %w", err)
+	}
+	// temporary files are created readable by their owner only
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not replace keyring file: %w", err)
+	}
+	return nil
+}
+
+// Rotate makes key the primary key. The previous primary key is kept to
+// decrypt the secrets it encrypted.
+func (k *Keyring) Rotate(key *EncryptionKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.primary = key
+	k.keys[key.ID] = key
+}
+
+// Retire removes a key from the keyring, the secrets it encrypted can no
+// longer be decrypted and must have been re-encrypted. The primary key
+// cannot be retired.
+func (k *Keyring) Retire(keyID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.primary.ID == keyID {
+		return errors.New("cannot retire the primary encryption key")
+	}
+	if _, ok := k.keys[keyID]; !ok {
+		return fmt.Errorf("unknown encryption key [%s]", keyID)
+	}
+	delete(k.keys, keyID)
+	return nil
+}
+
+// PrimaryKeyID returns the ID of the key encrypting new secrets
+func (k *Keyring) PrimaryKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary.ID
+}
+
+// KeyIDs returns the IDs of the keys of the keyring, oldest first
+func (k *Keyring) KeyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]*EncryptionKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.ID)
+	}
+	return ids
+}
+
+// Encrypt encrypts a secret with a new data key, bound to the associated
+// data. Empty secrets are stored as is.
+func (k *Keyring) Encrypt(plaintext, associatedData string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	k.mu.RLock()
+	primary := k.primary
+	k.mu.RUnlock()
+
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("could not generate data key: %w", err)
+	}
+	// the data key is bound to the key ID, the secret to its column
+	wrappedKey, err := seal(primary.Key, dataKey, []byte(primary.ID))
+	if err != nil {
+		return "", fmt.Errorf("could not encrypt data key: %w", err)
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), []byte(associatedData))
+	if err != nil {
+		return "", fmt.Errorf("could not encrypt secret: %w", err)
+	}
+	return encryptedSecretPrefix + primary.ID +
+		":" + base64.RawURLEncoding.EncodeToString(wrappedKey) +
+		":" + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts a secret encrypted with the associated data. Secrets
+// stored before they were encrypted are returned as is, until they are
+// re-encrypted.
+func (k *Keyring) Decrypt(secret, associatedData string) (string, error) {
+	if !strings.HasPrefix(secret, encryptedSecretPrefix) {
+		return secret, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(secret, encryptedSecretPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted secret")
+	}
+	keyID := parts[0]
+	k.mu.RLock()
+	key, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown encryption key [%s]", keyID)
+	}
+
+	wrappedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("could not decode data key: %w", err)
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("could not decode secret: %w", err)
+	}
+	dataKey, err := unseal(key.Key, wrappedKey, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt data key: %w", err)
+	}
+	plaintext, err := unseal(dataKey, ciphertext, []byte(associatedData))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencryption reports whether a secret is stored in plaintext or
+// encrypted with another key than the primary one
+func (k *Keyring) NeedsReencryption(secret string) bool {
+	if secret == "" {
+		return false
+	}
+	if !strings.HasPrefix(secret, encryptedSecretPrefix) {
+		return true
+	}
+	keyID, _, _ := strings.Cut(strings.TrimPrefix(secret, encryptedSecretPrefix), ":")
+	return keyID != k.PrimaryKeyID()
+}
+
+// reencrypt decrypts a secret and encrypts it again with the primary key
+func (k *Keyring) reencrypt(secret, associatedData string) (string, error) {
+	plaintext, err := k.Decrypt(secret, associatedData)
+	if err != nil {
+		return "", err
+	}
+	return k.Encrypt(plaintext, associatedData)
+}
+
+// seal encrypts plaintext with AES-GCM, prefixing the ciphertext with
+// its random nonce
+func seal(key, plaintext, associatedData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+// unseal decrypts a ciphertext sealed by seal
+func unseal(key, ciphertext, associatedData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, associatedData)
+}
+
+// newGCM returns the AES-GCM cipher of a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,118 @@
+package betalinkauth_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring_Encrypt(t *testing.T) {
+	key, err := betalinkauth.GenerateEncryptionKey()
+	require.NoError(t, err)
+	keyring := betalinkauth.NewKeyring(key)
+
+	encrypted, err := keyring.Encrypt("provider-token", "column")
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, "provider-token")
+	assert.Contains(t, encrypted, key.ID)
+	assert.False(t, keyring.NeedsReencryption(encrypted))
+
+	decrypted, err := keyring.Decrypt(encrypted, "column")
+	require.NoError(t, err)
+	assert.Equal(t, "provider-token", decrypted)
+
+	// each secret has its own data key
+	again, err := keyring.Encrypt("provider-token", "column")
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, again)
+
+	t.Run("secret moved to another column", func(t *testing.T) {
+		_, err := keyring.Decrypt(encrypted, "another column")
+		assert.Error(t, err)
+	})
+
+	t.Run("tampered secret", func(t *testing.T) {
+		tampered := encrypted[:len(encrypted)-2] + "AA"
+		if tampered == encrypted {
+			tampered = encrypted[:len(encrypted)-2] + "BB"
+		}
+		_, err := keyring.Decrypt(tampered, "column")
+		assert.Error(t, err)
+		_, err = keyring.Decrypt("enc:v1:"+key.ID+":malformed", "column")
+		assert.Error(t, err)
+	})
+
+	t.Run("plaintext secrets stored before encryption", func(t *testing.T) {
+		decrypted, err := keyring.Decrypt("legacy-token", "column")
+		require.NoError(t, err)
+		assert.Equal(t, "legacy-token", decrypted)
+		assert.True(t, keyring.NeedsReencryption("legacy-token"))
+
+		empty, err := keyring.Encrypt("", "column")
+		require.NoError(t, err)
+		assert.Empty(t, empty)
+		assert.False(t, keyring.NeedsReencryption(empty))
+	})
+}
+
+func TestKeyring_Rotate(t *testing.T) {
+	oldKey, err := betalinkauth.GenerateEncryptionKey()
+	require.NoError(t, err)
+	keyring := betalinkauth.NewKeyring(oldKey)
+	encrypted, err := keyring.Encrypt("client-secret", "column")
+	require.NoError(t, err)
+
+	newKey, err := betalinkauth.GenerateEncryptionKey()
+	require.NoError(t, err)
+	keyring.Rotate(newKey)
+	assert.Equal(t, newKey.ID, keyring.PrimaryKeyID())
+	assert.True(t, keyring.NeedsReencryption(encrypted))
+
+	// the previous key still decrypts the secrets it encrypted
+	decrypted, err := keyring.Decrypt(encrypted, "column")
+	require.NoError(t, err)
+	assert.Equal(t, "client-secret", decrypted)
+
+	assert.Error(t, keyring.Retire(newKey.ID), "the primary key cannot be retired")
+	require.NoError(t, keyring.Retire(oldKey.ID))
+	_, err = keyring.Decrypt(encrypted, "column")
+	assert.Error(t, err)
+}
+
+func TestLoadKeyring(t *testing.T) {
+	oldKey, err := betalinkauth.GenerateEncryptionKey()
+	require.NoError(t, err)
+	newKey, err := betalinkauth.GenerateEncryptionKey()
+	require.NoError(t, err)
+	keyring := betalinkauth.NewKeyring(newKey, oldKey)
+	encrypted, err := keyring.Encrypt("client-secret", "column")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	require.NoError(t, keyring.Save(path))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := betalinkauth.LoadKeyring(path)
+	require.NoError(t, err)
+	assert.Equal(t, newKey.ID, loaded.PrimaryKeyID())
+	assert.ElementsMatch(t, []string{oldKey.ID, newKey.ID}, loaded.KeyIDs())
+	decrypted, err := loaded.Decrypt(encrypted, "column")
+	require.NoError(t, err)
+	assert.Equal(t, "client-secret", decrypted)
+
+	t.Run("missing primary key", func(t *testing.T) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		broken := strings.Replace(string(data), `"primary": "`+newKey.ID, `"primary": "unknown`, 1)
+		require.NoError(t, os.WriteFile(path, []byte(broken), 0600))
+		_, err = betalinkauth.LoadKeyring(path)
+		assert.Error(t, err)
+	})
+}
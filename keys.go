@@ -0,0 +1,376 @@
+package betalinkauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// signingKeySize is the size in bits of generated signing keys
+	signingKeySize = 2048
+	// tokenIssuer is the issuer of the tokens signed by the auth service
+	tokenIssuer = "betalink-auth"
+	// tokenAudience is the audience of the access tokens
+	tokenAudience = "betalink"
+)
+
+// SigningKey is a RSA key used to sign access tokens
+type SigningKey struct {
+	// ID identifies the key in the "kid" header of the tokens it signs.
+	// It is the RFC 7638 thumbprint of the public key.
+	ID         string
+	PrivateKey *rsa.PrivateKey
+}
+
+// NewSigningKey creates a signing key from a RSA private key
+func NewSigningKey(privateKey *rsa.PrivateKey) (*SigningKey, error) {
+	thumbprint, err := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   encodeBigInt(big.NewInt(int64(privateKey.PublicKey.E))),
+		Kty: "RSA",
+		N:   encodeBigInt(privateKey.PublicKey.N),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not compute key thumbprint: %w", err)
+	}
+	hash := sha256.Sum256(thumbprint)
+	return &SigningKey{
+		ID:         base64.RawURLEncoding.EncodeToString(hash[:]),
+		PrivateKey: privateKey,
+	}, nil
+}
+
+// GenerateSigningKey generates a new random signing key
+func GenerateSigningKey() (*SigningKey, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, signingKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate RSA key: %w", err)
+	}
+	return NewSigningKey(privateKey)
+}
+
+// LoadSigningKey loads a signing key from a PEM encoded PKCS#1 or
+// PKCS#8 RSA private key file
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode signing key: no PEM block found in %s", path)
+	}
+
+	var privateKey *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var key interface{}
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if privateKey, ok = key.(*rsa.PrivateKey); !ok {
+				err = fmt.Errorf("unsupported key type %T", key)
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing key: %w", err)
+	}
+	return NewSigningKey(privateKey)
+}
+
+// EncodePEM returns the PEM encoded PKCS#8 form of the signing key
+func (k *SigningKey) EncodePEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal signing key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// JSONWebKey is the public part of a signing key, as published
+// in a JSON Web Key Set (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JSONWebKeySet is a set of public keys (RFC 7517)
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// KeySet holds the key signing new access tokens along with the
+// previous keys, still accepted to verify tokens issued before a rotation
+type KeySet struct {
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// NewKeySet creates a key set signing tokens with the active key and
+// accepting tokens signed by any of the given keys
+func NewKeySet(active *SigningKey, previous ...*SigningKey) *KeySet {
+	keySet := &KeySet{
+		keys: make(map[string]*SigningKey),
+	}
+	for _, key := range previous {
+		keySet.keys[key.ID] = key
+	}
+	keySet.Rotate(active)
+	return keySet
+}
+
+// Rotate makes key the active signing key. The previously active
+// key is kept to verify the tokens it signed.
+func (k *KeySet) Rotate(key *SigningKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active = key
+	k.keys[key.ID] = key
+}
+
+// Retire removes a key from the set, tokens signed with it are no
+// longer accepted. The active key cannot be retired.
+func (k *KeySet) Retire(keyID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.active.ID == keyID {
+		return fmt.Errorf("cannot retire the active signing key")
+	}
+	delete(k.keys, keyID)
+	return nil
+}
+
+// JWKS returns the public keys of the set
+func (k *KeySet) JWKS() JSONWebKeySet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	jwks := JSONWebKeySet{
+		Keys: make([]JSONWebKey, 0, len(k.keys)),
+	}
+	// the active key comes first, followed by the previous keys
+	keys := []*SigningKey{k.active}
+	for _, key := range k.keys {
+		if key.ID != k.active.ID {
+			keys = append(keys, key)
+		}
+	}
+	previous := keys[1:]
+	sort.Slice(previous, func(i, j int) bool {
+		return previous[i].ID < previous[j].ID
+	})
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, JSONWebKey{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: key.ID,
+			N:   encodeBigInt(key.PrivateKey.PublicKey.N),
+			E:   encodeBigInt(big.NewInt(int64(key.PrivateKey.PublicKey.E))),
+		})
+	}
+	return jwks
+}
+
+// SignJWT signs a JWT containing the provided claims with the active key
+func (k *KeySet) SignJWT(claims jwt.MapClaims) (string, error) {
+	k.mu.RLock()
+	active := k.active
+	k.mu.RUnlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = active.ID
+	return token.SignedString(active.PrivateKey)
+}
+
+// ParseJWT parses a JWT signed by one of the keys of the set
+func (k *KeySet) ParseJWT(token string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		k.mu.RLock()
+		key, ok := k.keys[keyID]
+		k.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", keyID)
+		}
+		return &key.PrivateKey.PublicKey, nil
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse token: %w", err)
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("could not extract claims from token")
+	}
+	return claims, nil
+}
+
+// AccessTokenClaims are the user claims carried by an access token
+type AccessTokenClaims struct {
+	UserID    string
+	FirstName string
+	LastName  string
+	Roles     []string
+	// Permissions are granted to the user on top of those implied by
+	// its roles
+	Permissions []string
+	// SessionID is the session the token is issued for, ending the
+	// session revokes the token. Tokens issued without a session cannot
+	// be revoked this way.
+	SessionID string
+	// ClientID is the OAuth client the token is issued to, empty for the
+	// first-party apps logging in directly
+	ClientID string
+	// Scopes are the scopes granted to the OAuth client
+	Scopes []string
+}
+
+// GenerateAccessToken generates an access token signed with the active key
+func (k *KeySet) GenerateAccessToken(claims AccessTokenClaims, validity time.Duration) (string, error) {
+	now := time.Now()
+	mapClaims := jwt.MapClaims{
+		"user_id":     claims.UserID,
+		"first_name":  claims.FirstName,
+		"last_name":   claims.LastName,
+		"roles":       claims.Roles,
+		"permissions": claims.Permissions,
+		"exp":         now.Add(validity).Unix(),
+		"iat":         now.Unix(),
+		"iss":         tokenIssuer,
+		"aud":         tokenAudience,
+		// the token ID allows revoking a single token
+		"jti": uuid.NewString(),
+	}
+	if claims.SessionID != "" {
+		mapClaims["sid"] = claims.SessionID
+	}
+	if claims.ClientID != "" {
+		mapClaims["client_id"] = claims.ClientID
+		mapClaims["scope"] = strings.Join(claims.Scopes, " ")
+	}
+	return k.SignJWT(mapClaims)
+}
+
+// ClientTokenClaims are the claims carried by an access token issued to
+// a machine client through the client credentials grant, on behalf of
+// no user
+type ClientTokenClaims struct {
+	ClientID string
+	// Audience is the service the token is issued for
+	Audience string
+	Scopes   []string
+}
+
+// GenerateClientToken generates an access token for a machine client
+// signed with the active key. It carries the gty claim set to
+// client_credentials and no user claims.
+func (k *KeySet) GenerateClientToken(claims ClientTokenClaims, validity time.Duration) (string, error) {
+	now := time.Now()
+	return k.SignJWT(jwt.MapClaims{
+		"sub":       claims.ClientID,
+		"client_id": claims.ClientID,
+		"scope":     strings.Join(claims.Scopes, " "),
+		"gty":       GrantTypeClientCredentials,
+		"exp":       now.Add(validity).Unix(),
+		"iat":       now.Unix(),
+		"iss":       tokenIssuer,
+		"aud":       claims.Audience,
+		"jti":       uuid.NewString(),
+	})
+}
+
+// IDTokenClaims are the claims carried by an OpenID Connect ID token
+type IDTokenClaims struct {
+	Issuer string
+	// Audience is the client the ID token is issued to
+	Audience      string
+	UserID        string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+	// Nonce is the value sent by the client in its authentication
+	// request, echoed to mitigate replay attacks
+	Nonce string
+	// AuthTime is when the user authenticated
+	AuthTime time.Time
+}
+
+// GenerateIDToken generates an OpenID Connect ID token signed with the
+// active key
+func (k *KeySet) GenerateIDToken(claims IDTokenClaims, validity time.Duration) (string, error) {
+	now := time.Now()
+	mapClaims := jwt.MapClaims{
+		"iss":       claims.Issuer,
+		"sub":       claims.UserID,
+		"aud":       claims.Audience,
+		"exp":       now.Add(validity).Unix(),
+		"iat":       now.Unix(),
+		"auth_time": claims.AuthTime.Unix(),
+	}
+	if claims.GivenName != "" || claims.FamilyName != "" {
+		mapClaims["given_name"] = claims.GivenName
+		mapClaims["family_name"] = claims.FamilyName
+	}
+	if claims.Email != "" {
+		mapClaims["email"] = claims.Email
+		mapClaims["email_verified"] = claims.EmailVerified
+	}
+	if claims.Nonce != "" {
+		mapClaims["nonce"] = claims.Nonce
+	}
+	return k.SignJWT(mapClaims)
+}
+
+// ValidateAccessToken validates an access token issued to a user and
+// signed by the key set
+func (k *KeySet) ValidateAccessToken(token string) (jwt.MapClaims, error) {
+	return k.ParseJWT(token, jwt.WithIssuer(tokenIssuer), jwt.WithAudience(tokenAudience))
+}
+
+// ParseAccessToken validates an access token signed by the key set,
+// whatever its audience, such as the tokens issued to machine clients
+func (k *KeySet) ParseAccessToken(token string) (jwt.MapClaims, error) {
+	return k.ParseJWT(token, jwt.WithIssuer(tokenIssuer))
+}
+
+// isClientToken reports whether the claims are those of a token issued
+// to a machine client through the client credentials grant
+func isClientToken(claims jwt.MapClaims) bool {
+	return claims["gty"] == GrantTypeClientCredentials
+}
+
+// encodeBigInt encodes an integer as the base64url encoding of its
+// big-endian representation, as required by JSON Web Keys
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
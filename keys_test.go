@@ -0,0 +1,189 @@
+package betalinkauth_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSigningKey(t *testing.T) {
+	key, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	encoded, err := key.EncodePEM()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "signing-key.pem")
+	require.NoError(t, os.WriteFile(path, encoded, 0600))
+
+	loadedKey, err := betalinkauth.LoadSigningKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, loadedKey.ID)
+	assert.True(t, key.PrivateKey.Equal(loadedKey.PrivateKey))
+}
+
+func TestKeySet_AccessToken(t *testing.T) {
+	key, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	keys := betalinkauth.NewKeySet(key)
+
+	token, err := keys.GenerateAccessToken(betalinkauth.AccessTokenClaims{
+		UserID:      "12345",
+		FirstName:   "John",
+		LastName:    "Doe",
+		Roles:       []string{"user"},
+		Permissions: []string{"profile:read"},
+		SessionID:   "67890",
+	}, time.Hour)
+	require.NoError(t, err)
+
+	parsedToken, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", parsedToken.Method.Alg())
+	assert.Equal(t, key.ID, parsedToken.Header["kid"])
+
+	claims, err := keys.ValidateAccessToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "12345", claims["user_id"])
+	assert.Equal(t, "John", claims["first_name"])
+	assert.Equal(t, "Doe", claims["last_name"])
+	assert.ElementsMatch(t, []string{"user"}, claims["roles"])
+	assert.ElementsMatch(t, []string{"profile:read"}, claims["permissions"])
+	assert.Equal(t, "betalink-auth", claims["iss"])
+	assert.Equal(t, "betalink", claims["aud"])
+	assert.Equal(t, "67890", claims["sid"])
+	assert.NotEmpty(t, claims["jti"])
+
+	otherToken, err := keys.GenerateAccessToken(betalinkauth.AccessTokenClaims{UserID: "12345"}, time.Hour)
+	require.NoError(t, err)
+	otherClaims, err := keys.ValidateAccessToken(otherToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, claims["jti"], otherClaims["jti"])
+	assert.NotContains(t, otherClaims, "sid")
+	assert.NotContains(t, otherClaims, "scope")
+
+	clientToken, err := keys.GenerateAccessToken(betalinkauth.AccessTokenClaims{
+		UserID:   "12345",
+		ClientID: "mobile-app",
+		Scopes:   []string{"openid", "profile"},
+	}, time.Hour)
+	require.NoError(t, err)
+	clientClaims, err := keys.ValidateAccessToken(clientToken)
+	require.NoError(t, err)
+	assert.Equal(t, "mobile-app", clientClaims["client_id"])
+	assert.Equal(t, "openid profile", clientClaims["scope"])
+}
+
+func TestKeySet_ClientToken(t *testing.T) {
+	key, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	keys := betalinkauth.NewKeySet(key)
+
+	token, err := keys.GenerateClientToken(betalinkauth.ClientTokenClaims{
+		ClientID: "orders-worker",
+		Audience: "billing",
+		Scopes:   []string{"billing:read", "billing:write"},
+	}, time.Hour)
+	require.NoError(t, err)
+
+	claims, err := keys.ParseAccessToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "orders-worker", claims["sub"])
+	assert.Equal(t, "orders-worker", claims["client_id"])
+	assert.Equal(t, "client_credentials", claims["gty"])
+	assert.Equal(t, "billing", claims["aud"])
+	assert.Equal(t, "billing:read billing:write", claims["scope"])
+	assert.NotContains(t, claims, "user_id")
+	assert.NotEmpty(t, claims["jti"])
+
+	// client tokens are not accepted as user access tokens
+	_, err = keys.ValidateAccessToken(token)
+	require.Error(t, err)
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	oldKey, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	newKey, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	keys := betalinkauth.NewKeySet(oldKey)
+
+	oldToken, err := keys.GenerateAccessToken(betalinkauth.AccessTokenClaims{UserID: "12345"}, time.Hour)
+	require.NoError(t, err)
+
+	keys.Rotate(newKey)
+	jwks := keys.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, newKey.ID, jwks.Keys[0].Kid)
+	assert.Equal(t, oldKey.ID, jwks.Keys[1].Kid)
+
+	// tokens signed before the rotation are still valid
+	_, err = keys.ValidateAccessToken(oldToken)
+	assert.NoError(t, err)
+
+	assert.Error(t, keys.Retire(newKey.ID))
+	require.NoError(t, keys.Retire(oldKey.ID))
+	_, err = keys.ValidateAccessToken(oldToken)
+	assert.Error(t, err)
+	assert.Len(t, keys.JWKS().Keys, 1)
+}
+
+func TestKeySet_RejectsSymmetricTokens(t *testing.T) {
+	key, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	keys := betalinkauth.NewKeySet(key)
+
+	token, err := betalinkauth.GenerateAccessToken("12345", []string{"user"}, "test-secret", time.Hour)
+	require.NoError(t, err)
+	_, err = keys.ValidateAccessToken(token)
+	assert.Error(t, err)
+}
+
+func TestKeySet_IDToken(t *testing.T) {
+	key, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	keys := betalinkauth.NewKeySet(key)
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	token, err := keys.GenerateIDToken(betalinkauth.IDTokenClaims{
+		Issuer:        "https://auth.betalink.com",
+		Audience:      "mobile-app",
+		UserID:        "12345",
+		Email:         "john.doe@example.com",
+		EmailVerified: true,
+		GivenName:     "John",
+		FamilyName:    "Doe",
+		Nonce:         "n-0S6_WzA2Mj",
+		AuthTime:      authTime,
+	}, time.Hour)
+	require.NoError(t, err)
+
+	claims, err := keys.ParseJWT(token, jwt.WithIssuer("https://auth.betalink.com"), jwt.WithAudience("mobile-app"))
+	require.NoError(t, err)
+	assert.Equal(t, "12345", claims["sub"])
+	assert.Equal(t, "john.doe@example.com", claims["email"])
+	assert.Equal(t, true, claims["email_verified"])
+	assert.Equal(t, "John", claims["given_name"])
+	assert.Equal(t, "Doe", claims["family_name"])
+	assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+	assert.Equal(t, float64(authTime.Unix()), claims["auth_time"])
+
+	// users without an email and requests without a nonce
+	token, err = keys.GenerateIDToken(betalinkauth.IDTokenClaims{
+		Issuer:   "https://auth.betalink.com",
+		Audience: "mobile-app",
+		UserID:   "12345",
+		AuthTime: authTime,
+	}, time.Hour)
+	require.NoError(t, err)
+	claims, err = keys.ParseJWT(token)
+	require.NoError(t, err)
+	assert.NotContains(t, claims, "email")
+	assert.NotContains(t, claims, "email_verified")
+	assert.NotContains(t, claims, "nonce")
+}
@@ -0,0 +1,197 @@
+package betalinkauth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Defaults of the LDAP configuration, matching the inetOrgPerson schema
+const (
+	DefaultLDAPUserFilter         = "(&(objectClass=inetOrgPerson)(mail=%s))"
+	DefaultLDAPFirstNameAttribute = "givenName"
+	DefaultLDAPLastNameAttribute  = "sn"
+	DefaultLDAPTimeout            = time.Second * 5
+)
+
+// LDAPConfig defines how users are authenticated against an LDAP
+// directory
+type LDAPConfig struct {
+	// URL is the address of the directory, an ldap:// or ldaps:// URL
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS before binding, so
+	// the passwords are never sent in plaintext
+	StartTLS  bool
+	TLSConfig *tls.Config
+	// BindDN and BindPassword are the service account searching the
+	// entries of the users. The search is anonymous without BindDN.
+	BindDN       string
+	BindPassword string
+	// BaseDN is the subtree the entries of the users are searched in
+	BaseDN string
+	// UserFilter finds the entry of a user, %s being replaced by its
+	// escaped email
+	UserFilter string
+	// FirstNameAttribute and LastNameAttribute are the attributes of the
+	// entries naming the users
+	FirstNameAttribute string
+	LastNameAttribute  string
+	// Timeout bounds the connection and each request to the directory
+	Timeout time.Duration
+}
+
+// LDAPBackend verifies the passwords of the accounts flagged as LDAP
+// with a simple bind as the entry of the user, found by a search of the
+// service account
+type LDAPBackend struct {
+	config LDAPConfig
+}
+
+// NewLDAPBackend creates a new LDAPBackend instance, the settings not
+// given falling back to the defaults
+func NewLDAPBackend(config LDAPConfig) (*LDAPBackend, error) {
+	directoryURL, err := url.Parse(config.URL)
+	if err != nil || (directoryURL.Scheme != "ldap" && directoryURL.Scheme != "ldaps") || directoryURL.Host == "" {
+		return nil, fmt.Errorf("invalid LDAP URL [%s]", config.URL)
+	}
+	if config.StartTLS && directoryURL.Scheme == "ldaps" {
+		return nil, errors.New("StartTLS cannot be used with an ldaps:// URL")
+	}
+	if config.BaseDN == "" {
+		return nil, errors.New("LDAP base DN is required")
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = DefaultLDAPUserFilter
+	}
+	if strings.Count(config.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("LDAP user filter [%s] must contain %%s exactly once", config.UserFilter)
+	}
+	if config.FirstNameAttribute == "" {
+		config.FirstNameAttribute = DefaultLDAPFirstNameAttribute
+	}
+	if config.LastNameAttribute == "" {
+		config.LastNameAttribute = DefaultLDAPLastNameAttribute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultLDAPTimeout
+	}
+	return &LDAPBackend{config: config}, nil
+}
+
+// Authenticate implements CredentialBackend, binding as the entry of
+// the email of the login data
+func (b *LDAPBackend) Authenticate(ctx context.Context, loginData Userslogindatum, password string) (*CredentialProfile, error) {
+	// an empty password would be an unauthenticated bind, which succeeds
+	// on most directories
+	if password == "" {
+		return nil, ErrInvalidPassword
+	}
+	conn, err := b.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := b.search(conn, loginData.Email)
+	if err != nil {
+		if errors.Is(err, ErrDirectoryUserNotFound) {
+			// the user has left the directory since the account was created
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPassword, err)
+		}
+		return nil, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidPassword
+		}
+		return nil, fmt.Errorf("could not bind as LDAP user: %w", err)
+	}
+	return b.profile(entry), nil
+}
+
+// Lookup returns the profile of the entry of an email
+func (b *LDAPBackend) Lookup(ctx context.Context, email string) (*CredentialProfile, error) {
+	conn, err := b.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := b.search(conn, email)
+	if err != nil {
+		return nil, err
+	}
+	return b.profile(entry), nil
+}
+
+// connect opens a connection to the directory, bound as the service
+// account
+func (b *LDAPBackend) connect(ctx context.Context) (*ldap.Conn, error) {
+	timeout := b.config.Timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	conn, err := ldap.DialURL(b.config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(b.config.TLSConfig))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to LDAP directory: %w", err)
+	}
+	conn.SetTimeout(timeout)
+	if b.config.StartTLS {
+		if err := conn.StartTLS(b.config.TLSConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not start TLS with LDAP directory: %w", err)
+		}
+	}
+	if b.config.BindDN != "" {
+		if err := conn.Bind(b.config.BindDN, b.config.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not bind as LDAP service account: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// search returns the only entry of an email
+func (b *LDAPBackend) search(conn *ldap.Conn, email string) (*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		b.config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		// a second entry makes the email ambiguous
+		2,
+		int(b.config.Timeout/time.Second),
+		false,
+		// the filter is not a format string, a literal % in it stays as is
+		strings.Replace(b.config.UserFilter, "%s", ldap.EscapeFilter(email), 1),
+		[]string{b.config.FirstNameAttribute, b.config.LastNameAttribute},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("could not search LDAP user: %w", err)
+	}
+	switch {
+	case result == nil || len(result.Entries) == 0:
+		return nil, ErrDirectoryUserNotFound
+	case len(result.Entries) > 1:
+		return nil, fmt.Errorf("several LDAP entries match email [%s]", email)
+	}
+	return result.Entries[0], nil
+}
+
+// profile maps the attributes of an entry to the profile of its user
+func (b *LDAPBackend) profile(entry *ldap.Entry) *CredentialProfile {
+	return &CredentialProfile{
+		FirstName: entry.GetAttributeValue(b.config.FirstNameAttribute),
+		LastName:  entry.GetAttributeValue(b.config.LastNameAttribute),
+	}
+}
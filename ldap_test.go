@@ -0,0 +1,316 @@
+package betalinkauth_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testLDAPBaseDN          = "ou=people,dc=betalink,dc=test"
+	testLDAPServiceDN       = "cn=betalink-auth,dc=betalink,dc=test"
+	testLDAPServicePassword = "service-password"
+)
+
+// LDAP operations answered by the mock directory (RFC 4511 section 4.2
+// to 4.5)
+const (
+	ldapApplicationBindRequest      = 0
+	ldapApplicationBindResponse     = 1
+	ldapApplicationUnbindRequest    = 2
+	ldapApplicationSearchRequest    = 3
+	ldapApplicationSearchEntry      = 4
+	ldapApplicationSearchResultDone = 5
+)
+
+// mockLDAPEntry is an entry of the mock directory
+type mockLDAPEntry struct {
+	dn         string
+	password   string
+	attributes map[string]string
+}
+
+// mockLDAPServer is an in-process LDAP directory answering the simple
+// binds and the equality searches of the LDAP backend
+type mockLDAPServer struct {
+	listener net.Listener
+	entries  []mockLDAPEntry
+	wg       sync.WaitGroup
+}
+
+func newMockLDAPServer(t *testing.T, entries ...mockLDAPEntry) *mockLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &mockLDAPServer{listener: listener, entries: entries}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.wg.Add(1)
+			go func() {
+				defer server.wg.Done()
+				server.serve(conn)
+			}()
+		}
+	}()
+	t.Cleanup(server.close)
+	return server
+}
+
+// url returns the ldap:// URL of the mock directory
+func (s *mockLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *mockLDAPServer) close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// serve answers the requests of a connection until it is unbound
+func (s *mockLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+		switch request.Tag {
+		case ldapApplicationBindRequest:
+			dn := request.Children[1].Data.String()
+			password := request.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			if s.checkPassword(dn, password) {
+				code = ldap.LDAPResultSuccess
+				bound = true
+			}
+			conn.Write(ldapResponse(messageID, ldapApplicationBindResponse, code).Bytes())
+		case ldapApplicationSearchRequest:
+			if !bound {
+				conn.Write(ldapResponse(messageID, ldapApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights).Bytes())
+				continue
+			}
+			filter, err := ldap.DecompileFilter(request.Children[6])
+			if err != nil {
+				conn.Write(ldapResponse(messageID, ldapApplicationSearchResultDone, ldap.LDAPResultProtocolError).Bytes())
+				continue
+			}
+			for _, entry := range s.entries {
+				if entry.matches(filter) {
+					conn.Write(entry.searchEntry(messageID).Bytes())
+				}
+			}
+			conn.Write(ldapResponse(messageID, ldapApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldapApplicationUnbindRequest:
+			return
+		default:
+			io.Copy(io.Discard, conn)
+			return
+		}
+	}
+}
+
+// checkPassword reports whether a simple bind is valid
+func (s *mockLDAPServer) checkPassword(dn, password string) bool {
+	if dn == testLDAPServiceDN {
+		return password == testLDAPServicePassword
+	}
+	for _, entry := range s.entries {
+		if entry.dn == dn {
+			return password != "" && password == entry.password
+		}
+	}
+	return false
+}
+
+// matches reports whether the equality assertions of a filter on mail
+// match the entry, the only ones the backend sends
+func (e mockLDAPEntry) matches(filter string) bool {
+	return strings.Contains(filter, "(mail="+ldap.EscapeFilter(e.attributes["mail"])+")")
+}
+
+// searchEntry returns the search result of the entry
+func (e mockLDAPEntry) searchEntry(messageID int64) *ber.Packet {
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, value := range e.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		attribute.AppendChild(values)
+		attributes.AppendChild(attribute)
+	}
+	return ldapMessage(messageID, ldapApplicationSearchEntry,
+		ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "DN"),
+		attributes)
+}
+
+// ldapResponse returns a response to a request holding an LDAPResult
+func ldapResponse(messageID int64, operation ber.Tag, code int64) *ber.Packet {
+	return ldapMessage(messageID, operation,
+		ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"),
+		ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"),
+		ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+}
+
+// ldapMessage returns a message of an operation. The children are
+// encoded when appended, they must be complete.
+func ldapMessage(messageID int64, operation ber.Tag, children ...*ber.Packet) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Message")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, operation, nil, "Operation")
+	for _, child := range children {
+		response.AppendChild(child)
+	}
+	envelope.AppendChild(response)
+	return envelope
+}
+
+// testLDAPEntries are the users of the mock directory
+var testLDAPEntries = []mockLDAPEntry{
+	{
+		dn:       "uid=ada,ou=people,dc=betalink,dc=test",
+		password: "Directory-Pa55",
+		attributes: map[string]string{
+			"mail":      "ada@qa.betalink.test",
+			"givenName": "Ada",
+			"sn":        "Lovelace",
+		},
+	},
+	{
+		dn:         "uid=twin1,ou=people,dc=betalink,dc=test",
+		password:   "Twin-Pa55",
+		attributes: map[string]string{"mail": "twins@qa.betalink.test"},
+	},
+	{
+		dn:         "uid=twin2,ou=people,dc=betalink,dc=test",
+		password:   "Twin-Pa55",
+		attributes: map[string]string{"mail": "twins@qa.betalink.test"},
+	},
+}
+
+// newTestLDAPBackend returns a backend of a mock directory holding the
+// test entries
+func newTestLDAPBackend(t *testing.T) *betalinkauth.LDAPBackend {
+	server := newMockLDAPServer(t, testLDAPEntries...)
+	backend, err := betalinkauth.NewLDAPBackend(betalinkauth.LDAPConfig{
+		URL:          server.url(),
+		BindDN:       testLDAPServiceDN,
+		BindPassword: testLDAPServicePassword,
+		BaseDN:       testLDAPBaseDN,
+		UserFilter:   "(&(objectClass=inetOrgPerson)(mail=%s))",
+	})
+	require.NoError(t, err)
+	return backend
+}
+
+func TestLDAPBackend_Authenticate(t *testing.T) {
+	backend := newTestLDAPBackend(t)
+	ctx := context.Background()
+	loginData := func(email string) betalinkauth.Userslogindatum {
+		return betalinkauth.Userslogindatum{Email: email, Hashalgorithm: betalinkauth.HashAlgorithmLDAP}
+	}
+
+	profile, err := backend.Authenticate(ctx, loginData("ada@qa.betalink.test"), "Directory-Pa55")
+	require.NoError(t, err)
+	assert.Equal(t, &betalinkauth.CredentialProfile{FirstName: "Ada", LastName: "Lovelace"}, profile)
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+	}{
+		{"wrong password", "ada@qa.betalink.test", "wrong"},
+		{"empty password", "ada@qa.betalink.test", ""},
+		{"user not in directory", "grace@qa.betalink.test", "Directory-Pa55"},
+		{"filter injection", "*", "Directory-Pa55"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := backend.Authenticate(ctx, loginData(tt.email), tt.password)
+			assert.ErrorIs(t, err, betalinkauth.ErrInvalidPassword)
+		})
+	}
+
+	t.Run("ambiguous email", func(t *testing.T) {
+		_, err := backend.Authenticate(ctx, loginData("twins@qa.betalink.test"), "Twin-Pa55")
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, betalinkauth.ErrInvalidPassword))
+	})
+
+	t.Run("wrong service account password", func(t *testing.T) {
+		server := newMockLDAPServer(t, testLDAPEntries...)
+		misconfigured, err := betalinkauth.NewLDAPBackend(betalinkauth.LDAPConfig{
+			URL:          server.url(),
+			BindDN:       testLDAPServiceDN,
+			BindPassword: "wrong",
+			BaseDN:       testLDAPBaseDN,
+		})
+		require.NoError(t, err)
+		_, err = misconfigured.Authenticate(ctx, loginData("ada@qa.betalink.test"), "Directory-Pa55")
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, betalinkauth.ErrInvalidPassword), "a broken directory is not a wrong password")
+	})
+}
+
+func TestLDAPBackend_Lookup(t *testing.T) {
+	backend := newTestLDAPBackend(t)
+
+	profile, err := backend.Lookup(context.Background(), "ada@qa.betalink.test")
+	require.NoError(t, err)
+	assert.Equal(t, "Ada", profile.FirstName)
+	assert.Equal(t, "Lovelace", profile.LastName)
+
+	_, err = backend.Lookup(context.Background(), "grace@qa.betalink.test")
+	assert.ErrorIs(t, err, betalinkauth.ErrDirectoryUserNotFound)
+
+	t.Run("filter with a literal percent sign", func(t *testing.T) {
+		server := newMockLDAPServer(t, testLDAPEntries...)
+		backend, err := betalinkauth.NewLDAPBackend(betalinkauth.LDAPConfig{
+			URL:          server.url(),
+			BindDN:       testLDAPServiceDN,
+			BindPassword: testLDAPServicePassword,
+			BaseDN:       testLDAPBaseDN,
+			UserFilter:   "(&(mail=%s)(!(department=R%D)))",
+		})
+		require.NoError(t, err)
+		profile, err := backend.Lookup(context.Background(), "ada@qa.betalink.test")
+		require.NoError(t, err)
+		assert.Equal(t, "Ada", profile.FirstName)
+	})
+}
+
+func TestNewLDAPBackend(t *testing.T) {
+	tests := []struct {
+		name   string
+		config betalinkauth.LDAPConfig
+	}{
+		{"not an LDAP URL", betalinkauth.LDAPConfig{URL: "https://directory.test", BaseDN: testLDAPBaseDN}},
+		{"StartTLS over ldaps", betalinkauth.LDAPConfig{URL: "ldaps://directory.test", StartTLS: true, BaseDN: testLDAPBaseDN}},
+		{"missing base DN", betalinkauth.LDAPConfig{URL: "ldap://directory.test"}},
+		{"filter without email", betalinkauth.LDAPConfig{URL: "ldap://directory.test", BaseDN: testLDAPBaseDN, UserFilter: "(uid=admin)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := betalinkauth.NewLDAPBackend(tt.config)
+			assert.Error(t, err)
+		})
+	}
+}
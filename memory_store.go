@@ -0,0 +1,792 @@
+package betalinkauth
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// MemoryStore is a Store keeping its data in memory, safe for concurrent
+// use. It starts with the roles created by the migrations, granting no
+// permission.
+type MemoryStore struct {
+	// mu serializes the operations, a transaction holding it until it
+	// ends so that it is isolated from the others
+	mu   *sync.Mutex
+	data *memoryData
+	// inTx is set on the store passed to the functions run by InTx,
+	// which already hold mu
+	inTx bool
+}
+
+// memoryData is the data of a MemoryStore, the tables of the Postgres
+// store it mirrors
+type memoryData struct {
+	users               map[pgtype.UUID]User
+	loginData           map[string]Userslogindatum
+	roles               map[string]Role
+	permissions         map[string]Permission
+	rolePermissions     map[Rolepermission]struct{}
+	userRoles           map[memoryUserRole]struct{}
+	loginEvents         map[pgtype.UUID]Loginevent
+	passwordRecoveries  map[pgtype.UUID]Passwordrecovery
+	sessions            map[pgtype.UUID]Session
+	revokedAccessTokens map[pgtype.UUID]pgtype.Timestamptz
+}
+
+// memoryUserRole is the key of a role assigned to a user
+type memoryUserRole struct {
+	userID   pgtype.UUID
+	roleName string
+}
+
+// clone returns a copy of the data, restored when a transaction is
+// rolled back
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		users:               maps.Clone(d.users),
+		loginData:           maps.Clone(d.loginData),
+		roles:               maps.Clone(d.roles),
+		permissions:         maps.Clone(d.permissions),
+		rolePermissions:     maps.Clone(d.rolePermissions),
+		userRoles:           maps.Clone(d.userRoles),
+		loginEvents:         maps.Clone(d.loginEvents),
+		passwordRecoveries:  maps.Clone(d.passwordRecoveries),
+		sessions:            maps.Clone(d.sessions),
+		revokedAccessTokens: maps.Clone(d.revokedAccessTokens),
+	}
+}
+
+// NewMemoryStore creates a new empty MemoryStore instance
+func NewMemoryStore() *MemoryStore {
+	now := pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	return &MemoryStore{
+		mu: &sync.Mutex{},
+		data: &memoryData{
+			users:     map[pgtype.UUID]User{},
+			loginData: map[string]Userslogindatum{},
+			roles: map[string]Role{
+				DefaultRole: {RoleName: DefaultRole, Description: "Default role of every registered user", CreatedAt: now},
+				AdminRole:   {RoleName: AdminRole, Description: "Administrators of the auth service", CreatedAt: now},
+			},
+			permissions:         map[string]Permission{},
+			rolePermissions:     map[Rolepermission]struct{}{},
+			userRoles:           map[memoryUserRole]struct{}{},
+			loginEvents:         map[pgtype.UUID]Loginevent{},
+			passwordRecoveries:  map[pgtype.UUID]Passwordrecovery{},
+			sessions:            map[pgtype.UUID]Session{},
+			revokedAccessTokens: map[pgtype.UUID]pgtype.Timestamptz{},
+		},
+	}
+}
+
+// lock locks the store unless it runs in a transaction, and returns the
+// function unlocking it
+func (s *MemoryStore) lock() func() {
+	if s.inTx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// newMemoryUUID returns a new random UUID, as generated by Postgres
+func newMemoryUUID() pgtype.UUID {
+	return pgtype.UUID{Bytes: uuid.New(), Valid: true}
+}
+
+// InTx implements Store. Transactions are serialized with the other
+// operations, nested ones are rolled back on their own.
+func (s *MemoryStore) InTx(ctx context.Context, fn func(Store) error) error {
+	defer s.lock()()
+	snapshot := s.data.clone()
+	if err := fn(&MemoryStore{mu: s.mu, data: s.data, inTx: true}); err != nil {
+		*s.data = *snapshot
+		return err
+	}
+	return nil
+}
+
+// CreateUser implements Store
+func (s *MemoryStore) CreateUser(ctx context.Context, arg CreateUserParams) (pgtype.UUID, error) {
+	defer s.lock()()
+	now := pgtype.Timestamp{Time: time.Now(), Valid: true}
+	user := User{
+		UserID:    newMemoryUUID(),
+		FirstName: arg.FirstName,
+		LastName:  arg.LastName,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.data.users[user.UserID] = user
+	return user.UserID, nil
+}
+
+// GetUserById implements Store
+func (s *MemoryStore) GetUserById(ctx context.Context, userID pgtype.UUID) (GetUserByIdRow, error) {
+	defer s.lock()()
+	user, ok := s.data.users[userID]
+	if !ok {
+		return GetUserByIdRow{}, pgx.ErrNoRows
+	}
+	row := GetUserByIdRow{
+		UserID:    user.UserID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+	if loginData, ok := s.loginDataOfUser(userID); ok {
+		row.Email = pgtype.Text{String: loginData.Email, Valid: true}
+		row.EmailVerified = pgtype.Bool{Bool: loginData.EmailVerified, Valid: true}
+	}
+	return row, nil
+}
+
+// GetUserAccount implements Store
+func (s *MemoryStore) GetUserAccount(ctx context.Context, userID pgtype.UUID) (GetUserAccountRow, error) {
+	defer s.lock()()
+	user, ok := s.data.users[userID]
+	if !ok {
+		return GetUserAccountRow{}, pgx.ErrNoRows
+	}
+	return s.userAccount(user), nil
+}
+
+// userAccount returns the account of a user along with its login data
+func (s *MemoryStore) userAccount(user User) GetUserAccountRow {
+	row := GetUserAccountRow{
+		UserID:     user.UserID,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		CreatedAt:  user.CreatedAt,
+		DisabledAt: user.DisabledAt,
+	}
+	if loginData, ok := s.loginDataOfUser(user.UserID); ok {
+		row.Email = pgtype.Text{String: loginData.Email, Valid: true}
+		row.EmailVerified = pgtype.Bool{Bool: loginData.EmailVerified, Valid: true}
+		row.Hashalgorithm = pgtype.Text{String: loginData.Hashalgorithm, Valid: true}
+	}
+	return row
+}
+
+// likeUnescaper reverts the escaping of the LIKE patterns searched by
+// ListUsers
+var likeUnescaper = strings.NewReplacer(`\\`, `\`, `\%`, `%`, `\_`, `_`)
+
+// ListUsers implements Store
+func (s *MemoryStore) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error) {
+	defer s.lock()()
+	search := strings.ToLower(likeUnescaper.Replace(arg.Search.String))
+	var rows []ListUsersRow
+	for _, user := range s.data.users {
+		if arg.AfterCreatedAt.Valid && compareMemoryUsers(user, arg.AfterCreatedAt, arg.AfterUserID) <= 0 {
+			continue
+		}
+		account := s.userAccount(user)
+		if arg.Search.Valid &&
+			!strings.Contains(strings.ToLower(account.Email.String), search) &&
+			!strings.Contains(strings.ToLower(user.FirstName+" "+user.LastName), search) {
+			continue
+		}
+		if _, ok := s.data.userRoles[memoryUserRole{userID: user.UserID, roleName: arg.RoleName.String}]; arg.RoleName.Valid && !ok {
+			continue
+		}
+		if arg.Disabled.Valid && user.DisabledAt.Valid != arg.Disabled.Bool {
+			continue
+		}
+		rows = append(rows, ListUsersRow(account))
+	}
+	slices.SortFunc(rows, func(a, b ListUsersRow) int {
+		return compareMemoryUsers(s.data.users[a.UserID], b.CreatedAt, b.UserID)
+	})
+	if len(rows) > int(arg.PageSize) {
+		rows = rows[:arg.PageSize]
+	}
+	return rows, nil
+}
+
+// compareMemoryUsers compares a user to the one created at createdAt
+// with the given ID, in the order of the listed users
+func compareMemoryUsers(user User, createdAt pgtype.Timestamp, userID pgtype.UUID) int {
+	if c := user.CreatedAt.Time.Compare(createdAt.Time); c != 0 {
+		return c
+	}
+	return bytes.Compare(user.UserID.Bytes[:], userID.Bytes[:])
+}
+
+// UpdateUserNames implements Store
+func (s *MemoryStore) UpdateUserNames(ctx context.Context, arg UpdateUserNamesParams) error {
+	defer s.lock()()
+	if user, ok := s.data.users[arg.UserID]; ok {
+		user.FirstName = arg.FirstName
+		user.LastName = arg.LastName
+		s.data.users[arg.UserID] = user
+	}
+	return nil
+}
+
+// SetUserDisabledAt implements Store
+func (s *MemoryStore) SetUserDisabledAt(ctx context.Context, arg SetUserDisabledAtParams) (int64, error) {
+	defer s.lock()()
+	user, ok := s.data.users[arg.UserID]
+	if !ok {
+		return 0, nil
+	}
+	user.DisabledAt = arg.DisabledAt
+	s.data.users[arg.UserID] = user
+	return 1, nil
+}
+
+// GetUserRoles implements Store
+func (s *MemoryStore) GetUserRoles(ctx context.Context, userID pgtype.UUID) ([]Role, error) {
+	defer s.lock()()
+	var roles []Role
+	for userRole := range s.data.userRoles {
+		if userRole.userID == userID {
+			roles = append(roles, s.data.roles[userRole.roleName])
+		}
+	}
+	slices.SortFunc(roles, func(a, b Role) int {
+		return strings.Compare(a.RoleName, b.RoleName)
+	})
+	return roles, nil
+}
+
+// GetUserPermissions implements Store
+func (s *MemoryStore) GetUserPermissions(ctx context.Context, userID pgtype.UUID) ([]string, error) {
+	defer s.lock()()
+	var permissions []string
+	for rolePermission := range s.data.rolePermissions {
+		_, ok := s.data.userRoles[memoryUserRole{userID: userID, roleName: rolePermission.RoleName}]
+		if ok && !slices.Contains(permissions, rolePermission.PermissionName) {
+			permissions = append(permissions, rolePermission.PermissionName)
+		}
+	}
+	slices.Sort(permissions)
+	return permissions, nil
+}
+
+// AssignUserRole implements Store
+func (s *MemoryStore) AssignUserRole(ctx context.Context, arg AssignUserRoleParams) error {
+	defer s.lock()()
+	if _, ok := s.data.users[arg.UserID]; !ok {
+		return constraintViolation(pgForeignKeyViolation, "userroles_user_id_fkey")
+	}
+	if _, ok := s.data.roles[arg.RoleName]; !ok {
+		return constraintViolation(pgForeignKeyViolation, "userroles_role_name_fkey")
+	}
+	s.data.userRoles[memoryUserRole{userID: arg.UserID, roleName: arg.RoleName}] = struct{}{}
+	return nil
+}
+
+// UnassignUserRole implements Store
+func (s *MemoryStore) UnassignUserRole(ctx context.Context, arg UnassignUserRoleParams) (int64, error) {
+	defer s.lock()()
+	userRole := memoryUserRole{userID: arg.UserID, roleName: arg.RoleName}
+	if _, ok := s.data.userRoles[userRole]; !ok {
+		return 0, nil
+	}
+	delete(s.data.userRoles, userRole)
+	return 1, nil
+}
+
+// LockUserForSession implements Store. The store is locked for the
+// whole transaction, locking the user is a lookup.
+func (s *MemoryStore) LockUserForSession(ctx context.Context, userID pgtype.UUID) (LockUserForSessionRow, error) {
+	defer s.lock()()
+	user, ok := s.data.users[userID]
+	if !ok {
+		return LockUserForSessionRow{}, pgx.ErrNoRows
+	}
+	return LockUserForSessionRow{MaxSessions: user.MaxSessions, DisabledAt: user.DisabledAt}, nil
+}
+
+// CreateUserLoginData implements Store
+func (s *MemoryStore) CreateUserLoginData(ctx context.Context, arg CreateUserLoginDataParams) error {
+	defer s.lock()()
+	if _, ok := s.data.users[arg.UserID]; !ok {
+		return constraintViolation(pgForeignKeyViolation, "userslogindata_user_id_fkey")
+	}
+	if _, ok := s.data.loginData[arg.Email]; ok {
+		return constraintViolation(pgUniqueViolation, "userslogindata_email_key")
+	}
+	if _, ok := s.loginDataOfUser(arg.UserID); ok {
+		return constraintViolation(pgUniqueViolation, "userslogindata_pkey")
+	}
+	s.data.loginData[arg.Email] = Userslogindatum{
+		UserID:        arg.UserID,
+		Email:         arg.Email,
+		Passwordhash:  arg.Passwordhash,
+		Passwordsalt:  arg.Passwordsalt,
+		Hashalgorithm: arg.Hashalgorithm,
+	}
+	return nil
+}
+
+// GetLoginDataByEmail implements Store
+func (s *MemoryStore) GetLoginDataByEmail(ctx context.Context, email string) (Userslogindatum, error) {
+	defer s.lock()()
+	loginData, ok := s.data.loginData[email]
+	if !ok {
+		return Userslogindatum{}, pgx.ErrNoRows
+	}
+	return loginData, nil
+}
+
+// loginDataOfUser returns the login data of a user, if any
+func (s *MemoryStore) loginDataOfUser(userID pgtype.UUID) (Userslogindatum, bool) {
+	for _, loginData := range s.data.loginData {
+		if loginData.UserID == userID {
+			return loginData, true
+		}
+	}
+	return Userslogindatum{}, false
+}
+
+// UpdateUserEmail implements Store
+func (s *MemoryStore) UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) (int64, error) {
+	defer s.lock()()
+	loginData, ok := s.loginDataOfUser(arg.UserID)
+	if !ok {
+		return 0, nil
+	}
+	if other, ok := s.data.loginData[arg.Email]; ok && other.UserID != arg.UserID {
+		return 0, constraintViolation(pgUniqueViolation, "userslogindata_email_key")
+	}
+	delete(s.data.loginData, loginData.Email)
+	loginData.Email = arg.Email
+	loginData.EmailVerified = false
+	s.data.loginData[arg.Email] = loginData
+	return 1, nil
+}
+
+// UpdateUserPassword implements Store
+func (s *MemoryStore) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	defer s.lock()()
+	if loginData, ok := s.loginDataOfUser(arg.UserID); ok {
+		loginData.Passwordhash = arg.Passwordhash
+		loginData.Passwordsalt = arg.Passwordsalt
+		s.data.loginData[loginData.Email] = loginData
+	}
+	return nil
+}
+
+// CreateLoginEvent implements Store
+func (s *MemoryStore) CreateLoginEvent(ctx context.Context, arg CreateLoginEventParams) error {
+	defer s.lock()()
+	if _, ok := s.data.users[arg.UserID]; !ok {
+		return constraintViolation(pgForeignKeyViolation, "loginevents_user_id_fkey")
+	}
+	event := Loginevent{
+		EventID:       newMemoryUUID(),
+		UserID:        arg.UserID,
+		OccurredAt:    arg.OccurredAt,
+		LoginMethod:   arg.LoginMethod,
+		Succeeded:     arg.Succeeded,
+		FailureReason: arg.FailureReason,
+		IpAddress:     arg.IpAddress,
+		UserAgent:     arg.UserAgent,
+	}
+	s.data.loginEvents[event.EventID] = event
+	return nil
+}
+
+// ListUserLoginEvents implements Store
+func (s *MemoryStore) ListUserLoginEvents(ctx context.Context, arg ListUserLoginEventsParams) ([]Loginevent, error) {
+	defer s.lock()()
+	var events []Loginevent
+	for _, event := range s.data.loginEvents {
+		if event.UserID == arg.UserID {
+			events = append(events, event)
+		}
+	}
+	slices.SortFunc(events, func(a, b Loginevent) int {
+		return b.OccurredAt.Time.Compare(a.OccurredAt.Time)
+	})
+	if len(events) > int(arg.Limit) {
+		events = events[:arg.Limit]
+	}
+	return events, nil
+}
+
+// DeleteOldLoginEvents implements Store
+func (s *MemoryStore) DeleteOldLoginEvents(ctx context.Context, arg DeleteOldLoginEventsParams) (int64, error) {
+	defer s.lock()()
+	var deleted int64
+	for eventID, event := range s.data.loginEvents {
+		if deleted == int64(arg.BatchSize) {
+			break
+		}
+		if event.OccurredAt.Time.Before(arg.OccurredBefore.Time) {
+			delete(s.data.loginEvents, eventID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// UpsertPasswordRecovery implements Store
+func (s *MemoryStore) UpsertPasswordRecovery(ctx context.Context, arg UpsertPasswordRecoveryParams) error {
+	defer s.lock()()
+	if _, ok := s.data.users[arg.UserID]; !ok {
+		return constraintViolation(pgForeignKeyViolation, "passwordrecovery_user_id_fkey")
+	}
+	s.data.passwordRecoveries[arg.UserID] = Passwordrecovery{
+		UserID:        arg.UserID,
+		RecoveryToken: arg.RecoveryToken,
+		CreatedAt:     pgtype.Timestamp{Time: time.Now(), Valid: true},
+	}
+	return nil
+}
+
+// ConsumePasswordRecovery implements Store
+func (s *MemoryStore) ConsumePasswordRecovery(ctx context.Context, arg ConsumePasswordRecoveryParams) (pgtype.UUID, error) {
+	defer s.lock()()
+	notBefore := time.Now().Add(-time.Duration(arg.MaxAgeSeconds) * time.Second)
+	for userID, recovery := range s.data.passwordRecoveries {
+		if recovery.RecoveryToken == arg.RecoveryToken && !recovery.Used && !recovery.CreatedAt.Time.Before(notBefore) {
+			recovery.Used = true
+			s.data.passwordRecoveries[userID] = recovery
+			return userID, nil
+		}
+	}
+	return pgtype.UUID{}, pgx.ErrNoRows
+}
+
+// DeleteStalePasswordRecoveries implements Store
+func (s *MemoryStore) DeleteStalePasswordRecoveries(ctx context.Context, arg DeleteStalePasswordRecoveriesParams) (int64, error) {
+	defer s.lock()()
+	notBefore := time.Now().Add(-time.Duration(arg.MaxAgeSeconds) * time.Second)
+	var deleted int64
+	for userID, recovery := range s.data.passwordRecoveries {
+		if deleted == int64(arg.BatchSize) {
+			break
+		}
+		if recovery.Used || recovery.CreatedAt.Time.Before(notBefore) {
+			delete(s.data.passwordRecoveries, userID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ListRoles implements Store
+func (s *MemoryStore) ListRoles(ctx context.Context) ([]Role, error) {
+	defer s.lock()()
+	roles := make([]Role, 0, len(s.data.roles))
+	for _, role := range s.data.roles {
+		roles = append(roles, role)
+	}
+	slices.SortFunc(roles, func(a, b Role) int {
+		return strings.Compare(a.RoleName, b.RoleName)
+	})
+	return roles, nil
+}
+
+// CreateRole implements Store
+func (s *MemoryStore) CreateRole(ctx context.Context, arg CreateRoleParams) error {
+	defer s.lock()()
+	if _, ok := s.data.roles[arg.RoleName]; ok {
+		return constraintViolation(pgUniqueViolation, "roles_pkey")
+	}
+	s.data.roles[arg.RoleName] = Role{
+		RoleName:    arg.RoleName,
+		Description: arg.Description,
+		MaxSessions: arg.MaxSessions,
+		CreatedAt:   pgtype.Timestamptz{Time: time.Now(), Valid: true},
+	}
+	return nil
+}
+
+// DeleteRole implements Store, deleting the grants of the role
+func (s *MemoryStore) DeleteRole(ctx context.Context, roleName string) (int64, error) {
+	defer s.lock()()
+	if _, ok := s.data.roles[roleName]; !ok {
+		return 0, nil
+	}
+	delete(s.data.roles, roleName)
+	maps.DeleteFunc(s.data.userRoles, func(userRole memoryUserRole, _ struct{}) bool {
+		return userRole.roleName == roleName
+	})
+	maps.DeleteFunc(s.data.rolePermissions, func(rolePermission Rolepermission, _ struct{}) bool {
+		return rolePermission.RoleName == roleName
+	})
+	return 1, nil
+}
+
+// ListPermissions implements Store
+func (s *MemoryStore) ListPermissions(ctx context.Context) ([]Permission, error) {
+	defer s.lock()()
+	permissions := make([]Permission, 0, len(s.data.permissions))
+	for _, permission := range s.data.permissions {
+		permissions = append(permissions, permission)
+	}
+	slices.SortFunc(permissions, func(a, b Permission) int {
+		return strings.Compare(a.PermissionName, b.PermissionName)
+	})
+	return permissions, nil
+}
+
+// CreatePermission implements Store
+func (s *MemoryStore) CreatePermission(ctx context.Context, arg CreatePermissionParams) error {
+	defer s.lock()()
+	if _, ok := s.data.permissions[arg.PermissionName]; ok {
+		return constraintViolation(pgUniqueViolation, "permissions_pkey")
+	}
+	s.data.permissions[arg.PermissionName] = Permission{
+		PermissionName: arg.PermissionName,
+		Description:    arg.Description,
+		CreatedAt:      pgtype.Timestamptz{Time: time.Now(), Valid: true},
+	}
+	return nil
+}
+
+// DeletePermission implements Store, deleting the grants of the
+// permission
+func (s *MemoryStore) DeletePermission(ctx context.Context, permissionName string) (int64, error) {
+	defer s.lock()()
+	if _, ok := s.data.permissions[permissionName]; !ok {
+		return 0, nil
+	}
+	delete(s.data.permissions, permissionName)
+	maps.DeleteFunc(s.data.rolePermissions, func(rolePermission Rolepermission, _ struct{}) bool {
+		return rolePermission.PermissionName == permissionName
+	})
+	return 1, nil
+}
+
+// ListRolePermissions implements Store
+func (s *MemoryStore) ListRolePermissions(ctx context.Context) ([]Rolepermission, error) {
+	defer s.lock()()
+	rolePermissions := make([]Rolepermission, 0, len(s.data.rolePermissions))
+	for rolePermission := range s.data.rolePermissions {
+		rolePermissions = append(rolePermissions, rolePermission)
+	}
+	slices.SortFunc(rolePermissions, func(a, b Rolepermission) int {
+		if c := strings.Compare(a.RoleName, b.RoleName); c != 0 {
+			return c
+		}
+		return strings.Compare(a.PermissionName, b.PermissionName)
+	})
+	return rolePermissions, nil
+}
+
+// GrantRolePermission implements Store
+func (s *MemoryStore) GrantRolePermission(ctx context.Context, arg GrantRolePermissionParams) error {
+	defer s.lock()()
+	if _, ok := s.data.roles[arg.RoleName]; !ok {
+		return constraintViolation(pgForeignKeyViolation, "rolepermissions_role_name_fkey")
+	}
+	if _, ok := s.data.permissions[arg.PermissionName]; !ok {
+		return constraintViolation(pgForeignKeyViolation, "rolepermissions_permission_name_fkey")
+	}
+	s.data.rolePermissions[Rolepermission(arg)] = struct{}{}
+	return nil
+}
+
+// RevokeRolePermission implements Store
+func (s *MemoryStore) RevokeRolePermission(ctx context.Context, arg RevokeRolePermissionParams) (int64, error) {
+	defer s.lock()()
+	if _, ok := s.data.rolePermissions[Rolepermission(arg)]; !ok {
+		return 0, nil
+	}
+	delete(s.data.rolePermissions, Rolepermission(arg))
+	return 1, nil
+}
+
+// CreateSession implements Store
+func (s *MemoryStore) CreateSession(ctx context.Context, arg CreateSessionParams) (pgtype.UUID, error) {
+	defer s.lock()()
+	if _, ok := s.data.users[arg.UserID]; !ok {
+		return pgtype.UUID{}, constraintViolation(pgForeignKeyViolation, "sessions_user_id_fkey")
+	}
+	if _, err := s.sessionByRefreshTokenHash(arg.RefreshTokenHash); arg.RefreshTokenHash.Valid && err == nil {
+		return pgtype.UUID{}, constraintViolation(pgUniqueViolation, "sessions_refresh_token_hash_idx")
+	}
+	session := Session{
+		SessionID:         newMemoryUUID(),
+		UserID:            arg.UserID,
+		CreatedAt:         arg.CreatedAt,
+		UpdatedAt:         arg.UpdatedAt,
+		ExpiresAt:         arg.ExpiresAt,
+		IpAddress:         arg.IpAddress,
+		UserAgent:         arg.UserAgent,
+		Device:            arg.Device,
+		LoginMethod:       arg.LoginMethod,
+		AbsoluteExpiresAt: arg.AbsoluteExpiresAt,
+		RememberMe:        arg.RememberMe,
+		ClientID:          arg.ClientID,
+		Scope:             arg.Scope,
+		RefreshTokenHash:  arg.RefreshTokenHash,
+	}
+	s.data.sessions[session.SessionID] = session
+	return session.SessionID, nil
+}
+
+// GetSessionById implements Store
+func (s *MemoryStore) GetSessionById(ctx context.Context, sessionID pgtype.UUID) (Session, error) {
+	defer s.lock()()
+	session, ok := s.data.sessions[sessionID]
+	if !ok {
+		return Session{}, pgx.ErrNoRows
+	}
+	return session, nil
+}
+
+// GetSessionByRefreshTokenHash implements Store
+func (s *MemoryStore) GetSessionByRefreshTokenHash(ctx context.Context, refreshTokenHash pgtype.Text) (Session, error) {
+	defer s.lock()()
+	return s.sessionByRefreshTokenHash(refreshTokenHash)
+}
+
+// sessionByRefreshTokenHash returns the session of a refresh token hash
+func (s *MemoryStore) sessionByRefreshTokenHash(refreshTokenHash pgtype.Text) (Session, error) {
+	for _, session := range s.data.sessions {
+		if refreshTokenHash.Valid && session.RefreshTokenHash == refreshTokenHash {
+			return session, nil
+		}
+	}
+	return Session{}, pgx.ErrNoRows
+}
+
+// ListUserSessions implements Store
+func (s *MemoryStore) ListUserSessions(ctx context.Context, userID pgtype.UUID) ([]Session, error) {
+	defer s.lock()()
+	var sessions []Session
+	for _, session := range s.activeSessions(userID) {
+		if session.AbsoluteExpiresAt.Time.After(time.Now()) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// TouchSession implements Store
+func (s *MemoryStore) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	defer s.lock()()
+	if session, ok := s.data.sessions[arg.SessionID]; ok {
+		session.UpdatedAt = arg.UpdatedAt
+		session.ExpiresAt = arg.ExpiresAt
+		s.data.sessions[arg.SessionID] = session
+	}
+	return nil
+}
+
+// DeleteSession implements Store
+func (s *MemoryStore) DeleteSession(ctx context.Context, sessionID pgtype.UUID) error {
+	defer s.lock()()
+	delete(s.data.sessions, sessionID)
+	return nil
+}
+
+// DeleteUserSessions implements Store
+func (s *MemoryStore) DeleteUserSessions(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	defer s.lock()()
+	var deleted int64
+	for sessionID, session := range s.data.sessions {
+		if session.UserID == userID {
+			delete(s.data.sessions, sessionID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// SessionExists implements Store
+func (s *MemoryStore) SessionExists(ctx context.Context, sessionID pgtype.UUID) (bool, error) {
+	defer s.lock()()
+	_, ok := s.data.sessions[sessionID]
+	return ok, nil
+}
+
+// activeSessions returns the sessions of a user which have not expired,
+// the oldest first
+func (s *MemoryStore) activeSessions(userID pgtype.UUID) []Session {
+	now := time.Now()
+	var sessions []Session
+	for _, session := range s.data.sessions {
+		if session.UserID == userID && session.ExpiresAt.Time.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b Session) int {
+		return a.CreatedAt.Time.Compare(b.CreatedAt.Time)
+	})
+	return sessions
+}
+
+// CountActiveSessions implements Store
+func (s *MemoryStore) CountActiveSessions(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	defer s.lock()()
+	return int64(len(s.activeSessions(userID))), nil
+}
+
+// DeleteOldestActiveSessions implements Store
+func (s *MemoryStore) DeleteOldestActiveSessions(ctx context.Context, arg DeleteOldestActiveSessionsParams) (int64, error) {
+	defer s.lock()()
+	sessions := s.activeSessions(arg.UserID)
+	if len(sessions) > int(arg.Limit) {
+		sessions = sessions[:arg.Limit]
+	}
+	for _, session := range sessions {
+		delete(s.data.sessions, session.SessionID)
+	}
+	return int64(len(sessions)), nil
+}
+
+// DeleteExpiredSessions implements Store
+func (s *MemoryStore) DeleteExpiredSessions(ctx context.Context, limit int32) (int64, error) {
+	defer s.lock()()
+	now := time.Now()
+	var deleted int64
+	for sessionID, session := range s.data.sessions {
+		if deleted == int64(limit) {
+			break
+		}
+		if session.ExpiresAt.Time.Before(now) {
+			delete(s.data.sessions, sessionID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// RevokeAccessToken implements Store
+func (s *MemoryStore) RevokeAccessToken(ctx context.Context, arg RevokeAccessTokenParams) error {
+	defer s.lock()()
+	if _, ok := s.data.revokedAccessTokens[arg.TokenID]; !ok {
+		s.data.revokedAccessTokens[arg.TokenID] = arg.ExpiresAt
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked implements Store
+func (s *MemoryStore) IsAccessTokenRevoked(ctx context.Context, tokenID pgtype.UUID) (bool, error) {
+	defer s.lock()()
+	_, ok := s.data.revokedAccessTokens[tokenID]
+	return ok, nil
+}
+
+// DeleteExpiredRevokedAccessTokens implements Store
+func (s *MemoryStore) DeleteExpiredRevokedAccessTokens(ctx context.Context, limit int32) (int64, error) {
+	defer s.lock()()
+	now := time.Now()
+	var deleted int64
+	for tokenID, expiresAt := range s.data.revokedAccessTokens {
+		if deleted == int64(limit) {
+			break
+		}
+		if expiresAt.Time.Before(now) {
+			delete(s.data.revokedAccessTokens, tokenID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,19 @@
+package betalinkauth_test
+
+import (
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, betalinkauth.NewMemoryStore())
+}
+
+func TestUsecases_MemoryStore(t *testing.T) {
+	testStoreUsecases(t, betalinkauth.NewMemoryStore())
+}
+
+func TestUsecases_MemoryStoreSessionLimit(t *testing.T) {
+	testStoreSessionLimit(t, betalinkauth.NewMemoryStore())
+}
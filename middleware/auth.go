@@ -1,19 +1,67 @@
 package middleware
 
 import (
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"strings"
+	"slices"
 
 	"github.com/gin-gonic/gin"
 )
 
-// UserData represents the user information retrieved from the auth server
+// UserData represents the user information retrieved from the auth server.
+// For the tokens of machine clients, Service is set and the user fields
+// are empty.
 type UserData struct {
 	UserID    string `json:"user_id"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	// Roles are the roles granted to the user
+	Roles []string `json:"roles,omitempty"`
+	// Permissions are the permissions granted to the user
+	Permissions []string `json:"permissions,omitempty"`
+	// Scopes are the scopes granted to the OAuth client the token was
+	// issued to, empty for the first-party apps
+	Scopes []string `json:"scopes,omitempty"`
+	// ClientID is the OAuth client the token was issued to, empty for
+	// the first-party apps
+	ClientID string `json:"client_id,omitempty"`
+	// Service reports a token issued to a machine client through the
+	// client credentials grant, on behalf of no user
+	Service bool `json:"service,omitempty"`
+}
+
+// HasRoles reports whether the user has all the given roles
+func (u *UserData) HasRoles(roles ...string) bool {
+	for _, role := range roles {
+		if !slices.Contains(u.Roles, role) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasScopes reports whether the token was granted all the given scopes.
+// The tokens of the first-party apps, issued to no OAuth client, are
+// granted every scope.
+func (u *UserData) HasScopes(scopes ...string) bool {
+	if u.ClientID == "" && !u.Service {
+		return true
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(u.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAnyPermission reports whether the user has at least one of the
+// given permissions
+func (u *UserData) HasAnyPermission(permissions ...string) bool {
+	for _, permission := range permissions {
+		if slices.Contains(u.Permissions, permission) {
+			return true
+		}
+	}
+	return false
 }
 
 // AuthResponse represents the response from the auth server
@@ -26,72 +74,42 @@ type AuthResponse struct {
 // AuthRequired is a gin middleware that checks if the user is authenticated.
 // If the user is not authenticated, it will return a 401 Unauthorized status.
 // Otherwise, it will store the user information in the context and call
-// the next handler.
+// the next handler. The token is validated by the auth server at
+// authServerURL, using the default AuthServerConfig.
 func AuthRequired(authServerURL string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Extract the Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
-			c.Abort()
-			return
-		}
+	config := DefaultAuthServerConfig
+	config.URL = authServerURL
+	return AuthRequiredRemote(NewAuthServer(config))
+}
 
-		// Validate the format of the Authorization header
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header format"})
-			c.Abort()
-			return
-		}
+// AuthRequiredRemote is a gin middleware that checks if the user is
+// authenticated by validating the token with the auth server. If the auth
+// server is unavailable, it will return a 503 Service Unavailable status.
+func AuthRequiredRemote(authServer *AuthServer) gin.HandlerFunc {
+	return Gin(NewChecker(authServer))
+}
 
-		req, err := http.NewRequest("GET", authServerURL, nil)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-			c.Abort()
-			return
-		}
-		// Forward the Authorization header to the auth server
-		req.Header.Set("Authorization", authHeader)
+// AuthRequiredLocal is a gin middleware that checks if the user is
+// authenticated, like AuthRequired, but verifies the access token
+// locally against the keys of the auth server instead of calling it
+// on every request.
+func AuthRequiredLocal(jwks *JWKS) gin.HandlerFunc {
+	return Gin(NewChecker(jwks))
+}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			message := "Unauthorized"
-			if err != nil {
-				message = fmt.Sprintf("Could not connect to auth server: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"success": false,
-					"data":    "",
-					"error":   message,
-				})
-			} else {
-				var respBody map[string]interface{}
-				if decodeErr := json.NewDecoder(resp.Body).Decode(&respBody); decodeErr != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{
-						"success": false,
-						"data":    "",
-						"error":   "Failed to parse response from auth server",
-					})
-					return
-				}
-				c.JSON(http.StatusUnauthorized, respBody)
-			}
-			c.Abort()
+// Gin adapts a Checker to a gin middleware. The authenticated user is
+// stored under the "user" key of the gin context and in the context of
+// the request.
+func Gin(checker *Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := checker.Check(c.Request.Context(), c.GetHeader("Authorization"))
+		if err != nil {
+			c.AbortWithStatusJSON(errorResponse(err))
 			return
 		}
 
-		var authResp AuthResponse
-		if err := json.NewDecoder(resp.Body).Decode(&authResp); err == nil {
-			if !authResp.Success {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": authResp.Error})
-				c.Abort()
-				return
-			}
-			c.Set("user", authResp.Data) // Store user info in the context
-		}
-		resp.Body.Close()
-
+		c.Set("user", *user) // Store user info in the context
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), user))
 		c.Next()
 	}
 }
@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sony/gobreaker"
+)
+
+// ErrAuthServerUnavailable is returned when the auth server cannot be
+// reached, fails, or when the circuit breaker is open
+var ErrAuthServerUnavailable = errors.New("auth server is unavailable")
+
+// TokenRejectedError is returned when the auth server rejects a token.
+// It holds the response of the auth server.
+type TokenRejectedError struct {
+	Response map[string]interface{}
+}
+
+func (e *TokenRejectedError) Error() string {
+	return fmt.Sprintf("token rejected by the auth server: %v", e.Response["error"])
+}
+
+// AuthServerConfig configures how tokens are validated by the auth server
+type AuthServerConfig struct {
+	// URL is the token validation endpoint of the auth server,
+	// e.g. https://auth.betalink.com/token/validate
+	URL string
+	// HTTPClient is the client calling the auth server. It is shared by
+	// all the requests and must have a timeout.
+	HTTPClient *http.Client
+	// CacheTTL is how long a validated token is cached. A token is never
+	// cached past its expiry.
+	CacheTTL time.Duration
+	// CacheSize is the maximum number of cached tokens
+	CacheSize int
+	// MaxRetries is the number of times a failed call to the auth server
+	// is retried. Rejected tokens are never retried.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled before
+	// each following one
+	RetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failed validations
+	// after which the circuit breaker opens
+	BreakerThreshold uint32
+	// BreakerTimeout is how long the circuit breaker stays open before
+	// letting a request probe the auth server again
+	BreakerTimeout time.Duration
+}
+
+// DefaultAuthServerConfig holds the default values of the auth server
+// configuration
+var DefaultAuthServerConfig = AuthServerConfig{
+	HTTPClient: &http.Client{
+		Timeout: time.Second * 5,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   time.Second * 2,
+				KeepAlive: time.Second * 30,
+			}).DialContext,
+			TLSHandshakeTimeout:   time.Second * 2,
+			ResponseHeaderTimeout: time.Second * 3,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   100,
+			IdleConnTimeout:       time.Second * 90,
+		},
+	},
+	CacheTTL:         time.Minute,
+	CacheSize:        10000,
+	MaxRetries:       2,
+	RetryBackoff:     time.Millisecond * 100,
+	BreakerThreshold: 5,
+	BreakerTimeout:   time.Second * 30,
+}
+
+// AuthServer validates access tokens by calling the auth server
+type AuthServer struct {
+	config  AuthServerConfig
+	cache   *tokenCache
+	breaker *gobreaker.CircuitBreaker
+}
+
+// NewAuthServer creates a new AuthServer instance calling config.URL.
+// Zero values of the configuration are replaced by those of
+// DefaultAuthServerConfig, except MaxRetries where zero disables retries.
+func NewAuthServer(config AuthServerConfig) *AuthServer {
+	if config.HTTPClient == nil {
+		config.HTTPClient = DefaultAuthServerConfig.HTTPClient
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = DefaultAuthServerConfig.CacheTTL
+	}
+	if config.CacheSize == 0 {
+		config.CacheSize = DefaultAuthServerConfig.CacheSize
+	}
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = DefaultAuthServerConfig.RetryBackoff
+	}
+	if config.BreakerThreshold == 0 {
+		config.BreakerThreshold = DefaultAuthServerConfig.BreakerThreshold
+	}
+	if config.BreakerTimeout == 0 {
+		config.BreakerTimeout = DefaultAuthServerConfig.BreakerTimeout
+	}
+
+	return &AuthServer{
+		config: config,
+		cache:  newTokenCache(config.CacheSize),
+		breaker: gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "auth-server",
+			Timeout: config.BreakerTimeout,
+			ReadyToTrip: func(counts gobreaker.Counts) bool {
+				return counts.ConsecutiveFailures >= config.BreakerThreshold
+			},
+			// only an unavailable auth server trips the breaker
+			IsSuccessful: func(err error) bool {
+				return !errors.Is(err, ErrAuthServerUnavailable)
+			},
+		}),
+	}
+}
+
+// Verify validates an access token with the auth server and returns the
+// user it was issued to
+func (a *AuthServer) Verify(ctx context.Context, token string) (*UserData, error) {
+	if user, ok := a.cache.get(token); ok {
+		return &user, nil
+	}
+
+	result, err := a.breaker.Execute(func() (interface{}, error) {
+		return a.validateWithRetries(ctx, token)
+	})
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return nil, fmt.Errorf("%w: %v", ErrAuthServerUnavailable, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	user := result.(*UserData)
+
+	expiresAt := time.Now().Add(a.config.CacheTTL)
+	if tokenExpiresAt, ok := tokenExpiry(token); ok && tokenExpiresAt.Before(expiresAt) {
+		expiresAt = tokenExpiresAt
+	}
+	a.cache.set(token, *user, expiresAt)
+	return user, nil
+}
+
+// validateWithRetries calls the auth server, retrying with an exponential
+// backoff while it is unavailable. The error of a canceled ctx is
+// returned as is, a caller hanging up does not trip the breaker.
+func (a *AuthServer) validateWithRetries(ctx context.Context, token string) (*UserData, error) {
+	backoff := a.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		user, err := a.validate(ctx, token)
+		if err == nil || !errors.Is(err, ErrAuthServerUnavailable) || attempt >= a.config.MaxRetries {
+			return user, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// validate calls the auth server once
+func (a *AuthServer) validate(ctx context.Context, token string) (*UserData, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.config.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	// Forward the token to the auth server
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := a.config.HTTPClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrAuthServerUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrAuthServerUnavailable, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// the auth server, or a proxy in front of it, answered: the token
+		// is rejected even when the body is not the expected JSON
+		var respBody map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil || respBody == nil {
+			respBody = map[string]interface{}{"error": http.StatusText(resp.StatusCode)}
+		}
+		return nil, &TokenRejectedError{Response: respBody}
+	}
+
+	var authResp AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		return nil, fmt.Errorf("%w: could not decode response: %v", ErrAuthServerUnavailable, err)
+	}
+	if !authResp.Success {
+		return nil, &TokenRejectedError{Response: map[string]interface{}{"error": authResp.Error}}
+	}
+	return &authResp.Data, nil
+}
+
+// tokenExpiry reads the expiry of a JWT without verifying it, the
+// token has already been validated by the auth server
+func tokenExpiry(token string) (time.Time, bool) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return time.Time{}, false
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Time{}, false
+	}
+	return exp.Time, true
+}
@@ -0,0 +1,312 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BragdonD/betalink-auth/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockAuthServer validates the tokens listed in validTokens and fails
+// with the status returned by failure while it is not zero
+type mockAuthServer struct {
+	*httptest.Server
+	validTokens map[string]bool
+	failure     func() int
+	requests    atomic.Int32
+}
+
+func newMockAuthServer(validTokens ...string) *mockAuthServer {
+	server := &mockAuthServer{
+		validTokens: make(map[string]bool),
+		failure:     func() int { return 0 },
+	}
+	for _, token := range validTokens {
+		server.validTokens[token] = true
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.requests.Add(1)
+		if status := server.failure(); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		if server.validTokens[r.Header.Get("Authorization")[len("Bearer "):]] {
+			_ = json.NewEncoder(w).Encode(authResponse)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(middleware.AuthResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+	}))
+	return server
+}
+
+func newRemoteAuthRouter(authServer *middleware.AuthServer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.AuthRequiredRemote(authServer))
+	r.GET("/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, c.MustGet("user"))
+	})
+	return r
+}
+
+// expiringToken returns an unsigned JWT expiring after validity, the
+// middleware only reads its expiry
+func expiringToken(t *testing.T, validity time.Duration) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"exp": time.Now().Add(validity).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	return token
+}
+
+func TestAuthRequiredRemote_Cache(t *testing.T) {
+	t.Run("valid tokens are cached", func(t *testing.T) {
+		authServer := newMockAuthServer("valid-token")
+		defer authServer.Close()
+		r := newRemoteAuthRouter(middleware.NewAuthServer(middleware.AuthServerConfig{
+			URL: authServer.URL,
+		}))
+
+		for i := 0; i < 3; i++ {
+			w := serveWithToken(r, "valid-token")
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.JSONEq(t, `{"user_id":"12345","first_name":"John","last_name":"Doe"}`, w.Body.String())
+		}
+		assert.Equal(t, int32(1), authServer.requests.Load())
+	})
+
+	t.Run("rejected tokens are not cached", func(t *testing.T) {
+		authServer := newMockAuthServer()
+		defer authServer.Close()
+		r := newRemoteAuthRouter(middleware.NewAuthServer(middleware.AuthServerConfig{
+			URL: authServer.URL,
+		}))
+
+		for i := 0; i < 2; i++ {
+			w := serveWithToken(r, "invalid-token")
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+		assert.Equal(t, int32(2), authServer.requests.Load())
+	})
+
+	t.Run("tokens are not cached past their expiry", func(t *testing.T) {
+		token := expiringToken(t, time.Second)
+		authServer := newMockAuthServer(token)
+		defer authServer.Close()
+		r := newRemoteAuthRouter(middleware.NewAuthServer(middleware.AuthServerConfig{
+			URL:      authServer.URL,
+			CacheTTL: time.Hour,
+		}))
+
+		w := serveWithToken(r, token)
+		require.Equal(t, http.StatusOK, w.Code)
+		w = serveWithToken(r, token)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int32(1), authServer.requests.Load())
+
+		time.Sleep(time.Second)
+		w = serveWithToken(r, token)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int32(2), authServer.requests.Load())
+	})
+
+	t.Run("cache is bounded", func(t *testing.T) {
+		authServer := newMockAuthServer("token-1", "token-2")
+		defer authServer.Close()
+		r := newRemoteAuthRouter(middleware.NewAuthServer(middleware.AuthServerConfig{
+			URL:       authServer.URL,
+			CacheSize: 1,
+		}))
+
+		for _, token := range []string{"token-1", "token-2", "token-1"} {
+			w := serveWithToken(r, token)
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+		assert.Equal(t, int32(3), authServer.requests.Load())
+	})
+}
+
+func TestAuthRequiredRemote_Retry(t *testing.T) {
+	t.Run("failures are retried", func(t *testing.T) {
+		authServer := newMockAuthServer("valid-token")
+		defer authServer.Close()
+		failures := atomic.Int32{}
+		authServer.failure = func() int {
+			if failures.Add(1) <= 2 {
+				return http.StatusBadGateway
+			}
+			return 0
+		}
+		r := newRemoteAuthRouter(middleware.NewAuthServer(middleware.AuthServerConfig{
+			URL:          authServer.URL,
+			MaxRetries:   2,
+			RetryBackoff: time.Millisecond,
+		}))
+
+		w := serveWithToken(r, "valid-token")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int32(3), authServer.requests.Load())
+	})
+
+	t.Run("rejected tokens are not retried", func(t *testing.T) {
+		authServer := newMockAuthServer()
+		defer authServer.Close()
+		r := newRemoteAuthRouter(middleware.NewAuthServer(middleware.AuthServerConfig{
+			URL:          authServer.URL,
+			MaxRetries:   2,
+			RetryBackoff: time.Millisecond,
+		}))
+
+		w := serveWithToken(r, "invalid-token")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, int32(1), authServer.requests.Load())
+	})
+}
+
+func TestAuthServer_Verify(t *testing.T) {
+	t.Run("canceled requests do not trip the breaker", func(t *testing.T) {
+		authServer := newMockAuthServer("valid-token")
+		defer authServer.Close()
+		verifier := middleware.NewAuthServer(middleware.AuthServerConfig{
+			URL:              authServer.URL,
+			RetryBackoff:     time.Millisecond,
+			BreakerThreshold: 1,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := verifier.Verify(ctx, "valid-token")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, middleware.ErrAuthServerUnavailable)
+
+		user, err := verifier.Verify(context.Background(), "valid-token")
+		require.NoError(t, err)
+		assert.Equal(t, authResponse.Data.UserID, user.UserID)
+	})
+
+	t.Run("non JSON client errors reject the token", func(t *testing.T) {
+		authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("<html>Not Found</html>"))
+		}))
+		defer authServer.Close()
+		verifier := middleware.NewAuthServer(middleware.AuthServerConfig{
+			URL:              authServer.URL,
+			RetryBackoff:     time.Millisecond,
+			BreakerThreshold: 1,
+		})
+
+		for i := 0; i < 2; i++ {
+			_, err := verifier.Verify(context.Background(), "valid-token")
+			var rejectedErr *middleware.TokenRejectedError
+			require.ErrorAs(t, err, &rejectedErr)
+			assert.Equal(t, "Not Found", rejectedErr.Response["error"])
+		}
+	})
+}
+
+func TestAuthRequiredRemote_Scopes(t *testing.T) {
+	// a client registered with no scopes validates to an empty scope
+	// list, which the auth server omits from its response
+	clientUser := validUser
+	clientUser.ClientID = "empty-scope-client"
+	clientUser.Scopes = []string{}
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := validUser
+		if r.Header.Get("Authorization") == "Bearer client-token" {
+			user = clientUser
+		}
+		_ = json.NewEncoder(w).Encode(middleware.AuthResponse{Success: true, Data: user})
+	}))
+	defer authServer.Close()
+	verifier := middleware.NewAuthServer(middleware.AuthServerConfig{
+		URL:          authServer.URL,
+		RetryBackoff: time.Millisecond,
+	})
+
+	user, err := verifier.Verify(context.Background(), "client-token")
+	require.NoError(t, err)
+	assert.Equal(t, "empty-scope-client", user.ClientID)
+	assert.False(t, user.HasScopes("orders:read"))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.AuthRequiredRemote(verifier))
+	r.GET("/test", middleware.RequireScopes("orders:read"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := serveWithToken(r, "client-token")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serveWithToken(r, "first-party-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAuthRequiredRemote_CircuitBreaker(t *testing.T) {
+	authServer := newMockAuthServer("valid-token")
+	defer authServer.Close()
+	var down atomic.Bool
+	down.Store(true)
+	authServer.failure = func() int {
+		if down.Load() {
+			return http.StatusInternalServerError
+		}
+		return 0
+	}
+	r := newRemoteAuthRouter(middleware.NewAuthServer(middleware.AuthServerConfig{
+		URL:              authServer.URL,
+		RetryBackoff:     time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerTimeout:   time.Millisecond * 100,
+	}))
+
+	for i := 0; i < 2; i++ {
+		w := serveWithToken(r, "valid-token")
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"error":"Auth server unavailable"}`, w.Body.String())
+	}
+	requests := authServer.requests.Load()
+
+	// the breaker is open, the auth server is no longer called
+	w := serveWithToken(r, "valid-token")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, requests, authServer.requests.Load())
+
+	// once the auth server recovered, the breaker closes after a probe
+	down.Store(false)
+	time.Sleep(time.Millisecond * 150)
+	w = serveWithToken(r, "valid-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAuthRequiredRemote_Timeout(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 200)
+	}))
+	defer authServer.Close()
+	r := newRemoteAuthRouter(middleware.NewAuthServer(middleware.AuthServerConfig{
+		URL:          authServer.URL,
+		HTTPClient:   &http.Client{Timeout: time.Millisecond * 20},
+		RetryBackoff: time.Millisecond,
+	}))
+
+	start := time.Now()
+	w := serveWithToken(r, "valid-token")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Less(t, time.Since(start), time.Millisecond*150)
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessRequest is an access to decide on. The identity, roles and
+// permissions of the user are added to the subject by the auth server.
+type AccessRequest struct {
+	Subject  map[string]interface{} `json:"subject,omitempty"`
+	Action   string                 `json:"action"`
+	Resource map[string]interface{} `json:"resource,omitempty"`
+}
+
+// Decision is the decision of the auth server on an access
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Policy  string `json:"policy,omitempty"`
+}
+
+// decisionResponse is the response of the auth server decision endpoint
+type decisionResponse struct {
+	Success bool     `json:"success"`
+	Data    Decision `json:"data"`
+	Error   string   `json:"error"`
+}
+
+// Authorizer asks the auth server whether users may access resources
+type Authorizer struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewAuthorizer creates a new Authorizer calling the decision endpoint at
+// url, e.g. https://auth.betalink.com/authz/check. A nil httpClient is
+// replaced by the one of DefaultAuthServerConfig.
+func NewAuthorizer(url string, httpClient *http.Client) *Authorizer {
+	if httpClient == nil {
+		httpClient = DefaultAuthServerConfig.HTTPClient
+	}
+	return &Authorizer{
+		url:        url,
+		httpClient: httpClient,
+	}
+}
+
+// Check asks the auth server whether the user authenticated by
+// authorization may perform the access
+func (a *Authorizer) Check(ctx context.Context, authorization string, access AccessRequest) (*Decision, error) {
+	body, err := json.Marshal(access)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode access request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthServerUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrAuthServerUnavailable, resp.StatusCode)
+	}
+	var decisionResp decisionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decisionResp); err != nil {
+		return nil, fmt.Errorf("%w: could not decode response: %v", ErrAuthServerUnavailable, err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, &TokenRejectedError{Response: map[string]interface{}{"error": decisionResp.Error}}
+	}
+	if resp.StatusCode != http.StatusOK || !decisionResp.Success {
+		return nil, fmt.Errorf("could not check access: %s", decisionResp.Error)
+	}
+	return &decisionResp.Data, nil
+}
+
+// RequirePolicy is a gin middleware that only lets through the requests
+// allowed by the policies of the auth server for action. The attributes
+// func extracts the subject and resource attributes of the request, it
+// may be nil. It must be used after one of the authentication middlewares.
+// Denied requests get a 403 Forbidden status.
+func RequirePolicy(authorizer *Authorizer, action string, attributes func(c *gin.Context) (subject, resource map[string]interface{})) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := FromContext(c.Request.Context()); !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorBody("Authentication is required"))
+			return
+		}
+		access := AccessRequest{Action: action}
+		if attributes != nil {
+			access.Subject, access.Resource = attributes(c)
+		}
+
+		decision, err := authorizer.Check(c.Request.Context(), c.GetHeader("Authorization"), access)
+		if err != nil {
+			status, body := errorResponse(err)
+			c.AbortWithStatusJSON(status, errorBody(errorMessage(body)))
+			return
+		}
+		if !decision.Allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, errorBody("Access denied by policy"))
+			return
+		}
+		c.Next()
+	}
+}
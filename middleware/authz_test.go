@@ -0,0 +1,112 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BragdonD/betalink-auth/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockDecisionServer answers the access checks with handler and records
+// the last access request it received
+func mockDecisionServer(t *testing.T, handler func(access middleware.AccessRequest) (int, interface{})) (*httptest.Server, *middleware.AccessRequest) {
+	received := &middleware.AccessRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer valid-token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(received))
+		status, body := handler(*received)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func newPolicyRouter(authorizer *middleware.Authorizer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.Gin(middleware.NewChecker(rolesVerifier{roles: []string{"tester"}})))
+	r.GET("/test", middleware.RequirePolicy(authorizer, "builds:download", func(c *gin.Context) (map[string]interface{}, map[string]interface{}) {
+		return map[string]interface{}{"country": "FR"}, map[string]interface{}{"project_id": "betalink"}
+	}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+func TestRequirePolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         interface{}
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Access allowed",
+			status:       http.StatusOK,
+			body:         gin.H{"success": true, "data": gin.H{"allowed": true, "policy": "testers"}, "error": ""},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Access denied",
+			status:       http.StatusOK,
+			body:         gin.H{"success": true, "data": gin.H{"allowed": false}, "error": ""},
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"success":false,"data":null,"error":"Access denied by policy"}`,
+		},
+		{
+			name:         "Token rejected",
+			status:       http.StatusUnauthorized,
+			body:         gin.H{"success": false, "data": nil, "error": "Invalid token"},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"success":false,"data":null,"error":"Invalid token"}`,
+		},
+		{
+			name:         "Auth server failing",
+			status:       http.StatusInternalServerError,
+			body:         gin.H{"success": false, "data": nil, "error": "could not check access"},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: `{"success":false,"data":null,"error":"Auth server unavailable"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, received := mockDecisionServer(t, func(_ middleware.AccessRequest) (int, interface{}) {
+				return tt.status, tt.body
+			})
+			r := newPolicyRouter(middleware.NewAuthorizer(server.URL, server.Client()))
+
+			w := serveWithToken(r, "valid-token")
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+			assert.Equal(t, middleware.AccessRequest{
+				Subject:  map[string]interface{}{"country": "FR"},
+				Action:   "builds:download",
+				Resource: map[string]interface{}{"project_id": "betalink"},
+			}, *received)
+		})
+	}
+}
+
+func TestRequirePolicy_Unauthenticated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	authorizer := middleware.NewAuthorizer("http://localhost:0", nil)
+	r.GET("/test", middleware.RequirePolicy(authorizer, "builds:download", nil), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := serveWithToken(r, "valid-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"success":false,"data":null,"error":"Authentication is required"}`, w.Body.String())
+}
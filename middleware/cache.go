@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// tokenCache is a bounded cache of validated tokens. Tokens are keyed by
+// their hash so the cache never holds usable credentials. When full, the
+// least recently used entry is evicted.
+type tokenCache struct {
+	mu       sync.Mutex
+	size     int
+	entries  map[[sha256.Size]byte]*list.Element
+	eviction *list.List
+}
+
+// tokenCacheEntry is the user a token was issued to, valid until expiresAt
+type tokenCacheEntry struct {
+	key       [sha256.Size]byte
+	user      UserData
+	expiresAt time.Time
+}
+
+// newTokenCache creates a cache holding at most size entries
+func newTokenCache(size int) *tokenCache {
+	return &tokenCache{
+		size:     size,
+		entries:  make(map[[sha256.Size]byte]*list.Element, size),
+		eviction: list.New(),
+	}
+}
+
+// get returns the user a cached token was issued to
+func (c *tokenCache) get(token string) (UserData, bool) {
+	key := sha256.Sum256([]byte(token))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return UserData{}, false
+	}
+	entry := element.Value.(*tokenCacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.remove(element)
+		return UserData{}, false
+	}
+	c.eviction.MoveToFront(element)
+	return entry.user, true
+}
+
+// set caches the user a token was issued to until expiresAt
+func (c *tokenCache) set(token string, user UserData, expiresAt time.Time) {
+	if c.size <= 0 {
+		return
+	}
+	key := sha256.Sum256([]byte(token))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*tokenCacheEntry)
+		entry.user = user
+		entry.expiresAt = expiresAt
+		c.eviction.MoveToFront(element)
+		return
+	}
+	for c.eviction.Len() >= c.size {
+		c.remove(c.eviction.Back())
+	}
+	c.entries[key] = c.eviction.PushFront(&tokenCacheEntry{
+		key:       key,
+		user:      user,
+		expiresAt: expiresAt,
+	})
+}
+
+// remove removes an entry, the caller must hold mu
+func (c *tokenCache) remove(element *list.Element) {
+	c.eviction.Remove(element)
+	delete(c.entries, element.Value.(*tokenCacheEntry).key)
+}
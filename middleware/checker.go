@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrMissingAuthorization is returned when a request carries no
+	// Authorization header or authorization metadata
+	ErrMissingAuthorization = errors.New("authorization is required")
+	// ErrInvalidAuthorization is returned when the authorization of a
+	// request is not a bearer token
+	ErrInvalidAuthorization = errors.New("invalid authorization format")
+)
+
+// TokenVerifier verifies an access token and returns the user it was
+// issued to. It is implemented by JWKS, verifying tokens locally, and by
+// AuthServer, validating them with the auth server.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*UserData, error)
+}
+
+// Checker authenticates requests independently of the framework serving
+// them. The Gin, HTTP, UnaryServerInterceptor and StreamServerInterceptor
+// adapters plug it into gin, net/http and gRPC servers.
+type Checker struct {
+	verifier TokenVerifier
+}
+
+// NewChecker creates a new Checker verifying tokens with verifier
+func NewChecker(verifier TokenVerifier) *Checker {
+	return &Checker{verifier: verifier}
+}
+
+// Check authenticates the value of an Authorization header, or of the
+// authorization metadata of a gRPC call, and returns the authenticated user
+func (c *Checker) Check(ctx context.Context, authorization string) (*UserData, error) {
+	if authorization == "" {
+		return nil, ErrMissingAuthorization
+	}
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return nil, ErrInvalidAuthorization
+	}
+	return c.verifier.Verify(ctx, parts[1])
+}
+
+// contextKey is the key of the authenticated user in a context
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the authenticated user
+func NewContext(ctx context.Context, user *UserData) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// FromContext returns the authenticated user stored in ctx by one of the
+// adapters of Checker. With gin, use the context of the request:
+// FromContext(c.Request.Context()).
+func FromContext(ctx context.Context) (*UserData, bool) {
+	user, ok := ctx.Value(contextKey{}).(*UserData)
+	return user, ok
+}
+
+// errorResponse returns the status and the body answering a request
+// which failed to authenticate with err
+func errorResponse(err error) (int, map[string]interface{}) {
+	var rejectedErr *TokenRejectedError
+	switch {
+	case errors.Is(err, ErrMissingAuthorization):
+		return http.StatusUnauthorized, map[string]interface{}{"error": "Authorization header is required"}
+	case errors.Is(err, ErrInvalidAuthorization):
+		return http.StatusUnauthorized, map[string]interface{}{"error": "Invalid Authorization header format"}
+	case errors.As(err, &rejectedErr):
+		return http.StatusUnauthorized, rejectedErr.Response
+	case errors.Is(err, ErrTokenExpired):
+		return http.StatusUnauthorized, map[string]interface{}{"error": "Token has expired"}
+	case errors.Is(err, ErrInvalidToken):
+		return http.StatusUnauthorized, map[string]interface{}{"error": "Invalid token"}
+	case errors.Is(err, ErrAuthServerUnavailable), errors.Is(err, ErrKeysUnavailable):
+		return http.StatusServiceUnavailable, map[string]interface{}{"error": "Auth server unavailable"}
+	default:
+		return http.StatusInternalServerError, map[string]interface{}{"error": "Internal server error"}
+	}
+}
+
+// errorMessage returns the message of an error response body
+func errorMessage(body map[string]interface{}) string {
+	return fmt.Sprint(body["error"])
+}
@@ -0,0 +1,219 @@
+package middleware_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BragdonD/betalink-auth/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// staticVerifier accepts a single token
+type staticVerifier struct {
+	token string
+	err   error
+}
+
+func (v staticVerifier) Verify(_ context.Context, token string) (*middleware.UserData, error) {
+	if v.err != nil {
+		return nil, v.err
+	}
+	if token != v.token {
+		return nil, middleware.ErrInvalidToken
+	}
+	user := validUser
+	return &user, nil
+}
+
+func TestHTTP(t *testing.T) {
+	checker := middleware.NewChecker(staticVerifier{token: "valid-token"})
+	handler := middleware.HTTP(checker)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := middleware.FromContext(r.Context())
+		require.True(t, ok)
+		_, _ = w.Write([]byte(user.UserID))
+	}))
+
+	tests := []struct {
+		name         string
+		authHeader   string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Valid token",
+			authHeader:   "Bearer valid-token",
+			expectedCode: http.StatusOK,
+			expectedBody: "12345",
+		},
+		{
+			name:         "Missing token",
+			authHeader:   "",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Authorization header is required"}`,
+		},
+		{
+			name:         "Invalid token format",
+			authHeader:   "Basic valid-token",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Invalid Authorization header format"}`,
+		},
+		{
+			name:         "Invalid token",
+			authHeader:   "Bearer invalid-token",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Invalid token"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedCode == http.StatusOK {
+				assert.Equal(t, tt.expectedBody, w.Body.String())
+			} else {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("Auth server unavailable", func(t *testing.T) {
+		checker := middleware.NewChecker(staticVerifier{err: middleware.ErrAuthServerUnavailable})
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer valid-token")
+		w := httptest.NewRecorder()
+		middleware.HTTP(checker)(handler).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"error":"Auth server unavailable"}`, w.Body.String())
+	})
+}
+
+func TestGin_FromContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.Gin(middleware.NewChecker(staticVerifier{token: "valid-token"})))
+	r.GET("/test", func(c *gin.Context) {
+		user, ok := middleware.FromContext(c.Request.Context())
+		require.True(t, ok)
+		c.String(http.StatusOK, user.UserID)
+	})
+
+	w := serveWithToken(r, "valid-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "12345", w.Body.String())
+}
+
+// authenticatedHealthServer records the user of the last call
+type authenticatedHealthServer struct {
+	*health.Server
+	user chan *middleware.UserData
+}
+
+func (s *authenticatedHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	user, _ := middleware.FromContext(ctx)
+	s.user <- user
+	return s.Server.Check(ctx, req)
+}
+
+func (s *authenticatedHealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	user, _ := middleware.FromContext(stream.Context())
+	s.user <- user
+	return s.Server.Watch(req, stream)
+}
+
+func newGRPCClient(t *testing.T, verifier middleware.TokenVerifier) (healthpb.HealthClient, chan *middleware.UserData) {
+	t.Helper()
+	checker := middleware.NewChecker(verifier)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(middleware.UnaryServerInterceptor(checker)),
+		grpc.StreamInterceptor(middleware.StreamServerInterceptor(checker)),
+	)
+	healthServer := &authenticatedHealthServer{
+		Server: health.NewServer(),
+		user:   make(chan *middleware.UserData, 1),
+	}
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	listener := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return healthpb.NewHealthClient(conn), healthServer.user
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	client, users := newGRPCClient(t, staticVerifier{token: "valid-token"})
+	authenticated := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer valid-token")
+
+	t.Run("unary call", func(t *testing.T) {
+		_, err := client.Check(authenticated, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, &validUser, <-users)
+	})
+
+	t.Run("stream call", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(authenticated)
+		defer cancel()
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, &validUser, <-users)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.Equal(t, "Authorization header is required", status.Convert(err).Message())
+
+		stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer invalid-token")
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.Equal(t, "Invalid token", status.Convert(err).Message())
+	})
+
+	t.Run("auth server unavailable", func(t *testing.T) {
+		client, _ := newGRPCClient(t, staticVerifier{err: middleware.ErrAuthServerUnavailable})
+		_, err := client.Check(authenticated, &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
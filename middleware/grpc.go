@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor adapts a Checker to a gRPC unary server
+// interceptor reading the token from the authorization metadata. The
+// authenticated user is stored in the context of the call and retrieved
+// with FromContext.
+func UnaryServerInterceptor(checker *Checker) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticateCall(ctx, checker)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor adapts a Checker to a gRPC stream server
+// interceptor, like UnaryServerInterceptor
+func StreamServerInterceptor(checker *Checker) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticateCall(stream.Context(), checker)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticatedStream overrides the context of a stream with the one
+// carrying the authenticated user
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticateCall checks the authorization metadata of a gRPC call and
+// returns its context carrying the authenticated user
+func authenticateCall(ctx context.Context, checker *Checker) (context.Context, error) {
+	var authorization string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	user, err := checker.Check(ctx, authorization)
+	if err != nil {
+		statusCode, body := errorResponse(err)
+		code := codes.Internal
+		switch statusCode {
+		case http.StatusUnauthorized:
+			code = codes.Unauthenticated
+		case http.StatusServiceUnavailable:
+			code = codes.Unavailable
+		}
+		return nil, status.Error(code, errorMessage(body))
+	}
+	return NewContext(ctx, user), nil
+}
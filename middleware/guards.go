@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRoles is a gin middleware that only lets through users having
+// all the given roles. It must be used after one of the authentication
+// middlewares. Other users get a 403 Forbidden status.
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return guard("Missing required role", func(user *UserData) bool {
+		return user.HasRoles(roles...)
+	})
+}
+
+// RequireAnyPermission is a gin middleware that only lets through users
+// having at least one of the given permissions. It must be used after one
+// of the authentication middlewares. Other users get a 403 Forbidden status.
+func RequireAnyPermission(permissions ...string) gin.HandlerFunc {
+	return guard("Missing required permission", func(user *UserData) bool {
+		return user.HasAnyPermission(permissions...)
+	})
+}
+
+// RequireScopes is a gin middleware that only lets through tokens granted
+// all the given scopes, such as the tokens of the machine clients allowed
+// to call a service. The tokens of the first-party apps are granted every
+// scope. It must be used after one of the authentication middlewares.
+// Other tokens get a 403 Forbidden status.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return guard("Missing required scope", func(user *UserData) bool {
+		return user.HasScopes(scopes...)
+	})
+}
+
+// RequireService is a gin middleware that only lets through the tokens
+// of machine clients, for the routes only meant for other services. It
+// must be used after one of the authentication middlewares. User tokens
+// get a 403 Forbidden status.
+func RequireService() gin.HandlerFunc {
+	return guard("Reserved to services", func(user *UserData) bool {
+		return user.Service
+	})
+}
+
+// guard aborts the requests of the users not allowed by the allowed func
+func guard(message string, allowed func(user *UserData) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := FromContext(c.Request.Context())
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorBody("Authentication is required"))
+			return
+		}
+		if !allowed(user) {
+			c.AbortWithStatusJSON(http.StatusForbidden, errorBody(message))
+			return
+		}
+		c.Next()
+	}
+}
+
+// errorBody returns the standard error body of the auth service responses
+func errorBody(message string) gin.H {
+	return gin.H{
+		"success": false,
+		"data":    nil,
+		"error":   message,
+	}
+}
@@ -0,0 +1,226 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/BragdonD/betalink-auth/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// rolesVerifier authenticates every token as a user with the given
+// roles and permissions
+type rolesVerifier struct {
+	roles       []string
+	permissions []string
+}
+
+func (v rolesVerifier) Verify(_ context.Context, _ string) (*middleware.UserData, error) {
+	user := validUser
+	user.Roles = v.roles
+	user.Permissions = v.permissions
+	return &user, nil
+}
+
+func TestRequireRoles(t *testing.T) {
+	tests := []struct {
+		name         string
+		userRoles    []string
+		required     []string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "User has the role",
+			userRoles:    []string{"user", "admin"},
+			required:     []string{"admin"},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "User has all the roles",
+			userRoles:    []string{"user", "admin"},
+			required:     []string{"user", "admin"},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "User misses a role",
+			userRoles:    []string{"user"},
+			required:     []string{"user", "admin"},
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"success":false,"data":null,"error":"Missing required role"}`,
+		},
+		{
+			name:         "User has no roles",
+			userRoles:    nil,
+			required:     []string{"admin"},
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"success":false,"data":null,"error":"Missing required role"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(middleware.Gin(middleware.NewChecker(rolesVerifier{roles: tt.userRoles})))
+			r.GET("/test", middleware.RequireRoles(tt.required...), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := serveWithToken(r, "valid-token")
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireAnyPermission(t *testing.T) {
+	tests := []struct {
+		name            string
+		userPermissions []string
+		required        []string
+		expectedCode    int
+		expectedBody    string
+	}{
+		{
+			name:            "User has one of the permissions",
+			userPermissions: []string{"users:read"},
+			required:        []string{"users:read", "users:write"},
+			expectedCode:    http.StatusOK,
+		},
+		{
+			name:            "User has none of the permissions",
+			userPermissions: []string{"sessions:read"},
+			required:        []string{"users:read", "users:write"},
+			expectedCode:    http.StatusForbidden,
+			expectedBody:    `{"success":false,"data":null,"error":"Missing required permission"}`,
+		},
+		{
+			name:            "User has no permissions",
+			userPermissions: nil,
+			required:        []string{"users:read"},
+			expectedCode:    http.StatusForbidden,
+			expectedBody:    `{"success":false,"data":null,"error":"Missing required permission"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(middleware.Gin(middleware.NewChecker(rolesVerifier{permissions: tt.userPermissions})))
+			r.GET("/test", middleware.RequireAnyPermission(tt.required...), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := serveWithToken(r, "valid-token")
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireRoles_Unauthenticated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/test", middleware.RequireRoles("admin"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := serveWithToken(r, "valid-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"success":false,"data":null,"error":"Authentication is required"}`, w.Body.String())
+}
+
+// principalVerifier authenticates every token as the given principal
+type principalVerifier struct {
+	principal middleware.UserData
+}
+
+func (v principalVerifier) Verify(_ context.Context, _ string) (*middleware.UserData, error) {
+	principal := v.principal
+	return &principal, nil
+}
+
+func TestRequireScopes(t *testing.T) {
+	service := middleware.UserData{
+		ClientID: "orders-worker",
+		Scopes:   []string{"billing:read"},
+		Service:  true,
+	}
+	tests := []struct {
+		name         string
+		principal    middleware.UserData
+		guard        gin.HandlerFunc
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Service has the scope",
+			principal:    service,
+			guard:        middleware.RequireScopes("billing:read"),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Service misses a scope",
+			principal:    service,
+			guard:        middleware.RequireScopes("billing:read", "billing:write"),
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"success":false,"data":null,"error":"Missing required scope"}`,
+		},
+		{
+			name:         "Service without scopes",
+			principal:    middleware.UserData{ClientID: "orders-worker", Service: true},
+			guard:        middleware.RequireScopes("billing:read"),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Client without scopes",
+			principal:    middleware.UserData{UserID: "12345", ClientID: "empty-scope-client"},
+			guard:        middleware.RequireScopes("billing:read"),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "First-party user tokens are granted every scope",
+			principal:    validUser,
+			guard:        middleware.RequireScopes("billing:read"),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Service route called by a service",
+			principal:    service,
+			guard:        middleware.RequireService(),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Service route called by a user",
+			principal:    validUser,
+			guard:        middleware.RequireService(),
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"success":false,"data":null,"error":"Reserved to services"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(middleware.Gin(middleware.NewChecker(principalVerifier{principal: tt.principal})))
+			r.GET("/test", tt.guard, func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := serveWithToken(r, "valid-token")
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
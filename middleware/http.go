@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HTTP adapts a Checker to a net/http middleware, compatible with routers
+// such as chi. The authenticated user is stored in the context of the
+// request and retrieved with FromContext.
+func HTTP(checker *Checker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := checker.Check(r.Context(), r.Header.Get("Authorization"))
+			if err != nil {
+				status, body := errorResponse(err)
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(status)
+				_ = json.NewEncoder(w).Encode(body)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), user)))
+		})
+	}
+}
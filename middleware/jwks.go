@@ -0,0 +1,298 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BragdonD/betalink-auth/internal/claim"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrTokenExpired is returned when verifying an expired access token
+	ErrTokenExpired = errors.New("token has expired")
+	// ErrKeysUnavailable is returned when the public keys of the auth
+	// server cannot be fetched
+	ErrKeysUnavailable = errors.New("signing keys are unavailable")
+	// ErrInvalidToken is returned when verifying a malformed, forged or
+	// otherwise invalid access token
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// grantTypeClientCredentials is the gty claim of the access tokens issued
+// to machine clients
+const grantTypeClientCredentials = "client_credentials"
+
+// JWKSConfig configures the local verification of access tokens
+// against the public keys published by the auth server
+type JWKSConfig struct {
+	// URL is the JWKS endpoint of the auth server,
+	// e.g. https://auth.betalink.com/.well-known/jwks.json
+	URL string
+	// RefreshInterval is the time between two background refreshes
+	// of the keys
+	RefreshInterval time.Duration
+	// MinRefreshInterval is the minimum time between two refreshes
+	// triggered by tokens signed with an unknown key, so forged tokens
+	// cannot flood the auth server
+	MinRefreshInterval time.Duration
+	// Issuer is the expected issuer of the access tokens
+	Issuer string
+	// Audience is the expected audience of the access tokens
+	Audience string
+	// ServiceAudience is the audience of the service in the access
+	// tokens of machine clients, which are rejected when it is empty
+	ServiceAudience string
+	// HTTPClient is the client fetching the keys
+	HTTPClient *http.Client
+}
+
+// DefaultJWKSConfig holds the default values of the JWKS configuration
+var DefaultJWKSConfig = JWKSConfig{
+	RefreshInterval:    time.Minute * 10,
+	MinRefreshInterval: time.Second * 30,
+	Issuer:             "betalink-auth",
+	Audience:           "betalink",
+	HTTPClient: &http.Client{
+		Timeout: time.Second * 10,
+	},
+}
+
+// jsonWebKey is a RSA public key as published by the auth server
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS caches the public keys of the auth server and verifies
+// access tokens locally
+type JWKS struct {
+	config JWKSConfig
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	lastRefresh time.Time
+
+	// refreshMu serializes the refreshes of the keys
+	refreshMu sync.Mutex
+}
+
+// NewJWKS creates a new JWKS instance fetching the keys from
+// config.URL. Zero values of the configuration are replaced by
+// those of DefaultJWKSConfig. The keys are refreshed in the
+// background until ctx is cancelled.
+func NewJWKS(ctx context.Context, config JWKSConfig) *JWKS {
+	if config.RefreshInterval == 0 {
+		config.RefreshInterval = DefaultJWKSConfig.RefreshInterval
+	}
+	if config.MinRefreshInterval == 0 {
+		config.MinRefreshInterval = DefaultJWKSConfig.MinRefreshInterval
+	}
+	if config.Issuer == "" {
+		config.Issuer = DefaultJWKSConfig.Issuer
+	}
+	if config.Audience == "" {
+		config.Audience = DefaultJWKSConfig.Audience
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = DefaultJWKSConfig.HTTPClient
+	}
+
+	jwks := &JWKS{
+		config: config,
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+	go jwks.refreshLoop(ctx)
+	return jwks
+}
+
+// refreshLoop refreshes the keys every refresh interval until
+// ctx is cancelled. Failures keep the previously fetched keys.
+func (j *JWKS) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(j.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		_ = j.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh fetches the keys from the auth server
+func (j *JWKS) Refresh(ctx context.Context) error {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+	return j.refresh(ctx)
+}
+
+// refresh fetches the keys, the caller must hold refreshMu
+func (j *JWKS) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.config.URL, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	resp, err := j.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not fetch keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not fetch keys: unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("could not decode keys: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(body.Keys))
+	for _, key := range body.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+		publicKey, err := parseRSAPublicKey(key)
+		if err != nil {
+			return fmt.Errorf("could not parse key %q: %w", key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.lastRefresh = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+// key returns the public key identified by keyID, refreshing the keys
+// once if it is unknown, for instance after a key rotation
+func (j *JWKS) key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	j.mu.RLock()
+	key, ok := j.keys[keyID]
+	j.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+	// another request may have refreshed the keys while waiting
+	j.mu.RLock()
+	key, ok = j.keys[keyID]
+	lastRefresh := j.lastRefresh
+	j.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if time.Since(lastRefresh) < j.config.MinRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+	if err := j.refresh(ctx); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+
+	j.mu.RLock()
+	key, ok = j.keys[keyID]
+	j.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+	return key, nil
+}
+
+// Verify verifies an access token and returns the user it was issued to
+func (j *JWKS) Verify(ctx context.Context, token string) (*UserData, error) {
+	var keyErr error
+	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		key, err := j.key(ctx, keyID)
+		keyErr = err
+		return key, err
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(j.config.Issuer),
+		jwt.WithExpirationRequired(),
+	)
+	switch {
+	case errors.Is(keyErr, ErrKeysUnavailable):
+		return nil, keyErr
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, ErrTokenExpired
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("%w: could not extract claims", ErrInvalidToken)
+	}
+	// the tokens of machine clients are issued for a single service
+	service := claims["gty"] == grantTypeClientCredentials
+	audience := j.config.Audience
+	if service {
+		audience = j.config.ServiceAudience
+	}
+	audiences, err := claims.GetAudience()
+	if err != nil || audience == "" || !slices.Contains(audiences, audience) {
+		return nil, fmt.Errorf("%w: token is not issued for this audience", ErrInvalidToken)
+	}
+	clientID, _ := claims["client_id"].(string)
+	if service {
+		return &UserData{
+			Scopes:   claim.Scope(claims),
+			ClientID: clientID,
+			Service:  true,
+		}, nil
+	}
+
+	userID, _ := claims["user_id"].(string)
+	if userID == "" {
+		return nil, fmt.Errorf("%w: missing user ID", ErrInvalidToken)
+	}
+	firstName, _ := claims["first_name"].(string)
+	lastName, _ := claims["last_name"].(string)
+	return &UserData{
+		UserID:      userID,
+		FirstName:   firstName,
+		LastName:    lastName,
+		Roles:       claim.Strings(claims, "roles"),
+		Permissions: claim.Strings(claims, "permissions"),
+		Scopes:      claim.Scope(claims),
+		ClientID:    clientID,
+	}, nil
+}
+
+// parseRSAPublicKey decodes the modulus and exponent of a JSON Web Key
+func parseRSAPublicKey(key jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
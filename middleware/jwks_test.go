@@ -0,0 +1,309 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BragdonD/betalink-auth/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKey is a signing key of the mock auth server
+type testKey struct {
+	id         string
+	privateKey *rsa.PrivateKey
+}
+
+func newTestKey(t *testing.T, id string) testKey {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return testKey{id: id, privateKey: privateKey}
+}
+
+func (k testKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = k.id
+	signedToken, err := token.SignedString(k.privateKey)
+	require.NoError(t, err)
+	return signedToken
+}
+
+func userClaims(validity time.Duration) jwt.MapClaims {
+	return jwt.MapClaims{
+		"user_id":    validUser.UserID,
+		"first_name": validUser.FirstName,
+		"last_name":  validUser.LastName,
+		"roles":      []string{"user"},
+		"exp":        time.Now().Add(validity).Unix(),
+		"iat":        time.Now().Unix(),
+		"iss":        "betalink-auth",
+		"aud":        "betalink",
+	}
+}
+
+// mockJWKSServer serves the public part of its current keys
+type mockJWKSServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []testKey
+	requests atomic.Int32
+}
+
+func newMockJWKSServer(keys ...testKey) *mockJWKSServer {
+	server := &mockJWKSServer{keys: keys}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.requests.Add(1)
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		jwks := map[string]interface{}{"keys": []map[string]string{}}
+		for _, key := range server.keys {
+			jwks["keys"] = append(jwks["keys"].([]map[string]string), map[string]string{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": key.id,
+				"n":   base64.RawURLEncoding.EncodeToString(key.privateKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.privateKey.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	return server
+}
+
+func (s *mockJWKSServer) setKeys(keys ...testKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func newLocalAuthRouter(jwks *middleware.JWKS) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.AuthRequiredLocal(jwks))
+	r.GET("/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, c.MustGet("user"))
+	})
+	return r
+}
+
+func serveWithToken(r *gin.Engine, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/test", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthRequiredLocal(t *testing.T) {
+	key := newTestKey(t, "key-1")
+	otherKey := newTestKey(t, "key-2")
+	authServer := newMockJWKSServer(key)
+	defer authServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jwks := middleware.NewJWKS(ctx, middleware.JWKSConfig{
+		URL:                authServer.URL,
+		MinRefreshInterval: time.Millisecond,
+	})
+	r := newLocalAuthRouter(jwks)
+
+	wrongAudience := userClaims(time.Hour)
+	wrongAudience["aud"] = "another-service"
+	symmetricToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, userClaims(time.Hour)).
+		SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Valid token",
+			token:        key.sign(t, userClaims(time.Hour)),
+			expectedCode: http.StatusOK,
+			expectedBody: `{"user_id":"12345","first_name":"John","last_name":"Doe","roles":["user"]}`,
+		},
+		{
+			name:         "Missing token",
+			token:        "",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Authorization header is required"}`,
+		},
+		{
+			name:         "Expired token",
+			token:        key.sign(t, userClaims(-time.Hour)),
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Token has expired"}`,
+		},
+		{
+			name:         "Wrong audience",
+			token:        key.sign(t, wrongAudience),
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Invalid token"}`,
+		},
+		{
+			name:         "Unknown key",
+			token:        otherKey.sign(t, userClaims(time.Hour)),
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Invalid token"}`,
+		},
+		{
+			name:         "Symmetric token",
+			token:        symmetricToken,
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Invalid token"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveWithToken(r, tt.token)
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestAuthRequiredLocal_KeyRotation(t *testing.T) {
+	key := newTestKey(t, "key-1")
+	rotatedKey := newTestKey(t, "key-2")
+	authServer := newMockJWKSServer(key)
+	defer authServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jwks := middleware.NewJWKS(ctx, middleware.JWKSConfig{
+		URL:                authServer.URL,
+		MinRefreshInterval: time.Hour,
+	})
+	require.NoError(t, jwks.Refresh(ctx))
+	r := newLocalAuthRouter(jwks)
+
+	// wait for the initial background refresh
+	require.Eventually(t, func() bool {
+		return authServer.requests.Load() == 2
+	}, time.Second, time.Millisecond)
+	requests := authServer.requests.Load()
+
+	w := serveWithToken(r, key.sign(t, userClaims(time.Hour)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// tokens are verified locally
+	assert.Equal(t, requests, authServer.requests.Load())
+
+	// the auth server rotated its key after the last refresh, the
+	// unknown key is rate limited by the minimum refresh interval
+	authServer.setKeys(rotatedKey, key)
+	w = serveWithToken(r, rotatedKey.sign(t, userClaims(time.Hour)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, requests, authServer.requests.Load())
+
+	// once the minimum refresh interval elapsed, a token signed by an
+	// unknown key triggers a refresh
+	authServer.setKeys(key)
+	jwks = middleware.NewJWKS(ctx, middleware.JWKSConfig{
+		URL:                authServer.URL,
+		MinRefreshInterval: time.Millisecond,
+	})
+	require.NoError(t, jwks.Refresh(ctx))
+	r = newLocalAuthRouter(jwks)
+	authServer.setKeys(rotatedKey, key)
+	time.Sleep(2 * time.Millisecond)
+
+	w = serveWithToken(r, rotatedKey.sign(t, userClaims(time.Hour)))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAuthRequiredLocal_AuthServerUnavailable(t *testing.T) {
+	key := newTestKey(t, "key-1")
+	authServer := newMockJWKSServer(key)
+	authServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jwks := middleware.NewJWKS(ctx, middleware.JWKSConfig{
+		URL:                authServer.URL,
+		MinRefreshInterval: time.Millisecond,
+	})
+	r := newLocalAuthRouter(jwks)
+	time.Sleep(2 * time.Millisecond)
+
+	w := serveWithToken(r, key.sign(t, userClaims(time.Hour)))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"error":"Auth server unavailable"}`, w.Body.String())
+}
+
+func serviceClaims(audience string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":       "orders-worker",
+		"client_id": "orders-worker",
+		"scope":     "billing:read billing:write",
+		"gty":       "client_credentials",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"iat":       time.Now().Unix(),
+		"iss":       "betalink-auth",
+		"aud":       audience,
+	}
+}
+
+func TestAuthRequiredLocal_ServiceTokens(t *testing.T) {
+	key := newTestKey(t, "key-1")
+	authServer := newMockJWKSServer(key)
+	defer authServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newRouter := func(serviceAudience string) *gin.Engine {
+		return newLocalAuthRouter(middleware.NewJWKS(ctx, middleware.JWKSConfig{
+			URL:                authServer.URL,
+			MinRefreshInterval: time.Millisecond,
+			ServiceAudience:    serviceAudience,
+		}))
+	}
+
+	t.Run("service token for this service", func(t *testing.T) {
+		w := serveWithToken(newRouter("billing"), key.sign(t, serviceClaims("billing")))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t,
+			`{"user_id":"","first_name":"","last_name":"","scopes":["billing:read","billing:write"],"client_id":"orders-worker","service":true}`,
+			w.Body.String())
+	})
+
+	t.Run("service token for another service", func(t *testing.T) {
+		w := serveWithToken(newRouter("billing"), key.sign(t, serviceClaims("shipping")))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("service tokens are rejected without service audience", func(t *testing.T) {
+		w := serveWithToken(newRouter(""), key.sign(t, serviceClaims("billing")))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("user tokens are not service tokens", func(t *testing.T) {
+		userToken := userClaims(time.Hour)
+		userToken["aud"] = "billing"
+		w := serveWithToken(newRouter("billing"), key.sign(t, userToken))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
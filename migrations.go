@@ -0,0 +1,56 @@
+package betalinkauth
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// MigrationLockID is the postgres advisory lock held by the process
+// currently migrating the database
+const MigrationLockID int64 = 0x62657461_6d696772 // "betamigr"
+
+// migrations are the goose migrations of the postgres database, and in
+// the sqlite directory the ones of the SQLite store
+//
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrations embed.FS
+
+// NewPostgresMigrations returns the migrations of a postgres database,
+// embedded in the binary. Several replicas may migrate the same
+// database on start, an advisory lock ensures they apply them one at a
+// time.
+func NewPostgresMigrations(db *sql.DB) (*goose.Provider, error) {
+	locker, err := lock.NewPostgresSessionLocker(lock.WithLockID(MigrationLockID))
+	if err != nil {
+		return nil, fmt.Errorf("could not create migration lock: %w", err)
+	}
+	return newMigrations(goose.DialectPostgres, db, "migrations", goose.WithSessionLocker(locker))
+}
+
+// NewSQLiteMigrations returns the migrations of a SQLiteStore database,
+// embedded in the binary
+func NewSQLiteMigrations(db *sql.DB) (*goose.Provider, error) {
+	return newMigrations(goose.DialectSQLite3, db, "migrations/sqlite")
+}
+
+// newMigrations returns the migrations of a dialect stored in a
+// directory of the embedded ones
+func newMigrations(dialect goose.Dialect, db *sql.DB, dir string, opts ...goose.ProviderOption) (*goose.Provider, error) {
+	fsys, err := fs.Sub(migrations, dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not open migrations: %w", err)
+	}
+	// the migrations are SQL files only, and must not pick up the Go
+	// migrations registered by other packages
+	opts = append(opts, goose.WithDisableGlobalRegistry(true))
+	provider, err := goose.NewProvider(dialect, db, fsys, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load migrations: %w", err)
+	}
+	return provider, nil
+}
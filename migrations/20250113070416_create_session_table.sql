@@ -1,7 +1,5 @@
 -- +goose Up
 
--- CREATE EXTENSION IF NOT EXISTS "pg_cron";
-
 CREATE TABLE Sessions (
     session_token TEXT NOT NULL PRIMARY KEY,
     user_id UUID NOT NULL,
@@ -9,7 +7,4 @@ CREATE TABLE Sessions (
     updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
     expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
     FOREIGN KEY (user_id) REFERENCES Users(user_id)
-);
-
--- Delete expired sessions every 20 minutes
--- SELECT cron.schedule('session-cleanup', '20 * * * *', 'DELETE FROM Sessions WHERE expires_at < NOW()');
\ No newline at end of file
+);
\ No newline at end of file
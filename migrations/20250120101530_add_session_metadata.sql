@@ -0,0 +1,25 @@
+-- +goose Up
+
+CREATE TABLE LoginMethod (
+    loginMethod VARCHAR(255) NOT NULL UNIQUE,
+    PRIMARY KEY (loginMethod)
+);
+
+INSERT INTO LoginMethod (loginMethod) VALUES ('PASSWORD');
+
+ALTER TABLE Sessions
+ADD COLUMN ip_address INET,
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN device VARCHAR(255) NOT NULL DEFAULT '',
+ADD COLUMN login_method VARCHAR(255) NOT NULL DEFAULT 'PASSWORD',
+ADD FOREIGN KEY (login_method) REFERENCES LoginMethod(loginMethod);
+
+-- +goose Down
+
+ALTER TABLE Sessions
+DROP COLUMN login_method,
+DROP COLUMN device,
+DROP COLUMN user_agent,
+DROP COLUMN ip_address;
+
+DROP TABLE LoginMethod;
@@ -0,0 +1,16 @@
+-- +goose Up
+
+ALTER TABLE Sessions
+ADD COLUMN absolute_expires_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN remember_me BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE Sessions SET absolute_expires_at = expires_at;
+
+ALTER TABLE Sessions
+ALTER COLUMN absolute_expires_at SET NOT NULL;
+
+-- +goose Down
+
+ALTER TABLE Sessions
+DROP COLUMN remember_me,
+DROP COLUMN absolute_expires_at;
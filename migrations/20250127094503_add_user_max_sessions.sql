@@ -0,0 +1,10 @@
+-- +goose Up
+
+-- NULL means the user follows the default limit of the service
+ALTER TABLE Users
+ADD COLUMN max_sessions INTEGER CHECK (max_sessions >= 0);
+
+-- +goose Down
+
+ALTER TABLE Users
+DROP COLUMN max_sessions;
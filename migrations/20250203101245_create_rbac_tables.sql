@@ -0,0 +1,48 @@
+-- +goose Up
+
+CREATE TABLE Roles (
+    role_name VARCHAR(255) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    -- NULL means the role does not limit the sessions of its users
+    max_sessions INTEGER CHECK (max_sessions >= 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE Permissions (
+    permission_name VARCHAR(255) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE RolePermissions (
+    role_name VARCHAR(255) NOT NULL,
+    permission_name VARCHAR(255) NOT NULL,
+    PRIMARY KEY (role_name, permission_name),
+    FOREIGN KEY (role_name) REFERENCES Roles(role_name) ON DELETE CASCADE,
+    FOREIGN KEY (permission_name) REFERENCES Permissions(permission_name) ON DELETE CASCADE
+);
+
+CREATE TABLE UserRoles (
+    user_id UUID NOT NULL,
+    role_name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_name),
+    FOREIGN KEY (user_id) REFERENCES Users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (role_name) REFERENCES Roles(role_name) ON DELETE CASCADE
+);
+
+CREATE INDEX userroles_role_name_idx ON UserRoles (role_name);
+
+INSERT INTO Roles (role_name, description) VALUES
+    ('user', 'Default role of every registered user'),
+    ('admin', 'Administrators of the auth service');
+
+-- every existing user gets the default role, previously hard-coded in the tokens
+INSERT INTO UserRoles (user_id, role_name) SELECT user_id, 'user' FROM Users;
+
+-- +goose Down
+
+DROP TABLE UserRoles;
+DROP TABLE RolePermissions;
+DROP TABLE Permissions;
+DROP TABLE Roles;
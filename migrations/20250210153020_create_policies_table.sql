@@ -0,0 +1,18 @@
+-- +goose Up
+
+CREATE TABLE Policies (
+    policy_name VARCHAR(255) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    -- action the policy applies to, '*' applies to every action
+    action VARCHAR(255) NOT NULL,
+    effect VARCHAR(16) NOT NULL CHECK (effect IN ('allow', 'deny')),
+    -- CEL expression over subject, action and resource
+    expression TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+
+DROP TABLE Policies;
@@ -0,0 +1,24 @@
+-- +goose Up
+
+CREATE TABLE OAuthClients (
+    client_id VARCHAR(255) PRIMARY KEY,
+    -- SHA-256 of the client secret, secrets are random enough not to
+    -- need a slow password hash
+    client_secret_hash VARCHAR(64) NOT NULL,
+    client_name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- access tokens revoked before their expiry, identified by their jti claim.
+-- Rows are useless once the token has expired and are deleted by the janitor.
+CREATE TABLE RevokedAccessTokens (
+    token_id UUID PRIMARY KEY,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX revokedaccesstokens_expires_at_idx ON RevokedAccessTokens (expires_at);
+
+-- +goose Down
+
+DROP TABLE RevokedAccessTokens;
+DROP TABLE OAuthClients;
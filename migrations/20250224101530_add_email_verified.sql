@@ -0,0 +1,9 @@
+-- +goose Up
+
+-- the used email verifications are deleted by the janitor, the outcome
+-- of the verification is kept with the login data
+ALTER TABLE UsersLoginData ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+
+ALTER TABLE UsersLoginData DROP COLUMN email_verified;
@@ -0,0 +1,49 @@
+-- +goose Up
+
+-- public clients, such as mobile apps, cannot keep a secret: their
+-- secret hash is empty and they authenticate with PKCE only
+ALTER TABLE OAuthClients
+ADD COLUMN client_type VARCHAR(16) NOT NULL DEFAULT 'confidential' CHECK (client_type IN ('public', 'confidential')),
+ADD COLUMN redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+ADD COLUMN allowed_scopes TEXT[] NOT NULL DEFAULT '{}';
+
+-- sessions opened through an OAuth client are bound to it, NULL for the
+-- first-party apps logging in directly
+ALTER TABLE Sessions
+ADD COLUMN client_id VARCHAR(255) REFERENCES OAuthClients(client_id) ON DELETE CASCADE,
+ADD COLUMN scope TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE AuthorizationCodes (
+    -- SHA-256 of the code, the code itself is only known by the client
+    code_hash VARCHAR(64) PRIMARY KEY,
+    client_id VARCHAR(255) NOT NULL,
+    user_id UUID NOT NULL,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    nonce TEXT NOT NULL DEFAULT '',
+    -- PKCE S256 challenge
+    code_challenge VARCHAR(128) NOT NULL,
+    -- metadata of the browser the user authorized the client from,
+    -- recorded on the session opened when the code is exchanged
+    ip_address INET,
+    user_agent TEXT NOT NULL DEFAULT '',
+    auth_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (client_id) REFERENCES OAuthClients(client_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES Users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX authorizationcodes_expires_at_idx ON AuthorizationCodes (expires_at);
+
+-- +goose Down
+
+DROP TABLE AuthorizationCodes;
+
+ALTER TABLE Sessions
+DROP COLUMN scope,
+DROP COLUMN client_id;
+
+ALTER TABLE OAuthClients
+DROP COLUMN allowed_scopes,
+DROP COLUMN redirect_uris,
+DROP COLUMN client_type;
@@ -0,0 +1,30 @@
+-- +goose Up
+
+-- machine clients use the client_credentials grant to call other
+-- services, which are the audiences of their tokens. Clients with a
+-- public key authenticate with private_key_jwt instead of a secret.
+ALTER TABLE OAuthClients
+ADD COLUMN grant_types TEXT[] NOT NULL DEFAULT '{authorization_code,refresh_token}',
+ADD COLUMN allowed_audiences TEXT[] NOT NULL DEFAULT '{}',
+ADD COLUMN public_key TEXT NOT NULL DEFAULT '';
+
+-- IDs of the client assertions already used, rejected if replayed
+-- until they expire (RFC 7523 section 3)
+CREATE TABLE ClientAssertions (
+    client_id VARCHAR(255) NOT NULL,
+    assertion_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (client_id, assertion_id),
+    FOREIGN KEY (client_id) REFERENCES OAuthClients(client_id) ON DELETE CASCADE
+);
+
+CREATE INDEX clientassertions_expires_at_idx ON ClientAssertions (expires_at);
+
+-- +goose Down
+
+DROP TABLE ClientAssertions;
+
+ALTER TABLE OAuthClients
+DROP COLUMN public_key,
+DROP COLUMN allowed_audiences,
+DROP COLUMN grant_types;
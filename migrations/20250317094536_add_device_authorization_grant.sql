@@ -0,0 +1,35 @@
+-- +goose Up
+
+-- device authorization requests of the clients running on devices
+-- without a browser, such as CLIs (RFC 8628)
+CREATE TABLE DeviceAuthorizations (
+    -- SHA-256 of the device code, the code itself is only known by the
+    -- client polling the token endpoint
+    device_code_hash VARCHAR(64) PRIMARY KEY,
+    -- the code typed by the user, stored without separator
+    user_code VARCHAR(16) NOT NULL UNIQUE,
+    client_id VARCHAR(255) NOT NULL,
+    scope TEXT NOT NULL,
+    -- pending until the user approves or denies the request
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'denied')),
+    -- the user who approved the request, and when
+    user_id UUID,
+    auth_time TIMESTAMP WITH TIME ZONE,
+    -- minimum number of seconds between two polls of the client, raised
+    -- each time it polls too fast
+    polling_interval INTEGER NOT NULL,
+    last_polled_at TIMESTAMP WITH TIME ZONE,
+    -- metadata of the device, recorded on the session opened when the
+    -- tokens are issued
+    ip_address INET,
+    user_agent TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (client_id) REFERENCES OAuthClients(client_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES Users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX deviceauthorizations_expires_at_idx ON DeviceAuthorizations (expires_at);
+
+-- +goose Down
+
+DROP TABLE DeviceAuthorizations;
@@ -0,0 +1,64 @@
+-- +goose Up
+
+-- external login providers are OpenID Connect providers, discovered from
+-- their issuer. The slug identifies them in the URLs of the service,
+-- providers without client ID are not configured and cannot be used.
+ALTER TABLE ExternalLoginProviders
+ADD COLUMN slug VARCHAR(64) UNIQUE,
+ADD COLUMN issuer TEXT NOT NULL DEFAULT '',
+ADD COLUMN client_id TEXT NOT NULL DEFAULT '',
+ADD COLUMN client_secret TEXT NOT NULL DEFAULT '',
+ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{openid,email,profile}';
+
+UPDATE ExternalLoginProviders SET slug = 'google', issuer = 'https://accounts.google.com' WHERE provider_name = 'Google';
+UPDATE ExternalLoginProviders SET slug = lower(provider_name) WHERE slug IS NULL;
+
+ALTER TABLE ExternalLoginProviders
+ALTER COLUMN slug SET NOT NULL;
+
+-- users are identified by the subject of the ID tokens of the provider.
+-- Provider tokens are often longer than 255 characters.
+ALTER TABLE UserLoginExternal
+ADD COLUMN provider_subject VARCHAR(255) NOT NULL DEFAULT '',
+ALTER COLUMN provider_access_token TYPE TEXT,
+ALTER COLUMN provider_refresh_token TYPE TEXT;
+
+ALTER TABLE UserLoginExternal
+ALTER COLUMN provider_subject DROP DEFAULT,
+ADD UNIQUE (provider_id, provider_subject);
+
+-- pending authorization requests sent to external providers, the state
+-- being kept in a cookie of the browser which started the login
+CREATE TABLE ExternalLoginStates (
+    -- SHA-256 of the state
+    state_hash VARCHAR(64) PRIMARY KEY,
+    provider_id UUID NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    -- PKCE verifier of the authorization request
+    code_verifier VARCHAR(128) NOT NULL,
+    remember_me BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (provider_id) REFERENCES ExternalLoginProviders(provider_id) ON DELETE CASCADE
+);
+
+CREATE INDEX externalloginstates_expires_at_idx ON ExternalLoginStates (expires_at);
+
+INSERT INTO LoginMethod (loginMethod) VALUES ('EXTERNAL');
+
+-- +goose Down
+
+DELETE FROM LoginMethod WHERE loginMethod = 'EXTERNAL';
+
+DROP TABLE ExternalLoginStates;
+
+ALTER TABLE UserLoginExternal
+DROP COLUMN provider_subject,
+ALTER COLUMN provider_access_token TYPE VARCHAR(255),
+ALTER COLUMN provider_refresh_token TYPE VARCHAR(255);
+
+ALTER TABLE ExternalLoginProviders
+DROP COLUMN scopes,
+DROP COLUMN client_secret,
+DROP COLUMN client_id,
+DROP COLUMN issuer,
+DROP COLUMN slug;
@@ -0,0 +1,37 @@
+-- +goose Up
+
+-- an external identity is the subject of a provider, a user can link one
+-- identity of each provider to its account
+ALTER TABLE UserLoginExternal
+DROP CONSTRAINT userloginexternal_pkey,
+DROP CONSTRAINT userloginexternal_provider_id_provider_subject_key,
+ALTER COLUMN user_id SET NOT NULL,
+ADD COLUMN linked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+ALTER TABLE UserLoginExternal
+ADD PRIMARY KEY (provider_id, provider_subject),
+ADD UNIQUE (user_id, provider_id);
+
+-- the user linking an identity to its account, NULL when logging in
+ALTER TABLE ExternalLoginStates
+ADD COLUMN user_id UUID REFERENCES Users(user_id) ON DELETE CASCADE;
+
+-- +goose Down
+
+ALTER TABLE ExternalLoginStates
+DROP COLUMN user_id;
+
+-- only the first identity linked by each user can be kept
+DELETE FROM UserLoginExternal linked
+USING UserLoginExternal first
+WHERE linked.user_id = first.user_id
+AND (linked.linked_at, linked.provider_id) > (first.linked_at, first.provider_id);
+
+ALTER TABLE UserLoginExternal
+DROP CONSTRAINT userloginexternal_pkey,
+DROP CONSTRAINT userloginexternal_user_id_provider_id_key,
+DROP COLUMN linked_at;
+
+ALTER TABLE UserLoginExternal
+ADD PRIMARY KEY (user_id),
+ADD UNIQUE (provider_id, provider_subject);
@@ -0,0 +1,15 @@
+-- +goose Up
+
+-- the code verifiers are encrypted, their ciphertext being longer than
+-- the verifier itself
+ALTER TABLE ExternalLoginStates
+ALTER COLUMN code_verifier TYPE TEXT;
+
+-- +goose Down
+
+-- pending external logins only last minutes, their encrypted verifiers
+-- would not fit anymore
+DELETE FROM ExternalLoginStates;
+
+ALTER TABLE ExternalLoginStates
+ALTER COLUMN code_verifier TYPE VARCHAR(128);
@@ -0,0 +1,66 @@
+-- +goose Up
+
+-- organizations are the enterprise customers whose users log in through
+-- their own identity provider
+CREATE TABLE Organizations (
+    organization_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    slug VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- the SAML 2.0 identity provider of an organization. The attributes of
+-- the assertions named by the mapping set the names and email of users.
+CREATE TABLE SAMLIdentityProviders (
+    organization_id UUID PRIMARY KEY,
+    entity_id TEXT NOT NULL,
+    sso_url TEXT NOT NULL,
+    -- PEM encoded signing certificates, several during a rollover
+    certificates TEXT NOT NULL,
+    first_name_attribute TEXT NOT NULL DEFAULT 'urn:oid:2.5.4.42',
+    last_name_attribute TEXT NOT NULL DEFAULT 'urn:oid:2.5.4.4',
+    email_attribute TEXT NOT NULL DEFAULT 'urn:oid:0.9.2342.19200300.100.1.3',
+    -- users unknown to the service are created on their first login
+    jit_provisioning BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (organization_id) REFERENCES Organizations(organization_id) ON DELETE CASCADE
+);
+
+-- users are identified by the NameID of the assertions of their
+-- organization, the email being that asserted at their last login
+CREATE TABLE UserLoginSAML (
+    organization_id UUID NOT NULL,
+    name_id VARCHAR(255) NOT NULL,
+    user_id UUID NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    linked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, name_id),
+    UNIQUE (user_id, organization_id),
+    FOREIGN KEY (organization_id) REFERENCES Organizations(organization_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES Users(user_id) ON DELETE CASCADE
+);
+
+-- pending authentication requests, the responses of the identity
+-- providers must answer one of them from the browser which sent it
+CREATE TABLE SAMLRequests (
+    request_id VARCHAR(64) PRIMARY KEY,
+    organization_id UUID NOT NULL,
+    -- SHA-256 of the relay state, kept in a cookie of the browser
+    relay_state_hash VARCHAR(64) NOT NULL,
+    remember_me BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (organization_id) REFERENCES Organizations(organization_id) ON DELETE CASCADE
+);
+
+CREATE INDEX samlrequests_expires_at_idx ON SAMLRequests (expires_at);
+
+INSERT INTO LoginMethod (loginMethod) VALUES ('SAML');
+
+-- +goose Down
+
+DELETE FROM LoginMethod WHERE loginMethod = 'SAML';
+
+DROP TABLE SAMLRequests;
+DROP TABLE UserLoginSAML;
+DROP TABLE SAMLIdentityProviders;
+DROP TABLE Organizations;
@@ -0,0 +1,9 @@
+-- +goose Up
+
+-- accounts whose password is verified by an LDAP directory, no hash is
+-- stored for them
+INSERT INTO HashAlgorithm (hashAlgorithm) VALUES ('LDAP');
+
+-- +goose Down
+
+DELETE FROM HashAlgorithm WHERE hashAlgorithm = 'LDAP';
@@ -0,0 +1,8 @@
+-- +goose Up
+
+-- sessions opened by an operator for a user, without its credentials
+INSERT INTO LoginMethod (loginMethod) VALUES ('ADMIN');
+
+-- +goose Down
+
+DELETE FROM LoginMethod WHERE loginMethod = 'ADMIN';
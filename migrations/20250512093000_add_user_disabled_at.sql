@@ -0,0 +1,9 @@
+-- +goose Up
+
+-- disabled users cannot log in, their sessions are revoked when they are
+-- disabled
+ALTER TABLE Users ADD COLUMN disabled_at TIMESTAMP WITH TIME ZONE;
+
+-- +goose Down
+
+ALTER TABLE Users DROP COLUMN disabled_at;
@@ -0,0 +1,12 @@
+-- +goose Up
+
+-- the redirect URI may be omitted from the authorization request of the
+-- clients registering a single one, the token request then omits it too
+-- (RFC 6749 section 4.1.3)
+ALTER TABLE AuthorizationCodes
+ADD COLUMN redirect_uri_supplied BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- +goose Down
+
+ALTER TABLE AuthorizationCodes
+DROP COLUMN redirect_uri_supplied;
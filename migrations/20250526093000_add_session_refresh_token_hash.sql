@@ -0,0 +1,16 @@
+-- +goose Up
+
+-- the refresh tokens are opaque random values, the sessions only keep
+-- their SHA-256 hash. The sessions opened before cannot be refreshed
+-- anymore, their users log in again.
+ALTER TABLE Sessions
+ADD COLUMN refresh_token_hash TEXT;
+
+CREATE UNIQUE INDEX sessions_refresh_token_hash_idx ON Sessions (refresh_token_hash);
+
+-- +goose Down
+
+DROP INDEX sessions_refresh_token_hash_idx;
+
+ALTER TABLE Sessions
+DROP COLUMN refresh_token_hash;
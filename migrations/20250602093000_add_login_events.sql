@@ -0,0 +1,30 @@
+-- +goose Up
+
+-- every login attempt of a known user, successful or not, kept for the
+-- support staff looking into the accounts. The janitor deletes the
+-- events older than the configured retention.
+CREATE TABLE LoginEvents (
+    event_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    login_method VARCHAR(255) NOT NULL,
+    succeeded BOOLEAN NOT NULL,
+    failure_reason TEXT NOT NULL DEFAULT '',
+    ip_address INET,
+    user_agent TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (user_id) REFERENCES Users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (login_method) REFERENCES LoginMethod(loginMethod)
+);
+
+CREATE INDEX loginevents_user_id_occurred_at_idx ON LoginEvents (user_id, occurred_at);
+CREATE INDEX loginevents_occurred_at_idx ON LoginEvents (occurred_at);
+
+-- the password recoveries are the resets forced by an administrator,
+-- completed with their token whose SHA-256 hash is stored
+CREATE INDEX passwordrecovery_recovery_token_idx ON PasswordRecovery (recovery_token);
+
+-- +goose Down
+
+DROP INDEX passwordrecovery_recovery_token_idx;
+
+DROP TABLE LoginEvents;
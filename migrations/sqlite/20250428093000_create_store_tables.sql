@@ -0,0 +1,99 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The SQLite schema holds the tables of the Store interface. UUIDs are
+-- stored as text and timestamps as microseconds since the Unix epoch.
+CREATE TABLE Users (
+    user_id TEXT PRIMARY KEY,
+    first_name TEXT NOT NULL,
+    last_name TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    max_sessions INTEGER CHECK (max_sessions >= 0)
+);
+
+CREATE TABLE HashAlgorithm (
+    hashAlgorithm TEXT PRIMARY KEY
+);
+
+INSERT INTO HashAlgorithm (hashAlgorithm) VALUES ('BCRYPT'), ('LDAP');
+
+CREATE TABLE UsersLoginData (
+    user_id TEXT PRIMARY KEY REFERENCES Users(user_id) ON DELETE CASCADE,
+    email TEXT NOT NULL UNIQUE,
+    passwordHash TEXT NOT NULL,
+    passwordSalt TEXT NOT NULL,
+    hashAlgorithm TEXT NOT NULL REFERENCES HashAlgorithm(hashAlgorithm),
+    email_verified INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE Roles (
+    role_name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    max_sessions INTEGER CHECK (max_sessions >= 0),
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE Permissions (
+    permission_name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE RolePermissions (
+    role_name TEXT NOT NULL REFERENCES Roles(role_name) ON DELETE CASCADE,
+    permission_name TEXT NOT NULL REFERENCES Permissions(permission_name) ON DELETE CASCADE,
+    PRIMARY KEY (role_name, permission_name)
+);
+
+CREATE TABLE UserRoles (
+    user_id TEXT NOT NULL REFERENCES Users(user_id) ON DELETE CASCADE,
+    role_name TEXT NOT NULL REFERENCES Roles(role_name) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (user_id, role_name)
+);
+
+CREATE INDEX userroles_role_name_idx ON UserRoles (role_name);
+
+INSERT INTO Roles (role_name, description, created_at) VALUES
+    ('user', 'Default role of every registered user', CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    ('admin', 'Administrators of the auth service', CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+
+CREATE TABLE Sessions (
+    session_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES Users(user_id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    ip_address TEXT,
+    user_agent TEXT NOT NULL DEFAULT '',
+    device TEXT NOT NULL DEFAULT '',
+    login_method TEXT NOT NULL,
+    absolute_expires_at INTEGER NOT NULL,
+    remember_me INTEGER NOT NULL DEFAULT 0,
+    client_id TEXT,
+    scope TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX sessions_user_id_idx ON Sessions (user_id);
+CREATE INDEX sessions_expires_at_idx ON Sessions (expires_at);
+
+CREATE TABLE RevokedAccessTokens (
+    token_id TEXT PRIMARY KEY,
+    expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX revokedaccesstokens_expires_at_idx ON RevokedAccessTokens (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE RevokedAccessTokens;
+DROP TABLE Sessions;
+DROP TABLE UserRoles;
+DROP TABLE RolePermissions;
+DROP TABLE Permissions;
+DROP TABLE Roles;
+DROP TABLE UsersLoginData;
+DROP TABLE HashAlgorithm;
+DROP TABLE Users;
+-- +goose StatementEnd
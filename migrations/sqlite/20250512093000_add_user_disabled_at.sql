@@ -0,0 +1,8 @@
+-- +goose Up
+
+-- disabled users cannot log in
+ALTER TABLE Users ADD COLUMN disabled_at INTEGER;
+
+-- +goose Down
+
+ALTER TABLE Users DROP COLUMN disabled_at;
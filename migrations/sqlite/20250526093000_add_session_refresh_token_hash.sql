@@ -0,0 +1,13 @@
+-- +goose Up
+
+-- the refresh tokens are opaque random values, the sessions only keep
+-- their SHA-256 hash
+ALTER TABLE Sessions ADD COLUMN refresh_token_hash TEXT;
+
+CREATE UNIQUE INDEX sessions_refresh_token_hash_idx ON Sessions (refresh_token_hash);
+
+-- +goose Down
+
+DROP INDEX sessions_refresh_token_hash_idx;
+
+ALTER TABLE Sessions DROP COLUMN refresh_token_hash;
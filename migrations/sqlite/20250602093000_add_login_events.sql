@@ -0,0 +1,34 @@
+-- +goose Up
+
+-- every login attempt of a known user, successful or not, deleted by
+-- the janitor past the configured retention
+CREATE TABLE LoginEvents (
+    event_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES Users(user_id) ON DELETE CASCADE,
+    occurred_at INTEGER NOT NULL,
+    login_method TEXT NOT NULL,
+    succeeded INTEGER NOT NULL,
+    failure_reason TEXT NOT NULL DEFAULT '',
+    ip_address TEXT,
+    user_agent TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX loginevents_user_id_occurred_at_idx ON LoginEvents (user_id, occurred_at);
+CREATE INDEX loginevents_occurred_at_idx ON LoginEvents (occurred_at);
+
+-- the password resets forced by an administrator, completed with their
+-- token whose SHA-256 hash is stored
+CREATE TABLE PasswordRecovery (
+    user_id TEXT PRIMARY KEY REFERENCES Users(user_id) ON DELETE CASCADE,
+    recovery_token TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    used INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX passwordrecovery_recovery_token_idx ON PasswordRecovery (recovery_token);
+
+-- +goose Down
+
+DROP TABLE PasswordRecovery;
+
+DROP TABLE LoginEvents;
@@ -0,0 +1,67 @@
+package betalinkauth_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/pressly/goose/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	db, err := betalinkauth.OpenSQLite(filepath.Join(t.TempDir(), "betalink-auth.db"))
+	require.NoError(t, err)
+	migrations, err := betalinkauth.NewSQLiteMigrations(db)
+	require.NoError(t, err)
+	t.Cleanup(func() { migrations.Close() })
+
+	sources := migrations.ListSources()
+	require.NotEmpty(t, sources)
+	for _, source := range sources {
+		require.Equal(t, goose.TypeSQL, source.Type)
+	}
+
+	t.Run("up applies every migration", func(t *testing.T) {
+		results, err := migrations.Up(ctx)
+		require.NoError(t, err)
+		require.Len(t, results, len(sources))
+
+		statuses, err := migrations.Status(ctx)
+		require.NoError(t, err)
+		for _, status := range statuses {
+			require.Equal(t, goose.StateApplied, status.State, status.Source.Path)
+		}
+		pending, err := migrations.HasPending(ctx)
+		require.NoError(t, err)
+		require.False(t, pending)
+	})
+
+	t.Run("up is a no-op once applied", func(t *testing.T) {
+		results, err := migrations.Up(ctx)
+		require.NoError(t, err)
+		require.Empty(t, results)
+	})
+
+	t.Run("down rolls back every migration", func(t *testing.T) {
+		results, err := migrations.DownTo(ctx, 0)
+		require.NoError(t, err)
+		require.Len(t, results, len(sources))
+
+		version, err := migrations.GetDBVersion(ctx)
+		require.NoError(t, err)
+		require.Zero(t, version)
+		var tables int
+		require.NoError(t, db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'Users'`).Scan(&tables))
+		require.Zero(t, tables)
+	})
+
+	t.Run("up applies them again", func(t *testing.T) {
+		results, err := migrations.Up(ctx)
+		require.NoError(t, err)
+		require.Len(t, results, len(sources))
+		testStore(t, betalinkauth.NewSQLiteStore(db))
+	})
+}
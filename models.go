@@ -5,9 +5,47 @@
 package betalinkauth
 
 import (
+	"net/netip"
+
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Authorizationcode struct {
+	CodeHash            string
+	ClientID            string
+	UserID              pgtype.UUID
+	RedirectUri         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	IpAddress           *netip.Addr
+	UserAgent           string
+	AuthTime            pgtype.Timestamptz
+	ExpiresAt           pgtype.Timestamptz
+	RedirectUriSupplied bool
+}
+
+type Clientassertion struct {
+	ClientID    string
+	AssertionID string
+	ExpiresAt   pgtype.Timestamptz
+}
+
+type Deviceauthorization struct {
+	DeviceCodeHash  string
+	UserCode        string
+	ClientID        string
+	Scope           string
+	Status          string
+	UserID          pgtype.UUID
+	AuthTime        pgtype.Timestamptz
+	PollingInterval int32
+	LastPolledAt    pgtype.Timestamptz
+	IpAddress       *netip.Addr
+	UserAgent       string
+	ExpiresAt       pgtype.Timestamptz
+}
+
 type Emailverification struct {
 	UserID            pgtype.UUID
 	VerificationToken string
@@ -19,12 +57,62 @@ type Externalloginprovider struct {
 	ProviderID       pgtype.UUID
 	ProviderName     string
 	ProviderEndpoint string
+	Slug             string
+	Issuer           string
+	ClientID         string
+	ClientSecret     string
+	Scopes           []string
+}
+
+type Externalloginstate struct {
+	StateHash    string
+	ProviderID   pgtype.UUID
+	Nonce        string
+	CodeVerifier string
+	RememberMe   bool
+	ExpiresAt    pgtype.Timestamptz
+	UserID       pgtype.UUID
 }
 
 type Hashalgorithm struct {
 	Hashalgorithm string
 }
 
+type Loginevent struct {
+	EventID       pgtype.UUID
+	UserID        pgtype.UUID
+	OccurredAt    pgtype.Timestamptz
+	LoginMethod   string
+	Succeeded     bool
+	FailureReason string
+	IpAddress     *netip.Addr
+	UserAgent     string
+}
+
+type Loginmethod struct {
+	Loginmethod string
+}
+
+type Oauthclient struct {
+	ClientID         string
+	ClientSecretHash string
+	ClientName       string
+	CreatedAt        pgtype.Timestamptz
+	ClientType       string
+	RedirectUris     []string
+	AllowedScopes    []string
+	GrantTypes       []string
+	AllowedAudiences []string
+	PublicKey        string
+}
+
+type Organization struct {
+	OrganizationID pgtype.UUID
+	Slug           string
+	Name           string
+	CreatedAt      pgtype.Timestamptz
+}
+
 type Passwordrecovery struct {
 	UserID        pgtype.UUID
 	RecoveryToken string
@@ -32,20 +120,85 @@ type Passwordrecovery struct {
 	Used          bool
 }
 
-type Session struct {
-	SessionID pgtype.UUID
-	UserID    pgtype.UUID
-	CreatedAt pgtype.Timestamptz
-	UpdatedAt pgtype.Timestamptz
+type Permission struct {
+	PermissionName string
+	Description    string
+	CreatedAt      pgtype.Timestamptz
+}
+
+type Policy struct {
+	PolicyName  string
+	Description string
+	Action      string
+	Effect      string
+	Expression  string
+	Enabled     bool
+	CreatedAt   pgtype.Timestamptz
+	UpdatedAt   pgtype.Timestamptz
+}
+
+type Revokedaccesstoken struct {
+	TokenID   pgtype.UUID
 	ExpiresAt pgtype.Timestamptz
 }
 
+type Role struct {
+	RoleName    string
+	Description string
+	MaxSessions pgtype.Int4
+	CreatedAt   pgtype.Timestamptz
+}
+
+type Rolepermission struct {
+	RoleName       string
+	PermissionName string
+}
+
+type Samlidentityprovider struct {
+	OrganizationID     pgtype.UUID
+	EntityID           string
+	SsoUrl             string
+	Certificates       string
+	FirstNameAttribute string
+	LastNameAttribute  string
+	EmailAttribute     string
+	JitProvisioning    bool
+	UpdatedAt          pgtype.Timestamptz
+}
+
+type Samlrequest struct {
+	RequestID      string
+	OrganizationID pgtype.UUID
+	RelayStateHash string
+	RememberMe     bool
+	ExpiresAt      pgtype.Timestamptz
+}
+
+type Session struct {
+	SessionID         pgtype.UUID
+	UserID            pgtype.UUID
+	CreatedAt         pgtype.Timestamptz
+	UpdatedAt         pgtype.Timestamptz
+	ExpiresAt         pgtype.Timestamptz
+	IpAddress         *netip.Addr
+	UserAgent         string
+	Device            string
+	LoginMethod       string
+	AbsoluteExpiresAt pgtype.Timestamptz
+	RememberMe        bool
+	ClientID          pgtype.Text
+	Scope             string
+	RefreshTokenHash  pgtype.Text
+}
+
 type User struct {
-	UserID    pgtype.UUID
-	FirstName string
-	LastName  string
-	CreatedAt pgtype.Timestamp
-	UpdatedAt pgtype.Timestamp
+	UserID      pgtype.UUID
+	FirstName   string
+	LastName    string
+	CreatedAt   pgtype.Timestamp
+	UpdatedAt   pgtype.Timestamp
+	MaxSessions pgtype.Int4
+	DisabledAt  pgtype.Timestamptz
 }
 
 type Userloginexternal struct {
@@ -53,6 +206,22 @@ type Userloginexternal struct {
 	ProviderID           pgtype.UUID
 	ProviderAccessToken  string
 	ProviderRefreshToken string
+	ProviderSubject      string
+	LinkedAt             pgtype.Timestamptz
+}
+
+type Userloginsaml struct {
+	OrganizationID pgtype.UUID
+	NameID         string
+	UserID         pgtype.UUID
+	Email          string
+	LinkedAt       pgtype.Timestamptz
+}
+
+type Userrole struct {
+	UserID    pgtype.UUID
+	RoleName  string
+	CreatedAt pgtype.Timestamptz
 }
 
 type Userslogindatum struct {
@@ -61,4 +230,5 @@ type Userslogindatum struct {
 	Passwordhash  string
 	Passwordsalt  string
 	Hashalgorithm string
+	EmailVerified bool
 }
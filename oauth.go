@@ -0,0 +1,649 @@
+package betalinkauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BragdonD/betalink-auth/internal/claim"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// TokenTypeHintAccessToken hints that a token being introspected or
+	// revoked is an access token (RFC 7009)
+	TokenTypeHintAccessToken = "access_token"
+	// TokenTypeHintRefreshToken hints that a token being introspected or
+	// revoked is a refresh token (RFC 7009)
+	TokenTypeHintRefreshToken = "refresh_token"
+
+	// clientSecretSize is the size in bytes of generated client secrets
+	clientSecretSize = 32
+	// clientIDSize is the size in bytes of generated client IDs
+	clientIDSize = 16
+
+	// ClientTypeConfidential is the type of the clients able to keep
+	// a secret, such as server-side apps (RFC 6749 section 2.1)
+	ClientTypeConfidential = "confidential"
+	// ClientTypePublic is the type of the clients unable to keep a
+	// secret, such as mobile and single-page apps, which authenticate
+	// with PKCE only
+	ClientTypePublic = "public"
+)
+
+// InvalidClientError is an error that represents a client which could
+// not be authenticated
+var InvalidClientError = &ValidationError{
+	Message: "Invalid client credentials",
+}
+
+// ClientData is an OAuth client of the auth service
+type ClientData struct {
+	ClientID     string   `json:"client_id"`
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	// Audiences are the services the client may get tokens for with
+	// the client credentials grant
+	Audiences []string `json:"audiences"`
+	// PublicKey is the PEM encoded RSA public key of the clients
+	// authenticating with private_key_jwt
+	PublicKey string    `json:"public_key,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ClientRegistration describes an OAuth client to register
+type ClientRegistration struct {
+	Name string
+	// Type is ClientTypeConfidential, the default, or ClientTypePublic
+	Type string
+	// RedirectURIs are the URIs the authorization responses may be sent
+	// to, matched exactly
+	RedirectURIs []string
+	// Scopes are the scopes the client may request, every supported
+	// scope by default. Machine clients may also request the scopes of
+	// the services they call, such as orders:read.
+	Scopes []string
+	// GrantTypes are the grants the client may use, the authorization
+	// code and refresh token grants by default
+	GrantTypes []string
+	// Audiences are the services a machine client may get tokens for,
+	// required with the client credentials grant
+	Audiences []string
+	// PublicKey is the PEM encoded RSA public key of a confidential
+	// client authenticating with private_key_jwt instead of a secret
+	PublicKey string
+}
+
+// ClientCredentials are the credentials of a new OAuth client. The
+// secret is only known when the client is created, public clients
+// have none.
+type ClientCredentials struct {
+	ClientData
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// Introspection is the state of a token, as returned by the token
+// introspection endpoint (RFC 7662)
+type Introspection struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	TokenID   string `json:"jti,omitempty"`
+	// ClientID and Scope are those of the tokens issued to an OAuth
+	// client
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// Roles and Permissions are those carried by an access token
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// CreateClient registers a new OAuth client and returns its credentials
+func (u *Usecases) CreateClient(ctx context.Context, registration ClientRegistration) (*ClientCredentials, error) {
+	queries, err := u.postgresQueries("OAuth clients")
+	if err != nil {
+		return nil, err
+	}
+	if err := validateClientRegistration(&registration); err != nil {
+		return nil, err
+	}
+
+	clientID, err := randomString(clientIDSize)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not generate client ID: %w", err).Error(),
+		}
+	}
+	// public clients cannot keep a secret and clients authenticating
+	// with private_key_jwt do not need one, they are given none
+	var clientSecret, clientSecretHash string
+	if registration.Type == ClientTypeConfidential && registration.PublicKey == "" {
+		clientSecret, err = randomString(clientSecretSize)
+		if err != nil {
+			return nil, &ServerError{
+				Message: fmt.Errorf("could not generate client secret: %w", err).Error(),
+			}
+		}
+		clientSecretHash = hashSecret(clientSecret)
+	}
+
+	err = queries.CreateOAuthClient(ctx, CreateOAuthClientParams{
+		ClientID:         clientID,
+		ClientSecretHash: clientSecretHash,
+		ClientName:       registration.Name,
+		ClientType:       registration.Type,
+		RedirectUris:     registration.RedirectURIs,
+		AllowedScopes:    registration.Scopes,
+		GrantTypes:       registration.GrantTypes,
+		AllowedAudiences: registration.Audiences,
+		PublicKey:        registration.PublicKey,
+	})
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not create client: %w", err).Error(),
+		}
+	}
+	return &ClientCredentials{
+		ClientData: ClientData{
+			ClientID:     clientID,
+			Name:         registration.Name,
+			Type:         registration.Type,
+			RedirectURIs: registration.RedirectURIs,
+			Scopes:       registration.Scopes,
+			GrantTypes:   registration.GrantTypes,
+			Audiences:    registration.Audiences,
+			PublicKey:    registration.PublicKey,
+			CreatedAt:    time.Now(),
+		},
+		ClientSecret: clientSecret,
+	}, nil
+}
+
+// validateClientRegistration validates a client registration and fills
+// in its default values
+func validateClientRegistration(registration *ClientRegistration) error {
+	if registration.Name == "" {
+		return &ValidationError{Message: "client name is required"}
+	}
+	if registration.Type == "" {
+		registration.Type = ClientTypeConfidential
+	}
+	if registration.Type != ClientTypeConfidential && registration.Type != ClientTypePublic {
+		return &ValidationError{
+			Message: fmt.Sprintf("client type [%s] is not supported", registration.Type),
+		}
+	}
+
+	if registration.GrantTypes == nil {
+		registration.GrantTypes = []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken}
+	}
+	for _, grantType := range registration.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, grantType) {
+			return &ValidationError{
+				Message: fmt.Sprintf("grant type [%s] is not supported", grantType),
+			}
+		}
+	}
+	machine := slices.Contains(registration.GrantTypes, GrantTypeClientCredentials)
+	if machine && registration.Type == ClientTypePublic {
+		return &ValidationError{Message: "public clients cannot use the client credentials grant"}
+	}
+
+	if registration.RedirectURIs == nil {
+		registration.RedirectURIs = []string{}
+	}
+	for _, redirectURI := range registration.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return err
+		}
+	}
+
+	if registration.Scopes == nil {
+		registration.Scopes = []string{}
+		if slices.Contains(registration.GrantTypes, GrantTypeAuthorizationCode) ||
+			slices.Contains(registration.GrantTypes, GrantTypeDeviceCode) {
+			registration.Scopes = supportedScopes
+		}
+	}
+	for _, scope := range registration.Scopes {
+		// the scopes of other services can only be granted to machine
+		// clients, the user facing ones are those of the auth service
+		if !slices.Contains(supportedScopes, scope) && !(machine && validScopeToken(scope)) {
+			return &ValidationError{
+				Message: fmt.Sprintf("scope [%s] is not supported", scope),
+			}
+		}
+	}
+
+	if registration.Audiences == nil {
+		registration.Audiences = []string{}
+	}
+	if machine && len(registration.Audiences) == 0 {
+		return &ValidationError{Message: "machine clients require at least one audience"}
+	}
+	for _, audience := range registration.Audiences {
+		if audience == tokenAudience || !validScopeToken(audience) {
+			return &ValidationError{
+				Message: fmt.Sprintf("audience [%s] is not allowed", audience),
+			}
+		}
+	}
+
+	if registration.PublicKey != "" {
+		if registration.Type == ClientTypePublic {
+			return &ValidationError{Message: "public clients cannot authenticate with a public key"}
+		}
+		if _, err := parseClientPublicKey(registration.PublicKey); err != nil {
+			return &ValidationError{
+				Message: fmt.Errorf("invalid public key: %w", err).Error(),
+			}
+		}
+	}
+	return nil
+}
+
+// ListClients returns the OAuth clients
+func (u *Usecases) ListClients(ctx context.Context) ([]ClientData, error) {
+	queries, err := u.postgresQueries("OAuth clients")
+	if err != nil {
+		return nil, err
+	}
+	clients, err := queries.ListOAuthClients(ctx)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list clients: %w", err).Error(),
+		}
+	}
+	data := make([]ClientData, 0, len(clients))
+	for _, client := range clients {
+		data = append(data, clientData(client))
+	}
+	return data, nil
+}
+
+// DeleteClient deletes an OAuth client
+func (u *Usecases) DeleteClient(ctx context.Context, clientID string) error {
+	queries, err := u.postgresQueries("OAuth clients")
+	if err != nil {
+		return err
+	}
+	deleted, err := queries.DeleteOAuthClient(ctx, clientID)
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not delete client: %w", err).Error(),
+		}
+	}
+	if deleted == 0 {
+		return &NotFoundError{Message: fmt.Sprintf("client [%s] not found", clientID)}
+	}
+	return nil
+}
+
+// AuthenticateClient checks the credentials of an OAuth client
+func (u *Usecases) AuthenticateClient(ctx context.Context, clientID, clientSecret string) (*ClientData, error) {
+	queries, err := u.postgresQueries("OAuth clients")
+	if err != nil {
+		return nil, err
+	}
+	if clientID == "" || clientSecret == "" {
+		return nil, InvalidClientError
+	}
+	client, err := queries.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, InvalidClientError
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not get client: %w", err).Error(),
+		}
+	}
+	// public clients have no secret hash, no secret matches it
+	secretHash := hashSecret(clientSecret)
+	if subtle.ConstantTimeCompare([]byte(secretHash), []byte(client.ClientSecretHash)) != 1 {
+		return nil, InvalidClientError
+	}
+	data := clientData(client)
+	return &data, nil
+}
+
+// IdentifyClient identifies the OAuth client of a token request.
+// Confidential clients authenticate with their secret, public clients
+// only send their client ID.
+func (u *Usecases) IdentifyClient(ctx context.Context, clientID, clientSecret string) (*ClientData, error) {
+	if clientSecret != "" {
+		return u.AuthenticateClient(ctx, clientID, clientSecret)
+	}
+	client, err := u.getClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.Type != ClientTypePublic {
+		return nil, InvalidClientError
+	}
+	return client, nil
+}
+
+// getClient returns an OAuth client, or InvalidClientError if it does
+// not exist
+func (u *Usecases) getClient(ctx context.Context, clientID string) (*ClientData, error) {
+	queries, err := u.postgresQueries("OAuth clients")
+	if err != nil {
+		return nil, err
+	}
+	if clientID == "" {
+		return nil, InvalidClientError
+	}
+	client, err := queries.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, InvalidClientError
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not get client: %w", err).Error(),
+		}
+	}
+	data := clientData(client)
+	return &data, nil
+}
+
+// IntrospectToken returns the state of an access or refresh token. The
+// hint, if any, tells which kind of token is tried first. Invalid,
+// expired and revoked tokens are inactive.
+func (u *Usecases) IntrospectToken(ctx context.Context, token, hint string) (*Introspection, error) {
+	for _, introspect := range tokenHandlers(hint, u.introspectAccessToken, u.introspectRefreshToken) {
+		introspection, err := introspect(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if introspection != nil {
+			return introspection, nil
+		}
+	}
+	return &Introspection{Active: false}, nil
+}
+
+// RevokeToken revokes an access or refresh token. Revoking a refresh
+// token ends its session, which also revokes the access tokens issued
+// for it. The hint, if any, tells which kind of token is tried first.
+// Revoking an invalid or already revoked token is not an error.
+//
+// A client may only revoke the tokens issued to it (RFC 7009 section
+// 2.1), a nil client revokes the token whoever it was issued to, for the
+// operators of the auth service.
+func (u *Usecases) RevokeToken(ctx context.Context, client *ClientData, token, hint string) error {
+	for _, revoke := range tokenHandlers(hint, u.revokeAccessToken, u.revokeRefreshToken) {
+		revoked, err := revoke(ctx, client, token)
+		if err != nil || revoked {
+			return err
+		}
+	}
+	return nil
+}
+
+// tokenNotIssuedToClient is returned when a client revokes a token
+// issued to another client, or to a first-party app
+var tokenNotIssuedToClient = &OAuthError{
+	Code:        OAuthErrorUnauthorizedClient,
+	Description: "token was not issued to this client",
+}
+
+// tokenHandlers orders the access and refresh token handlers according
+// to the token type hint. Unknown hints are ignored (RFC 7009).
+func tokenHandlers[T any](hint string, accessHandler, refreshHandler T) []T {
+	if hint == TokenTypeHintRefreshToken {
+		return []T{refreshHandler, accessHandler}
+	}
+	return []T{accessHandler, refreshHandler}
+}
+
+// introspectAccessToken returns the state of an access token, or nil
+// if token is not an access token
+func (u *Usecases) introspectAccessToken(ctx context.Context, token string) (*Introspection, error) {
+	claims, err := u.keys.ParseAccessToken(token)
+	if err != nil {
+		return nil, nil
+	}
+	if err := u.checkAccessTokenRevocation(ctx, claims); err != nil {
+		if err == RevokedTokenError {
+			return &Introspection{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	introspection := &Introspection{
+		Active:      true,
+		TokenType:   "Bearer",
+		Issuer:      tokenIssuer,
+		Audience:    tokenAudience,
+		Roles:       claim.Strings(claims, "roles"),
+		Permissions: claim.Strings(claims, "permissions"),
+	}
+	introspection.Subject, _ = claims["user_id"].(string)
+	if isClientToken(claims) {
+		// the tokens of machine clients are issued for other services
+		introspection.Subject, _ = claims["sub"].(string)
+		introspection.Audience, _ = claims["aud"].(string)
+	}
+	introspection.TokenID, _ = claims["jti"].(string)
+	introspection.ClientID, _ = claims["client_id"].(string)
+	introspection.Scope, _ = claims["scope"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		introspection.ExpiresAt = exp.Unix()
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		introspection.IssuedAt = iat.Unix()
+	}
+	return introspection, nil
+}
+
+// introspectRefreshToken returns the state of a refresh token, or nil
+// if token is not a refresh token
+func (u *Usecases) introspectRefreshToken(ctx context.Context, token string) (*Introspection, error) {
+	if !isRefreshToken(token) {
+		return nil, nil
+	}
+	session, err := u.getRefreshTokenSession(ctx, token)
+	if err == RevokedTokenError {
+		return &Introspection{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if session.ExpiresAt.Time.Before(now) || session.AbsoluteExpiresAt.Time.Before(now) {
+		return &Introspection{Active: false}, nil
+	}
+	return &Introspection{
+		Active:    true,
+		TokenType: TokenTypeHintRefreshToken,
+		Subject:   session.UserID.String(),
+		ExpiresAt: session.AbsoluteExpiresAt.Time.Unix(),
+		IssuedAt:  session.CreatedAt.Time.Unix(),
+		Issuer:    tokenIssuer,
+		Audience:  tokenAudience,
+		ClientID:  session.ClientID.String,
+		Scope:     session.Scope,
+	}, nil
+}
+
+// revokeAccessToken revokes an access token until it expires, and
+// returns false if token is not an access token
+func (u *Usecases) revokeAccessToken(ctx context.Context, client *ClientData, token string) (bool, error) {
+	claims, err := u.keys.ParseAccessToken(token)
+	if err != nil {
+		return false, nil
+	}
+	if clientID, _ := claims["client_id"].(string); client != nil && clientID != client.ClientID {
+		return false, tokenNotIssuedToClient
+	}
+	tokenID, ok := uuidClaim(claims, "jti")
+	if !ok {
+		// tokens issued without an ID expire shortly, they cannot be
+		// revoked one by one
+		return true, nil
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return true, nil
+	}
+	err = u.store.RevokeAccessToken(ctx, RevokeAccessTokenParams{
+		TokenID: tokenID,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  expiresAt.Time,
+			Valid: true,
+		},
+	})
+	if err != nil {
+		return false, &ServerError{
+			Message: fmt.Errorf("could not revoke access token: %w", err).Error(),
+		}
+	}
+	return true, nil
+}
+
+// revokeRefreshToken ends the session of a refresh token, and returns
+// false if token is not a refresh token
+func (u *Usecases) revokeRefreshToken(ctx context.Context, client *ClientData, token string) (bool, error) {
+	if !isRefreshToken(token) {
+		return false, nil
+	}
+	session, err := u.getRefreshTokenSession(ctx, token)
+	if err == RevokedTokenError {
+		// the session has already ended
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if client != nil && session.ClientID.String != client.ClientID {
+		return false, tokenNotIssuedToClient
+	}
+	if err := u.store.DeleteSession(ctx, session.SessionID); err != nil {
+		return false, &ServerError{
+			Message: fmt.Errorf("could not delete session: %w", err).Error(),
+		}
+	}
+	return true, nil
+}
+
+// checkAccessTokenRevocation returns RevokedTokenError if the access
+// token has been revoked or its session has ended
+func (u *Usecases) checkAccessTokenRevocation(ctx context.Context, claims jwt.MapClaims) error {
+	if tokenID, ok := uuidClaim(claims, "jti"); ok {
+		revoked, err := u.store.IsAccessTokenRevoked(ctx, tokenID)
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not check access token revocation: %w", err).Error(),
+			}
+		}
+		if revoked {
+			return RevokedTokenError
+		}
+	}
+	if sessionID, ok := uuidClaim(claims, "sid"); ok {
+		exists, err := u.store.SessionExists(ctx, sessionID)
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not check session: %w", err).Error(),
+			}
+		}
+		if !exists {
+			return RevokedTokenError
+		}
+	}
+	return nil
+}
+
+// clientData returns the data of an OAuth client
+func clientData(client Oauthclient) ClientData {
+	return ClientData{
+		ClientID:     client.ClientID,
+		Name:         client.ClientName,
+		Type:         client.ClientType,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.AllowedScopes,
+		GrantTypes:   client.GrantTypes,
+		Audiences:    client.AllowedAudiences,
+		PublicKey:    client.PublicKey,
+		CreatedAt:    client.CreatedAt.Time,
+	}
+}
+
+// validateRedirectURI checks that a redirect URI can be registered. It
+// must be absolute and without fragment (RFC 6749 section 3.1.2), and
+// use https unless it is a loopback or private-use URI (RFC 8252).
+func validateRedirectURI(redirectURI string) error {
+	parsedURI, err := url.Parse(redirectURI)
+	if err != nil || !parsedURI.IsAbs() || parsedURI.Fragment != "" {
+		return &ValidationError{
+			Message: fmt.Sprintf("redirect URI [%s] must be an absolute URI without fragment", redirectURI),
+		}
+	}
+	switch parsedURI.Scheme {
+	case "https":
+		if parsedURI.Host != "" {
+			return nil
+		}
+	case "http":
+		switch parsedURI.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+			return nil
+		}
+	default:
+		// private-use schemes of native apps are reverse domain names
+		if strings.Contains(parsedURI.Scheme, ".") {
+			return nil
+		}
+	}
+	return &ValidationError{
+		Message: fmt.Sprintf("redirect URI [%s] must use https", redirectURI),
+	}
+}
+
+// uuidClaim returns a claim holding a UUID, such as a token ID
+func uuidClaim(claims jwt.MapClaims, name string) (pgtype.UUID, bool) {
+	value, ok := claims[name].(string)
+	if !ok {
+		return pgtype.UUID{}, false
+	}
+	parsedUUID, err := uuid.Parse(value)
+	if err != nil {
+		return pgtype.UUID{}, false
+	}
+	return pgtype.UUID{Bytes: parsedUUID, Valid: true}, true
+}
+
+// hashSecret returns the hex encoded SHA-256 of a secret, such as a
+// client secret or an authorization code
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// randomString returns size random bytes, base64url encoded
+func randomString(size int) (string, error) {
+	bytes := make([]byte, size)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,183 @@
+package betalinkauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createClientDto is the data transfer object for creating an OAuth client
+type createClientDto struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	Audiences    []string `json:"audiences"`
+	PublicKey    string   `json:"public_key"`
+}
+
+// introspectToken handles the token introspection request of an
+// OAuth client (RFC 7662)
+func (r *Router) introspectToken(ctx *gin.Context) {
+	if _, ok := r.authenticateClient(ctx); !ok {
+		return
+	}
+	token := ctx.PostForm("token")
+	if token == "" {
+		writeOAuthError(ctx, http.StatusBadRequest, OAuthErrorInvalidRequest, "token is required")
+		return
+	}
+
+	introspection, err := r.usecases.IntrospectToken(ctx, token, ctx.PostForm("token_type_hint"))
+	if err != nil {
+		r.logger.Error(fmt.Errorf("could not introspect token: %w", err))
+		writeOAuthError(ctx, http.StatusInternalServerError, OAuthErrorServerError, "could not introspect token")
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, introspection)
+}
+
+// revokeToken handles the token revocation request of an OAuth
+// client (RFC 7009)
+func (r *Router) revokeToken(ctx *gin.Context) {
+	client, ok := r.authenticateClient(ctx)
+	if !ok {
+		return
+	}
+	token := ctx.PostForm("token")
+	if token == "" {
+		writeOAuthError(ctx, http.StatusBadRequest, OAuthErrorInvalidRequest, "token is required")
+		return
+	}
+
+	if err := r.usecases.RevokeToken(ctx, client, token, ctx.PostForm("token_type_hint")); err != nil {
+		if oauthErr, ok := err.(*OAuthError); ok {
+			writeOAuthError(ctx, http.StatusBadRequest, oauthErr.Code, oauthErr.Description)
+			return
+		}
+		r.logger.Error(fmt.Errorf("could not revoke token: %w", err))
+		writeOAuthError(ctx, http.StatusInternalServerError, OAuthErrorServerError, "could not revoke token")
+		return
+	}
+	// invalid tokens are not an error, the client has nothing to do
+	ctx.Status(http.StatusOK)
+}
+
+// authenticateClient authenticates the OAuth client of a request with
+// the HTTP Basic scheme, the client_id and client_secret form parameters
+// or a client assertion. The error response is written if it fails.
+func (r *Router) authenticateClient(ctx *gin.Context) (*ClientData, bool) {
+	return r.checkClient(ctx, r.usecases.AuthenticateClient)
+}
+
+// identifyClient identifies the OAuth client of a token request like
+// authenticateClient, public clients only sending their client ID
+func (r *Router) identifyClient(ctx *gin.Context) (*ClientData, bool) {
+	return r.checkClient(ctx, r.usecases.IdentifyClient)
+}
+
+// checkClient checks the OAuth client credentials of a request with the
+// given usecase. The error response is written if it fails.
+func (r *Router) checkClient(
+	ctx *gin.Context,
+	check func(ctx context.Context, clientID, clientSecret string) (*ClientData, error),
+) (*ClientData, bool) {
+	// clients authenticating with private_key_jwt send a signed
+	// assertion instead of a secret (RFC 7523 section 2.2)
+	if assertionType := ctx.PostForm("client_assertion_type"); assertionType != "" {
+		if assertionType != ClientAssertionTypeJWTBearer {
+			writeOAuthError(ctx, http.StatusBadRequest, OAuthErrorInvalidRequest, "client_assertion_type is not supported")
+			return nil, false
+		}
+		assertion := ctx.PostForm("client_assertion")
+		check = func(ctx context.Context, clientID, _ string) (*ClientData, error) {
+			return r.usecases.AuthenticateClientAssertion(ctx, clientID, assertion)
+		}
+	}
+
+	clientID, clientSecret, basic := ctx.Request.BasicAuth()
+	if basic {
+		// the credentials are form encoded before being put in the header
+		// (RFC 6749 section 2.3.1)
+		var errID, errSecret error
+		clientID, errID = url.QueryUnescape(clientID)
+		clientSecret, errSecret = url.QueryUnescape(clientSecret)
+		if errID != nil || errSecret != nil {
+			writeOAuthError(ctx, http.StatusBadRequest, OAuthErrorInvalidRequest, "malformed client credentials")
+			return nil, false
+		}
+	} else {
+		clientID = ctx.PostForm("client_id")
+		clientSecret = ctx.PostForm("client_secret")
+	}
+
+	client, err := check(ctx, clientID, clientSecret)
+	if err != nil {
+		if err == InvalidClientError {
+			ctx.Header("WWW-Authenticate", `Basic realm="betalink-auth"`)
+			writeOAuthError(ctx, http.StatusUnauthorized, OAuthErrorInvalidClient, "client authentication failed")
+			return nil, false
+		}
+		r.logger.Error(fmt.Errorf("could not authenticate client: %w", err))
+		writeOAuthError(ctx, http.StatusInternalServerError, OAuthErrorServerError, "could not authenticate client")
+		return nil, false
+	}
+	return client, true
+}
+
+// writeOAuthError writes an OAuth error response (RFC 6749 section 5.2)
+func writeOAuthError(ctx *gin.Context, status int, code, description string) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// listClients handles the http request to list the OAuth clients
+func (r *Router) listClients(ctx *gin.Context) {
+	clients, err := r.usecases.ListClients(ctx)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not list clients: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, clients, nil)
+}
+
+// createClient handles the http request to create an OAuth client.
+// The response holds the client secret, which cannot be retrieved later.
+func (r *Router) createClient(ctx *gin.Context) {
+	var dto createClientDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	credentials, err := r.usecases.CreateClient(ctx, ClientRegistration{
+		Name:         dto.Name,
+		Type:         dto.Type,
+		RedirectURIs: dto.RedirectURIs,
+		Scopes:       dto.Scopes,
+		GrantTypes:   dto.GrantTypes,
+		Audiences:    dto.Audiences,
+		PublicKey:    dto.PublicKey,
+	})
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not create client: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusCreated, true, credentials, nil)
+}
+
+// deleteClient handles the http request to delete an OAuth client
+func (r *Router) deleteClient(ctx *gin.Context) {
+	if err := r.usecases.DeleteClient(ctx, ctx.Param("client_id")); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not delete client: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
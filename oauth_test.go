@@ -0,0 +1,168 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsecases_OAuthClients(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+
+	credentials, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{Name: "api-gateway"})
+	require.NoError(t, err)
+	require.NotEmpty(t, credentials.ClientID)
+	require.NotEmpty(t, credentials.ClientSecret)
+
+	t.Run("valid credentials", func(t *testing.T) {
+		client, err := usecases.AuthenticateClient(testCtx, credentials.ClientID, credentials.ClientSecret)
+		require.NoError(t, err)
+		require.Equal(t, "api-gateway", client.Name)
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		_, err := usecases.AuthenticateClient(testCtx, credentials.ClientID, "wrong-secret")
+		require.Equal(t, betalinkauth.InvalidClientError, err)
+		_, err = usecases.AuthenticateClient(testCtx, "unknown", credentials.ClientSecret)
+		require.Equal(t, betalinkauth.InvalidClientError, err)
+		_, err = usecases.AuthenticateClient(testCtx, credentials.ClientID, "")
+		require.Equal(t, betalinkauth.InvalidClientError, err)
+	})
+
+	t.Run("deleted clients cannot authenticate", func(t *testing.T) {
+		clients, err := usecases.ListClients(testCtx)
+		require.NoError(t, err)
+		require.Len(t, clients, 1)
+
+		require.NoError(t, usecases.DeleteClient(testCtx, credentials.ClientID))
+		_, err = usecases.AuthenticateClient(testCtx, credentials.ClientID, credentials.ClientSecret)
+		require.Equal(t, betalinkauth.InvalidClientError, err)
+		err = usecases.DeleteClient(testCtx, credentials.ClientID)
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+	})
+}
+
+func TestUsecases_IntrospectAndRevokeTokens(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+
+	testEmail := "introspect.user@example.com"
+	testPassword := "Introspect123!"
+	err = usecases.RegisterUser(testCtx, "Introspect", "User", testEmail, testPassword)
+	require.NoError(t, err)
+	loginData, err := queries.GetLoginDataByEmail(testCtx, testEmail)
+	require.NoError(t, err)
+
+	login := func(t *testing.T) *betalinkauth.IDTokens {
+		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+		return tokens
+	}
+	requireActive := func(t *testing.T, token, hint string, active bool) *betalinkauth.Introspection {
+		introspection, err := usecases.IntrospectToken(testCtx, token, hint)
+		require.NoError(t, err)
+		require.Equal(t, active, introspection.Active)
+		return introspection
+	}
+
+	t.Run("introspect active tokens", func(t *testing.T) {
+		tokens := login(t)
+
+		introspection := requireActive(t, tokens.AccessToken, "", true)
+		require.Equal(t, "Bearer", introspection.TokenType)
+		require.Equal(t, loginData.UserID.String(), introspection.Subject)
+		require.Equal(t, []string{betalinkauth.DefaultRole}, introspection.Roles)
+		require.NotEmpty(t, introspection.TokenID)
+		require.NotZero(t, introspection.ExpiresAt)
+
+		// the hint only changes the order in which the token types are tried
+		introspection = requireActive(t, tokens.RefreshToken, betalinkauth.TokenTypeHintAccessToken, true)
+		require.Equal(t, betalinkauth.TokenTypeHintRefreshToken, introspection.TokenType)
+		require.Equal(t, loginData.UserID.String(), introspection.Subject)
+		require.Equal(t, tokens.RefreshExpiresAt.Unix(), introspection.ExpiresAt)
+	})
+
+	t.Run("introspect invalid tokens", func(t *testing.T) {
+		introspection := requireActive(t, "invalid-token", "", false)
+		require.Equal(t, &betalinkauth.Introspection{Active: false}, introspection)
+	})
+
+	t.Run("revoke an access token", func(t *testing.T) {
+		tokens := login(t)
+		otherTokens := login(t)
+
+		err := usecases.RevokeToken(testCtx, nil, tokens.AccessToken, betalinkauth.TokenTypeHintAccessToken)
+		require.NoError(t, err)
+		requireActive(t, tokens.AccessToken, "", false)
+		_, err = usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.Equal(t, betalinkauth.RevokedTokenError, err)
+
+		// the session and the other access tokens are left untouched
+		requireActive(t, tokens.RefreshToken, "", true)
+		requireActive(t, otherTokens.AccessToken, "", true)
+
+		// revoking twice is not an error
+		err = usecases.RevokeToken(testCtx, nil, tokens.AccessToken, "")
+		require.NoError(t, err)
+	})
+
+	t.Run("revoke a refresh token", func(t *testing.T) {
+		tokens := login(t)
+
+		err := usecases.RevokeToken(testCtx, nil, tokens.RefreshToken, betalinkauth.TokenTypeHintRefreshToken)
+		require.NoError(t, err)
+		requireActive(t, tokens.RefreshToken, "", false)
+		_, err = usecases.RefreshAccessToken(testCtx, tokens.RefreshToken)
+		require.Equal(t, betalinkauth.RevokedTokenError, err)
+
+		// the access tokens of the session are revoked along with it
+		requireActive(t, tokens.AccessToken, "", false)
+		_, err = usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.Equal(t, betalinkauth.RevokedTokenError, err)
+	})
+
+	t.Run("clients only revoke their own tokens", func(t *testing.T) {
+		tokens := login(t)
+		credentials, err := usecases.CreateClient(testCtx, betalinkauth.ClientRegistration{Name: "revoking-client"})
+		require.NoError(t, err)
+
+		err = usecases.RevokeToken(testCtx, &credentials.ClientData, tokens.AccessToken, "")
+		require.Equal(t, betalinkauth.OAuthErrorUnauthorizedClient, err.(*betalinkauth.OAuthError).Code)
+		requireActive(t, tokens.AccessToken, "", true)
+
+		// the session of a refresh token issued to another client is
+		// left in place
+		err = usecases.RevokeToken(testCtx, &credentials.ClientData, tokens.RefreshToken, betalinkauth.TokenTypeHintRefreshToken)
+		require.Equal(t, betalinkauth.OAuthErrorUnauthorizedClient, err.(*betalinkauth.OAuthError).Code)
+		requireActive(t, tokens.RefreshToken, "", true)
+	})
+
+	t.Run("revoke an invalid token", func(t *testing.T) {
+		err := usecases.RevokeToken(testCtx, nil, "invalid-token", "unknown_hint")
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,166 @@
+package betalinkauth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// idTokenValidity is how long an ID token is valid
+const idTokenValidity = time.Hour
+
+// Scopes an OAuth client can be granted
+const (
+	// ScopeOpenID requests an ID token
+	ScopeOpenID = "openid"
+	// ScopeProfile grants access to the name of the user
+	ScopeProfile = "profile"
+	// ScopeEmail grants access to the email address of the user
+	ScopeEmail = "email"
+)
+
+// supportedScopes are the scopes known by the auth service
+var supportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// OpenIDConfiguration is the OpenID Connect discovery document of the
+// auth service (OpenID Connect Discovery 1.0)
+type OpenIDConfiguration struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	JWKSURI                                    string   `json:"jwks_uri"`
+	UserinfoEndpoint                           string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint                      string   `json:"introspection_endpoint"`
+	RevocationEndpoint                         string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint                string   `json:"device_authorization_endpoint"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	GrantTypesSupported                        []string `json:"grant_types_supported"`
+	SubjectTypesSupported                      []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	ScopesSupported                            []string `json:"scopes_supported"`
+	ClaimsSupported                            []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported  []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported     []string `json:"revocation_endpoint_auth_methods_supported"`
+}
+
+// UserInfo holds the standard claims of a user, as returned by the
+// userinfo endpoint
+type UserInfo struct {
+	Subject string `json:"sub"`
+	// GivenName and FamilyName are only set when the profile scope is
+	// granted
+	GivenName  string `json:"given_name,omitempty"`
+	FamilyName string `json:"family_name,omitempty"`
+	// Email and EmailVerified are only set for users logging in with
+	// an email and password, when the email scope is granted
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// OpenIDConfiguration returns the OpenID Connect discovery document
+func (u *Usecases) OpenIDConfiguration() OpenIDConfiguration {
+	clientAuthMethods := []string{"client_secret_basic", "client_secret_post", "private_key_jwt"}
+	return OpenIDConfiguration{
+		Issuer:                           u.issuer,
+		AuthorizationEndpoint:            u.issuer + "/oauth/authorize",
+		TokenEndpoint:                    u.issuer + "/oauth/token",
+		JWKSURI:                          u.issuer + "/.well-known/jwks.json",
+		UserinfoEndpoint:                 u.issuer + "/userinfo",
+		IntrospectionEndpoint:            u.issuer + "/oauth/introspect",
+		RevocationEndpoint:               u.issuer + "/oauth/revoke",
+		DeviceAuthorizationEndpoint:      u.issuer + "/oauth/device_authorization",
+		ResponseTypesSupported:           []string{ResponseTypeCode},
+		GrantTypesSupported:              supportedGrantTypes,
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{"RS256"},
+		ScopesSupported:                  supportedScopes,
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			"given_name", "family_name", "email", "email_verified",
+		},
+		CodeChallengeMethodsSupported: []string{CodeChallengeMethodS256},
+		// client assertions are signed like the tokens of the service
+		TokenEndpointAuthSigningAlgValuesSupported: []string{"RS256"},
+		// public clients do not authenticate at the token endpoint
+		TokenEndpointAuthMethodsSupported:         append(clientAuthMethods, "none"),
+		IntrospectionEndpointAuthMethodsSupported: clientAuthMethods,
+		RevocationEndpointAuthMethodsSupported:    clientAuthMethods,
+	}
+}
+
+// GetUserInfo returns the standard claims of a user granted by the
+// scopes of the OAuth client the token was issued to. The first-party
+// apps, with no client ID, are granted every claim.
+func (u *Usecases) GetUserInfo(ctx context.Context, userID, clientID string, scopes []string) (*UserInfo, error) {
+	parsedUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, &ValidationError{Message: "invalid UUID format"}
+	}
+	user, err := u.store.GetUserById(ctx, pgtype.UUID{Bytes: parsedUUID, Valid: true})
+	if err != nil {
+		return nil, userNotFound(err)
+	}
+
+	userInfo := &UserInfo{Subject: user.UserID.String()}
+	if grantsScope(clientID, scopes, ScopeProfile) {
+		userInfo.GivenName = user.FirstName
+		userInfo.FamilyName = user.LastName
+	}
+	if user.Email.Valid && grantsScope(clientID, scopes, ScopeEmail) {
+		emailVerified := user.EmailVerified.Bool
+		userInfo.Email = user.Email.String
+		userInfo.EmailVerified = &emailVerified
+	}
+	return userInfo, nil
+}
+
+// generateIDToken generates an ID token for the user of a grant. The
+// audience is the OAuth client of the grant, or the first-party apps.
+func (u *Usecases) generateIDToken(ctx context.Context, grant tokenGrant) (string, error) {
+	user, err := u.store.GetUserById(ctx, grant.UserID)
+	if err != nil {
+		return "", &ServerError{
+			Message: fmt.Errorf("could not get user by ID: %w", err).Error(),
+		}
+	}
+
+	claims := IDTokenClaims{
+		Issuer:   u.issuer,
+		Audience: tokenAudience,
+		UserID:   user.UserID.String(),
+		Nonce:    grant.Nonce,
+		AuthTime: grant.AuthTime,
+	}
+	if grant.ClientID != "" {
+		claims.Audience = grant.ClientID
+	}
+	if grantsScope(grant.ClientID, grant.Scopes, ScopeProfile) {
+		claims.GivenName = user.FirstName
+		claims.FamilyName = user.LastName
+	}
+	if grantsScope(grant.ClientID, grant.Scopes, ScopeEmail) {
+		claims.Email = user.Email.String
+		claims.EmailVerified = user.EmailVerified.Bool
+	}
+	idToken, err := u.keys.GenerateIDToken(claims, idTokenValidity)
+	if err != nil {
+		return "", &ServerError{
+			Message: fmt.Errorf("could not generate ID token: %w", err).Error(),
+		}
+	}
+	return idToken, nil
+}
+
+// grantsScope reports whether the scopes granted to an OAuth client
+// include the given scope. The first-party apps, with no client ID, are
+// granted every scope.
+func grantsScope(clientID string, scopes []string, scope string) bool {
+	return clientID == "" || slices.Contains(scopes, scope)
+}
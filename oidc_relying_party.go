@@ -0,0 +1,292 @@
+package betalinkauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// providerMetadataTTL is how long the discovery document and the keys
+	// of an external provider are cached
+	providerMetadataTTL = time.Hour
+	// providerKeysMinRefreshInterval is the minimum time between two
+	// fetches of the keys of a provider, triggered by unknown key IDs
+	providerKeysMinRefreshInterval = time.Minute
+	// providerResponseMaxSize is the maximum size of the responses read
+	// from external providers
+	providerResponseMaxSize = 1 << 20
+)
+
+// providerMetadata holds the endpoints of an OpenID Connect provider,
+// read from its discovery document (OpenID Connect Discovery 1.0)
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// providerTokens is the token response of an external provider
+type providerTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+}
+
+// discoveredProvider is the cached metadata and keys of a provider
+type discoveredProvider struct {
+	metadata      providerMetadata
+	discoveredAt  time.Time
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// relyingParty talks to the external OpenID Connect providers users log
+// in with, caching their discovery documents and keys
+type relyingParty struct {
+	client    *http.Client
+	mu        sync.Mutex
+	providers map[string]*discoveredProvider
+}
+
+// newRelyingParty creates a relyingParty sending its requests with client
+func newRelyingParty(client *http.Client) *relyingParty {
+	return &relyingParty{
+		client:    client,
+		providers: map[string]*discoveredProvider{},
+	}
+}
+
+// discover returns the metadata of the provider of an issuer, fetching
+// its discovery document unless it is cached
+func (rp *relyingParty) discover(ctx context.Context, issuer string) (providerMetadata, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	provider, err := rp.provider(ctx, issuer)
+	if err != nil {
+		return providerMetadata{}, err
+	}
+	return provider.metadata, nil
+}
+
+// provider returns the cached provider of an issuer, discovering it if
+// it is unknown or stale. The caller must hold mu.
+func (rp *relyingParty) provider(ctx context.Context, issuer string) (*discoveredProvider, error) {
+	provider, ok := rp.providers[issuer]
+	if ok && time.Since(provider.discoveredAt) < providerMetadataTTL {
+		return provider, nil
+	}
+
+	var metadata providerMetadata
+	err := rp.get(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not get discovery document: %w", err)
+	}
+	// the discovery document must be that of the issuer, so a provider
+	// cannot impersonate another one (OpenID Connect Discovery section 4.3)
+	if metadata.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document issuer [%s] does not match [%s]", metadata.Issuer, issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+	provider = &discoveredProvider{
+		metadata:     metadata,
+		discoveredAt: time.Now(),
+	}
+	rp.providers[issuer] = provider
+	return provider, nil
+}
+
+// key returns the public key of a provider identified by keyID,
+// fetching the keys again if it is unknown, for instance after a key
+// rotation of the provider
+func (rp *relyingParty) key(ctx context.Context, issuer, keyID string) (*rsa.PublicKey, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	provider, err := rp.provider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := provider.keys[keyID]; ok {
+		return key, nil
+	}
+	if time.Since(provider.keysFetchedAt) < providerKeysMinRefreshInterval {
+		return nil, fmt.Errorf("unknown key [%s]", keyID)
+	}
+
+	var jwks JSONWebKeySet
+	if err := rp.get(ctx, provider.metadata.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("could not get provider keys: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := decodeRSAPublicKey(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	provider.keys = keys
+	provider.keysFetchedAt = time.Now()
+	if key, ok := keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key [%s]", keyID)
+}
+
+// exchangeCode exchanges an authorization code for the tokens of a
+// provider, the client authenticating with its secret
+// (client_secret_post) and proving the request with its PKCE verifier
+func (rp *relyingParty) exchangeCode(ctx context.Context, provider Externalloginprovider, code, redirectURI, codeVerifier string) (*providerTokens, error) {
+	metadata, err := rp.discover(ctx, provider.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+		"client_id":     {provider.ClientID},
+		"client_secret": {provider.ClientSecret},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create token request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var tokens providerTokens
+	if err := rp.do(request, &tokens); err != nil {
+		return nil, fmt.Errorf("could not exchange authorization code: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no ID token")
+	}
+	return &tokens, nil
+}
+
+// verifyIDToken verifies an ID token issued by a provider to the
+// client, and returns its claims. The nonce is checked when given.
+func (rp *relyingParty) verifyIDToken(ctx context.Context, provider Externalloginprovider, idToken, nonce string) (jwt.MapClaims, error) {
+	parsedToken, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return rp.key(ctx, provider.Issuer, keyID)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithAudience(provider.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid ID token claims")
+	}
+
+	issuer, _ := claims.GetIssuer()
+	if !matchesIssuer(issuer, provider.Issuer) {
+		return nil, fmt.Errorf("ID token issuer [%s] does not match [%s]", issuer, provider.Issuer)
+	}
+	// with several audiences, the client must be the authorized party
+	// (OpenID Connect Core section 3.1.3.7)
+	audiences, _ := claims.GetAudience()
+	if authorizedParty, ok := claims["azp"].(string); (ok || len(audiences) > 1) && authorizedParty != provider.ClientID {
+		return nil, errors.New("ID token was not issued to the client")
+	}
+	if subject, _ := claims.GetSubject(); subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	if nonce != "" {
+		if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+			return nil, errors.New("ID token nonce does not match")
+		}
+	}
+	return claims, nil
+}
+
+// get fetches a JSON document
+func (rp *relyingParty) get(ctx context.Context, url string, document interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return rp.do(request, document)
+}
+
+// do sends a request to a provider and decodes its JSON response
+func (rp *relyingParty) do(request *http.Request, response interface{}) error {
+	request.Header.Set("Accept", "application/json")
+	httpResponse, err := rp.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(httpResponse.Body, providerResponseMaxSize))
+	if err != nil {
+		return err
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", httpResponse.StatusCode, body)
+	}
+	return json.Unmarshal(body, response)
+}
+
+// matchesIssuer reports whether the issuer of an ID token is that of a
+// provider. Google issues ID tokens with its issuer with or without the
+// https scheme.
+func matchesIssuer(issuer, providerIssuer string) bool {
+	return issuer == providerIssuer ||
+		(issuer != "" && "https://"+issuer == providerIssuer)
+}
+
+// decodeRSAPublicKey decodes the modulus and exponent of a JSON Web Key
+func decodeRSAPublicKey(key JSONWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent is too large")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+// emailClaim returns the email of an ID token, and whether the provider
+// verified it. Some providers send email_verified as a string.
+func emailClaim(claims jwt.MapClaims) (string, bool) {
+	email, _ := claims["email"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		return email, verified
+	case string:
+		return email, verified == "true"
+	}
+	return email, false
+}
@@ -0,0 +1,102 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"io"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	betalinklogger "github.com/BragdonD/betalink-logger"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsecases_OpenIDConfiguration(t *testing.T) {
+	logger := betalinklogger.NewLogger("betalink-auth", false, false, io.Discard)
+	usecases, err := betalinkauth.NewUsecase(logger, nil, betalinkauth.WithIssuer("https://auth.betalink.com/"))
+	require.NoError(t, err)
+
+	config := usecases.OpenIDConfiguration()
+	assert.Equal(t, "https://auth.betalink.com", config.Issuer)
+	assert.Equal(t, "https://auth.betalink.com/.well-known/jwks.json", config.JWKSURI)
+	assert.Equal(t, "https://auth.betalink.com/userinfo", config.UserinfoEndpoint)
+	assert.Equal(t, []string{"RS256"}, config.IDTokenSigningAlgValuesSupported)
+	assert.Contains(t, config.ScopesSupported, "openid")
+	assert.Equal(t, "https://auth.betalink.com/oauth/authorize", config.AuthorizationEndpoint)
+	assert.Equal(t, "https://auth.betalink.com/oauth/token", config.TokenEndpoint)
+	assert.Equal(t, []string{"code"}, config.ResponseTypesSupported)
+	assert.Equal(t, []string{"S256"}, config.CodeChallengeMethodsSupported)
+	assert.Equal(t, "https://auth.betalink.com/oauth/device_authorization", config.DeviceAuthorizationEndpoint)
+	assert.Contains(t, config.GrantTypesSupported, betalinkauth.GrantTypeDeviceCode)
+}
+
+func TestUsecases_IDTokenAndUserInfo(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	signingKey, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	keys := betalinkauth.NewKeySet(signingKey)
+	usecases, err := betalinkauth.NewUsecase(logger, queries,
+		betalinkauth.WithKeySet(keys),
+		betalinkauth.WithIssuer("https://auth.betalink.com"),
+	)
+	require.NoError(t, err)
+
+	testEmail := "oidc.user@example.com"
+	testPassword := "OidcPassword123!"
+	err = usecases.RegisterUser(testCtx, "Oidc", "User", testEmail, testPassword)
+	require.NoError(t, err)
+	loginData, err := queries.GetLoginDataByEmail(testCtx, testEmail)
+	require.NoError(t, err)
+	userID := loginData.UserID.String()
+
+	tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+	require.NoError(t, err)
+
+	t.Run("login issues an ID token", func(t *testing.T) {
+		claims, err := keys.ParseJWT(tokens.IDToken, jwt.WithIssuer("https://auth.betalink.com"))
+		require.NoError(t, err)
+		require.Equal(t, userID, claims["sub"])
+		require.Equal(t, testEmail, claims["email"])
+		require.Equal(t, false, claims["email_verified"])
+		require.Equal(t, "Oidc", claims["given_name"])
+		require.Equal(t, "User", claims["family_name"])
+		require.NotContains(t, claims, "nonce")
+	})
+
+	t.Run("refresh issues an ID token", func(t *testing.T) {
+		refreshedTokens, err := usecases.RefreshAccessToken(testCtx, tokens.RefreshToken)
+		require.NoError(t, err)
+		claims, err := keys.ParseJWT(refreshedTokens.IDToken)
+		require.NoError(t, err)
+		require.Equal(t, userID, claims["sub"])
+	})
+
+	t.Run("user info", func(t *testing.T) {
+		userInfo, err := usecases.GetUserInfo(testCtx, userID, "", nil)
+		require.NoError(t, err)
+		emailVerified := false
+		require.Equal(t, &betalinkauth.UserInfo{
+			Subject:       userID,
+			GivenName:     "Oidc",
+			FamilyName:    "User",
+			Email:         testEmail,
+			EmailVerified: &emailVerified,
+		}, userInfo)
+
+		_, err = usecases.GetUserInfo(testCtx, "00000000-0000-0000-0000-000000000000", "", nil)
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+		_, err = usecases.GetUserInfo(testCtx, "invalid", "", nil)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+	})
+}
@@ -0,0 +1,370 @@
+package betalinkauth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BragdonD/betalink-auth/middleware"
+	betalinklogger "github.com/BragdonD/betalink-logger"
+	"github.com/google/cel-go/cel"
+)
+
+const (
+	// PolicyEffectAllow grants the access when the policy matches
+	PolicyEffectAllow = "allow"
+	// PolicyEffectDeny refuses the access when the policy matches,
+	// overriding any allow policy
+	PolicyEffectDeny = "deny"
+	// PolicyAnyAction is the action of the policies applying to every action
+	PolicyAnyAction = "*"
+)
+
+// Decision is the outcome of an access check
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Policy is the name of the policy which took the decision, empty
+	// when no policy matched and the access is denied by default
+	Policy string `json:"policy,omitempty"`
+}
+
+// compiledPolicy is a policy along with its compiled expression
+type compiledPolicy struct {
+	Policy
+	program cel.Program
+}
+
+// PolicyEngine evaluates the access policies stored in the database.
+// Policies are CEL expressions over the subject, action and resource
+// of an access check, e.g.
+//
+//	"tester" in subject.roles && resource.project_id in subject.projects
+//
+// An access is allowed when an allow policy matches and no deny policy
+// does. Policies are reloaded when they change in the database.
+type PolicyEngine struct {
+	logger  *betalinklogger.Logger
+	queries *Queries
+	env     *cel.Env
+
+	mu       sync.RWMutex
+	policies []compiledPolicy
+	version  GetPoliciesVersionRow
+}
+
+// NewPolicyEngine creates a new PolicyEngine instance without any
+// policy, call Reload to load the policies of the database
+func NewPolicyEngine(logger *betalinklogger.Logger, queries *Queries) (*PolicyEngine, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("subject", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("action", cel.StringType),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create CEL environment: %w", err)
+	}
+	return &PolicyEngine{
+		logger:  logger,
+		queries: queries,
+		env:     env,
+	}, nil
+}
+
+// Compile checks that expression is a valid policy expression
+func (e *PolicyEngine) Compile(expression string) (cel.Program, error) {
+	ast, issues := e.env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	// attributes are dynamically typed, their expressions are checked
+	// to be bools when evaluated
+	if outputType := ast.OutputType(); outputType != cel.BoolType && outputType != cel.DynType {
+		return nil, fmt.Errorf("expression must evaluate to a bool, not %s", outputType)
+	}
+	return e.env.Program(ast)
+}
+
+// Load replaces the policies of the engine. Disabled policies are
+// ignored, invalid ones are logged and ignored.
+func (e *PolicyEngine) Load(policies []Policy) {
+	compiled := make([]compiledPolicy, 0, len(policies))
+	for _, policy := range policies {
+		if !policy.Enabled {
+			continue
+		}
+		program, err := e.Compile(policy.Expression)
+		if err != nil {
+			e.logger.Error(fmt.Errorf("could not compile policy %s: %w", policy.PolicyName, err))
+			continue
+		}
+		compiled = append(compiled, compiledPolicy{Policy: policy, program: program})
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policies = compiled
+}
+
+// Reload loads the policies of the database if they changed since
+// the last reload. An engine created without queries keeps the policies
+// it was loaded with.
+func (e *PolicyEngine) Reload(ctx context.Context) error {
+	if e.queries == nil {
+		return nil
+	}
+	version, err := e.queries.GetPoliciesVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get policies version: %w", err)
+	}
+	e.mu.RLock()
+	unchanged := e.version == version
+	e.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	policies, err := e.queries.ListPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list policies: %w", err)
+	}
+	e.Load(policies)
+
+	e.mu.Lock()
+	e.version = version
+	e.mu.Unlock()
+	e.logger.Info(fmt.Sprintf("Loaded %d policies", len(policies)))
+	return nil
+}
+
+// Run reloads the policies every interval until the context is cancelled
+func (e *PolicyEngine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Reload(ctx); err != nil && ctx.Err() == nil {
+			e.logger.Error(fmt.Errorf("could not reload policies: %w", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate decides whether subject may perform action on resource.
+// A deny policy failing to evaluate, for instance because of a missing
+// attribute, denies the access, while a failing allow policy does not
+// allow it.
+func (e *PolicyEngine) Evaluate(subject map[string]interface{}, action string, resource map[string]interface{}) Decision {
+	if subject == nil {
+		subject = map[string]interface{}{}
+	}
+	if resource == nil {
+		resource = map[string]interface{}{}
+	}
+	input := map[string]interface{}{
+		"subject":  subject,
+		"action":   action,
+		"resource": resource,
+	}
+
+	e.mu.RLock()
+	policies := e.policies
+	e.mu.RUnlock()
+
+	decision := Decision{Allowed: false}
+	for _, policy := range policies {
+		if policy.Action != action && policy.Action != PolicyAnyAction {
+			continue
+		}
+		matched, err := evalPolicy(policy, input)
+		switch {
+		case policy.Effect == PolicyEffectDeny && (matched || err != nil):
+			return Decision{Allowed: false, Policy: policy.PolicyName}
+		case policy.Effect == PolicyEffectAllow && matched && !decision.Allowed:
+			decision = Decision{Allowed: true, Policy: policy.PolicyName}
+		}
+	}
+	return decision
+}
+
+// evalPolicy evaluates the expression of a policy
+func evalPolicy(policy compiledPolicy, input map[string]interface{}) (bool, error) {
+	value, _, err := policy.program.Eval(input)
+	if err != nil {
+		return false, err
+	}
+	matched, ok := value.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("policy %s did not evaluate to a bool", policy.PolicyName)
+	}
+	return matched, nil
+}
+
+// PolicyData is an access policy
+type PolicyData struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Action      string `json:"action"`
+	Effect      string `json:"effect"`
+	Expression  string `json:"expression"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// Policies returns the policy engine of the usecases
+func (u *Usecases) Policies() *PolicyEngine {
+	return u.policies
+}
+
+// CheckAccess decides whether the authenticated user may perform action
+// on resource. The identity of the user, its roles and permissions are
+// added to the subject attributes provided by the caller.
+func (u *Usecases) CheckAccess(user *middleware.UserData, subject map[string]interface{}, action string, resource map[string]interface{}) (Decision, error) {
+	if action == "" {
+		return Decision{}, &ValidationError{Message: "action is required"}
+	}
+	attributes := make(map[string]interface{}, len(subject)+3)
+	for name, value := range subject {
+		attributes[name] = value
+	}
+	attributes["id"] = user.UserID
+	attributes["roles"] = user.Roles
+	attributes["permissions"] = user.Permissions
+	return u.policies.Evaluate(attributes, action, resource), nil
+}
+
+// ListPolicies returns the access policies
+func (u *Usecases) ListPolicies(ctx context.Context) ([]PolicyData, error) {
+	queries, err := u.postgresQueries("Policies")
+	if err != nil {
+		return nil, err
+	}
+	policies, err := queries.ListPolicies(ctx)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list policies: %w", err).Error(),
+		}
+	}
+	data := make([]PolicyData, 0, len(policies))
+	for _, policy := range policies {
+		data = append(data, PolicyData{
+			Name:        policy.PolicyName,
+			Description: policy.Description,
+			Action:      policy.Action,
+			Effect:      policy.Effect,
+			Expression:  policy.Expression,
+			Enabled:     policy.Enabled,
+		})
+	}
+	return data, nil
+}
+
+// CreatePolicy creates a new access policy
+func (u *Usecases) CreatePolicy(ctx context.Context, policy PolicyData) error {
+	queries, err := u.postgresQueries("Policies")
+	if err != nil {
+		return err
+	}
+	if err := u.validatePolicy(policy); err != nil {
+		return err
+	}
+	err = queries.CreatePolicy(ctx, CreatePolicyParams{
+		PolicyName:  policy.Name,
+		Description: policy.Description,
+		Action:      policy.Action,
+		Effect:      policy.Effect,
+		Expression:  policy.Expression,
+		Enabled:     policy.Enabled,
+	})
+	if err != nil {
+		if pgErrorCode(err) == pgUniqueViolation {
+			return &ValidationError{Message: fmt.Sprintf("policy [%s] already exists", policy.Name)}
+		}
+		return &ServerError{
+			Message: fmt.Errorf("could not create policy: %w", err).Error(),
+		}
+	}
+	u.reloadPolicies(ctx)
+	return nil
+}
+
+// UpdatePolicy replaces an access policy
+func (u *Usecases) UpdatePolicy(ctx context.Context, policy PolicyData) error {
+	queries, err := u.postgresQueries("Policies")
+	if err != nil {
+		return err
+	}
+	if err := u.validatePolicy(policy); err != nil {
+		return err
+	}
+	updated, err := queries.UpdatePolicy(ctx, UpdatePolicyParams{
+		PolicyName:  policy.Name,
+		Description: policy.Description,
+		Action:      policy.Action,
+		Effect:      policy.Effect,
+		Expression:  policy.Expression,
+		Enabled:     policy.Enabled,
+	})
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not update policy: %w", err).Error(),
+		}
+	}
+	if updated == 0 {
+		return &NotFoundError{Message: fmt.Sprintf("policy [%s] not found", policy.Name)}
+	}
+	u.reloadPolicies(ctx)
+	return nil
+}
+
+// DeletePolicy deletes an access policy
+func (u *Usecases) DeletePolicy(ctx context.Context, name string) error {
+	queries, err := u.postgresQueries("Policies")
+	if err != nil {
+		return err
+	}
+	deleted, err := queries.DeletePolicy(ctx, name)
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not delete policy: %w", err).Error(),
+		}
+	}
+	if deleted == 0 {
+		return &NotFoundError{Message: fmt.Sprintf("policy [%s] not found", name)}
+	}
+	u.reloadPolicies(ctx)
+	return nil
+}
+
+// validatePolicy validates the fields and the expression of a policy
+func (u *Usecases) validatePolicy(policy PolicyData) error {
+	if err := validateName("policy", policy.Name); err != nil {
+		return err
+	}
+	if policy.Action == "" {
+		return &ValidationError{Message: "policy action is required"}
+	}
+	if policy.Effect != PolicyEffectAllow && policy.Effect != PolicyEffectDeny {
+		return &ValidationError{
+			Message: fmt.Sprintf("policy effect must be %s or %s", PolicyEffectAllow, PolicyEffectDeny),
+		}
+	}
+	if _, err := u.policies.Compile(policy.Expression); err != nil {
+		return &ValidationError{
+			Message: fmt.Errorf("invalid policy expression: %w", err).Error(),
+		}
+	}
+	return nil
+}
+
+// reloadPolicies applies a policy change to this instance right away,
+// the other instances pick it up on their next reload
+func (u *Usecases) reloadPolicies(ctx context.Context) {
+	if err := u.policies.Reload(ctx); err != nil {
+		u.logger.Error(fmt.Errorf("could not reload policies: %w", err))
+	}
+}
@@ -0,0 +1,139 @@
+package betalinkauth_test
+
+import (
+	"io"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	betalinklogger "github.com/BragdonD/betalink-logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyEngine_Evaluate(t *testing.T) {
+	logger := betalinklogger.NewLogger("betalink-auth", false, false, io.Discard)
+	engine, err := betalinkauth.NewPolicyEngine(logger, nil)
+	require.NoError(t, err)
+
+	engine.Load([]betalinkauth.Policy{
+		{
+			PolicyName: "testers-download-their-projects",
+			Action:     "builds:download",
+			Effect:     betalinkauth.PolicyEffectAllow,
+			Expression: `"tester" in subject.roles && resource.project_id in subject.projects`,
+			Enabled:    true,
+		},
+		{
+			PolicyName: "admins-do-anything",
+			Action:     betalinkauth.PolicyAnyAction,
+			Effect:     betalinkauth.PolicyEffectAllow,
+			Expression: `"admin" in subject.roles`,
+			Enabled:    true,
+		},
+		{
+			PolicyName: "no-archived-projects",
+			Action:     "builds:download",
+			Effect:     betalinkauth.PolicyEffectDeny,
+			Expression: `resource.archived`,
+			Enabled:    true,
+		},
+		{
+			PolicyName: "disabled",
+			Action:     betalinkauth.PolicyAnyAction,
+			Effect:     betalinkauth.PolicyEffectAllow,
+			Expression: `true`,
+			Enabled:    false,
+		},
+		{
+			PolicyName: "invalid",
+			Action:     betalinkauth.PolicyAnyAction,
+			Effect:     betalinkauth.PolicyEffectAllow,
+			Expression: `subject.`,
+			Enabled:    true,
+		},
+	})
+
+	tester := map[string]interface{}{
+		"roles":    []string{"user", "tester"},
+		"projects": []string{"betalink"},
+	}
+	tests := []struct {
+		name     string
+		subject  map[string]interface{}
+		action   string
+		resource map[string]interface{}
+		expected betalinkauth.Decision
+	}{
+		{
+			name:     "allow policy matches",
+			subject:  tester,
+			action:   "builds:download",
+			resource: map[string]interface{}{"project_id": "betalink", "archived": false},
+			expected: betalinkauth.Decision{Allowed: true, Policy: "testers-download-their-projects"},
+		},
+		{
+			name:     "no policy matches",
+			subject:  tester,
+			action:   "builds:download",
+			resource: map[string]interface{}{"project_id": "other", "archived": false},
+			expected: betalinkauth.Decision{Allowed: false},
+		},
+		{
+			name:     "deny overrides allow",
+			subject:  tester,
+			action:   "builds:download",
+			resource: map[string]interface{}{"project_id": "betalink", "archived": true},
+			expected: betalinkauth.Decision{Allowed: false, Policy: "no-archived-projects"},
+		},
+		{
+			name:     "deny policy failing on a missing attribute denies",
+			subject:  tester,
+			action:   "builds:download",
+			resource: map[string]interface{}{"project_id": "betalink"},
+			expected: betalinkauth.Decision{Allowed: false, Policy: "no-archived-projects"},
+		},
+		{
+			name:     "policies of other actions do not apply",
+			subject:  tester,
+			action:   "builds:upload",
+			resource: map[string]interface{}{"project_id": "betalink"},
+			expected: betalinkauth.Decision{Allowed: false},
+		},
+		{
+			name:     "policies of any action apply",
+			subject:  map[string]interface{}{"roles": []string{"admin"}},
+			action:   "builds:upload",
+			resource: nil,
+			expected: betalinkauth.Decision{Allowed: true, Policy: "admins-do-anything"},
+		},
+		{
+			name:     "no subject attributes",
+			subject:  nil,
+			action:   "builds:upload",
+			resource: nil,
+			expected: betalinkauth.Decision{Allowed: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := engine.Evaluate(tt.subject, tt.action, tt.resource)
+			assert.Equal(t, tt.expected, decision)
+		})
+	}
+}
+
+func TestPolicyEngine_Compile(t *testing.T) {
+	logger := betalinklogger.NewLogger("betalink-auth", false, false, io.Discard)
+	engine, err := betalinkauth.NewPolicyEngine(logger, nil)
+	require.NoError(t, err)
+
+	_, err = engine.Compile(`action.startsWith("builds:") && subject.id != ""`)
+	assert.NoError(t, err)
+	_, err = engine.Compile(`subject.`)
+	assert.Error(t, err)
+	_, err = engine.Compile(`size(subject.roles)`)
+	assert.Error(t, err, "the expression does not evaluate to a bool")
+	_, err = engine.Compile(`user.id == "1"`)
+	assert.Error(t, err, "user is not a declared variable")
+}
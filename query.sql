@@ -11,19 +11,377 @@ INSERT INTO PasswordRecovery (user_id, recovery_token) VALUES ($1, $2);
 INSERT INTO EmailVerification (user_id, verification_token) VALUES ($1, $2);
 
 -- name: GetLoginDataByEmail :one
-SELECT user_id, email, passwordHash, passwordSalt, hashAlgorithm FROM UsersLoginData WHERE email = $1;
+SELECT user_id, email, passwordHash, passwordSalt, hashAlgorithm, email_verified FROM UsersLoginData WHERE email = $1;
 
 -- name: GetUserById :one
-SELECT user_id, first_name, last_name FROM Users WHERE user_id = $1;
+SELECT u.user_id, u.first_name, u.last_name, ld.email, ld.email_verified FROM Users u
+LEFT JOIN UsersLoginData ld ON ld.user_id = u.user_id
+WHERE u.user_id = $1;
 
 -- name: CreateSession :one
-INSERT INTO Sessions (user_id, created_at, updated_at, expires_at) VALUES ($1, $2, $3, $4) RETURNING session_id;
+INSERT INTO Sessions (user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING session_id;
 
 -- name: GetSessionById :one
-SELECT session_id, user_id, created_at, updated_at, expires_at FROM Sessions WHERE session_id = $1;
+SELECT session_id, user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash FROM Sessions WHERE session_id = $1;
+
+-- name: GetSessionByRefreshTokenHash :one
+SELECT session_id, user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash FROM Sessions WHERE refresh_token_hash = $1;
+
+-- name: TouchSession :exec
+UPDATE Sessions SET updated_at = $1, expires_at = $2 WHERE session_id = $3;
 
 -- name: Test_UpdateSessionExpiresAt :exec
 UPDATE Sessions SET expires_at = $1 WHERE session_id = $2;
 
+-- name: Test_UpdateSessionAbsoluteExpiresAt :exec
+UPDATE Sessions SET absolute_expires_at = $1 WHERE session_id = $2;
+
+-- name: LockUserForSession :one
+SELECT max_sessions, disabled_at FROM Users WHERE user_id = $1 FOR UPDATE;
+
+-- name: SetUserMaxSessions :exec
+UPDATE Users SET max_sessions = $1 WHERE user_id = $2;
+
+-- name: CountActiveSessions :one
+SELECT COUNT(*) FROM Sessions WHERE user_id = $1 AND expires_at > NOW();
+
+-- name: DeleteOldestActiveSessions :execrows
+DELETE FROM Sessions WHERE session_id IN (
+    SELECT session_id FROM Sessions
+    WHERE user_id = $1 AND expires_at > NOW()
+    ORDER BY created_at ASC
+    LIMIT $2
+);
+
 -- name: DeleteSession :exec
-DELETE FROM Sessions WHERE session_id = $1;
\ No newline at end of file
+DELETE FROM Sessions WHERE session_id = $1;
+
+-- name: DeleteExpiredSessions :execrows
+DELETE FROM Sessions WHERE session_id IN (
+    SELECT session_id FROM Sessions WHERE expires_at < NOW() LIMIT $1
+);
+
+-- name: DeleteStaleEmailVerifications :execrows
+DELETE FROM EmailVerification WHERE user_id IN (
+    SELECT user_id FROM EmailVerification
+    WHERE used OR created_at < LOCALTIMESTAMP - sqlc.arg(max_age_seconds)::int * INTERVAL '1 second'
+    LIMIT sqlc.arg(batch_size)
+);
+
+-- name: DeleteStalePasswordRecoveries :execrows
+DELETE FROM PasswordRecovery WHERE user_id IN (
+    SELECT user_id FROM PasswordRecovery
+    WHERE used OR created_at < LOCALTIMESTAMP - sqlc.arg(max_age_seconds)::int * INTERVAL '1 second'
+    LIMIT sqlc.arg(batch_size)
+);
+
+-- name: TryAdvisoryLock :one
+SELECT pg_try_advisory_lock(sqlc.arg(lock_id)::bigint);
+
+-- name: AdvisoryUnlock :one
+SELECT pg_advisory_unlock(sqlc.arg(lock_id)::bigint);
+
+-- name: CreateRole :exec
+INSERT INTO Roles (role_name, description, max_sessions) VALUES ($1, $2, $3);
+
+-- name: ListRoles :many
+SELECT role_name, description, max_sessions, created_at FROM Roles ORDER BY role_name;
+
+-- name: DeleteRole :execrows
+DELETE FROM Roles WHERE role_name = $1;
+
+-- name: CreatePermission :exec
+INSERT INTO Permissions (permission_name, description) VALUES ($1, $2);
+
+-- name: ListPermissions :many
+SELECT permission_name, description, created_at FROM Permissions ORDER BY permission_name;
+
+-- name: DeletePermission :execrows
+DELETE FROM Permissions WHERE permission_name = $1;
+
+-- name: GrantRolePermission :exec
+INSERT INTO RolePermissions (role_name, permission_name) VALUES ($1, $2) ON CONFLICT DO NOTHING;
+
+-- name: RevokeRolePermission :execrows
+DELETE FROM RolePermissions WHERE role_name = $1 AND permission_name = $2;
+
+-- name: ListRolePermissions :many
+SELECT role_name, permission_name FROM RolePermissions ORDER BY role_name, permission_name;
+
+-- name: AssignUserRole :exec
+INSERT INTO UserRoles (user_id, role_name) VALUES ($1, $2) ON CONFLICT DO NOTHING;
+
+-- name: UnassignUserRole :execrows
+DELETE FROM UserRoles WHERE user_id = $1 AND role_name = $2;
+
+-- name: GetUserRoles :many
+SELECT r.role_name, r.description, r.max_sessions, r.created_at FROM Roles r
+JOIN UserRoles ur ON ur.role_name = r.role_name
+WHERE ur.user_id = $1
+ORDER BY r.role_name;
+
+-- name: GetUserPermissions :many
+SELECT DISTINCT rp.permission_name FROM RolePermissions rp
+JOIN UserRoles ur ON ur.role_name = rp.role_name
+WHERE ur.user_id = $1
+ORDER BY rp.permission_name;
+
+-- name: CreatePolicy :exec
+INSERT INTO Policies (policy_name, description, action, effect, expression, enabled) VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: UpdatePolicy :execrows
+UPDATE Policies SET description = $2, action = $3, effect = $4, expression = $5, enabled = $6, updated_at = NOW() WHERE policy_name = $1;
+
+-- name: DeletePolicy :execrows
+DELETE FROM Policies WHERE policy_name = $1;
+
+-- name: ListPolicies :many
+SELECT policy_name, description, action, effect, expression, enabled, created_at, updated_at FROM Policies ORDER BY policy_name;
+
+-- name: GetPoliciesVersion :one
+SELECT COUNT(*) AS policy_count, COALESCE(MAX(updated_at), 'epoch'::timestamptz)::timestamptz AS last_updated_at FROM Policies;
+
+-- name: SessionExists :one
+SELECT EXISTS (SELECT 1 FROM Sessions WHERE session_id = $1);
+
+-- name: CreateOAuthClient :exec
+INSERT INTO OAuthClients (client_id, client_secret_hash, client_name, client_type, redirect_uris, allowed_scopes, grant_types, allowed_audiences, public_key) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: GetOAuthClient :one
+SELECT client_id, client_secret_hash, client_name, created_at, client_type, redirect_uris, allowed_scopes, grant_types, allowed_audiences, public_key FROM OAuthClients WHERE client_id = $1;
+
+-- name: ListOAuthClients :many
+SELECT client_id, client_secret_hash, client_name, created_at, client_type, redirect_uris, allowed_scopes, grant_types, allowed_audiences, public_key FROM OAuthClients ORDER BY client_name, client_id;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM OAuthClients WHERE client_id = $1;
+
+-- name: RevokeAccessToken :exec
+INSERT INTO RevokedAccessTokens (token_id, expires_at) VALUES ($1, $2) ON CONFLICT DO NOTHING;
+
+-- name: IsAccessTokenRevoked :one
+SELECT EXISTS (SELECT 1 FROM RevokedAccessTokens WHERE token_id = $1);
+
+-- name: DeleteExpiredRevokedAccessTokens :execrows
+DELETE FROM RevokedAccessTokens WHERE token_id IN (
+    SELECT token_id FROM RevokedAccessTokens WHERE expires_at < NOW() LIMIT $1
+);
+
+-- name: CreateAuthorizationCode :exec
+INSERT INTO AuthorizationCodes (code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, ip_address, user_agent, auth_time, expires_at, redirect_uri_supplied) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);
+
+-- name: ConsumeAuthorizationCode :one
+DELETE FROM AuthorizationCodes WHERE code_hash = $1 RETURNING code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, ip_address, user_agent, auth_time, expires_at, redirect_uri_supplied;
+
+-- name: DeleteExpiredAuthorizationCodes :execrows
+DELETE FROM AuthorizationCodes WHERE code_hash IN (
+    SELECT code_hash FROM AuthorizationCodes WHERE expires_at < NOW() LIMIT $1
+);
+
+-- name: CreateDeviceAuthorization :exec
+INSERT INTO DeviceAuthorizations (device_code_hash, user_code, client_id, scope, polling_interval, ip_address, user_agent, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: GetPendingDeviceAuthorization :one
+SELECT device_code_hash, user_code, client_id, scope, status, user_id, auth_time, polling_interval, last_polled_at, ip_address, user_agent, expires_at FROM DeviceAuthorizations WHERE user_code = $1 AND status = 'pending' AND expires_at > NOW();
+
+-- name: DecideDeviceAuthorization :execrows
+UPDATE DeviceAuthorizations SET status = $2, user_id = $3, auth_time = $4 WHERE user_code = $1 AND status = 'pending' AND expires_at > NOW();
+
+-- name: PollDeviceAuthorization :one
+-- records the poll and returns the authorization as it was before it
+UPDATE DeviceAuthorizations polled SET last_polled_at = NOW()
+FROM DeviceAuthorizations previous
+WHERE polled.device_code_hash = previous.device_code_hash AND polled.device_code_hash = $1
+RETURNING previous.device_code_hash, previous.user_code, previous.client_id, previous.scope, previous.status, previous.user_id, previous.auth_time, previous.polling_interval, previous.last_polled_at, previous.ip_address, previous.user_agent, previous.expires_at;
+
+-- name: SlowDownDeviceAuthorization :exec
+UPDATE DeviceAuthorizations SET polling_interval = polling_interval + $2 WHERE device_code_hash = $1;
+
+-- name: DeleteDeviceAuthorization :execrows
+DELETE FROM DeviceAuthorizations WHERE device_code_hash = $1;
+
+-- name: DeleteExpiredDeviceAuthorizations :execrows
+DELETE FROM DeviceAuthorizations WHERE device_code_hash IN (
+    SELECT device_code_hash FROM DeviceAuthorizations WHERE expires_at < NOW() LIMIT $1
+);
+
+-- name: RecordClientAssertion :execrows
+INSERT INTO ClientAssertions (client_id, assertion_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;
+
+-- name: DeleteExpiredClientAssertions :execrows
+DELETE FROM ClientAssertions WHERE (client_id, assertion_id) IN (
+    SELECT client_id, assertion_id FROM ClientAssertions WHERE expires_at < NOW() LIMIT $1
+);
+
+-- name: GetExternalLoginProvider :one
+SELECT provider_id, provider_name, provider_endpoint, slug, issuer, client_id, client_secret, scopes FROM ExternalLoginProviders WHERE slug = $1;
+
+-- name: CreateExternalLoginState :exec
+INSERT INTO ExternalLoginStates (state_hash, provider_id, nonce, code_verifier, remember_me, expires_at, user_id) VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ConsumeExternalLoginState :one
+DELETE FROM ExternalLoginStates WHERE state_hash = $1 RETURNING state_hash, provider_id, nonce, code_verifier, remember_me, expires_at, user_id;
+
+-- name: DeleteExpiredExternalLoginStates :execrows
+DELETE FROM ExternalLoginStates WHERE state_hash IN (
+    SELECT state_hash FROM ExternalLoginStates WHERE expires_at < NOW() LIMIT $1
+);
+
+-- name: GetUserLoginExternal :one
+SELECT user_id, provider_id, provider_access_token, provider_refresh_token, provider_subject, linked_at FROM UserLoginExternal WHERE provider_id = $1 AND provider_subject = $2;
+
+-- name: CreateUserLoginExternal :exec
+INSERT INTO UserLoginExternal (user_id, provider_id, provider_subject, provider_access_token, provider_refresh_token) VALUES ($1, $2, $3, $4, $5);
+
+-- name: UpdateUserLoginExternalTokens :exec
+UPDATE UserLoginExternal SET provider_access_token = $3, provider_refresh_token = $4 WHERE provider_id = $1 AND provider_subject = $2;
+
+-- name: ListUserLoginExternals :many
+SELECT p.slug, p.provider_name, ule.provider_subject, ule.linked_at FROM UserLoginExternal ule
+JOIN ExternalLoginProviders p ON p.provider_id = ule.provider_id
+WHERE ule.user_id = $1
+ORDER BY ule.linked_at;
+
+-- name: DeleteUserLoginExternal :execrows
+DELETE FROM UserLoginExternal WHERE user_id = $1 AND provider_id = $2;
+
+-- name: LockUser :one
+SELECT user_id FROM Users WHERE user_id = $1 FOR UPDATE;
+
+-- name: CountUserLoginMethods :one
+-- CountUserLoginMethods counts the password, the external and the SAML identities a user can log in with
+SELECT ((SELECT COUNT(*) FROM UsersLoginData ld WHERE ld.user_id = $1)
+    + (SELECT COUNT(*) FROM UserLoginExternal ule WHERE ule.user_id = $1)
+    + (SELECT COUNT(*) FROM UserLoginSAML uls WHERE uls.user_id = $1))::bigint AS login_methods;
+
+-- name: ListUserLoginExternalSecrets :many
+SELECT provider_id, provider_subject, provider_access_token, provider_refresh_token FROM UserLoginExternal
+WHERE (provider_id, provider_subject) > (sqlc.arg(after_provider_id)::uuid, sqlc.arg(after_provider_subject)::text)
+ORDER BY provider_id, provider_subject
+LIMIT sqlc.arg(batch_size);
+
+-- name: ReencryptUserLoginExternalTokens :execrows
+UPDATE UserLoginExternal SET provider_access_token = sqlc.arg(access_token), provider_refresh_token = sqlc.arg(refresh_token)
+WHERE provider_id = sqlc.arg(provider_id) AND provider_subject = sqlc.arg(provider_subject)
+AND provider_access_token = sqlc.arg(previous_access_token) AND provider_refresh_token = sqlc.arg(previous_refresh_token);
+
+-- name: ListExternalLoginProviderSecrets :many
+SELECT provider_id, client_secret FROM ExternalLoginProviders WHERE client_secret <> '';
+
+-- name: ReencryptExternalLoginProviderSecret :execrows
+UPDATE ExternalLoginProviders SET client_secret = sqlc.arg(client_secret)
+WHERE provider_id = sqlc.arg(provider_id) AND client_secret = sqlc.arg(previous_client_secret);
+
+-- name: ListExternalLoginStateSecrets :many
+SELECT state_hash, code_verifier FROM ExternalLoginStates WHERE expires_at > NOW();
+
+-- name: ReencryptExternalLoginStateSecret :execrows
+UPDATE ExternalLoginStates SET code_verifier = sqlc.arg(code_verifier)
+WHERE state_hash = sqlc.arg(state_hash) AND code_verifier = sqlc.arg(previous_code_verifier);
+
+-- name: CreateOrganization :one
+INSERT INTO Organizations (slug, name) VALUES ($1, $2) RETURNING organization_id, slug, name, created_at;
+
+-- name: GetOrganization :one
+SELECT organization_id, slug, name, created_at FROM Organizations WHERE slug = $1;
+
+-- name: ListOrganizations :many
+SELECT organization_id, slug, name, created_at FROM Organizations ORDER BY slug;
+
+-- name: GetSAMLIdentityProvider :one
+SELECT organization_id, entity_id, sso_url, certificates, first_name_attribute, last_name_attribute, email_attribute, jit_provisioning, updated_at
+FROM SAMLIdentityProviders WHERE organization_id = $1;
+
+-- name: UpsertSAMLIdentityProvider :exec
+INSERT INTO SAMLIdentityProviders (organization_id, entity_id, sso_url, certificates, first_name_attribute, last_name_attribute, email_attribute, jit_provisioning)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (organization_id) DO UPDATE SET entity_id = EXCLUDED.entity_id, sso_url = EXCLUDED.sso_url,
+    certificates = EXCLUDED.certificates, first_name_attribute = EXCLUDED.first_name_attribute,
+    last_name_attribute = EXCLUDED.last_name_attribute, email_attribute = EXCLUDED.email_attribute,
+    jit_provisioning = EXCLUDED.jit_provisioning, updated_at = NOW();
+
+-- name: DeleteSAMLIdentityProvider :execrows
+DELETE FROM SAMLIdentityProviders WHERE organization_id = $1;
+
+-- name: CreateSAMLRequest :exec
+INSERT INTO SAMLRequests (request_id, organization_id, relay_state_hash, remember_me, expires_at) VALUES ($1, $2, $3, $4, $5);
+
+-- name: ConsumeSAMLRequest :one
+DELETE FROM SAMLRequests WHERE request_id = $1 RETURNING request_id, organization_id, relay_state_hash, remember_me, expires_at;
+
+-- name: DeleteExpiredSAMLRequests :execrows
+DELETE FROM SAMLRequests WHERE request_id IN (
+    SELECT request_id FROM SAMLRequests WHERE expires_at < NOW() LIMIT $1
+);
+
+-- name: GetUserLoginSAML :one
+SELECT organization_id, name_id, user_id, email, linked_at FROM UserLoginSAML WHERE organization_id = $1 AND name_id = $2;
+
+-- name: CreateUserLoginSAML :exec
+INSERT INTO UserLoginSAML (organization_id, name_id, user_id, email) VALUES ($1, $2, $3, $4);
+
+-- name: UpdateUserLoginSAMLEmail :exec
+UPDATE UserLoginSAML SET email = $3 WHERE organization_id = $1 AND name_id = $2;
+
+-- name: UpdateUserNames :exec
+UPDATE Users SET first_name = $2, last_name = $3 WHERE user_id = $1;
+
+-- name: ListUsers :many
+SELECT u.user_id, u.first_name, u.last_name, u.created_at, u.disabled_at, ld.email, ld.email_verified, ld.hashalgorithm FROM Users u
+LEFT JOIN UsersLoginData ld ON ld.user_id = u.user_id
+WHERE (sqlc.narg(after_created_at)::timestamp IS NULL
+        OR (u.created_at, u.user_id) > (sqlc.narg(after_created_at)::timestamp, sqlc.narg(after_user_id)::uuid))
+    AND (sqlc.narg(search)::text IS NULL
+        OR ld.email ILIKE '%' || sqlc.narg(search) || '%'
+        OR u.first_name || ' ' || u.last_name ILIKE '%' || sqlc.narg(search) || '%')
+    AND (sqlc.narg(role_name)::text IS NULL
+        OR EXISTS (SELECT 1 FROM UserRoles ur WHERE ur.user_id = u.user_id AND ur.role_name = sqlc.narg(role_name)))
+    AND (sqlc.narg(disabled)::boolean IS NULL OR (u.disabled_at IS NOT NULL) = sqlc.narg(disabled))
+ORDER BY u.created_at, u.user_id
+LIMIT sqlc.arg(page_size);
+
+-- name: GetUserAccount :one
+SELECT u.user_id, u.first_name, u.last_name, u.created_at, u.disabled_at, ld.email, ld.email_verified, ld.hashalgorithm FROM Users u
+LEFT JOIN UsersLoginData ld ON ld.user_id = u.user_id
+WHERE u.user_id = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE UsersLoginData SET passwordhash = $2, passwordsalt = $3 WHERE user_id = $1;
+
+-- name: ListUserSessions :many
+SELECT session_id, user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash FROM Sessions
+WHERE user_id = $1 AND expires_at > NOW() AND absolute_expires_at > NOW()
+ORDER BY created_at;
+
+-- name: DeleteUserSessions :execrows
+DELETE FROM Sessions WHERE user_id = $1;
+
+-- name: SetUserDisabledAt :execrows
+UPDATE Users SET disabled_at = $2 WHERE user_id = $1;
+
+-- name: UpdateUserEmail :execrows
+UPDATE UsersLoginData SET email = $2, email_verified = FALSE WHERE user_id = $1;
+
+-- name: CreateLoginEvent :exec
+INSERT INTO LoginEvents (user_id, occurred_at, login_method, succeeded, failure_reason, ip_address, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListUserLoginEvents :many
+SELECT event_id, user_id, occurred_at, login_method, succeeded, failure_reason, ip_address, user_agent FROM LoginEvents
+WHERE user_id = $1
+ORDER BY occurred_at DESC
+LIMIT $2;
+
+-- name: DeleteOldLoginEvents :execrows
+DELETE FROM LoginEvents WHERE event_id IN (
+    SELECT event_id FROM LoginEvents WHERE occurred_at < sqlc.arg(occurred_before) LIMIT sqlc.arg(batch_size)
+);
+
+-- name: UpsertPasswordRecovery :exec
+INSERT INTO PasswordRecovery (user_id, recovery_token) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET recovery_token = EXCLUDED.recovery_token, created_at = CURRENT_TIMESTAMP, used = FALSE;
+
+-- name: ConsumePasswordRecovery :one
+UPDATE PasswordRecovery SET used = TRUE
+WHERE recovery_token = sqlc.arg(recovery_token) AND NOT used
+    AND created_at >= LOCALTIMESTAMP - sqlc.arg(max_age_seconds)::int * INTERVAL '1 second'
+RETURNING user_id;
@@ -7,10 +7,206 @@ package betalinkauth
 
 import (
 	"context"
+	"net/netip"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const advisoryUnlock = `-- name: AdvisoryUnlock :one
+SELECT pg_advisory_unlock($1::bigint)
+`
+
+func (q *Queries) AdvisoryUnlock(ctx context.Context, lockID int64) (bool, error) {
+	row := q.db.QueryRow(ctx, advisoryUnlock, lockID)
+	var pg_advisory_unlock bool
+	err := row.Scan(&pg_advisory_unlock)
+	return pg_advisory_unlock, err
+}
+
+const assignUserRole = `-- name: AssignUserRole :exec
+INSERT INTO UserRoles (user_id, role_name) VALUES ($1, $2) ON CONFLICT DO NOTHING
+`
+
+type AssignUserRoleParams struct {
+	UserID   pgtype.UUID
+	RoleName string
+}
+
+func (q *Queries) AssignUserRole(ctx context.Context, arg AssignUserRoleParams) error {
+	_, err := q.db.Exec(ctx, assignUserRole, arg.UserID, arg.RoleName)
+	return err
+}
+
+const consumeAuthorizationCode = `-- name: ConsumeAuthorizationCode :one
+DELETE FROM AuthorizationCodes WHERE code_hash = $1 RETURNING code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, ip_address, user_agent, auth_time, expires_at, redirect_uri_supplied
+`
+
+func (q *Queries) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (Authorizationcode, error) {
+	row := q.db.QueryRow(ctx, consumeAuthorizationCode, codeHash)
+	var i Authorizationcode
+	err := row.Scan(
+		&i.CodeHash,
+		&i.ClientID,
+		&i.UserID,
+		&i.RedirectUri,
+		&i.Scope,
+		&i.Nonce,
+		&i.CodeChallenge,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.AuthTime,
+		&i.ExpiresAt,
+		&i.RedirectUriSupplied,
+	)
+	return i, err
+}
+
+const consumeExternalLoginState = `-- name: ConsumeExternalLoginState :one
+DELETE FROM ExternalLoginStates WHERE state_hash = $1 RETURNING state_hash, provider_id, nonce, code_verifier, remember_me, expires_at, user_id
+`
+
+func (q *Queries) ConsumeExternalLoginState(ctx context.Context, stateHash string) (Externalloginstate, error) {
+	row := q.db.QueryRow(ctx, consumeExternalLoginState, stateHash)
+	var i Externalloginstate
+	err := row.Scan(
+		&i.StateHash,
+		&i.ProviderID,
+		&i.Nonce,
+		&i.CodeVerifier,
+		&i.RememberMe,
+		&i.ExpiresAt,
+		&i.UserID,
+	)
+	return i, err
+}
+
+const consumePasswordRecovery = `-- name: ConsumePasswordRecovery :one
+UPDATE PasswordRecovery SET used = TRUE
+WHERE recovery_token = $1 AND NOT used
+    AND created_at >= LOCALTIMESTAMP - $2::int * INTERVAL '1 second'
+RETURNING user_id
+`
+
+type ConsumePasswordRecoveryParams struct {
+	RecoveryToken string
+	MaxAgeSeconds int32
+}
+
+func (q *Queries) ConsumePasswordRecovery(ctx context.Context, arg ConsumePasswordRecoveryParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, consumePasswordRecovery, arg.RecoveryToken, arg.MaxAgeSeconds)
+	var user_id pgtype.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const consumeSAMLRequest = `-- name: ConsumeSAMLRequest :one
+DELETE FROM SAMLRequests WHERE request_id = $1 RETURNING request_id, organization_id, relay_state_hash, remember_me, expires_at
+`
+
+func (q *Queries) ConsumeSAMLRequest(ctx context.Context, requestID string) (Samlrequest, error) {
+	row := q.db.QueryRow(ctx, consumeSAMLRequest, requestID)
+	var i Samlrequest
+	err := row.Scan(
+		&i.RequestID,
+		&i.OrganizationID,
+		&i.RelayStateHash,
+		&i.RememberMe,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const countActiveSessions = `-- name: CountActiveSessions :one
+SELECT COUNT(*) FROM Sessions WHERE user_id = $1 AND expires_at > NOW()
+`
+
+func (q *Queries) CountActiveSessions(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countActiveSessions, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserLoginMethods = `-- name: CountUserLoginMethods :one
+SELECT ((SELECT COUNT(*) FROM UsersLoginData ld WHERE ld.user_id = $1)
+    + (SELECT COUNT(*) FROM UserLoginExternal ule WHERE ule.user_id = $1)
+    + (SELECT COUNT(*) FROM UserLoginSAML uls WHERE uls.user_id = $1))::bigint AS login_methods
+`
+
+// CountUserLoginMethods counts the password, the external and the SAML identities a user can log in with
+func (q *Queries) CountUserLoginMethods(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countUserLoginMethods, userID)
+	var login_methods int64
+	err := row.Scan(&login_methods)
+	return login_methods, err
+}
+
+const createAuthorizationCode = `-- name: CreateAuthorizationCode :exec
+INSERT INTO AuthorizationCodes (code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, ip_address, user_agent, auth_time, expires_at, redirect_uri_supplied) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+`
+
+type CreateAuthorizationCodeParams struct {
+	CodeHash            string
+	ClientID            string
+	UserID              pgtype.UUID
+	RedirectUri         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	IpAddress           *netip.Addr
+	UserAgent           string
+	AuthTime            pgtype.Timestamptz
+	ExpiresAt           pgtype.Timestamptz
+	RedirectUriSupplied bool
+}
+
+func (q *Queries) CreateAuthorizationCode(ctx context.Context, arg CreateAuthorizationCodeParams) error {
+	_, err := q.db.Exec(ctx, createAuthorizationCode,
+		arg.CodeHash,
+		arg.ClientID,
+		arg.UserID,
+		arg.RedirectUri,
+		arg.Scope,
+		arg.Nonce,
+		arg.CodeChallenge,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.AuthTime,
+		arg.ExpiresAt,
+		arg.RedirectUriSupplied,
+	)
+	return err
+}
+
+const createDeviceAuthorization = `-- name: CreateDeviceAuthorization :exec
+INSERT INTO DeviceAuthorizations (device_code_hash, user_code, client_id, scope, polling_interval, ip_address, user_agent, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+type CreateDeviceAuthorizationParams struct {
+	DeviceCodeHash  string
+	UserCode        string
+	ClientID        string
+	Scope           string
+	PollingInterval int32
+	IpAddress       *netip.Addr
+	UserAgent       string
+	ExpiresAt       pgtype.Timestamptz
+}
+
+func (q *Queries) CreateDeviceAuthorization(ctx context.Context, arg CreateDeviceAuthorizationParams) error {
+	_, err := q.db.Exec(ctx, createDeviceAuthorization,
+		arg.DeviceCodeHash,
+		arg.UserCode,
+		arg.ClientID,
+		arg.Scope,
+		arg.PollingInterval,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const createEmailVerification = `-- name: CreateEmailVerification :exec
 INSERT INTO EmailVerification (user_id, verification_token) VALUES ($1, $2)
 `
@@ -25,6 +221,112 @@ func (q *Queries) CreateEmailVerification(ctx context.Context, arg CreateEmailVe
 	return err
 }
 
+const createExternalLoginState = `-- name: CreateExternalLoginState :exec
+INSERT INTO ExternalLoginStates (state_hash, provider_id, nonce, code_verifier, remember_me, expires_at, user_id) VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateExternalLoginStateParams struct {
+	StateHash    string
+	ProviderID   pgtype.UUID
+	Nonce        string
+	CodeVerifier string
+	RememberMe   bool
+	ExpiresAt    pgtype.Timestamptz
+	UserID       pgtype.UUID
+}
+
+func (q *Queries) CreateExternalLoginState(ctx context.Context, arg CreateExternalLoginStateParams) error {
+	_, err := q.db.Exec(ctx, createExternalLoginState,
+		arg.StateHash,
+		arg.ProviderID,
+		arg.Nonce,
+		arg.CodeVerifier,
+		arg.RememberMe,
+		arg.ExpiresAt,
+		arg.UserID,
+	)
+	return err
+}
+
+const createLoginEvent = `-- name: CreateLoginEvent :exec
+INSERT INTO LoginEvents (user_id, occurred_at, login_method, succeeded, failure_reason, ip_address, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateLoginEventParams struct {
+	UserID        pgtype.UUID
+	OccurredAt    pgtype.Timestamptz
+	LoginMethod   string
+	Succeeded     bool
+	FailureReason string
+	IpAddress     *netip.Addr
+	UserAgent     string
+}
+
+func (q *Queries) CreateLoginEvent(ctx context.Context, arg CreateLoginEventParams) error {
+	_, err := q.db.Exec(ctx, createLoginEvent,
+		arg.UserID,
+		arg.OccurredAt,
+		arg.LoginMethod,
+		arg.Succeeded,
+		arg.FailureReason,
+		arg.IpAddress,
+		arg.UserAgent,
+	)
+	return err
+}
+
+const createOAuthClient = `-- name: CreateOAuthClient :exec
+INSERT INTO OAuthClients (client_id, client_secret_hash, client_name, client_type, redirect_uris, allowed_scopes, grant_types, allowed_audiences, public_key) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+
+type CreateOAuthClientParams struct {
+	ClientID         string
+	ClientSecretHash string
+	ClientName       string
+	ClientType       string
+	RedirectUris     []string
+	AllowedScopes    []string
+	GrantTypes       []string
+	AllowedAudiences []string
+	PublicKey        string
+}
+
+func (q *Queries) CreateOAuthClient(ctx context.Context, arg CreateOAuthClientParams) error {
+	_, err := q.db.Exec(ctx, createOAuthClient,
+		arg.ClientID,
+		arg.ClientSecretHash,
+		arg.ClientName,
+		arg.ClientType,
+		arg.RedirectUris,
+		arg.AllowedScopes,
+		arg.GrantTypes,
+		arg.AllowedAudiences,
+		arg.PublicKey,
+	)
+	return err
+}
+
+const createOrganization = `-- name: CreateOrganization :one
+INSERT INTO Organizations (slug, name) VALUES ($1, $2) RETURNING organization_id, slug, name, created_at
+`
+
+type CreateOrganizationParams struct {
+	Slug string
+	Name string
+}
+
+func (q *Queries) CreateOrganization(ctx context.Context, arg CreateOrganizationParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, createOrganization, arg.Slug, arg.Name)
+	var i Organization
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Slug,
+		&i.Name,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const createPasswordRecovery = `-- name: CreatePasswordRecovery :exec
 INSERT INTO PasswordRecovery (user_id, recovery_token) VALUES ($1, $2)
 `
@@ -39,15 +341,101 @@ func (q *Queries) CreatePasswordRecovery(ctx context.Context, arg CreatePassword
 	return err
 }
 
+const createPermission = `-- name: CreatePermission :exec
+INSERT INTO Permissions (permission_name, description) VALUES ($1, $2)
+`
+
+type CreatePermissionParams struct {
+	PermissionName string
+	Description    string
+}
+
+func (q *Queries) CreatePermission(ctx context.Context, arg CreatePermissionParams) error {
+	_, err := q.db.Exec(ctx, createPermission, arg.PermissionName, arg.Description)
+	return err
+}
+
+const createPolicy = `-- name: CreatePolicy :exec
+INSERT INTO Policies (policy_name, description, action, effect, expression, enabled) VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type CreatePolicyParams struct {
+	PolicyName  string
+	Description string
+	Action      string
+	Effect      string
+	Expression  string
+	Enabled     bool
+}
+
+func (q *Queries) CreatePolicy(ctx context.Context, arg CreatePolicyParams) error {
+	_, err := q.db.Exec(ctx, createPolicy,
+		arg.PolicyName,
+		arg.Description,
+		arg.Action,
+		arg.Effect,
+		arg.Expression,
+		arg.Enabled,
+	)
+	return err
+}
+
+const createRole = `-- name: CreateRole :exec
+INSERT INTO Roles (role_name, description, max_sessions) VALUES ($1, $2, $3)
+`
+
+type CreateRoleParams struct {
+	RoleName    string
+	Description string
+	MaxSessions pgtype.Int4
+}
+
+func (q *Queries) CreateRole(ctx context.Context, arg CreateRoleParams) error {
+	_, err := q.db.Exec(ctx, createRole, arg.RoleName, arg.Description, arg.MaxSessions)
+	return err
+}
+
+const createSAMLRequest = `-- name: CreateSAMLRequest :exec
+INSERT INTO SAMLRequests (request_id, organization_id, relay_state_hash, remember_me, expires_at) VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateSAMLRequestParams struct {
+	RequestID      string
+	OrganizationID pgtype.UUID
+	RelayStateHash string
+	RememberMe     bool
+	ExpiresAt      pgtype.Timestamptz
+}
+
+func (q *Queries) CreateSAMLRequest(ctx context.Context, arg CreateSAMLRequestParams) error {
+	_, err := q.db.Exec(ctx, createSAMLRequest,
+		arg.RequestID,
+		arg.OrganizationID,
+		arg.RelayStateHash,
+		arg.RememberMe,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const createSession = `-- name: CreateSession :one
-INSERT INTO Sessions (user_id, created_at, updated_at, expires_at) VALUES ($1, $2, $3, $4) RETURNING session_id
+INSERT INTO Sessions (user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING session_id
 `
 
 type CreateSessionParams struct {
-	UserID    pgtype.UUID
-	CreatedAt pgtype.Timestamptz
-	UpdatedAt pgtype.Timestamptz
-	ExpiresAt pgtype.Timestamptz
+	UserID            pgtype.UUID
+	CreatedAt         pgtype.Timestamptz
+	UpdatedAt         pgtype.Timestamptz
+	ExpiresAt         pgtype.Timestamptz
+	IpAddress         *netip.Addr
+	UserAgent         string
+	Device            string
+	LoginMethod       string
+	AbsoluteExpiresAt pgtype.Timestamptz
+	RememberMe        bool
+	ClientID          pgtype.Text
+	Scope             string
+	RefreshTokenHash  pgtype.Text
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (pgtype.UUID, error) {
@@ -56,6 +444,15 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (p
 		arg.CreatedAt,
 		arg.UpdatedAt,
 		arg.ExpiresAt,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.Device,
+		arg.LoginMethod,
+		arg.AbsoluteExpiresAt,
+		arg.RememberMe,
+		arg.ClientID,
+		arg.Scope,
+		arg.RefreshTokenHash,
 	)
 	var session_id pgtype.UUID
 	err := row.Scan(&session_id)
@@ -101,76 +498,1668 @@ func (q *Queries) CreateUserLoginData(ctx context.Context, arg CreateUserLoginDa
 	return err
 }
 
-const deleteSession = `-- name: DeleteSession :exec
-DELETE FROM Sessions WHERE session_id = $1
+const createUserLoginExternal = `-- name: CreateUserLoginExternal :exec
+INSERT INTO UserLoginExternal (user_id, provider_id, provider_subject, provider_access_token, provider_refresh_token) VALUES ($1, $2, $3, $4, $5)
 `
 
-func (q *Queries) DeleteSession(ctx context.Context, sessionID pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, deleteSession, sessionID)
+type CreateUserLoginExternalParams struct {
+	UserID               pgtype.UUID
+	ProviderID           pgtype.UUID
+	ProviderSubject      string
+	ProviderAccessToken  string
+	ProviderRefreshToken string
+}
+
+func (q *Queries) CreateUserLoginExternal(ctx context.Context, arg CreateUserLoginExternalParams) error {
+	_, err := q.db.Exec(ctx, createUserLoginExternal,
+		arg.UserID,
+		arg.ProviderID,
+		arg.ProviderSubject,
+		arg.ProviderAccessToken,
+		arg.ProviderRefreshToken,
+	)
 	return err
 }
 
-const getLoginDataByEmail = `-- name: GetLoginDataByEmail :one
-SELECT user_id, email, passwordHash, passwordSalt, hashAlgorithm FROM UsersLoginData WHERE email = $1
+const createUserLoginSAML = `-- name: CreateUserLoginSAML :exec
+INSERT INTO UserLoginSAML (organization_id, name_id, user_id, email) VALUES ($1, $2, $3, $4)
 `
 
-func (q *Queries) GetLoginDataByEmail(ctx context.Context, email string) (Userslogindatum, error) {
-	row := q.db.QueryRow(ctx, getLoginDataByEmail, email)
-	var i Userslogindatum
-	err := row.Scan(
-		&i.UserID,
-		&i.Email,
-		&i.Passwordhash,
-		&i.Passwordsalt,
-		&i.Hashalgorithm,
+type CreateUserLoginSAMLParams struct {
+	OrganizationID pgtype.UUID
+	NameID         string
+	UserID         pgtype.UUID
+	Email          string
+}
+
+func (q *Queries) CreateUserLoginSAML(ctx context.Context, arg CreateUserLoginSAMLParams) error {
+	_, err := q.db.Exec(ctx, createUserLoginSAML,
+		arg.OrganizationID,
+		arg.NameID,
+		arg.UserID,
+		arg.Email,
 	)
-	return i, err
+	return err
 }
 
-const getSessionById = `-- name: GetSessionById :one
-SELECT session_id, user_id, created_at, updated_at, expires_at FROM Sessions WHERE session_id = $1
+const decideDeviceAuthorization = `-- name: DecideDeviceAuthorization :execrows
+UPDATE DeviceAuthorizations SET status = $2, user_id = $3, auth_time = $4 WHERE user_code = $1 AND status = 'pending' AND expires_at > NOW()
 `
 
-func (q *Queries) GetSessionById(ctx context.Context, sessionID pgtype.UUID) (Session, error) {
-	row := q.db.QueryRow(ctx, getSessionById, sessionID)
-	var i Session
-	err := row.Scan(
-		&i.SessionID,
-		&i.UserID,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.ExpiresAt,
+type DecideDeviceAuthorizationParams struct {
+	UserCode string
+	Status   string
+	UserID   pgtype.UUID
+	AuthTime pgtype.Timestamptz
+}
+
+func (q *Queries) DecideDeviceAuthorization(ctx context.Context, arg DecideDeviceAuthorizationParams) (int64, error) {
+	result, err := q.db.Exec(ctx, decideDeviceAuthorization,
+		arg.UserCode,
+		arg.Status,
+		arg.UserID,
+		arg.AuthTime,
 	)
-	return i, err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-const getUserById = `-- name: GetUserById :one
-SELECT user_id, first_name, last_name FROM Users WHERE user_id = $1
+const deleteDeviceAuthorization = `-- name: DeleteDeviceAuthorization :execrows
+DELETE FROM DeviceAuthorizations WHERE device_code_hash = $1
 `
 
-type GetUserByIdRow struct {
-	UserID    pgtype.UUID
-	FirstName string
-	LastName  string
+func (q *Queries) DeleteDeviceAuthorization(ctx context.Context, deviceCodeHash string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteDeviceAuthorization, deviceCodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-func (q *Queries) GetUserById(ctx context.Context, userID pgtype.UUID) (GetUserByIdRow, error) {
-	row := q.db.QueryRow(ctx, getUserById, userID)
-	var i GetUserByIdRow
-	err := row.Scan(&i.UserID, &i.FirstName, &i.LastName)
-	return i, err
+const deleteExpiredAuthorizationCodes = `-- name: DeleteExpiredAuthorizationCodes :execrows
+DELETE FROM AuthorizationCodes WHERE code_hash IN (
+    SELECT code_hash FROM AuthorizationCodes WHERE expires_at < NOW() LIMIT $1
+)
+`
+
+func (q *Queries) DeleteExpiredAuthorizationCodes(ctx context.Context, limit int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredAuthorizationCodes, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-const test_UpdateSessionExpiresAt = `-- name: Test_UpdateSessionExpiresAt :exec
-UPDATE Sessions SET expires_at = $1 WHERE session_id = $2
+const deleteExpiredClientAssertions = `-- name: DeleteExpiredClientAssertions :execrows
+DELETE FROM ClientAssertions WHERE (client_id, assertion_id) IN (
+    SELECT client_id, assertion_id FROM ClientAssertions WHERE expires_at < NOW() LIMIT $1
+)
 `
 
-type Test_UpdateSessionExpiresAtParams struct {
-	ExpiresAt pgtype.Timestamptz
-	SessionID pgtype.UUID
+func (q *Queries) DeleteExpiredClientAssertions(ctx context.Context, limit int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredClientAssertions, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-func (q *Queries) Test_UpdateSessionExpiresAt(ctx context.Context, arg Test_UpdateSessionExpiresAtParams) error {
-	_, err := q.db.Exec(ctx, test_UpdateSessionExpiresAt, arg.ExpiresAt, arg.SessionID)
+const deleteExpiredDeviceAuthorizations = `-- name: DeleteExpiredDeviceAuthorizations :execrows
+DELETE FROM DeviceAuthorizations WHERE device_code_hash IN (
+    SELECT device_code_hash FROM DeviceAuthorizations WHERE expires_at < NOW() LIMIT $1
+)
+`
+
+func (q *Queries) DeleteExpiredDeviceAuthorizations(ctx context.Context, limit int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredDeviceAuthorizations, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredExternalLoginStates = `-- name: DeleteExpiredExternalLoginStates :execrows
+DELETE FROM ExternalLoginStates WHERE state_hash IN (
+    SELECT state_hash FROM ExternalLoginStates WHERE expires_at < NOW() LIMIT $1
+)
+`
+
+func (q *Queries) DeleteExpiredExternalLoginStates(ctx context.Context, limit int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredExternalLoginStates, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredRevokedAccessTokens = `-- name: DeleteExpiredRevokedAccessTokens :execrows
+DELETE FROM RevokedAccessTokens WHERE token_id IN (
+    SELECT token_id FROM RevokedAccessTokens WHERE expires_at < NOW() LIMIT $1
+)
+`
+
+func (q *Queries) DeleteExpiredRevokedAccessTokens(ctx context.Context, limit int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredRevokedAccessTokens, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredSAMLRequests = `-- name: DeleteExpiredSAMLRequests :execrows
+DELETE FROM SAMLRequests WHERE request_id IN (
+    SELECT request_id FROM SAMLRequests WHERE expires_at < NOW() LIMIT $1
+)
+`
+
+func (q *Queries) DeleteExpiredSAMLRequests(ctx context.Context, limit int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredSAMLRequests, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :execrows
+DELETE FROM Sessions WHERE session_id IN (
+    SELECT session_id FROM Sessions WHERE expires_at < NOW() LIMIT $1
+)
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, limit int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredSessions, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOAuthClient = `-- name: DeleteOAuthClient :execrows
+DELETE FROM OAuthClients WHERE client_id = $1
+`
+
+func (q *Queries) DeleteOAuthClient(ctx context.Context, clientID string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOAuthClient, clientID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOldLoginEvents = `-- name: DeleteOldLoginEvents :execrows
+DELETE FROM LoginEvents WHERE event_id IN (
+    SELECT event_id FROM LoginEvents WHERE occurred_at < $1 LIMIT $2
+)
+`
+
+type DeleteOldLoginEventsParams struct {
+	OccurredBefore pgtype.Timestamptz
+	BatchSize      int32
+}
+
+func (q *Queries) DeleteOldLoginEvents(ctx context.Context, arg DeleteOldLoginEventsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOldLoginEvents, arg.OccurredBefore, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOldestActiveSessions = `-- name: DeleteOldestActiveSessions :execrows
+DELETE FROM Sessions WHERE session_id IN (
+    SELECT session_id FROM Sessions
+    WHERE user_id = $1 AND expires_at > NOW()
+    ORDER BY created_at ASC
+    LIMIT $2
+)
+`
+
+type DeleteOldestActiveSessionsParams struct {
+	UserID pgtype.UUID
+	Limit  int32
+}
+
+func (q *Queries) DeleteOldestActiveSessions(ctx context.Context, arg DeleteOldestActiveSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOldestActiveSessions, arg.UserID, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deletePermission = `-- name: DeletePermission :execrows
+DELETE FROM Permissions WHERE permission_name = $1
+`
+
+func (q *Queries) DeletePermission(ctx context.Context, permissionName string) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePermission, permissionName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deletePolicy = `-- name: DeletePolicy :execrows
+DELETE FROM Policies WHERE policy_name = $1
+`
+
+func (q *Queries) DeletePolicy(ctx context.Context, policyName string) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePolicy, policyName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteRole = `-- name: DeleteRole :execrows
+DELETE FROM Roles WHERE role_name = $1
+`
+
+func (q *Queries) DeleteRole(ctx context.Context, roleName string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteRole, roleName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteSAMLIdentityProvider = `-- name: DeleteSAMLIdentityProvider :execrows
+DELETE FROM SAMLIdentityProviders WHERE organization_id = $1
+`
+
+func (q *Queries) DeleteSAMLIdentityProvider(ctx context.Context, organizationID pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSAMLIdentityProvider, organizationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteSession = `-- name: DeleteSession :exec
+DELETE FROM Sessions WHERE session_id = $1
+`
+
+func (q *Queries) DeleteSession(ctx context.Context, sessionID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteSession, sessionID)
+	return err
+}
+
+const deleteStaleEmailVerifications = `-- name: DeleteStaleEmailVerifications :execrows
+DELETE FROM EmailVerification WHERE user_id IN (
+    SELECT user_id FROM EmailVerification
+    WHERE used OR created_at < LOCALTIMESTAMP - $1::int * INTERVAL '1 second'
+    LIMIT $2
+)
+`
+
+type DeleteStaleEmailVerificationsParams struct {
+	MaxAgeSeconds int32
+	BatchSize     int32
+}
+
+func (q *Queries) DeleteStaleEmailVerifications(ctx context.Context, arg DeleteStaleEmailVerificationsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteStaleEmailVerifications, arg.MaxAgeSeconds, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteStalePasswordRecoveries = `-- name: DeleteStalePasswordRecoveries :execrows
+DELETE FROM PasswordRecovery WHERE user_id IN (
+    SELECT user_id FROM PasswordRecovery
+    WHERE used OR created_at < LOCALTIMESTAMP - $1::int * INTERVAL '1 second'
+    LIMIT $2
+)
+`
+
+type DeleteStalePasswordRecoveriesParams struct {
+	MaxAgeSeconds int32
+	BatchSize     int32
+}
+
+func (q *Queries) DeleteStalePasswordRecoveries(ctx context.Context, arg DeleteStalePasswordRecoveriesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteStalePasswordRecoveries, arg.MaxAgeSeconds, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUserLoginExternal = `-- name: DeleteUserLoginExternal :execrows
+DELETE FROM UserLoginExternal WHERE user_id = $1 AND provider_id = $2
+`
+
+type DeleteUserLoginExternalParams struct {
+	UserID     pgtype.UUID
+	ProviderID pgtype.UUID
+}
+
+func (q *Queries) DeleteUserLoginExternal(ctx context.Context, arg DeleteUserLoginExternalParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserLoginExternal, arg.UserID, arg.ProviderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUserSessions = `-- name: DeleteUserSessions :execrows
+DELETE FROM Sessions WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserSessions(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserSessions, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getExternalLoginProvider = `-- name: GetExternalLoginProvider :one
+SELECT provider_id, provider_name, provider_endpoint, slug, issuer, client_id, client_secret, scopes FROM ExternalLoginProviders WHERE slug = $1
+`
+
+func (q *Queries) GetExternalLoginProvider(ctx context.Context, slug string) (Externalloginprovider, error) {
+	row := q.db.QueryRow(ctx, getExternalLoginProvider, slug)
+	var i Externalloginprovider
+	err := row.Scan(
+		&i.ProviderID,
+		&i.ProviderName,
+		&i.ProviderEndpoint,
+		&i.Slug,
+		&i.Issuer,
+		&i.ClientID,
+		&i.ClientSecret,
+		&i.Scopes,
+	)
+	return i, err
+}
+
+const getLoginDataByEmail = `-- name: GetLoginDataByEmail :one
+SELECT user_id, email, passwordHash, passwordSalt, hashAlgorithm, email_verified FROM UsersLoginData WHERE email = $1
+`
+
+func (q *Queries) GetLoginDataByEmail(ctx context.Context, email string) (Userslogindatum, error) {
+	row := q.db.QueryRow(ctx, getLoginDataByEmail, email)
+	var i Userslogindatum
+	err := row.Scan(
+		&i.UserID,
+		&i.Email,
+		&i.Passwordhash,
+		&i.Passwordsalt,
+		&i.Hashalgorithm,
+		&i.EmailVerified,
+	)
+	return i, err
+}
+
+const getOAuthClient = `-- name: GetOAuthClient :one
+SELECT client_id, client_secret_hash, client_name, created_at, client_type, redirect_uris, allowed_scopes, grant_types, allowed_audiences, public_key FROM OAuthClients WHERE client_id = $1
+`
+
+func (q *Queries) GetOAuthClient(ctx context.Context, clientID string) (Oauthclient, error) {
+	row := q.db.QueryRow(ctx, getOAuthClient, clientID)
+	var i Oauthclient
+	err := row.Scan(
+		&i.ClientID,
+		&i.ClientSecretHash,
+		&i.ClientName,
+		&i.CreatedAt,
+		&i.ClientType,
+		&i.RedirectUris,
+		&i.AllowedScopes,
+		&i.GrantTypes,
+		&i.AllowedAudiences,
+		&i.PublicKey,
+	)
+	return i, err
+}
+
+const getOrganization = `-- name: GetOrganization :one
+SELECT organization_id, slug, name, created_at FROM Organizations WHERE slug = $1
+`
+
+func (q *Queries) GetOrganization(ctx context.Context, slug string) (Organization, error) {
+	row := q.db.QueryRow(ctx, getOrganization, slug)
+	var i Organization
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Slug,
+		&i.Name,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getPendingDeviceAuthorization = `-- name: GetPendingDeviceAuthorization :one
+SELECT device_code_hash, user_code, client_id, scope, status, user_id, auth_time, polling_interval, last_polled_at, ip_address, user_agent, expires_at FROM DeviceAuthorizations WHERE user_code = $1 AND status = 'pending' AND expires_at > NOW()
+`
+
+func (q *Queries) GetPendingDeviceAuthorization(ctx context.Context, userCode string) (Deviceauthorization, error) {
+	row := q.db.QueryRow(ctx, getPendingDeviceAuthorization, userCode)
+	var i Deviceauthorization
+	err := row.Scan(
+		&i.DeviceCodeHash,
+		&i.UserCode,
+		&i.ClientID,
+		&i.Scope,
+		&i.Status,
+		&i.UserID,
+		&i.AuthTime,
+		&i.PollingInterval,
+		&i.LastPolledAt,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getPoliciesVersion = `-- name: GetPoliciesVersion :one
+SELECT COUNT(*) AS policy_count, COALESCE(MAX(updated_at), 'epoch'::timestamptz)::timestamptz AS last_updated_at FROM Policies
+`
+
+type GetPoliciesVersionRow struct {
+	PolicyCount   int64
+	LastUpdatedAt pgtype.Timestamptz
+}
+
+func (q *Queries) GetPoliciesVersion(ctx context.Context) (GetPoliciesVersionRow, error) {
+	row := q.db.QueryRow(ctx, getPoliciesVersion)
+	var i GetPoliciesVersionRow
+	err := row.Scan(&i.PolicyCount, &i.LastUpdatedAt)
+	return i, err
+}
+
+const getSAMLIdentityProvider = `-- name: GetSAMLIdentityProvider :one
+SELECT organization_id, entity_id, sso_url, certificates, first_name_attribute, last_name_attribute, email_attribute, jit_provisioning, updated_at
+FROM SAMLIdentityProviders WHERE organization_id = $1
+`
+
+func (q *Queries) GetSAMLIdentityProvider(ctx context.Context, organizationID pgtype.UUID) (Samlidentityprovider, error) {
+	row := q.db.QueryRow(ctx, getSAMLIdentityProvider, organizationID)
+	var i Samlidentityprovider
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.EntityID,
+		&i.SsoUrl,
+		&i.Certificates,
+		&i.FirstNameAttribute,
+		&i.LastNameAttribute,
+		&i.EmailAttribute,
+		&i.JitProvisioning,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getSessionById = `-- name: GetSessionById :one
+SELECT session_id, user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash FROM Sessions WHERE session_id = $1
+`
+
+func (q *Queries) GetSessionById(ctx context.Context, sessionID pgtype.UUID) (Session, error) {
+	row := q.db.QueryRow(ctx, getSessionById, sessionID)
+	var i Session
+	err := row.Scan(
+		&i.SessionID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ExpiresAt,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.Device,
+		&i.LoginMethod,
+		&i.AbsoluteExpiresAt,
+		&i.RememberMe,
+		&i.ClientID,
+		&i.Scope,
+		&i.RefreshTokenHash,
+	)
+	return i, err
+}
+
+const getSessionByRefreshTokenHash = `-- name: GetSessionByRefreshTokenHash :one
+SELECT session_id, user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash FROM Sessions WHERE refresh_token_hash = $1
+`
+
+func (q *Queries) GetSessionByRefreshTokenHash(ctx context.Context, refreshTokenHash pgtype.Text) (Session, error) {
+	row := q.db.QueryRow(ctx, getSessionByRefreshTokenHash, refreshTokenHash)
+	var i Session
+	err := row.Scan(
+		&i.SessionID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ExpiresAt,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.Device,
+		&i.LoginMethod,
+		&i.AbsoluteExpiresAt,
+		&i.RememberMe,
+		&i.ClientID,
+		&i.Scope,
+		&i.RefreshTokenHash,
+	)
+	return i, err
+}
+
+const getUserAccount = `-- name: GetUserAccount :one
+SELECT u.user_id, u.first_name, u.last_name, u.created_at, u.disabled_at, ld.email, ld.email_verified, ld.hashalgorithm FROM Users u
+LEFT JOIN UsersLoginData ld ON ld.user_id = u.user_id
+WHERE u.user_id = $1
+`
+
+type GetUserAccountRow struct {
+	UserID        pgtype.UUID
+	FirstName     string
+	LastName      string
+	CreatedAt     pgtype.Timestamp
+	DisabledAt    pgtype.Timestamptz
+	Email         pgtype.Text
+	EmailVerified pgtype.Bool
+	Hashalgorithm pgtype.Text
+}
+
+func (q *Queries) GetUserAccount(ctx context.Context, userID pgtype.UUID) (GetUserAccountRow, error) {
+	row := q.db.QueryRow(ctx, getUserAccount, userID)
+	var i GetUserAccountRow
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&i.CreatedAt,
+		&i.DisabledAt,
+		&i.Email,
+		&i.EmailVerified,
+		&i.Hashalgorithm,
+	)
+	return i, err
+}
+
+const getUserById = `-- name: GetUserById :one
+SELECT u.user_id, u.first_name, u.last_name, ld.email, ld.email_verified FROM Users u
+LEFT JOIN UsersLoginData ld ON ld.user_id = u.user_id
+WHERE u.user_id = $1
+`
+
+type GetUserByIdRow struct {
+	UserID        pgtype.UUID
+	FirstName     string
+	LastName      string
+	Email         pgtype.Text
+	EmailVerified pgtype.Bool
+}
+
+func (q *Queries) GetUserById(ctx context.Context, userID pgtype.UUID) (GetUserByIdRow, error) {
+	row := q.db.QueryRow(ctx, getUserById, userID)
+	var i GetUserByIdRow
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.EmailVerified,
+	)
+	return i, err
+}
+
+const getUserLoginExternal = `-- name: GetUserLoginExternal :one
+SELECT user_id, provider_id, provider_access_token, provider_refresh_token, provider_subject, linked_at FROM UserLoginExternal WHERE provider_id = $1 AND provider_subject = $2
+`
+
+type GetUserLoginExternalParams struct {
+	ProviderID      pgtype.UUID
+	ProviderSubject string
+}
+
+func (q *Queries) GetUserLoginExternal(ctx context.Context, arg GetUserLoginExternalParams) (Userloginexternal, error) {
+	row := q.db.QueryRow(ctx, getUserLoginExternal, arg.ProviderID, arg.ProviderSubject)
+	var i Userloginexternal
+	err := row.Scan(
+		&i.UserID,
+		&i.ProviderID,
+		&i.ProviderAccessToken,
+		&i.ProviderRefreshToken,
+		&i.ProviderSubject,
+		&i.LinkedAt,
+	)
+	return i, err
+}
+
+const getUserLoginSAML = `-- name: GetUserLoginSAML :one
+SELECT organization_id, name_id, user_id, email, linked_at FROM UserLoginSAML WHERE organization_id = $1 AND name_id = $2
+`
+
+type GetUserLoginSAMLParams struct {
+	OrganizationID pgtype.UUID
+	NameID         string
+}
+
+func (q *Queries) GetUserLoginSAML(ctx context.Context, arg GetUserLoginSAMLParams) (Userloginsaml, error) {
+	row := q.db.QueryRow(ctx, getUserLoginSAML, arg.OrganizationID, arg.NameID)
+	var i Userloginsaml
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.NameID,
+		&i.UserID,
+		&i.Email,
+		&i.LinkedAt,
+	)
+	return i, err
+}
+
+const getUserPermissions = `-- name: GetUserPermissions :many
+SELECT DISTINCT rp.permission_name FROM RolePermissions rp
+JOIN UserRoles ur ON ur.role_name = rp.role_name
+WHERE ur.user_id = $1
+ORDER BY rp.permission_name
+`
+
+func (q *Queries) GetUserPermissions(ctx context.Context, userID pgtype.UUID) ([]string, error) {
+	rows, err := q.db.Query(ctx, getUserPermissions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var permission_name string
+		if err := rows.Scan(&permission_name); err != nil {
+			return nil, err
+		}
+		items = append(items, permission_name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserRoles = `-- name: GetUserRoles :many
+SELECT r.role_name, r.description, r.max_sessions, r.created_at FROM Roles r
+JOIN UserRoles ur ON ur.role_name = r.role_name
+WHERE ur.user_id = $1
+ORDER BY r.role_name
+`
+
+func (q *Queries) GetUserRoles(ctx context.Context, userID pgtype.UUID) ([]Role, error) {
+	rows, err := q.db.Query(ctx, getUserRoles, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Role
+	for rows.Next() {
+		var i Role
+		if err := rows.Scan(
+			&i.RoleName,
+			&i.Description,
+			&i.MaxSessions,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const grantRolePermission = `-- name: GrantRolePermission :exec
+INSERT INTO RolePermissions (role_name, permission_name) VALUES ($1, $2) ON CONFLICT DO NOTHING
+`
+
+type GrantRolePermissionParams struct {
+	RoleName       string
+	PermissionName string
+}
+
+func (q *Queries) GrantRolePermission(ctx context.Context, arg GrantRolePermissionParams) error {
+	_, err := q.db.Exec(ctx, grantRolePermission, arg.RoleName, arg.PermissionName)
+	return err
+}
+
+const isAccessTokenRevoked = `-- name: IsAccessTokenRevoked :one
+SELECT EXISTS (SELECT 1 FROM RevokedAccessTokens WHERE token_id = $1)
+`
+
+func (q *Queries) IsAccessTokenRevoked(ctx context.Context, tokenID pgtype.UUID) (bool, error) {
+	row := q.db.QueryRow(ctx, isAccessTokenRevoked, tokenID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listExternalLoginProviderSecrets = `-- name: ListExternalLoginProviderSecrets :many
+SELECT provider_id, client_secret FROM ExternalLoginProviders WHERE client_secret <> ''
+`
+
+type ListExternalLoginProviderSecretsRow struct {
+	ProviderID   pgtype.UUID
+	ClientSecret string
+}
+
+func (q *Queries) ListExternalLoginProviderSecrets(ctx context.Context) ([]ListExternalLoginProviderSecretsRow, error) {
+	rows, err := q.db.Query(ctx, listExternalLoginProviderSecrets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListExternalLoginProviderSecretsRow
+	for rows.Next() {
+		var i ListExternalLoginProviderSecretsRow
+		if err := rows.Scan(&i.ProviderID, &i.ClientSecret); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExternalLoginStateSecrets = `-- name: ListExternalLoginStateSecrets :many
+SELECT state_hash, code_verifier FROM ExternalLoginStates WHERE expires_at > NOW()
+`
+
+type ListExternalLoginStateSecretsRow struct {
+	StateHash    string
+	CodeVerifier string
+}
+
+func (q *Queries) ListExternalLoginStateSecrets(ctx context.Context) ([]ListExternalLoginStateSecretsRow, error) {
+	rows, err := q.db.Query(ctx, listExternalLoginStateSecrets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListExternalLoginStateSecretsRow
+	for rows.Next() {
+		var i ListExternalLoginStateSecretsRow
+		if err := rows.Scan(&i.StateHash, &i.CodeVerifier); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOAuthClients = `-- name: ListOAuthClients :many
+SELECT client_id, client_secret_hash, client_name, created_at, client_type, redirect_uris, allowed_scopes, grant_types, allowed_audiences, public_key FROM OAuthClients ORDER BY client_name, client_id
+`
+
+func (q *Queries) ListOAuthClients(ctx context.Context) ([]Oauthclient, error) {
+	rows, err := q.db.Query(ctx, listOAuthClients)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Oauthclient
+	for rows.Next() {
+		var i Oauthclient
+		if err := rows.Scan(
+			&i.ClientID,
+			&i.ClientSecretHash,
+			&i.ClientName,
+			&i.CreatedAt,
+			&i.ClientType,
+			&i.RedirectUris,
+			&i.AllowedScopes,
+			&i.GrantTypes,
+			&i.AllowedAudiences,
+			&i.PublicKey,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizations = `-- name: ListOrganizations :many
+SELECT organization_id, slug, name, created_at FROM Organizations ORDER BY slug
+`
+
+func (q *Queries) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	rows, err := q.db.Query(ctx, listOrganizations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Organization
+	for rows.Next() {
+		var i Organization
+		if err := rows.Scan(
+			&i.OrganizationID,
+			&i.Slug,
+			&i.Name,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPermissions = `-- name: ListPermissions :many
+SELECT permission_name, description, created_at FROM Permissions ORDER BY permission_name
+`
+
+func (q *Queries) ListPermissions(ctx context.Context) ([]Permission, error) {
+	rows, err := q.db.Query(ctx, listPermissions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Permission
+	for rows.Next() {
+		var i Permission
+		if err := rows.Scan(&i.PermissionName, &i.Description, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPolicies = `-- name: ListPolicies :many
+SELECT policy_name, description, action, effect, expression, enabled, created_at, updated_at FROM Policies ORDER BY policy_name
+`
+
+func (q *Queries) ListPolicies(ctx context.Context) ([]Policy, error) {
+	rows, err := q.db.Query(ctx, listPolicies)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Policy
+	for rows.Next() {
+		var i Policy
+		if err := rows.Scan(
+			&i.PolicyName,
+			&i.Description,
+			&i.Action,
+			&i.Effect,
+			&i.Expression,
+			&i.Enabled,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRolePermissions = `-- name: ListRolePermissions :many
+SELECT role_name, permission_name FROM RolePermissions ORDER BY role_name, permission_name
+`
+
+func (q *Queries) ListRolePermissions(ctx context.Context) ([]Rolepermission, error) {
+	rows, err := q.db.Query(ctx, listRolePermissions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Rolepermission
+	for rows.Next() {
+		var i Rolepermission
+		if err := rows.Scan(&i.RoleName, &i.PermissionName); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoles = `-- name: ListRoles :many
+SELECT role_name, description, max_sessions, created_at FROM Roles ORDER BY role_name
+`
+
+func (q *Queries) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := q.db.Query(ctx, listRoles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Role
+	for rows.Next() {
+		var i Role
+		if err := rows.Scan(
+			&i.RoleName,
+			&i.Description,
+			&i.MaxSessions,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserLoginEvents = `-- name: ListUserLoginEvents :many
+SELECT event_id, user_id, occurred_at, login_method, succeeded, failure_reason, ip_address, user_agent FROM LoginEvents
+WHERE user_id = $1
+ORDER BY occurred_at DESC
+LIMIT $2
+`
+
+type ListUserLoginEventsParams struct {
+	UserID pgtype.UUID
+	Limit  int32
+}
+
+func (q *Queries) ListUserLoginEvents(ctx context.Context, arg ListUserLoginEventsParams) ([]Loginevent, error) {
+	rows, err := q.db.Query(ctx, listUserLoginEvents, arg.UserID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Loginevent
+	for rows.Next() {
+		var i Loginevent
+		if err := rows.Scan(
+			&i.EventID,
+			&i.UserID,
+			&i.OccurredAt,
+			&i.LoginMethod,
+			&i.Succeeded,
+			&i.FailureReason,
+			&i.IpAddress,
+			&i.UserAgent,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserLoginExternalSecrets = `-- name: ListUserLoginExternalSecrets :many
+SELECT provider_id, provider_subject, provider_access_token, provider_refresh_token FROM UserLoginExternal
+WHERE (provider_id, provider_subject) > ($1::uuid, $2::text)
+ORDER BY provider_id, provider_subject
+LIMIT $3
+`
+
+type ListUserLoginExternalSecretsParams struct {
+	AfterProviderID      pgtype.UUID
+	AfterProviderSubject string
+	BatchSize            int32
+}
+
+type ListUserLoginExternalSecretsRow struct {
+	ProviderID           pgtype.UUID
+	ProviderSubject      string
+	ProviderAccessToken  string
+	ProviderRefreshToken string
+}
+
+func (q *Queries) ListUserLoginExternalSecrets(ctx context.Context, arg ListUserLoginExternalSecretsParams) ([]ListUserLoginExternalSecretsRow, error) {
+	rows, err := q.db.Query(ctx, listUserLoginExternalSecrets, arg.AfterProviderID, arg.AfterProviderSubject, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUserLoginExternalSecretsRow
+	for rows.Next() {
+		var i ListUserLoginExternalSecretsRow
+		if err := rows.Scan(
+			&i.ProviderID,
+			&i.ProviderSubject,
+			&i.ProviderAccessToken,
+			&i.ProviderRefreshToken,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserLoginExternals = `-- name: ListUserLoginExternals :many
+SELECT p.slug, p.provider_name, ule.provider_subject, ule.linked_at FROM UserLoginExternal ule
+JOIN ExternalLoginProviders p ON p.provider_id = ule.provider_id
+WHERE ule.user_id = $1
+ORDER BY ule.linked_at
+`
+
+type ListUserLoginExternalsRow struct {
+	Slug            string
+	ProviderName    string
+	ProviderSubject string
+	LinkedAt        pgtype.Timestamptz
+}
+
+func (q *Queries) ListUserLoginExternals(ctx context.Context, userID pgtype.UUID) ([]ListUserLoginExternalsRow, error) {
+	rows, err := q.db.Query(ctx, listUserLoginExternals, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUserLoginExternalsRow
+	for rows.Next() {
+		var i ListUserLoginExternalsRow
+		if err := rows.Scan(
+			&i.Slug,
+			&i.ProviderName,
+			&i.ProviderSubject,
+			&i.LinkedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserSessions = `-- name: ListUserSessions :many
+SELECT session_id, user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash FROM Sessions
+WHERE user_id = $1 AND expires_at > NOW() AND absolute_expires_at > NOW()
+ORDER BY created_at
+`
+
+func (q *Queries) ListUserSessions(ctx context.Context, userID pgtype.UUID) ([]Session, error) {
+	rows, err := q.db.Query(ctx, listUserSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.SessionID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ExpiresAt,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.Device,
+			&i.LoginMethod,
+			&i.AbsoluteExpiresAt,
+			&i.RememberMe,
+			&i.ClientID,
+			&i.Scope,
+			&i.RefreshTokenHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT u.user_id, u.first_name, u.last_name, u.created_at, u.disabled_at, ld.email, ld.email_verified, ld.hashalgorithm FROM Users u
+LEFT JOIN UsersLoginData ld ON ld.user_id = u.user_id
+WHERE ($1::timestamp IS NULL
+        OR (u.created_at, u.user_id) > ($1::timestamp, $2::uuid))
+    AND ($3::text IS NULL
+        OR ld.email ILIKE '%' || $3 || '%'
+        OR u.first_name || ' ' || u.last_name ILIKE '%' || $3 || '%')
+    AND ($4::text IS NULL
+        OR EXISTS (SELECT 1 FROM UserRoles ur WHERE ur.user_id = u.user_id AND ur.role_name = $4))
+    AND ($5::boolean IS NULL OR (u.disabled_at IS NOT NULL) = $5)
+ORDER BY u.created_at, u.user_id
+LIMIT $6
+`
+
+type ListUsersParams struct {
+	AfterCreatedAt pgtype.Timestamp
+	AfterUserID    pgtype.UUID
+	Search         pgtype.Text
+	RoleName       pgtype.Text
+	Disabled       pgtype.Bool
+	PageSize       int32
+}
+
+type ListUsersRow struct {
+	UserID        pgtype.UUID
+	FirstName     string
+	LastName      string
+	CreatedAt     pgtype.Timestamp
+	DisabledAt    pgtype.Timestamptz
+	Email         pgtype.Text
+	EmailVerified pgtype.Bool
+	Hashalgorithm pgtype.Text
+}
+
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error) {
+	rows, err := q.db.Query(ctx, listUsers,
+		arg.AfterCreatedAt,
+		arg.AfterUserID,
+		arg.Search,
+		arg.RoleName,
+		arg.Disabled,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersRow
+	for rows.Next() {
+		var i ListUsersRow
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.CreatedAt,
+			&i.DisabledAt,
+			&i.Email,
+			&i.EmailVerified,
+			&i.Hashalgorithm,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockUser = `-- name: LockUser :one
+SELECT user_id FROM Users WHERE user_id = $1 FOR UPDATE
+`
+
+func (q *Queries) LockUser(ctx context.Context, userID pgtype.UUID) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, lockUser, userID)
+	var user_id pgtype.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const lockUserForSession = `-- name: LockUserForSession :one
+SELECT max_sessions, disabled_at FROM Users WHERE user_id = $1 FOR UPDATE
+`
+
+type LockUserForSessionRow struct {
+	MaxSessions pgtype.Int4
+	DisabledAt  pgtype.Timestamptz
+}
+
+func (q *Queries) LockUserForSession(ctx context.Context, userID pgtype.UUID) (LockUserForSessionRow, error) {
+	row := q.db.QueryRow(ctx, lockUserForSession, userID)
+	var i LockUserForSessionRow
+	err := row.Scan(&i.MaxSessions, &i.DisabledAt)
+	return i, err
+}
+
+const pollDeviceAuthorization = `-- name: PollDeviceAuthorization :one
+UPDATE DeviceAuthorizations polled SET last_polled_at = NOW()
+FROM DeviceAuthorizations previous
+WHERE polled.device_code_hash = previous.device_code_hash AND polled.device_code_hash = $1
+RETURNING previous.device_code_hash, previous.user_code, previous.client_id, previous.scope, previous.status, previous.user_id, previous.auth_time, previous.polling_interval, previous.last_polled_at, previous.ip_address, previous.user_agent, previous.expires_at
+`
+
+// records the poll and returns the authorization as it was before it
+func (q *Queries) PollDeviceAuthorization(ctx context.Context, deviceCodeHash string) (Deviceauthorization, error) {
+	row := q.db.QueryRow(ctx, pollDeviceAuthorization, deviceCodeHash)
+	var i Deviceauthorization
+	err := row.Scan(
+		&i.DeviceCodeHash,
+		&i.UserCode,
+		&i.ClientID,
+		&i.Scope,
+		&i.Status,
+		&i.UserID,
+		&i.AuthTime,
+		&i.PollingInterval,
+		&i.LastPolledAt,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const recordClientAssertion = `-- name: RecordClientAssertion :execrows
+INSERT INTO ClientAssertions (client_id, assertion_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING
+`
+
+type RecordClientAssertionParams struct {
+	ClientID    string
+	AssertionID string
+	ExpiresAt   pgtype.Timestamptz
+}
+
+func (q *Queries) RecordClientAssertion(ctx context.Context, arg RecordClientAssertionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, recordClientAssertion, arg.ClientID, arg.AssertionID, arg.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const reencryptExternalLoginProviderSecret = `-- name: ReencryptExternalLoginProviderSecret :execrows
+UPDATE ExternalLoginProviders SET client_secret = $1
+WHERE provider_id = $2 AND client_secret = $3
+`
+
+type ReencryptExternalLoginProviderSecretParams struct {
+	ClientSecret         string
+	ProviderID           pgtype.UUID
+	PreviousClientSecret string
+}
+
+func (q *Queries) ReencryptExternalLoginProviderSecret(ctx context.Context, arg ReencryptExternalLoginProviderSecretParams) (int64, error) {
+	result, err := q.db.Exec(ctx, reencryptExternalLoginProviderSecret, arg.ClientSecret, arg.ProviderID, arg.PreviousClientSecret)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const reencryptExternalLoginStateSecret = `-- name: ReencryptExternalLoginStateSecret :execrows
+UPDATE ExternalLoginStates SET code_verifier = $1
+WHERE state_hash = $2 AND code_verifier = $3
+`
+
+type ReencryptExternalLoginStateSecretParams struct {
+	CodeVerifier         string
+	StateHash            string
+	PreviousCodeVerifier string
+}
+
+func (q *Queries) ReencryptExternalLoginStateSecret(ctx context.Context, arg ReencryptExternalLoginStateSecretParams) (int64, error) {
+	result, err := q.db.Exec(ctx, reencryptExternalLoginStateSecret, arg.CodeVerifier, arg.StateHash, arg.PreviousCodeVerifier)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const reencryptUserLoginExternalTokens = `-- name: ReencryptUserLoginExternalTokens :execrows
+UPDATE UserLoginExternal SET provider_access_token = $1, provider_refresh_token = $2
+WHERE provider_id = $3 AND provider_subject = $4
+AND provider_access_token = $5 AND provider_refresh_token = $6
+`
+
+type ReencryptUserLoginExternalTokensParams struct {
+	AccessToken          string
+	RefreshToken         string
+	ProviderID           pgtype.UUID
+	ProviderSubject      string
+	PreviousAccessToken  string
+	PreviousRefreshToken string
+}
+
+func (q *Queries) ReencryptUserLoginExternalTokens(ctx context.Context, arg ReencryptUserLoginExternalTokensParams) (int64, error) {
+	result, err := q.db.Exec(ctx, reencryptUserLoginExternalTokens,
+		arg.AccessToken,
+		arg.RefreshToken,
+		arg.ProviderID,
+		arg.ProviderSubject,
+		arg.PreviousAccessToken,
+		arg.PreviousRefreshToken,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeAccessToken = `-- name: RevokeAccessToken :exec
+INSERT INTO RevokedAccessTokens (token_id, expires_at) VALUES ($1, $2) ON CONFLICT DO NOTHING
+`
+
+type RevokeAccessTokenParams struct {
+	TokenID   pgtype.UUID
+	ExpiresAt pgtype.Timestamptz
+}
+
+func (q *Queries) RevokeAccessToken(ctx context.Context, arg RevokeAccessTokenParams) error {
+	_, err := q.db.Exec(ctx, revokeAccessToken, arg.TokenID, arg.ExpiresAt)
+	return err
+}
+
+const revokeRolePermission = `-- name: RevokeRolePermission :execrows
+DELETE FROM RolePermissions WHERE role_name = $1 AND permission_name = $2
+`
+
+type RevokeRolePermissionParams struct {
+	RoleName       string
+	PermissionName string
+}
+
+func (q *Queries) RevokeRolePermission(ctx context.Context, arg RevokeRolePermissionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeRolePermission, arg.RoleName, arg.PermissionName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const sessionExists = `-- name: SessionExists :one
+SELECT EXISTS (SELECT 1 FROM Sessions WHERE session_id = $1)
+`
+
+func (q *Queries) SessionExists(ctx context.Context, sessionID pgtype.UUID) (bool, error) {
+	row := q.db.QueryRow(ctx, sessionExists, sessionID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const setUserDisabledAt = `-- name: SetUserDisabledAt :execrows
+UPDATE Users SET disabled_at = $2 WHERE user_id = $1
+`
+
+type SetUserDisabledAtParams struct {
+	UserID     pgtype.UUID
+	DisabledAt pgtype.Timestamptz
+}
+
+func (q *Queries) SetUserDisabledAt(ctx context.Context, arg SetUserDisabledAtParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setUserDisabledAt, arg.UserID, arg.DisabledAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setUserMaxSessions = `-- name: SetUserMaxSessions :exec
+UPDATE Users SET max_sessions = $1 WHERE user_id = $2
+`
+
+type SetUserMaxSessionsParams struct {
+	MaxSessions pgtype.Int4
+	UserID      pgtype.UUID
+}
+
+func (q *Queries) SetUserMaxSessions(ctx context.Context, arg SetUserMaxSessionsParams) error {
+	_, err := q.db.Exec(ctx, setUserMaxSessions, arg.MaxSessions, arg.UserID)
+	return err
+}
+
+const slowDownDeviceAuthorization = `-- name: SlowDownDeviceAuthorization :exec
+UPDATE DeviceAuthorizations SET polling_interval = polling_interval + $2 WHERE device_code_hash = $1
+`
+
+type SlowDownDeviceAuthorizationParams struct {
+	DeviceCodeHash  string
+	PollingInterval int32
+}
+
+func (q *Queries) SlowDownDeviceAuthorization(ctx context.Context, arg SlowDownDeviceAuthorizationParams) error {
+	_, err := q.db.Exec(ctx, slowDownDeviceAuthorization, arg.DeviceCodeHash, arg.PollingInterval)
+	return err
+}
+
+const test_UpdateSessionAbsoluteExpiresAt = `-- name: Test_UpdateSessionAbsoluteExpiresAt :exec
+UPDATE Sessions SET absolute_expires_at = $1 WHERE session_id = $2
+`
+
+type Test_UpdateSessionAbsoluteExpiresAtParams struct {
+	AbsoluteExpiresAt pgtype.Timestamptz
+	SessionID         pgtype.UUID
+}
+
+func (q *Queries) Test_UpdateSessionAbsoluteExpiresAt(ctx context.Context, arg Test_UpdateSessionAbsoluteExpiresAtParams) error {
+	_, err := q.db.Exec(ctx, test_UpdateSessionAbsoluteExpiresAt, arg.AbsoluteExpiresAt, arg.SessionID)
+	return err
+}
+
+const test_UpdateSessionExpiresAt = `-- name: Test_UpdateSessionExpiresAt :exec
+UPDATE Sessions SET expires_at = $1 WHERE session_id = $2
+`
+
+type Test_UpdateSessionExpiresAtParams struct {
+	ExpiresAt pgtype.Timestamptz
+	SessionID pgtype.UUID
+}
+
+func (q *Queries) Test_UpdateSessionExpiresAt(ctx context.Context, arg Test_UpdateSessionExpiresAtParams) error {
+	_, err := q.db.Exec(ctx, test_UpdateSessionExpiresAt, arg.ExpiresAt, arg.SessionID)
+	return err
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE Sessions SET updated_at = $1, expires_at = $2 WHERE session_id = $3
+`
+
+type TouchSessionParams struct {
+	UpdatedAt pgtype.Timestamptz
+	ExpiresAt pgtype.Timestamptz
+	SessionID pgtype.UUID
+}
+
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.Exec(ctx, touchSession, arg.UpdatedAt, arg.ExpiresAt, arg.SessionID)
+	return err
+}
+
+const tryAdvisoryLock = `-- name: TryAdvisoryLock :one
+SELECT pg_try_advisory_lock($1::bigint)
+`
+
+func (q *Queries) TryAdvisoryLock(ctx context.Context, lockID int64) (bool, error) {
+	row := q.db.QueryRow(ctx, tryAdvisoryLock, lockID)
+	var pg_try_advisory_lock bool
+	err := row.Scan(&pg_try_advisory_lock)
+	return pg_try_advisory_lock, err
+}
+
+const unassignUserRole = `-- name: UnassignUserRole :execrows
+DELETE FROM UserRoles WHERE user_id = $1 AND role_name = $2
+`
+
+type UnassignUserRoleParams struct {
+	UserID   pgtype.UUID
+	RoleName string
+}
+
+func (q *Queries) UnassignUserRole(ctx context.Context, arg UnassignUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, unassignUserRole, arg.UserID, arg.RoleName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updatePolicy = `-- name: UpdatePolicy :execrows
+UPDATE Policies SET description = $2, action = $3, effect = $4, expression = $5, enabled = $6, updated_at = NOW() WHERE policy_name = $1
+`
+
+type UpdatePolicyParams struct {
+	PolicyName  string
+	Description string
+	Action      string
+	Effect      string
+	Expression  string
+	Enabled     bool
+}
+
+func (q *Queries) UpdatePolicy(ctx context.Context, arg UpdatePolicyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updatePolicy,
+		arg.PolicyName,
+		arg.Description,
+		arg.Action,
+		arg.Effect,
+		arg.Expression,
+		arg.Enabled,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateUserEmail = `-- name: UpdateUserEmail :execrows
+UPDATE UsersLoginData SET email = $2, email_verified = FALSE WHERE user_id = $1
+`
+
+type UpdateUserEmailParams struct {
+	UserID pgtype.UUID
+	Email  string
+}
+
+func (q *Queries) UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUserEmail, arg.UserID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateUserLoginExternalTokens = `-- name: UpdateUserLoginExternalTokens :exec
+UPDATE UserLoginExternal SET provider_access_token = $3, provider_refresh_token = $4 WHERE provider_id = $1 AND provider_subject = $2
+`
+
+type UpdateUserLoginExternalTokensParams struct {
+	ProviderID           pgtype.UUID
+	ProviderSubject      string
+	ProviderAccessToken  string
+	ProviderRefreshToken string
+}
+
+func (q *Queries) UpdateUserLoginExternalTokens(ctx context.Context, arg UpdateUserLoginExternalTokensParams) error {
+	_, err := q.db.Exec(ctx, updateUserLoginExternalTokens,
+		arg.ProviderID,
+		arg.ProviderSubject,
+		arg.ProviderAccessToken,
+		arg.ProviderRefreshToken,
+	)
+	return err
+}
+
+const updateUserLoginSAMLEmail = `-- name: UpdateUserLoginSAMLEmail :exec
+UPDATE UserLoginSAML SET email = $3 WHERE organization_id = $1 AND name_id = $2
+`
+
+type UpdateUserLoginSAMLEmailParams struct {
+	OrganizationID pgtype.UUID
+	NameID         string
+	Email          string
+}
+
+func (q *Queries) UpdateUserLoginSAMLEmail(ctx context.Context, arg UpdateUserLoginSAMLEmailParams) error {
+	_, err := q.db.Exec(ctx, updateUserLoginSAMLEmail, arg.OrganizationID, arg.NameID, arg.Email)
+	return err
+}
+
+const updateUserNames = `-- name: UpdateUserNames :exec
+UPDATE Users SET first_name = $2, last_name = $3 WHERE user_id = $1
+`
+
+type UpdateUserNamesParams struct {
+	UserID    pgtype.UUID
+	FirstName string
+	LastName  string
+}
+
+func (q *Queries) UpdateUserNames(ctx context.Context, arg UpdateUserNamesParams) error {
+	_, err := q.db.Exec(ctx, updateUserNames, arg.UserID, arg.FirstName, arg.LastName)
+	return err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE UsersLoginData SET passwordhash = $2, passwordsalt = $3 WHERE user_id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	UserID       pgtype.UUID
+	Passwordhash string
+	Passwordsalt string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.Exec(ctx, updateUserPassword, arg.UserID, arg.Passwordhash, arg.Passwordsalt)
+	return err
+}
+
+const upsertPasswordRecovery = `-- name: UpsertPasswordRecovery :exec
+INSERT INTO PasswordRecovery (user_id, recovery_token) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET recovery_token = EXCLUDED.recovery_token, created_at = CURRENT_TIMESTAMP, used = FALSE
+`
+
+type UpsertPasswordRecoveryParams struct {
+	UserID        pgtype.UUID
+	RecoveryToken string
+}
+
+func (q *Queries) UpsertPasswordRecovery(ctx context.Context, arg UpsertPasswordRecoveryParams) error {
+	_, err := q.db.Exec(ctx, upsertPasswordRecovery, arg.UserID, arg.RecoveryToken)
+	return err
+}
+
+const upsertSAMLIdentityProvider = `-- name: UpsertSAMLIdentityProvider :exec
+INSERT INTO SAMLIdentityProviders (organization_id, entity_id, sso_url, certificates, first_name_attribute, last_name_attribute, email_attribute, jit_provisioning)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (organization_id) DO UPDATE SET entity_id = EXCLUDED.entity_id, sso_url = EXCLUDED.sso_url,
+    certificates = EXCLUDED.certificates, first_name_attribute = EXCLUDED.first_name_attribute,
+    last_name_attribute = EXCLUDED.last_name_attribute, email_attribute = EXCLUDED.email_attribute,
+    jit_provisioning = EXCLUDED.jit_provisioning, updated_at = NOW()
+`
+
+type UpsertSAMLIdentityProviderParams struct {
+	OrganizationID     pgtype.UUID
+	EntityID           string
+	SsoUrl             string
+	Certificates       string
+	FirstNameAttribute string
+	LastNameAttribute  string
+	EmailAttribute     string
+	JitProvisioning    bool
+}
+
+func (q *Queries) UpsertSAMLIdentityProvider(ctx context.Context, arg UpsertSAMLIdentityProviderParams) error {
+	_, err := q.db.Exec(ctx, upsertSAMLIdentityProvider,
+		arg.OrganizationID,
+		arg.EntityID,
+		arg.SsoUrl,
+		arg.Certificates,
+		arg.FirstNameAttribute,
+		arg.LastNameAttribute,
+		arg.EmailAttribute,
+		arg.JitProvisioning,
+	)
 	return err
 }
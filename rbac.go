@@ -0,0 +1,327 @@
+package betalinkauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// DefaultRole is the role granted to every registered user
+	DefaultRole = "user"
+	// AdminRole is the role of the administrators of the auth service
+	AdminRole = "admin"
+
+	// pgUniqueViolation is the Postgres error code of a unique constraint violation
+	pgUniqueViolation = "23505"
+	// pgForeignKeyViolation is the Postgres error code of a foreign key violation
+	pgForeignKeyViolation = "23503"
+)
+
+// nameRegexp matches the role and permission names, e.g. "admin" or
+// "users:read"
+var nameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_.:-]{0,254}$`)
+
+// RoleData is a role along with the permissions it grants
+type RoleData struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	MaxSessions *int32   `json:"max_sessions"`
+	Permissions []string `json:"permissions"`
+}
+
+// PermissionData is a permission which can be granted to roles
+type PermissionData struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ListRoles returns the roles with the permissions they grant
+func (u *Usecases) ListRoles(ctx context.Context) ([]RoleData, error) {
+	roles, err := u.store.ListRoles(ctx)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list roles: %w", err).Error(),
+		}
+	}
+	rolePermissions, err := u.store.ListRolePermissions(ctx)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list role permissions: %w", err).Error(),
+		}
+	}
+
+	permissions := make(map[string][]string)
+	for _, rolePermission := range rolePermissions {
+		permissions[rolePermission.RoleName] = append(permissions[rolePermission.RoleName], rolePermission.PermissionName)
+	}
+	data := make([]RoleData, 0, len(roles))
+	for _, role := range roles {
+		roleData := RoleData{
+			Name:        role.RoleName,
+			Description: role.Description,
+			Permissions: permissions[role.RoleName],
+		}
+		if roleData.Permissions == nil {
+			roleData.Permissions = []string{}
+		}
+		if role.MaxSessions.Valid {
+			maxSessions := role.MaxSessions.Int32
+			roleData.MaxSessions = &maxSessions
+		}
+		data = append(data, roleData)
+	}
+	return data, nil
+}
+
+// CreateRole creates a new role. A non nil maxSessions limits the active
+// sessions of the users having the role, unless they have their own limit.
+func (u *Usecases) CreateRole(ctx context.Context, name, description string, maxSessions *int32) error {
+	if err := validateName("role", name); err != nil {
+		return err
+	}
+	params := CreateRoleParams{
+		RoleName:    name,
+		Description: description,
+	}
+	if maxSessions != nil {
+		if *maxSessions < 0 {
+			return &ValidationError{Message: "max sessions must be positive"}
+		}
+		params.MaxSessions = pgtype.Int4{Int32: *maxSessions, Valid: true}
+	}
+
+	if err := u.store.CreateRole(ctx, params); err != nil {
+		if pgErrorCode(err) == pgUniqueViolation {
+			return &ValidationError{Message: fmt.Sprintf("role [%s] already exists", name)}
+		}
+		return &ServerError{
+			Message: fmt.Errorf("could not create role: %w", err).Error(),
+		}
+	}
+	return nil
+}
+
+// DeleteRole deletes a role, the users having it lose it. The default
+// and admin roles cannot be deleted.
+func (u *Usecases) DeleteRole(ctx context.Context, name string) error {
+	if name == DefaultRole || name == AdminRole {
+		return &ValidationError{Message: fmt.Sprintf("role [%s] cannot be deleted", name)}
+	}
+	deleted, err := u.store.DeleteRole(ctx, name)
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not delete role: %w", err).Error(),
+		}
+	}
+	if deleted == 0 {
+		return &NotFoundError{Message: fmt.Sprintf("role [%s] not found", name)}
+	}
+	return nil
+}
+
+// ListPermissions returns the permissions
+func (u *Usecases) ListPermissions(ctx context.Context) ([]PermissionData, error) {
+	permissions, err := u.store.ListPermissions(ctx)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list permissions: %w", err).Error(),
+		}
+	}
+	data := make([]PermissionData, 0, len(permissions))
+	for _, permission := range permissions {
+		data = append(data, PermissionData{
+			Name:        permission.PermissionName,
+			Description: permission.Description,
+		})
+	}
+	return data, nil
+}
+
+// CreatePermission creates a new permission
+func (u *Usecases) CreatePermission(ctx context.Context, name, description string) error {
+	if err := validateName("permission", name); err != nil {
+		return err
+	}
+	err := u.store.CreatePermission(ctx, CreatePermissionParams{
+		PermissionName: name,
+		Description:    description,
+	})
+	if err != nil {
+		if pgErrorCode(err) == pgUniqueViolation {
+			return &ValidationError{Message: fmt.Sprintf("permission [%s] already exists", name)}
+		}
+		return &ServerError{
+			Message: fmt.Errorf("could not create permission: %w", err).Error(),
+		}
+	}
+	return nil
+}
+
+// DeletePermission deletes a permission, the roles granting it lose it
+func (u *Usecases) DeletePermission(ctx context.Context, name string) error {
+	deleted, err := u.store.DeletePermission(ctx, name)
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not delete permission: %w", err).Error(),
+		}
+	}
+	if deleted == 0 {
+		return &NotFoundError{Message: fmt.Sprintf("permission [%s] not found", name)}
+	}
+	return nil
+}
+
+// GrantPermission grants a permission to a role
+func (u *Usecases) GrantPermission(ctx context.Context, role, permission string) error {
+	err := u.store.GrantRolePermission(ctx, GrantRolePermissionParams{
+		RoleName:       role,
+		PermissionName: permission,
+	})
+	if err != nil {
+		if pgErrorCode(err) == pgForeignKeyViolation {
+			return &NotFoundError{
+				Message: fmt.Sprintf("role [%s] or permission [%s] not found", role, permission),
+			}
+		}
+		return &ServerError{
+			Message: fmt.Errorf("could not grant permission: %w", err).Error(),
+		}
+	}
+	return nil
+}
+
+// RevokePermission revokes a permission from a role
+func (u *Usecases) RevokePermission(ctx context.Context, role, permission string) error {
+	revoked, err := u.store.RevokeRolePermission(ctx, RevokeRolePermissionParams{
+		RoleName:       role,
+		PermissionName: permission,
+	})
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not revoke permission: %w", err).Error(),
+		}
+	}
+	if revoked == 0 {
+		return &NotFoundError{
+			Message: fmt.Sprintf("role [%s] does not grant permission [%s]", role, permission),
+		}
+	}
+	return nil
+}
+
+// GetUserRoles returns the roles of a user
+func (u *Usecases) GetUserRoles(ctx context.Context, userID pgtype.UUID) ([]string, error) {
+	if _, err := u.store.GetUserById(ctx, userID); err != nil {
+		return nil, userNotFound(err)
+	}
+	roles, err := u.store.GetUserRoles(ctx, userID)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not get user roles: %w", err).Error(),
+		}
+	}
+	return roleNames(roles), nil
+}
+
+// AssignRole grants a role to a user. The user gets the permissions of
+// the role from its next access token.
+func (u *Usecases) AssignRole(ctx context.Context, userID pgtype.UUID, role string) error {
+	if _, err := u.store.GetUserById(ctx, userID); err != nil {
+		return userNotFound(err)
+	}
+	err := u.store.AssignUserRole(ctx, AssignUserRoleParams{
+		UserID:   userID,
+		RoleName: role,
+	})
+	if err != nil {
+		if pgErrorCode(err) == pgForeignKeyViolation {
+			return &NotFoundError{Message: fmt.Sprintf("role [%s] not found", role)}
+		}
+		return &ServerError{
+			Message: fmt.Errorf("could not assign role: %w", err).Error(),
+		}
+	}
+	return nil
+}
+
+// UnassignRole removes a role from a user
+func (u *Usecases) UnassignRole(ctx context.Context, userID pgtype.UUID, role string) error {
+	unassigned, err := u.store.UnassignUserRole(ctx, UnassignUserRoleParams{
+		UserID:   userID,
+		RoleName: role,
+	})
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not unassign role: %w", err).Error(),
+		}
+	}
+	if unassigned == 0 {
+		return &NotFoundError{Message: fmt.Sprintf("user does not have role [%s]", role)}
+	}
+	return nil
+}
+
+// roleNames returns the names of roles
+func roleNames(roles []Role) []string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.RoleName)
+	}
+	return names
+}
+
+// rolesMaxSessions returns the session limit set by roles. When several
+// roles set a limit, the most generous one applies: a role without limit,
+// whose limit is zero, lifts the limits of the others.
+func rolesMaxSessions(roles []Role) (int32, bool) {
+	var maxSessions int32
+	var ok bool
+	for _, role := range roles {
+		if !role.MaxSessions.Valid {
+			continue
+		}
+		if role.MaxSessions.Int32 == 0 {
+			return 0, true
+		}
+		if !ok || role.MaxSessions.Int32 > maxSessions {
+			maxSessions = role.MaxSessions.Int32
+			ok = true
+		}
+	}
+	return maxSessions, ok
+}
+
+// validateName validates the name of a role or permission
+func validateName(kind, name string) error {
+	if !nameRegexp.MatchString(name) {
+		return &ValidationError{
+			Message: fmt.Sprintf("invalid %s name [%s]: must be lowercase letters, digits or _.:- and start with a letter", kind, name),
+		}
+	}
+	return nil
+}
+
+// userNotFound converts the error of a user lookup
+func userNotFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &NotFoundError{Message: "user not found"}
+	}
+	return &ServerError{
+		Message: fmt.Errorf("could not get user by ID: %w", err).Error(),
+	}
+}
+
+// pgErrorCode returns the Postgres error code of err, if any
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
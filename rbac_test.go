@@ -0,0 +1,186 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsecases_RBAC(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+
+	testEmail := "rbac.user@example.com"
+	testPassword := "RbacPassword123!"
+	err = usecases.RegisterUser(testCtx, "Rbac", "User", testEmail, testPassword)
+	require.NoError(t, err)
+	loginData, err := queries.GetLoginDataByEmail(testCtx, testEmail)
+	require.NoError(t, err)
+	userID := loginData.UserID
+
+	validateTokens := func(t *testing.T) *betalinkauth.UserData {
+		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+		userData, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.NoError(t, err)
+		return userData
+	}
+
+	t.Run("registered users get the default role", func(t *testing.T) {
+		roles, err := usecases.GetUserRoles(testCtx, userID)
+		require.NoError(t, err)
+		require.Equal(t, []string{betalinkauth.DefaultRole}, roles)
+
+		userData := validateTokens(t)
+		require.Equal(t, []string{betalinkauth.DefaultRole}, userData.Roles)
+		require.Empty(t, userData.Permissions)
+	})
+
+	t.Run("tokens carry the roles and permissions from the database", func(t *testing.T) {
+		require.NoError(t, usecases.CreateRole(testCtx, "tester", "Beta testers", nil))
+		require.NoError(t, usecases.CreatePermission(testCtx, "builds:download", "Download beta builds"))
+		require.NoError(t, usecases.CreatePermission(testCtx, "feedback:write", "Send feedback"))
+		require.NoError(t, usecases.GrantPermission(testCtx, "tester", "builds:download"))
+		require.NoError(t, usecases.GrantPermission(testCtx, "tester", "feedback:write"))
+		require.NoError(t, usecases.GrantPermission(testCtx, betalinkauth.DefaultRole, "feedback:write"))
+		require.NoError(t, usecases.AssignRole(testCtx, userID, "tester"))
+
+		userData := validateTokens(t)
+		require.Equal(t, []string{"tester", betalinkauth.DefaultRole}, userData.Roles)
+		require.Equal(t, []string{"builds:download", "feedback:write"}, userData.Permissions)
+
+		require.NoError(t, usecases.RevokePermission(testCtx, "tester", "builds:download"))
+		userData = validateTokens(t)
+		require.Equal(t, []string{"feedback:write"}, userData.Permissions)
+
+		require.NoError(t, usecases.UnassignRole(testCtx, userID, "tester"))
+		userData = validateTokens(t)
+		require.Equal(t, []string{betalinkauth.DefaultRole}, userData.Roles)
+	})
+
+	t.Run("list roles with their permissions", func(t *testing.T) {
+		roles, err := usecases.ListRoles(testCtx)
+		require.NoError(t, err)
+		require.Len(t, roles, 3)
+		require.Equal(t, "admin", roles[0].Name)
+		require.Empty(t, roles[0].Permissions)
+		require.Equal(t, "tester", roles[1].Name)
+		require.Equal(t, []string{"feedback:write"}, roles[1].Permissions)
+
+		permissions, err := usecases.ListPermissions(testCtx)
+		require.NoError(t, err)
+		require.Len(t, permissions, 2)
+	})
+
+	t.Run("invalid operations", func(t *testing.T) {
+		err := usecases.CreateRole(testCtx, "tester", "", nil)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		err = usecases.CreateRole(testCtx, "Not A Name", "", nil)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		err = usecases.CreatePermission(testCtx, "feedback:write", "")
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+
+		err = usecases.DeleteRole(testCtx, betalinkauth.DefaultRole)
+		require.IsType(t, &betalinkauth.ValidationError{}, err)
+		err = usecases.DeleteRole(testCtx, "unknown")
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+		err = usecases.DeletePermission(testCtx, "unknown")
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+		err = usecases.GrantPermission(testCtx, "tester", "unknown")
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+		err = usecases.AssignRole(testCtx, userID, "unknown")
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+		err = usecases.AssignRole(testCtx, pgtype.UUID{Valid: true}, "tester")
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+		err = usecases.UnassignRole(testCtx, userID, "admin")
+		require.IsType(t, &betalinkauth.NotFoundError{}, err)
+	})
+
+	t.Run("deleting a role removes it from its users", func(t *testing.T) {
+		require.NoError(t, usecases.AssignRole(testCtx, userID, "tester"))
+		require.NoError(t, usecases.DeleteRole(testCtx, "tester"))
+
+		roles, err := usecases.GetUserRoles(testCtx, userID)
+		require.NoError(t, err)
+		require.Equal(t, []string{betalinkauth.DefaultRole}, roles)
+	})
+}
+
+func TestUsecases_RoleSessionLimit(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
+
+	testEmail := "role.limit@example.com"
+	testPassword := "RoleLimit123!"
+	err = usecases.RegisterUser(testCtx, "Role", "Limit", testEmail, testPassword)
+	require.NoError(t, err)
+	loginData, err := queries.GetLoginDataByEmail(testCtx, testEmail)
+	require.NoError(t, err)
+
+	one, three := int32(1), int32(3)
+	require.NoError(t, usecases.CreateRole(testCtx, "trial", "Trial accounts", &one))
+	require.NoError(t, usecases.CreateRole(testCtx, "paid", "Paid tester accounts", &three))
+	require.NoError(t, usecases.AssignRole(testCtx, loginData.UserID, "trial"))
+
+	_, err = usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+	require.NoError(t, err)
+	_, err = usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+	require.Equal(t, betalinkauth.SessionLimitReachedError, err)
+
+	// the most generous role applies
+	require.NoError(t, usecases.AssignRole(testCtx, loginData.UserID, "paid"))
+	for i := 0; i < 2; i++ {
+		_, err = usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+	}
+	_, err = usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+	require.Equal(t, betalinkauth.SessionLimitReachedError, err)
+
+	// the limit of the user overrides those of its roles
+	err = queries.SetUserMaxSessions(testCtx, betalinkauth.SetUserMaxSessionsParams{
+		MaxSessions: pgtype.Int4{Int32: 4, Valid: true},
+		UserID:      loginData.UserID,
+	})
+	require.NoError(t, err)
+	_, err = usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+	require.NoError(t, err)
+
+	// a role without limit lifts the limits of the other roles
+	unlimitedEmail := "role.unlimited@example.com"
+	err = usecases.RegisterUser(testCtx, "Role", "Unlimited", unlimitedEmail, testPassword)
+	require.NoError(t, err)
+	unlimitedUser, err := queries.GetLoginDataByEmail(testCtx, unlimitedEmail)
+	require.NoError(t, err)
+	zero := int32(0)
+	require.NoError(t, usecases.CreateRole(testCtx, "staff", "Staff accounts", &zero))
+	require.NoError(t, usecases.AssignRole(testCtx, unlimitedUser.UserID, "staff"))
+	require.NoError(t, usecases.AssignRole(testCtx, unlimitedUser.UserID, "paid"))
+	for i := 0; i < 5; i++ {
+		_, err = usecases.LoginUser(testCtx, unlimitedEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,136 @@
+package betalinkauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ReencryptionBatchSize is the number of rows re-encrypted per batch
+const ReencryptionBatchSize int32 = 500
+
+// ReencryptionReport counts the secrets re-encrypted with the primary key
+// of a keyring
+type ReencryptionReport struct {
+	ProviderTokens      int64
+	ProviderSecrets     int64
+	CodeVerifiers       int64
+	ConcurrentlyChanged int64
+}
+
+// ReencryptSecrets encrypts again with the primary key of the keyring the
+// secrets stored in plaintext or encrypted with previous keys. Once done,
+// the previous keys can be retired. Secrets changed while they are
+// re-encrypted are left as written by the service, which must already
+// encrypt them with the new primary key.
+func ReencryptSecrets(ctx context.Context, queries *Queries, keyring *Keyring) (*ReencryptionReport, error) {
+	report := &ReencryptionReport{}
+	if err := reencryptProviderTokens(ctx, queries, keyring, report); err != nil {
+		return report, err
+	}
+
+	providers, err := queries.ListExternalLoginProviderSecrets(ctx)
+	if err != nil {
+		return report, fmt.Errorf("could not list provider secrets: %w", err)
+	}
+	for _, provider := range providers {
+		if !keyring.NeedsReencryption(provider.ClientSecret) {
+			continue
+		}
+		clientSecret, err := keyring.reencrypt(provider.ClientSecret, secretProviderClientSecret)
+		if err != nil {
+			return report, fmt.Errorf("could not re-encrypt provider secret: %w", err)
+		}
+		updated, err := queries.ReencryptExternalLoginProviderSecret(ctx, ReencryptExternalLoginProviderSecretParams{
+			ClientSecret:         clientSecret,
+			ProviderID:           provider.ProviderID,
+			PreviousClientSecret: provider.ClientSecret,
+		})
+		if err != nil {
+			return report, fmt.Errorf("could not update provider secret: %w", err)
+		}
+		report.count(&report.ProviderSecrets, updated)
+	}
+
+	states, err := queries.ListExternalLoginStateSecrets(ctx)
+	if err != nil {
+		return report, fmt.Errorf("could not list external login states: %w", err)
+	}
+	for _, state := range states {
+		if !keyring.NeedsReencryption(state.CodeVerifier) {
+			continue
+		}
+		codeVerifier, err := keyring.reencrypt(state.CodeVerifier, secretCodeVerifier)
+		if err != nil {
+			return report, fmt.Errorf("could not re-encrypt code verifier: %w", err)
+		}
+		updated, err := queries.ReencryptExternalLoginStateSecret(ctx, ReencryptExternalLoginStateSecretParams{
+			CodeVerifier:         codeVerifier,
+			StateHash:            state.StateHash,
+			PreviousCodeVerifier: state.CodeVerifier,
+		})
+		if err != nil {
+			return report, fmt.Errorf("could not update code verifier: %w", err)
+		}
+		report.count(&report.CodeVerifiers, updated)
+	}
+	return report, nil
+}
+
+// reencryptProviderTokens re-encrypts the provider tokens of the users,
+// in batches ordered by primary key
+func reencryptProviderTokens(ctx context.Context, queries *Queries, keyring *Keyring, report *ReencryptionReport) error {
+	params := ListUserLoginExternalSecretsParams{
+		// the nil UUID sorts before any provider ID
+		AfterProviderID: pgtype.UUID{Valid: true},
+		BatchSize:       ReencryptionBatchSize,
+	}
+	for {
+		logins, err := queries.ListUserLoginExternalSecrets(ctx, params)
+		if err != nil {
+			return fmt.Errorf("could not list provider tokens: %w", err)
+		}
+		for _, login := range logins {
+			if !keyring.NeedsReencryption(login.ProviderAccessToken) && !keyring.NeedsReencryption(login.ProviderRefreshToken) {
+				continue
+			}
+			accessToken, err := keyring.reencrypt(login.ProviderAccessToken, secretProviderAccessToken)
+			if err != nil {
+				return fmt.Errorf("could not re-encrypt provider access token: %w", err)
+			}
+			refreshToken, err := keyring.reencrypt(login.ProviderRefreshToken, secretProviderRefreshToken)
+			if err != nil {
+				return fmt.Errorf("could not re-encrypt provider refresh token: %w", err)
+			}
+			updated, err := queries.ReencryptUserLoginExternalTokens(ctx, ReencryptUserLoginExternalTokensParams{
+				AccessToken:          accessToken,
+				RefreshToken:         refreshToken,
+				ProviderID:           login.ProviderID,
+				ProviderSubject:      login.ProviderSubject,
+				PreviousAccessToken:  login.ProviderAccessToken,
+				PreviousRefreshToken: login.ProviderRefreshToken,
+			})
+			if err != nil {
+				return fmt.Errorf("could not update provider tokens: %w", err)
+			}
+			report.count(&report.ProviderTokens, updated)
+		}
+		if len(logins) < int(params.BatchSize) {
+			return nil
+		}
+		last := logins[len(logins)-1]
+		params.AfterProviderID = last.ProviderID
+		params.AfterProviderSubject = last.ProviderSubject
+	}
+}
+
+// count adds an updated row to counter, or counts it as concurrently
+// changed if it was not updated
+func (r *ReencryptionReport) count(counter *int64, updated int64) {
+	if updated == 0 {
+		r.ConcurrentlyChanged++
+		return
+	}
+	*counter += updated
+}
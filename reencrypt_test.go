@@ -0,0 +1,83 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"strings"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReencryptSecrets(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	idp := newMockIdP(t)
+	defer idp.Close()
+	// the client secret is configured in plaintext, as before encryption
+	_, err = conn.Exec(testCtx, `INSERT INTO ExternalLoginProviders
+		(provider_name, provider_endpoint, slug, issuer, client_id, client_secret)
+		VALUES ('Mock', $1, 'mock', $2, $3, $4)`,
+		idp.URL+"/authorize", idp.URL, testProviderClientID, testProviderClientSecret)
+	require.NoError(t, err)
+
+	oldKey, err := betalinkauth.GenerateEncryptionKey()
+	require.NoError(t, err)
+	keyring := betalinkauth.NewKeyring(oldKey)
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, queries, betalinkauth.WithKeyring(keyring))
+	require.NoError(t, err)
+
+	login := func(t *testing.T) {
+		login, err := usecases.StartExternalLogin(testCtx, "mock", false)
+		require.NoError(t, err)
+		code, state := idp.authorize(t, login.AuthorizationURL, jwt.MapClaims{"sub": "frank-subject", "name": "Frank Drebin"})
+		_, err = usecases.CompleteExternalLogin(testCtx, "mock", state, code, testSessionMetadata)
+		require.NoError(t, err)
+	}
+	storedSecrets := func(t *testing.T) (string, string, string) {
+		var accessToken, refreshToken, clientSecret string
+		err := conn.QueryRow(testCtx, `SELECT provider_access_token, provider_refresh_token, client_secret
+			FROM UserLoginExternal JOIN ExternalLoginProviders USING (provider_id)`).
+			Scan(&accessToken, &refreshToken, &clientSecret)
+		require.NoError(t, err)
+		return accessToken, refreshToken, clientSecret
+	}
+
+	login(t)
+	accessToken, refreshToken, clientSecret := storedSecrets(t)
+	require.True(t, strings.HasPrefix(accessToken, "enc:v1:"+oldKey.ID+":"))
+	require.NotContains(t, accessToken, "provider-access-token")
+	// the mock provider issues no refresh token
+	require.Empty(t, refreshToken)
+	require.Equal(t, testProviderClientSecret, clientSecret)
+
+	newKey, err := betalinkauth.GenerateEncryptionKey()
+	require.NoError(t, err)
+	keyring.Rotate(newKey)
+	report, err := betalinkauth.ReencryptSecrets(testCtx, queries, keyring)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), report.ProviderTokens)
+	require.Equal(t, int64(1), report.ProviderSecrets)
+	require.Zero(t, report.ConcurrentlyChanged)
+
+	accessToken, _, clientSecret = storedSecrets(t)
+	require.True(t, strings.HasPrefix(accessToken, "enc:v1:"+newKey.ID+":"))
+	require.True(t, strings.HasPrefix(clientSecret, "enc:v1:"+newKey.ID+":"))
+
+	// nothing is left to re-encrypt, and the old key is not needed anymore
+	report, err = betalinkauth.ReencryptSecrets(testCtx, queries, keyring)
+	require.NoError(t, err)
+	require.Equal(t, betalinkauth.ReencryptionReport{}, *report)
+	require.NoError(t, keyring.Retire(oldKey.ID))
+	login(t)
+}
@@ -0,0 +1,882 @@
+package betalinkauth
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	// LoginMethodSAML is the login method of sessions opened through the
+	// SAML identity provider of an organization
+	LoginMethodSAML = "SAML"
+
+	// samlRequestValidity is how long the user has to log in with the
+	// identity provider
+	samlRequestValidity = time.Minute * 10
+	// samlClockSkew is the difference tolerated between the clocks of
+	// the service and of the identity providers
+	samlClockSkew = time.Minute * 2
+	// samlRelayStateSize is the size in bytes of the relay state binding
+	// a login to the browser which started it
+	samlRelayStateSize = 32
+	// samlRequestIDSize is the size in bytes of the authentication
+	// request IDs
+	samlRequestIDSize = 20
+	// organizationSlugRegex is the regex pattern for organization slugs,
+	// used in the URLs of their SAML endpoints
+	organizationSlugRegex = `^[a-z0-9][a-z0-9-]{0,63}$`
+)
+
+// SAML 2.0 namespaces and identifiers (SAML core and bindings)
+const (
+	samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlProtocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlHTTPPostBinding    = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlStatusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearerConfirmation = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// Default attributes of the names and email of the users, the X.500
+// attribute names used by most identity providers
+const (
+	DefaultSAMLFirstNameAttribute = "urn:oid:2.5.4.42"
+	DefaultSAMLLastNameAttribute  = "urn:oid:2.5.4.4"
+	DefaultSAMLEmailAttribute     = "urn:oid:0.9.2342.19200300.100.1.3"
+)
+
+// OrganizationData is an organization whose users log in through its
+// own identity provider
+type OrganizationData struct {
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SAMLIdentityProviderData is the SAML identity provider of an
+// organization, and the attributes of its assertions naming its users
+type SAMLIdentityProviderData struct {
+	EntityID string `json:"entity_id"`
+	// SSOURL is the endpoint receiving the authentication requests with
+	// the HTTP-Redirect binding
+	SSOURL string `json:"sso_url"`
+	// Certificates are the PEM encoded certificates signing the
+	// assertions, several of them during a rollover
+	Certificates       string `json:"certificates"`
+	FirstNameAttribute string `json:"first_name_attribute"`
+	LastNameAttribute  string `json:"last_name_attribute"`
+	EmailAttribute     string `json:"email_attribute"`
+	// JITProvisioning creates the users unknown to the service on their
+	// first login, otherwise they must have been provisioned beforehand
+	JITProvisioning bool      `json:"jit_provisioning"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// SAMLLogin is a login started with the identity provider of an
+// organization. The user is redirected to the redirect URL, the relay
+// state must be kept by the browser to complete the login.
+type SAMLLogin struct {
+	RedirectURL string
+	RelayState  string
+}
+
+// samlAuthnRequest is an authentication request sent to identity
+// providers (SAML core section 3.4.1)
+type samlAuthnRequest struct {
+	XMLName                     xml.Name         `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string           `xml:"ID,attr"`
+	Version                     string           `xml:"Version,attr"`
+	IssueInstant                string           `xml:"IssueInstant,attr"`
+	Destination                 string           `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string           `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string           `xml:"ProtocolBinding,attr"`
+	Issuer                      samlIssuer       `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                samlNameIDPolicy `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+}
+
+// samlIssuer is the entity issuing a request or an assertion
+type samlIssuer struct {
+	Value string `xml:",chardata"`
+}
+
+// samlNameIDPolicy lets identity providers create the identifiers of
+// users logging in for the first time
+type samlNameIDPolicy struct {
+	AllowCreate bool `xml:"AllowCreate,attr"`
+}
+
+// samlEntityDescriptor is the metadata of the service provider given to
+// identity providers (SAML metadata section 2.3.2)
+type samlEntityDescriptor struct {
+	XMLName         xml.Name            `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string              `xml:"entityID,attr"`
+	SPSSODescriptor samlSPSSODescriptor `xml:"SPSSODescriptor"`
+}
+
+// samlSPSSODescriptor describes the single sign-on endpoints of the
+// service provider
+type samlSPSSODescriptor struct {
+	AuthnRequestsSigned        bool                         `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool                         `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string                       `xml:"protocolSupportEnumeration,attr"`
+	AssertionConsumerService   samlAssertionConsumerService `xml:"AssertionConsumerService"`
+}
+
+// samlAssertionConsumerService is the endpoint receiving the responses
+// of identity providers
+type samlAssertionConsumerService struct {
+	Binding   string `xml:"Binding,attr"`
+	Location  string `xml:"Location,attr"`
+	Index     int    `xml:"index,attr"`
+	IsDefault bool   `xml:"isDefault,attr"`
+}
+
+// samlResponse is the envelope of the assertions sent by identity
+// providers (SAML core section 3.3.3)
+type samlResponse struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Destination  string   `xml:"Destination,attr"`
+	Issuer       string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Status       struct {
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+}
+
+// samlAssertion is the statement of an identity provider about the
+// user it authenticated (SAML core section 2.3.3)
+type samlAssertion struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	Issuer  string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Subject struct {
+		NameID               string `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+		SubjectConfirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				Recipient    string    `xml:"Recipient,attr"`
+				NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+				InResponseTo string    `xml:"InResponseTo,attr"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmationData"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmation"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
+	Conditions struct {
+		NotBefore            time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter         time.Time `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Audience"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AudienceRestriction"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Conditions"`
+	Attributes []samlAttribute `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeStatement>Attribute"`
+}
+
+// samlAttribute is an attribute of the user asserted by an identity
+// provider
+type samlAttribute struct {
+	Name         string   `xml:"Name,attr"`
+	FriendlyName string   `xml:"FriendlyName,attr"`
+	Values       []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeValue"`
+}
+
+// value returns the first value of the attribute named name, matching
+// either its name or its friendly name
+func (a samlAssertion) value(name string) string {
+	for _, attribute := range a.Attributes {
+		if (attribute.Name == name || attribute.FriendlyName == name) && len(attribute.Values) > 0 {
+			return strings.TrimSpace(attribute.Values[0])
+		}
+	}
+	return ""
+}
+
+// CreateOrganization creates an organization, identified by its slug in
+// the URLs of its SAML endpoints
+func (u *Usecases) CreateOrganization(ctx context.Context, slug, name string) (*OrganizationData, error) {
+	queries, err := u.postgresQueries("Organizations")
+	if err != nil {
+		return nil, err
+	}
+	if !regexp.MustCompile(organizationSlugRegex).MatchString(slug) {
+		return nil, &ValidationError{
+			Message: fmt.Sprintf("invalid organization slug [%s]: must be lowercase letters, digits or -", slug),
+		}
+	}
+	if strings.TrimSpace(name) == "" {
+		return nil, &ValidationError{Message: "organization name is required"}
+	}
+	organization, err := queries.CreateOrganization(ctx, CreateOrganizationParams{
+		Slug: slug,
+		Name: strings.TrimSpace(name),
+	})
+	if err != nil {
+		if pgErrorCode(err) == pgUniqueViolation {
+			return nil, &ValidationError{
+				Message: fmt.Sprintf("organization [%s] already exists", slug),
+			}
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not create organization: %w", err).Error(),
+		}
+	}
+	data := organizationData(organization)
+	return &data, nil
+}
+
+// ListOrganizations lists the organizations
+func (u *Usecases) ListOrganizations(ctx context.Context) ([]OrganizationData, error) {
+	queries, err := u.postgresQueries("Organizations")
+	if err != nil {
+		return nil, err
+	}
+	organizations, err := queries.ListOrganizations(ctx)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not list organizations: %w", err).Error(),
+		}
+	}
+	data := make([]OrganizationData, 0, len(organizations))
+	for _, organization := range organizations {
+		data = append(data, organizationData(organization))
+	}
+	return data, nil
+}
+
+// GetSAMLIdentityProvider returns the SAML identity provider of an
+// organization
+func (u *Usecases) GetSAMLIdentityProvider(ctx context.Context, organizationSlug string) (*SAMLIdentityProviderData, error) {
+	_, provider, err := u.getSAMLIdentityProvider(ctx, organizationSlug)
+	if err != nil {
+		return nil, err
+	}
+	return &SAMLIdentityProviderData{
+		EntityID:           provider.EntityID,
+		SSOURL:             provider.SsoUrl,
+		Certificates:       provider.Certificates,
+		FirstNameAttribute: provider.FirstNameAttribute,
+		LastNameAttribute:  provider.LastNameAttribute,
+		EmailAttribute:     provider.EmailAttribute,
+		JITProvisioning:    provider.JitProvisioning,
+		UpdatedAt:          provider.UpdatedAt.Time,
+	}, nil
+}
+
+// ConfigureSAMLIdentityProvider sets the SAML identity provider of an
+// organization, replacing the previous one. The attributes not given
+// default to the X.500 ones.
+func (u *Usecases) ConfigureSAMLIdentityProvider(ctx context.Context, organizationSlug string, provider SAMLIdentityProviderData) error {
+	queries, err := u.postgresQueries("SAML logins")
+	if err != nil {
+		return err
+	}
+	organization, err := u.getOrganization(ctx, organizationSlug)
+	if err != nil {
+		return err
+	}
+	if provider.EntityID == "" {
+		return &ValidationError{Message: "identity provider entity ID is required"}
+	}
+	ssoURL, err := url.Parse(provider.SSOURL)
+	if err != nil || (ssoURL.Scheme != "https" && ssoURL.Scheme != "http") || ssoURL.Host == "" {
+		return &ValidationError{
+			Message: fmt.Sprintf("invalid identity provider SSO URL [%s]", provider.SSOURL),
+		}
+	}
+	if _, err := parseSAMLCertificates(provider.Certificates); err != nil {
+		return &ValidationError{
+			Message: fmt.Errorf("invalid identity provider certificates: %w", err).Error(),
+		}
+	}
+	defaults := []struct {
+		attribute *string
+		value     string
+	}{
+		{&provider.FirstNameAttribute, DefaultSAMLFirstNameAttribute},
+		{&provider.LastNameAttribute, DefaultSAMLLastNameAttribute},
+		{&provider.EmailAttribute, DefaultSAMLEmailAttribute},
+	}
+	for _, attribute := range defaults {
+		if *attribute.attribute == "" {
+			*attribute.attribute = attribute.value
+		}
+	}
+
+	err = queries.UpsertSAMLIdentityProvider(ctx, UpsertSAMLIdentityProviderParams{
+		OrganizationID:     organization.OrganizationID,
+		EntityID:           provider.EntityID,
+		SsoUrl:             provider.SSOURL,
+		Certificates:       provider.Certificates,
+		FirstNameAttribute: provider.FirstNameAttribute,
+		LastNameAttribute:  provider.LastNameAttribute,
+		EmailAttribute:     provider.EmailAttribute,
+		JitProvisioning:    provider.JITProvisioning,
+	})
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not configure identity provider: %w", err).Error(),
+		}
+	}
+	return nil
+}
+
+// DeleteSAMLIdentityProvider removes the SAML identity provider of an
+// organization, its users cannot log in with it anymore
+func (u *Usecases) DeleteSAMLIdentityProvider(ctx context.Context, organizationSlug string) error {
+	queries, err := u.postgresQueries("SAML logins")
+	if err != nil {
+		return err
+	}
+	organization, err := u.getOrganization(ctx, organizationSlug)
+	if err != nil {
+		return err
+	}
+	deleted, err := queries.DeleteSAMLIdentityProvider(ctx, organization.OrganizationID)
+	if err != nil {
+		return &ServerError{
+			Message: fmt.Errorf("could not delete identity provider: %w", err).Error(),
+		}
+	}
+	if deleted == 0 {
+		return &NotFoundError{
+			Message: fmt.Sprintf("organization [%s] has no identity provider", organizationSlug),
+		}
+	}
+	return nil
+}
+
+// SAMLMetadata returns the metadata of the service provider of an
+// organization, registered with its identity provider
+func (u *Usecases) SAMLMetadata(ctx context.Context, organizationSlug string) ([]byte, error) {
+	organization, err := u.getOrganization(ctx, organizationSlug)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := xml.MarshalIndent(samlEntityDescriptor{
+		EntityID: u.samlEntityID(organization),
+		SPSSODescriptor: samlSPSSODescriptor{
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: samlProtocolNamespace,
+			AssertionConsumerService: samlAssertionConsumerService{
+				Binding:   samlHTTPPostBinding,
+				Location:  u.samlAssertionConsumerServiceURL(organization),
+				IsDefault: true,
+			},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not marshal SAML metadata: %w", err).Error(),
+		}
+	}
+	return append([]byte(xml.Header), metadata...), nil
+}
+
+// StartSAMLLogin starts the login of a user with the SAML identity
+// provider of an organization, sending an authentication request with
+// the HTTP-Redirect binding
+func (u *Usecases) StartSAMLLogin(ctx context.Context, organizationSlug string, rememberMe bool) (*SAMLLogin, error) {
+	queries, err := u.postgresQueries("SAML logins")
+	if err != nil {
+		return nil, err
+	}
+	organization, provider, err := u.getSAMLIdentityProvider(ctx, organizationSlug)
+	if err != nil {
+		return nil, err
+	}
+	relayState, err := randomString(samlRelayStateSize)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not generate relay state: %w", err).Error(),
+		}
+	}
+	requestID, err := randomString(samlRequestIDSize)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not generate SAML request ID: %w", err).Error(),
+		}
+	}
+	// IDs are xsd:ID values which must not start with a digit or a dash
+	requestID = "id-" + requestID
+
+	now := time.Now()
+	err = queries.CreateSAMLRequest(ctx, CreateSAMLRequestParams{
+		RequestID:      requestID,
+		OrganizationID: organization.OrganizationID,
+		RelayStateHash: hashSecret(relayState),
+		RememberMe:     rememberMe,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  now.Add(samlRequestValidity),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not create SAML request: %w", err).Error(),
+		}
+	}
+
+	request, err := xml.Marshal(samlAuthnRequest{
+		ID:                          requestID,
+		Version:                     "2.0",
+		IssueInstant:                now.UTC().Format(time.RFC3339),
+		Destination:                 provider.SsoUrl,
+		AssertionConsumerServiceURL: u.samlAssertionConsumerServiceURL(organization),
+		ProtocolBinding:             samlHTTPPostBinding,
+		Issuer:                      samlIssuer{Value: u.samlEntityID(organization)},
+		NameIDPolicy:                samlNameIDPolicy{AllowCreate: true},
+	})
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not marshal SAML request: %w", err).Error(),
+		}
+	}
+	// the HTTP-Redirect binding deflates the request before encoding it
+	// (SAML bindings section 3.4.4.1)
+	var deflated bytes.Buffer
+	writer, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err == nil {
+		_, err = writer.Write(request)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not deflate SAML request: %w", err).Error(),
+		}
+	}
+
+	redirectURL, err := url.Parse(provider.SsoUrl)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("invalid SSO URL: %w", err).Error(),
+		}
+	}
+	query := redirectURL.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	query.Set("RelayState", relayState)
+	redirectURL.RawQuery = query.Encode()
+	return &SAMLLogin{
+		RedirectURL: redirectURL.String(),
+		RelayState:  relayState,
+	}, nil
+}
+
+// CompleteSAMLLogin completes the login of a user whose browser posted
+// the response of the identity provider of an organization. The
+// response must answer a request sent by the service for this browser,
+// identified by its relay state, unsolicited responses are rejected.
+// Users logging in for the first time are provisioned if the
+// organization allows it.
+func (u *Usecases) CompleteSAMLLogin(ctx context.Context, organizationSlug, encodedResponse, relayState string, metadata SessionMetadata) (*IDTokens, error) {
+	queries, err := u.postgresQueries("SAML logins")
+	if err != nil {
+		return nil, err
+	}
+	organization, provider, err := u.getSAMLIdentityProvider(ctx, organizationSlug)
+	if err != nil {
+		return nil, err
+	}
+	assertion, err := u.verifySAMLResponse(organization, provider, encodedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// the request can only be answered once, replayed responses find it
+	// consumed already
+	request, err := queries.ConsumeSAMLRequest(ctx, assertion.Subject.SubjectConfirmations[0].Data.InResponseTo)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, InvalidSAMLLoginError
+		}
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not consume SAML request: %w", err).Error(),
+		}
+	}
+	if request.ExpiresAt.Time.Before(time.Now()) || request.OrganizationID != organization.OrganizationID ||
+		request.RelayStateHash != hashSecret(relayState) {
+		return nil, InvalidSAMLLoginError
+	}
+
+	userID, err := u.samlUser(ctx, organization, provider, assertion)
+	if err != nil {
+		return nil, err
+	}
+	params := u.newCreateSessionParams(userID, LoginMethodSAML, request.RememberMe, metadata)
+	return u.openSession(ctx, params, "")
+}
+
+// verifySAMLResponse verifies the signature and the conditions of the
+// response of an identity provider, and returns its assertion. Only the
+// signed elements are read, so that no unsigned assertion can be
+// wrapped in a signed response.
+func (u *Usecases) verifySAMLResponse(organization Organization, provider Samlidentityprovider, encodedResponse string) (*samlAssertion, error) {
+	invalid := func(reason string) error {
+		return &ValidationError{Message: "invalid SAML response: " + reason}
+	}
+	rawResponse, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedResponse))
+	if err != nil {
+		return nil, invalid("malformed encoding")
+	}
+	// XML which does not survive a round trip through encoding/xml could
+	// be read differently by the signature validation and the parsing
+	if err := xrv.Validate(bytes.NewReader(rawResponse)); err != nil {
+		return nil, invalid(err.Error())
+	}
+	document := etree.NewDocument()
+	if err := document.ReadFromBytes(rawResponse); err != nil {
+		return nil, invalid("malformed XML")
+	}
+	root := document.Root()
+	if root == nil || root.Tag != "Response" || root.NamespaceURI() != samlProtocolNamespace {
+		return nil, invalid("not a SAML response")
+	}
+
+	var response samlResponse
+	if err := xml.Unmarshal(rawResponse, &response); err != nil {
+		return nil, invalid("malformed response")
+	}
+	if response.Status.StatusCode.Value != samlStatusSuccess {
+		return nil, invalid(fmt.Sprintf("identity provider refused the login: %s", response.Status.StatusCode.Value))
+	}
+	acsURL := u.samlAssertionConsumerServiceURL(organization)
+	if response.Destination != "" && response.Destination != acsURL {
+		return nil, invalid("wrong destination")
+	}
+	if response.Issuer != "" && response.Issuer != provider.EntityID {
+		return nil, invalid("wrong issuer")
+	}
+
+	certificates, err := parseSAMLCertificates(provider.Certificates)
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("invalid certificates of organization [%s]: %w", organization.Slug, err).Error(),
+		}
+	}
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certificates})
+	assertionElement, err := samlSignedAssertion(validator, root)
+	if err != nil {
+		return nil, invalid(err.Error())
+	}
+	signed := etree.NewDocument()
+	signed.SetRoot(assertionElement)
+	rawAssertion, err := signed.WriteToBytes()
+	if err != nil {
+		return nil, &ServerError{
+			Message: fmt.Errorf("could not serialize SAML assertion: %w", err).Error(),
+		}
+	}
+	var assertion samlAssertion
+	if err := xml.Unmarshal(rawAssertion, &assertion); err != nil {
+		return nil, invalid("malformed assertion")
+	}
+
+	now := time.Now()
+	if assertion.Issuer != provider.EntityID {
+		return nil, invalid("wrong assertion issuer")
+	}
+	if strings.TrimSpace(assertion.Subject.NameID) == "" {
+		return nil, invalid("missing name ID")
+	}
+	assertion.Subject.NameID = strings.TrimSpace(assertion.Subject.NameID)
+	// a bearer confirmation for this service and a request it sent must
+	// be present, checked first in place of the others
+	confirmed := false
+	for i, confirmation := range assertion.Subject.SubjectConfirmations {
+		data := confirmation.Data
+		if confirmation.Method == samlBearerConfirmation && data.Recipient == acsURL && data.InResponseTo != "" &&
+			(response.InResponseTo == "" || response.InResponseTo == data.InResponseTo) &&
+			now.Before(data.NotOnOrAfter.Add(samlClockSkew)) {
+			confirmations := assertion.Subject.SubjectConfirmations
+			confirmations[0], confirmations[i] = confirmations[i], confirmations[0]
+			confirmed = true
+			break
+		}
+	}
+	if !confirmed {
+		return nil, invalid("no valid bearer subject confirmation")
+	}
+	conditions := assertion.Conditions
+	if !conditions.NotBefore.IsZero() && now.Add(samlClockSkew).Before(conditions.NotBefore) {
+		return nil, invalid("assertion is not valid yet")
+	}
+	if !conditions.NotOnOrAfter.IsZero() && !now.Before(conditions.NotOnOrAfter.Add(samlClockSkew)) {
+		return nil, invalid("assertion has expired")
+	}
+	// every audience restriction must include the service
+	entityID := u.samlEntityID(organization)
+	if len(conditions.AudienceRestrictions) == 0 {
+		return nil, invalid("missing audience restriction")
+	}
+	for _, restriction := range conditions.AudienceRestrictions {
+		found := false
+		for _, audience := range restriction.Audiences {
+			found = found || strings.TrimSpace(audience) == entityID
+		}
+		if !found {
+			return nil, invalid("wrong audience")
+		}
+	}
+	return &assertion, nil
+}
+
+// samlSignedAssertion returns the assertion of a response, verified
+// either by its own signature or by the signature of the response
+func samlSignedAssertion(validator *dsig.ValidationContext, response *etree.Element) (*etree.Element, error) {
+	if response.FindElement("./EncryptedAssertion") != nil {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertions := response.FindElements("./Assertion")
+	if len(assertions) != 1 || assertions[0].NamespaceURI() != samlAssertionNamespace {
+		return nil, errors.New("response must contain exactly one assertion")
+	}
+	assertion := assertions[0]
+
+	if assertion.FindElement("./Signature") != nil {
+		// the namespaces declared by the response are copied to the
+		// assertion, verified on its own
+		namespaces, err := etreeutils.NSBuildParentContext(assertion)
+		if err != nil {
+			return nil, err
+		}
+		detached, err := etreeutils.NSDetatch(namespaces, assertion)
+		if err != nil {
+			return nil, err
+		}
+		verified, err := validator.Validate(detached)
+		if err != nil {
+			return nil, fmt.Errorf("invalid assertion signature: %w", err)
+		}
+		return verified, nil
+	}
+	verified, err := validator.Validate(response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response signature: %w", err)
+	}
+	assertions = verified.FindElements("./Assertion")
+	if len(assertions) != 1 {
+		return nil, errors.New("response must contain exactly one assertion")
+	}
+	namespaces, err := etreeutils.NSBuildParentContext(assertions[0])
+	if err != nil {
+		return nil, err
+	}
+	return etreeutils.NSDetatch(namespaces, assertions[0])
+}
+
+// samlUser returns the user of the name ID of an assertion, updating
+// the names and email from its attributes, or provisions the user if
+// the organization allows it
+func (u *Usecases) samlUser(ctx context.Context, organization Organization, provider Samlidentityprovider, assertion *samlAssertion) (pgtype.UUID, error) {
+	queries, err := u.postgresQueries("SAML logins")
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	firstName := assertion.value(provider.FirstNameAttribute)
+	lastName := assertion.value(provider.LastNameAttribute)
+	email := assertion.value(provider.EmailAttribute)
+	if valid, _ := ValidateEmail(email); email != "" && !valid {
+		return pgtype.UUID{}, &ValidationError{
+			Message: fmt.Sprintf("invalid email [%s] asserted by the identity provider", email),
+		}
+	}
+
+	login, err := queries.GetUserLoginSAML(ctx, GetUserLoginSAMLParams{
+		OrganizationID: organization.OrganizationID,
+		NameID:         assertion.Subject.NameID,
+	})
+	if err == nil {
+		// the identity provider is the source of truth of its users
+		err = queries.ExecTx(ctx, func(queries *Queries) error {
+			if firstName != "" || lastName != "" {
+				err := queries.UpdateUserNames(ctx, UpdateUserNamesParams{
+					UserID:    login.UserID,
+					FirstName: firstName,
+					LastName:  lastName,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			if email == "" || email == login.Email {
+				return nil
+			}
+			return queries.UpdateUserLoginSAMLEmail(ctx, UpdateUserLoginSAMLEmailParams{
+				OrganizationID: organization.OrganizationID,
+				NameID:         assertion.Subject.NameID,
+				Email:          email,
+			})
+		})
+		if err != nil {
+			return pgtype.UUID{}, &ServerError{
+				Message: fmt.Errorf("could not update SAML user: %w", err).Error(),
+			}
+		}
+		return login.UserID, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return pgtype.UUID{}, &ServerError{
+			Message: fmt.Errorf("could not get SAML login: %w", err).Error(),
+		}
+	}
+
+	if !provider.JitProvisioning {
+		return pgtype.UUID{}, &NotFoundError{
+			Message: fmt.Sprintf("user is not provisioned in organization [%s]", organization.Slug),
+		}
+	}
+	// an email of an existing account is rejected, so the identity
+	// provider cannot take the account over
+	if email != "" {
+		if err := checkEmailUniqueness(ctx, queries, email); err != nil {
+			return pgtype.UUID{}, err
+		}
+	}
+	var userID pgtype.UUID
+	err = queries.ExecTx(ctx, func(queries *Queries) error {
+		var err error
+		userID, err = queries.CreateUser(ctx, CreateUserParams{
+			FirstName: firstName,
+			LastName:  lastName,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not create user: %w", err).Error(),
+			}
+		}
+		err = queries.CreateUserLoginSAML(ctx, CreateUserLoginSAMLParams{
+			OrganizationID: organization.OrganizationID,
+			NameID:         assertion.Subject.NameID,
+			UserID:         userID,
+			Email:          email,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not create SAML login: %w", err).Error(),
+			}
+		}
+		err = queries.AssignUserRole(ctx, AssignUserRoleParams{
+			UserID:   userID,
+			RoleName: DefaultRole,
+		})
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not assign default role: %w", err).Error(),
+			}
+		}
+		return nil
+	})
+	return userID, err
+}
+
+// getOrganization returns an organization by its slug
+func (u *Usecases) getOrganization(ctx context.Context, organizationSlug string) (Organization, error) {
+	queries, err := u.postgresQueries("Organizations")
+	if err != nil {
+		return Organization{}, err
+	}
+	organization, err := queries.GetOrganization(ctx, organizationSlug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Organization{}, &NotFoundError{
+				Message: fmt.Sprintf("organization [%s] not found", organizationSlug),
+			}
+		}
+		return Organization{}, &ServerError{
+			Message: fmt.Errorf("could not get organization: %w", err).Error(),
+		}
+	}
+	return organization, nil
+}
+
+// getSAMLIdentityProvider returns an organization and its SAML identity
+// provider
+func (u *Usecases) getSAMLIdentityProvider(ctx context.Context, organizationSlug string) (Organization, Samlidentityprovider, error) {
+	queries, err := u.postgresQueries("SAML logins")
+	if err != nil {
+		return Organization{}, Samlidentityprovider{}, err
+	}
+	organization, err := u.getOrganization(ctx, organizationSlug)
+	if err != nil {
+		return Organization{}, Samlidentityprovider{}, err
+	}
+	provider, err := queries.GetSAMLIdentityProvider(ctx, organization.OrganizationID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Organization{}, Samlidentityprovider{}, &NotFoundError{
+				Message: fmt.Sprintf("organization [%s] has no identity provider", organizationSlug),
+			}
+		}
+		return Organization{}, Samlidentityprovider{}, &ServerError{
+			Message: fmt.Errorf("could not get identity provider: %w", err).Error(),
+		}
+	}
+	return organization, provider, nil
+}
+
+// samlEntityID returns the entity ID of the service provider of an
+// organization, the URL of its metadata
+func (u *Usecases) samlEntityID(organization Organization) string {
+	return u.issuer + "/saml/" + organization.Slug + "/metadata"
+}
+
+// samlAssertionConsumerServiceURL returns the endpoint receiving the
+// responses of the identity provider of an organization
+func (u *Usecases) samlAssertionConsumerServiceURL(organization Organization) string {
+	return u.issuer + "/saml/" + organization.Slug + "/acs"
+}
+
+// parseSAMLCertificates parses the PEM encoded certificates of an
+// identity provider
+func parseSAMLCertificates(certificates string) ([]*x509.Certificate, error) {
+	var parsed []*x509.Certificate
+	rest := []byte(certificates)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %s", block.Type)
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, certificate)
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("no PEM encoded certificate")
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, errors.New("trailing data after the certificates")
+	}
+	return parsed, nil
+}
+
+// organizationData converts an organization to its data transfer object
+func organizationData(organization Organization) OrganizationData {
+	return OrganizationData{
+		Slug:      organization.Slug,
+		Name:      organization.Name,
+		CreatedAt: organization.CreatedAt.Time,
+	}
+}
@@ -0,0 +1,147 @@
+package betalinkauth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// samlRelayStateCookie is the cookie binding a SAML login to the
+	// browser which started it, holding the relay state sent to the
+	// identity provider
+	samlRelayStateCookie = "saml_relay_state"
+	// samlMaxResponseSize is the maximum size in bytes of the forms
+	// posted by identity providers
+	samlMaxResponseSize = 256 << 10
+)
+
+// createOrganizationDto is the data transfer object for creating an
+// organization
+type createOrganizationDto struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+// samlMetadata handles the http request for the metadata of the service
+// provider of an organization
+func (r *Router) samlMetadata(ctx *gin.Context) {
+	metadata, err := r.usecases.SAMLMetadata(ctx, ctx.Param("organization"))
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not get SAML metadata: %w", err))
+		return
+	}
+	ctx.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// startSAMLLogin handles the http request to log in with the identity
+// provider of an organization, redirecting the user to the provider
+func (r *Router) startSAMLLogin(ctx *gin.Context) {
+	login, err := r.usecases.StartSAMLLogin(ctx, ctx.Param("organization"), ctx.Query("remember_me") == "true")
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not start SAML login: %w", err))
+		return
+	}
+	// the identity provider posts the response from its own site, the
+	// cookie must be sent with cross-site requests
+	ctx.SetSameSite(http.SameSiteNoneMode)
+	ctx.SetCookie(samlRelayStateCookie, login.RelayState, int(samlRequestValidity.Seconds()),
+		"/saml", "localhost", true, true)
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Redirect(http.StatusFound, login.RedirectURL)
+}
+
+// completeSAMLLogin handles the response of an identity provider posted
+// by the browser of the user, logging the user in
+func (r *Router) completeSAMLLogin(ctx *gin.Context) {
+	relayState, _ := ctx.Cookie(samlRelayStateCookie)
+	ctx.SetSameSite(http.SameSiteNoneMode)
+	ctx.SetCookie(samlRelayStateCookie, "", -1, "/saml", "localhost", true, true)
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, samlMaxResponseSize)
+	// the relay state must be the one of the login started by this
+	// browser, otherwise a user could be logged in the account of an
+	// attacker
+	if relayState == "" || subtle.ConstantTimeCompare([]byte(relayState), []byte(ctx.PostForm("RelayState"))) != 1 {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, errors.New(InvalidSAMLLoginError.Message))
+		return
+	}
+
+	metadata := SessionMetadata{
+		IPAddress: ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+	tokens, err := r.usecases.CompleteSAMLLogin(ctx, ctx.Param("organization"), ctx.PostForm("SAMLResponse"), relayState, metadata)
+	if err != nil {
+		statusCode := getErrorStatusCode(err)
+		if _, ok := err.(*ValidationError); ok {
+			statusCode = http.StatusUnauthorized
+		}
+		writeResponse(ctx, statusCode, false, nil, fmt.Errorf("could not login the user: %w", err))
+		return
+	}
+	writeLoginResponse(ctx, tokens, tokens.RememberMe)
+}
+
+// listOrganizations handles the http request to list the organizations
+func (r *Router) listOrganizations(ctx *gin.Context) {
+	organizations, err := r.usecases.ListOrganizations(ctx)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not list organizations: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, organizations, nil)
+}
+
+// createOrganization handles the http request to create an organization
+func (r *Router) createOrganization(ctx *gin.Context) {
+	var dto createOrganizationDto
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	organization, err := r.usecases.CreateOrganization(ctx, dto.Slug, dto.Name)
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not create organization: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusCreated, true, organization, nil)
+}
+
+// getSAMLIdentityProvider handles the http request for the SAML identity
+// provider of an organization
+func (r *Router) getSAMLIdentityProvider(ctx *gin.Context) {
+	provider, err := r.usecases.GetSAMLIdentityProvider(ctx, ctx.Param("organization"))
+	if err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not get identity provider: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, provider, nil)
+}
+
+// configureSAMLIdentityProvider handles the http request to set the SAML
+// identity provider of an organization
+func (r *Router) configureSAMLIdentityProvider(ctx *gin.Context) {
+	var dto SAMLIdentityProviderData
+	if err := ctx.BindJSON(&dto); err != nil {
+		writeResponse(ctx, http.StatusBadRequest, false, nil, fmt.Errorf("could not bind json: %w", err))
+		return
+	}
+	if err := r.usecases.ConfigureSAMLIdentityProvider(ctx, ctx.Param("organization"), dto); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not configure identity provider: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
+
+// deleteSAMLIdentityProvider handles the http request to remove the SAML
+// identity provider of an organization
+func (r *Router) deleteSAMLIdentityProvider(ctx *gin.Context) {
+	if err := r.usecases.DeleteSAMLIdentityProvider(ctx, ctx.Param("organization")); err != nil {
+		writeResponse(ctx, getErrorStatusCode(err), false, nil, fmt.Errorf("could not delete identity provider: %w", err))
+		return
+	}
+	writeResponse(ctx, http.StatusOK, true, nil, nil)
+}
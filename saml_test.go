@@ -0,0 +1,286 @@
+//go:build integration
+
+package betalinkauth_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testSAMLIssuer   = "https://auth.betalink.test"
+	testSAMLEntityID = "https://idp.acme.test/metadata"
+)
+
+// mockSAMLIdP is a SAML identity provider signing the responses built
+// by the tests with a locally generated certificate
+type mockSAMLIdP struct {
+	key         *rsa.PrivateKey
+	certificate *x509.Certificate
+}
+
+// samlAssertionOptions are the parts of the responses of the mock IdP
+// varied by the tests
+type samlAssertionOptions struct {
+	requestID    string
+	nameID       string
+	audience     string
+	notOnOrAfter time.Time
+	attributes   map[string]string
+}
+
+func newMockSAMLIdP(t *testing.T) *mockSAMLIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.acme.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &mockSAMLIdP{key: key, certificate: certificate}
+}
+
+// certificatePEM returns the PEM encoded signing certificate of the IdP
+func (idp *mockSAMLIdP) certificatePEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: idp.certificate.Raw}))
+}
+
+// response returns a base64 encoded response whose assertion is signed
+// when sign is true
+func (idp *mockSAMLIdP) response(t *testing.T, options samlAssertionOptions, sign bool) string {
+	acsURL := testSAMLIssuer + "/saml/acme/acs"
+	now := time.Now().UTC()
+	if options.notOnOrAfter.IsZero() {
+		options.notOnOrAfter = now.Add(5 * time.Minute)
+	}
+	if options.audience == "" {
+		options.audience = testSAMLIssuer + "/saml/acme/metadata"
+	}
+	var attributes strings.Builder
+	for name, value := range options.attributes {
+		fmt.Fprintf(&attributes, `<saml:Attribute Name="%s"><saml:AttributeValue>%s</saml:AttributeValue></saml:Attribute>`, name, value)
+	}
+	expiry := options.notOnOrAfter.UTC().Format(time.RFC3339)
+	response := fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="response-1" Version="2.0" IssueInstant="%[1]s" Destination="%[2]s" InResponseTo="%[3]s">
+<saml:Issuer>%[4]s</saml:Issuer>
+<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="assertion-1" Version="2.0" IssueInstant="%[1]s">
+<saml:Issuer>%[4]s</saml:Issuer>
+<saml:Subject>
+<saml:NameID>%[5]s</saml:NameID>
+<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer"><saml:SubjectConfirmationData Recipient="%[2]s" NotOnOrAfter="%[6]s" InResponseTo="%[3]s"/></saml:SubjectConfirmation>
+</saml:Subject>
+<saml:Conditions NotBefore="%[1]s" NotOnOrAfter="%[6]s"><saml:AudienceRestriction><saml:Audience>%[7]s</saml:Audience></saml:AudienceRestriction></saml:Conditions>
+<saml:AttributeStatement>%[8]s</saml:AttributeStatement>
+</saml:Assertion>
+</samlp:Response>`, now.Format(time.RFC3339), acsURL, options.requestID, testSAMLEntityID,
+		options.nameID, expiry, options.audience, attributes.String())
+
+	document := etree.NewDocument()
+	require.NoError(t, document.ReadFromString(response))
+	if sign {
+		signer, err := dsig.NewSigningContext(idp.key, [][]byte{idp.certificate.Raw})
+		require.NoError(t, err)
+		signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+		assertion := document.Root().FindElement("./Assertion")
+		signed, err := signer.SignEnveloped(assertion)
+		require.NoError(t, err)
+		document.Root().RemoveChild(assertion)
+		document.Root().AddChild(signed)
+	}
+	raw, err := document.WriteToBytes()
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// samlRequestID returns the ID of the authentication request sent with
+// the HTTP-Redirect binding to the redirect URL
+func samlRequestID(t *testing.T, redirectURL string) string {
+	parsed, err := url.Parse(redirectURL)
+	require.NoError(t, err)
+	deflated, err := base64.StdEncoding.DecodeString(parsed.Query().Get("SAMLRequest"))
+	require.NoError(t, err)
+	request, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	require.NoError(t, err)
+	assert.Contains(t, string(request), `AssertionConsumerServiceURL="`+testSAMLIssuer+`/saml/acme/acs"`)
+	assert.Contains(t, string(request), testSAMLIssuer+"/saml/acme/metadata</Issuer>")
+	matches := regexp.MustCompile(`ID="([^"]+)"`).FindStringSubmatch(string(request))
+	require.Len(t, matches, 2)
+	return matches[1]
+}
+
+func TestSAMLLogin(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(testCtx)
+
+	logger, err := createLogger()
+	require.NoError(t, err)
+	usecases, err := betalinkauth.NewUsecase(logger, betalinkauth.New(conn), betalinkauth.WithIssuer(testSAMLIssuer))
+	require.NoError(t, err)
+
+	idp := newMockSAMLIdP(t)
+	_, err = usecases.CreateOrganization(testCtx, "acme", "Acme")
+	require.NoError(t, err)
+	err = usecases.ConfigureSAMLIdentityProvider(testCtx, "acme", betalinkauth.SAMLIdentityProviderData{
+		EntityID:        testSAMLEntityID,
+		SSOURL:          "https://idp.acme.test/sso",
+		Certificates:    idp.certificatePEM(),
+		JITProvisioning: true,
+	})
+	require.NoError(t, err)
+
+	attributes := map[string]string{
+		betalinkauth.DefaultSAMLFirstNameAttribute: "Wile",
+		betalinkauth.DefaultSAMLLastNameAttribute:  "Coyote",
+		betalinkauth.DefaultSAMLEmailAttribute:     "wile@acme.test",
+	}
+	start := func(t *testing.T) (*betalinkauth.SAMLLogin, string) {
+		login, err := usecases.StartSAMLLogin(testCtx, "acme", false)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(login.RedirectURL, "https://idp.acme.test/sso?"))
+		return login, samlRequestID(t, login.RedirectURL)
+	}
+
+	t.Run("metadata", func(t *testing.T) {
+		metadata, err := usecases.SAMLMetadata(testCtx, "acme")
+		require.NoError(t, err)
+		assert.Contains(t, string(metadata), `entityID="`+testSAMLIssuer+`/saml/acme/metadata"`)
+		assert.Contains(t, string(metadata), `Location="`+testSAMLIssuer+`/saml/acme/acs"`)
+		assert.Contains(t, string(metadata), `WantAssertionsSigned="true"`)
+	})
+
+	t.Run("provisions the user on the first login", func(t *testing.T) {
+		login, requestID := start(t)
+		response := idp.response(t, samlAssertionOptions{requestID: requestID, nameID: "wile", attributes: attributes}, true)
+		tokens, err := usecases.CompleteSAMLLogin(testCtx, "acme", response, login.RelayState, testSessionMetadata)
+		require.NoError(t, err)
+		user, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "Wile", user.FirstName)
+		assert.Equal(t, "Coyote", user.LastName)
+		assert.Contains(t, user.Roles, betalinkauth.DefaultRole)
+
+		// the response cannot be replayed, its request has been answered
+		_, err = usecases.CompleteSAMLLogin(testCtx, "acme", response, login.RelayState, testSessionMetadata)
+		assert.Equal(t, betalinkauth.InvalidSAMLLoginError, err)
+
+		// the next login updates the names of the same user
+		login, requestID = start(t)
+		renamed := map[string]string{
+			betalinkauth.DefaultSAMLFirstNameAttribute: "Wile E.",
+			betalinkauth.DefaultSAMLLastNameAttribute:  "Coyote",
+		}
+		response = idp.response(t, samlAssertionOptions{requestID: requestID, nameID: "wile", attributes: renamed}, true)
+		tokens, err = usecases.CompleteSAMLLogin(testCtx, "acme", response, login.RelayState, testSessionMetadata)
+		require.NoError(t, err)
+		renamedUser, err := usecases.ValidateAccessToken(testCtx, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, user.UserID, renamedUser.UserID)
+		assert.Equal(t, "Wile E.", renamedUser.FirstName)
+	})
+
+	t.Run("rejects invalid responses", func(t *testing.T) {
+		otherIdP := newMockSAMLIdP(t)
+		tests := []struct {
+			name     string
+			response func(requestID string) string
+		}{
+			{
+				name: "unsigned assertion",
+				response: func(requestID string) string {
+					return idp.response(t, samlAssertionOptions{requestID: requestID, nameID: "wile"}, false)
+				},
+			},
+			{
+				name: "signed by another key",
+				response: func(requestID string) string {
+					return otherIdP.response(t, samlAssertionOptions{requestID: requestID, nameID: "wile"}, true)
+				},
+			},
+			{
+				name: "tampered assertion",
+				response: func(requestID string) string {
+					signed := idp.response(t, samlAssertionOptions{requestID: requestID, nameID: "wile"}, true)
+					raw, err := base64.StdEncoding.DecodeString(signed)
+					require.NoError(t, err)
+					tampered := strings.Replace(string(raw), ">wile<", ">road-runner<", 1)
+					return base64.StdEncoding.EncodeToString([]byte(tampered))
+				},
+			},
+			{
+				name: "wrong audience",
+				response: func(requestID string) string {
+					return idp.response(t, samlAssertionOptions{requestID: requestID, nameID: "wile", audience: "https://other.test"}, true)
+				},
+			},
+			{
+				name: "expired assertion",
+				response: func(requestID string) string {
+					return idp.response(t, samlAssertionOptions{requestID: requestID, nameID: "wile", notOnOrAfter: time.Now().Add(-time.Hour)}, true)
+				},
+			},
+			{
+				name: "unsolicited response",
+				response: func(string) string {
+					return idp.response(t, samlAssertionOptions{requestID: "id-unknown", nameID: "wile"}, true)
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				login, requestID := start(t)
+				_, err := usecases.CompleteSAMLLogin(testCtx, "acme", tt.response(requestID), login.RelayState, testSessionMetadata)
+				assert.IsType(t, &betalinkauth.ValidationError{}, err)
+			})
+		}
+	})
+
+	t.Run("rejects the relay state of another browser", func(t *testing.T) {
+		_, requestID := start(t)
+		response := idp.response(t, samlAssertionOptions{requestID: requestID, nameID: "wile"}, true)
+		_, err := usecases.CompleteSAMLLogin(testCtx, "acme", response, "another-relay-state", testSessionMetadata)
+		assert.Equal(t, betalinkauth.InvalidSAMLLoginError, err)
+	})
+
+	t.Run("rejects unknown users without provisioning", func(t *testing.T) {
+		err := usecases.ConfigureSAMLIdentityProvider(testCtx, "acme", betalinkauth.SAMLIdentityProviderData{
+			EntityID:     testSAMLEntityID,
+			SSOURL:       "https://idp.acme.test/sso",
+			Certificates: idp.certificatePEM(),
+		})
+		require.NoError(t, err)
+		login, requestID := start(t)
+		response := idp.response(t, samlAssertionOptions{requestID: requestID, nameID: "road-runner"}, true)
+		_, err = usecases.CompleteSAMLLogin(testCtx, "acme", response, login.RelayState, testSessionMetadata)
+		assert.IsType(t, &betalinkauth.NotFoundError{}, err)
+	})
+}
@@ -0,0 +1,761 @@
+package betalinkauth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteStore is a Store on a SQLite database, for the single-node and
+// development deployments running the auth service as a single binary.
+// Its schema is created by the migrations of migrations/sqlite.
+type SQLiteStore struct {
+	db sqliteDBTX
+	// depth is the number of transactions the store runs in, db being a
+	// *sql.Tx when it is not zero
+	depth int
+}
+
+// sqliteDBTX is implemented by the SQLite database handles, i.e.
+// databases and transactions
+type sqliteDBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// OpenSQLite opens the SQLite database of a file, created if it does not
+// exist, enforcing its foreign keys. The database is used by a single
+// connection, SQLite serializing the writes anyway: the transactions are
+// isolated and ":memory:" databases are shared by the whole store.
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("could not open SQLite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not open SQLite database: %w", err)
+	}
+	return db, nil
+}
+
+// NewSQLiteStore creates a new SQLiteStore instance on a database opened
+// with OpenSQLite
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+// sqliteError converts the errors of SQLite to the ones of the queries,
+// so that the usecases handle them the same way
+func sqliteError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return pgx.ErrNoRows
+	}
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	var code string
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		code = pgUniqueViolation
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		code = pgForeignKeyViolation
+	default:
+		return err
+	}
+	return &pgconn.PgError{Severity: "ERROR", Code: code, Message: sqliteErr.Error()}
+}
+
+// sqliteTime returns the value of a timestamp column, in microseconds
+// since the Unix epoch like the timestamps of Postgres
+func sqliteTime(t pgtype.Timestamptz) any {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time.UnixMicro()
+}
+
+// sqliteTimestamptz returns the timestamp of a timestamp column
+func sqliteTimestamptz(value sql.NullInt64) pgtype.Timestamptz {
+	if !value.Valid {
+		return pgtype.Timestamptz{}
+	}
+	return pgtype.Timestamptz{Time: time.UnixMicro(value.Int64), Valid: true}
+}
+
+// sqliteTimestamp returns the timestamp of a timestamp column without
+// time zone
+func sqliteTimestamp(value sql.NullInt64) pgtype.Timestamp {
+	if !value.Valid {
+		return pgtype.Timestamp{}
+	}
+	return pgtype.Timestamp{Time: time.UnixMicro(value.Int64).UTC(), Valid: true}
+}
+
+// sqliteNow returns the current time as a timestamp column
+func sqliteNow() int64 {
+	return time.Now().UnixMicro()
+}
+
+// InTx implements Store. Nested transactions are savepoints.
+func (s *SQLiteStore) InTx(ctx context.Context, fn func(Store) error) error {
+	if s.depth > 0 {
+		return s.inSavepoint(ctx, fn)
+	}
+	tx, err := s.db.(*sql.DB).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	if err := fn(&SQLiteStore{db: tx, depth: 1}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// inSavepoint runs fn in a savepoint of the transaction of the store
+func (s *SQLiteStore) inSavepoint(ctx context.Context, fn func(Store) error) error {
+	savepoint := fmt.Sprintf("store_savepoint_%d", s.depth)
+	if _, err := s.db.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return fmt.Errorf("could not create savepoint: %w", err)
+	}
+	if err := fn(&SQLiteStore{db: s.db, depth: s.depth + 1}); err != nil {
+		if _, rollbackErr := s.db.ExecContext(ctx, "ROLLBACK TO "+savepoint); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "RELEASE "+savepoint)
+	return err
+}
+
+// CreateUser implements Store
+func (s *SQLiteStore) CreateUser(ctx context.Context, arg CreateUserParams) (pgtype.UUID, error) {
+	userID := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+	now := sqliteNow()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO Users (user_id, first_name, last_name, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, arg.FirstName, arg.LastName, now, now)
+	if err != nil {
+		return pgtype.UUID{}, sqliteError(err)
+	}
+	return userID, nil
+}
+
+// GetUserById implements Store
+func (s *SQLiteStore) GetUserById(ctx context.Context, userID pgtype.UUID) (GetUserByIdRow, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT u.user_id, u.first_name, u.last_name, ld.email, ld.email_verified FROM Users u
+LEFT JOIN UsersLoginData ld ON ld.user_id = u.user_id
+WHERE u.user_id = ?`, userID)
+	var i GetUserByIdRow
+	var emailVerified sql.NullBool
+	if err := row.Scan(&i.UserID, &i.FirstName, &i.LastName, &i.Email, &emailVerified); err != nil {
+		return GetUserByIdRow{}, sqliteError(err)
+	}
+	i.EmailVerified = pgtype.Bool{Bool: emailVerified.Bool, Valid: emailVerified.Valid}
+	return i, nil
+}
+
+// sqliteUserAccountColumns are the columns of the user accounts scanned
+// by scanSQLiteUserAccount, from the users joined with their login data
+const sqliteUserAccountColumns = `u.user_id, u.first_name, u.last_name, u.created_at, u.disabled_at, ld.email, ld.email_verified, ld.hashAlgorithm`
+
+// sqliteScanner is implemented by *sql.Row and *sql.Rows
+type sqliteScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSQLiteUserAccount scans a row of the user accounts selected with
+// sqliteUserAccountColumns
+func scanSQLiteUserAccount(row sqliteScanner) (GetUserAccountRow, error) {
+	var i GetUserAccountRow
+	var createdAt, disabledAt sql.NullInt64
+	var emailVerified sql.NullBool
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&createdAt,
+		&disabledAt,
+		&i.Email,
+		&emailVerified,
+		&i.Hashalgorithm,
+	)
+	if err != nil {
+		return GetUserAccountRow{}, sqliteError(err)
+	}
+	i.CreatedAt = sqliteTimestamp(createdAt)
+	i.DisabledAt = sqliteTimestamptz(disabledAt)
+	i.EmailVerified = pgtype.Bool{Bool: emailVerified.Bool, Valid: emailVerified.Valid}
+	return i, nil
+}
+
+// GetUserAccount implements Store
+func (s *SQLiteStore) GetUserAccount(ctx context.Context, userID pgtype.UUID) (GetUserAccountRow, error) {
+	return scanSQLiteUserAccount(s.db.QueryRowContext(ctx, `SELECT `+sqliteUserAccountColumns+` FROM Users u
+LEFT JOIN UsersLoginData ld ON ld.user_id = u.user_id
+WHERE u.user_id = ?`, userID))
+}
+
+// ListUsers implements Store
+func (s *SQLiteStore) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error) {
+	var afterCreatedAt any
+	if arg.AfterCreatedAt.Valid {
+		afterCreatedAt = arg.AfterCreatedAt.Time.UnixMicro()
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteUserAccountColumns+` FROM Users u
+LEFT JOIN UsersLoginData ld ON ld.user_id = u.user_id
+WHERE (?1 IS NULL OR (u.created_at, u.user_id) > (?1, ?2))
+    AND (?3 IS NULL
+        OR ld.email LIKE '%' || ?3 || '%' ESCAPE '\'
+        OR u.first_name || ' ' || u.last_name LIKE '%' || ?3 || '%' ESCAPE '\')
+    AND (?4 IS NULL
+        OR EXISTS (SELECT 1 FROM UserRoles ur WHERE ur.user_id = u.user_id AND ur.role_name = ?4))
+    AND (?5 IS NULL OR (u.disabled_at IS NOT NULL) = ?5)
+ORDER BY u.created_at, u.user_id
+LIMIT ?6`, afterCreatedAt, arg.AfterUserID, arg.Search, arg.RoleName, arg.Disabled, arg.PageSize)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var items []ListUsersRow
+	for rows.Next() {
+		i, err := scanSQLiteUserAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, ListUsersRow(i))
+	}
+	return items, sqliteError(rows.Err())
+}
+
+// UpdateUserNames implements Store
+func (s *SQLiteStore) UpdateUserNames(ctx context.Context, arg UpdateUserNamesParams) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE Users SET first_name = ?, last_name = ? WHERE user_id = ?`,
+		arg.FirstName, arg.LastName, arg.UserID)
+	return sqliteError(err)
+}
+
+// SetUserDisabledAt implements Store
+func (s *SQLiteStore) SetUserDisabledAt(ctx context.Context, arg SetUserDisabledAtParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `UPDATE Users SET disabled_at = ? WHERE user_id = ?`,
+		sqliteTime(arg.DisabledAt), arg.UserID)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// GetUserRoles implements Store
+func (s *SQLiteStore) GetUserRoles(ctx context.Context, userID pgtype.UUID) ([]Role, error) {
+	return s.queryRoles(ctx, `SELECT r.role_name, r.description, r.max_sessions, r.created_at FROM Roles r
+JOIN UserRoles ur ON ur.role_name = r.role_name
+WHERE ur.user_id = ?
+ORDER BY r.role_name`, userID)
+}
+
+// queryRoles returns the roles selected by a query
+func (s *SQLiteStore) queryRoles(ctx context.Context, query string, args ...any) ([]Role, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var items []Role
+	for rows.Next() {
+		var i Role
+		var createdAt sql.NullInt64
+		if err := rows.Scan(&i.RoleName, &i.Description, &i.MaxSessions, &createdAt); err != nil {
+			return nil, sqliteError(err)
+		}
+		i.CreatedAt = sqliteTimestamptz(createdAt)
+		items = append(items, i)
+	}
+	return items, sqliteError(rows.Err())
+}
+
+// GetUserPermissions implements Store
+func (s *SQLiteStore) GetUserPermissions(ctx context.Context, userID pgtype.UUID) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT rp.permission_name FROM RolePermissions rp
+JOIN UserRoles ur ON ur.role_name = rp.role_name
+WHERE ur.user_id = ?
+ORDER BY rp.permission_name`, userID)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var permissionName string
+		if err := rows.Scan(&permissionName); err != nil {
+			return nil, sqliteError(err)
+		}
+		items = append(items, permissionName)
+	}
+	return items, sqliteError(rows.Err())
+}
+
+// AssignUserRole implements Store
+func (s *SQLiteStore) AssignUserRole(ctx context.Context, arg AssignUserRoleParams) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO UserRoles (user_id, role_name, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+		arg.UserID, arg.RoleName, sqliteNow())
+	return sqliteError(err)
+}
+
+// UnassignUserRole implements Store
+func (s *SQLiteStore) UnassignUserRole(ctx context.Context, arg UnassignUserRoleParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM UserRoles WHERE user_id = ? AND role_name = ?`,
+		arg.UserID, arg.RoleName)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// LockUserForSession implements Store. The transactions of a store are
+// serialized by its single connection, locking the user is a lookup.
+func (s *SQLiteStore) LockUserForSession(ctx context.Context, userID pgtype.UUID) (LockUserForSessionRow, error) {
+	var i LockUserForSessionRow
+	var disabledAt sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT max_sessions, disabled_at FROM Users WHERE user_id = ?`, userID).
+		Scan(&i.MaxSessions, &disabledAt)
+	i.DisabledAt = sqliteTimestamptz(disabledAt)
+	return i, sqliteError(err)
+}
+
+// CreateUserLoginData implements Store
+func (s *SQLiteStore) CreateUserLoginData(ctx context.Context, arg CreateUserLoginDataParams) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO UsersLoginData (user_id, email, passwordHash, passwordSalt, hashAlgorithm) VALUES (?, ?, ?, ?, ?)`,
+		arg.UserID, arg.Email, arg.Passwordhash, arg.Passwordsalt, arg.Hashalgorithm)
+	return sqliteError(err)
+}
+
+// GetLoginDataByEmail implements Store
+func (s *SQLiteStore) GetLoginDataByEmail(ctx context.Context, email string) (Userslogindatum, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT user_id, email, passwordHash, passwordSalt, hashAlgorithm, email_verified FROM UsersLoginData WHERE email = ?`,
+		email)
+	var i Userslogindatum
+	err := row.Scan(&i.UserID, &i.Email, &i.Passwordhash, &i.Passwordsalt, &i.Hashalgorithm, &i.EmailVerified)
+	return i, sqliteError(err)
+}
+
+// UpdateUserEmail implements Store
+func (s *SQLiteStore) UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `UPDATE UsersLoginData SET email = ?, email_verified = 0 WHERE user_id = ?`,
+		arg.Email, arg.UserID)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// UpdateUserPassword implements Store
+func (s *SQLiteStore) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE UsersLoginData SET passwordHash = ?, passwordSalt = ? WHERE user_id = ?`,
+		arg.Passwordhash, arg.Passwordsalt, arg.UserID)
+	return sqliteError(err)
+}
+
+// CreateLoginEvent implements Store
+func (s *SQLiteStore) CreateLoginEvent(ctx context.Context, arg CreateLoginEventParams) error {
+	var ipAddress sql.NullString
+	if arg.IpAddress != nil {
+		ipAddress = sql.NullString{String: arg.IpAddress.String(), Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO LoginEvents (event_id, user_id, occurred_at, login_method, succeeded, failure_reason, ip_address, user_agent)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		pgtype.UUID{Bytes: uuid.New(), Valid: true},
+		arg.UserID,
+		sqliteTime(arg.OccurredAt),
+		arg.LoginMethod,
+		arg.Succeeded,
+		arg.FailureReason,
+		ipAddress,
+		arg.UserAgent,
+	)
+	return sqliteError(err)
+}
+
+// ListUserLoginEvents implements Store
+func (s *SQLiteStore) ListUserLoginEvents(ctx context.Context, arg ListUserLoginEventsParams) ([]Loginevent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT event_id, user_id, occurred_at, login_method, succeeded, failure_reason, ip_address, user_agent FROM LoginEvents
+WHERE user_id = ?
+ORDER BY occurred_at DESC
+LIMIT ?`, arg.UserID, arg.Limit)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var items []Loginevent
+	for rows.Next() {
+		var i Loginevent
+		var occurredAt sql.NullInt64
+		var ipAddress sql.NullString
+		err := rows.Scan(
+			&i.EventID,
+			&i.UserID,
+			&occurredAt,
+			&i.LoginMethod,
+			&i.Succeeded,
+			&i.FailureReason,
+			&ipAddress,
+			&i.UserAgent,
+		)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		i.OccurredAt = sqliteTimestamptz(occurredAt)
+		if address, err := netip.ParseAddr(ipAddress.String); ipAddress.Valid && err == nil {
+			i.IpAddress = &address
+		}
+		items = append(items, i)
+	}
+	return items, sqliteError(rows.Err())
+}
+
+// DeleteOldLoginEvents implements Store
+func (s *SQLiteStore) DeleteOldLoginEvents(ctx context.Context, arg DeleteOldLoginEventsParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM LoginEvents WHERE event_id IN (
+    SELECT event_id FROM LoginEvents WHERE occurred_at < ? LIMIT ?
+)`, sqliteTime(arg.OccurredBefore), arg.BatchSize)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// UpsertPasswordRecovery implements Store
+func (s *SQLiteStore) UpsertPasswordRecovery(ctx context.Context, arg UpsertPasswordRecoveryParams) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO PasswordRecovery (user_id, recovery_token, created_at) VALUES (?1, ?2, ?3)
+ON CONFLICT (user_id) DO UPDATE SET recovery_token = ?2, created_at = ?3, used = 0`,
+		arg.UserID, arg.RecoveryToken, sqliteNow())
+	return sqliteError(err)
+}
+
+// ConsumePasswordRecovery implements Store
+func (s *SQLiteStore) ConsumePasswordRecovery(ctx context.Context, arg ConsumePasswordRecoveryParams) (pgtype.UUID, error) {
+	notBefore := sqliteNow() - int64(arg.MaxAgeSeconds)*int64(time.Second/time.Microsecond)
+	var userID pgtype.UUID
+	err := s.db.QueryRowContext(ctx, `UPDATE PasswordRecovery SET used = 1
+WHERE recovery_token = ? AND NOT used AND created_at >= ?
+RETURNING user_id`, arg.RecoveryToken, notBefore).Scan(&userID)
+	return userID, sqliteError(err)
+}
+
+// DeleteStalePasswordRecoveries implements Store
+func (s *SQLiteStore) DeleteStalePasswordRecoveries(ctx context.Context, arg DeleteStalePasswordRecoveriesParams) (int64, error) {
+	notBefore := sqliteNow() - int64(arg.MaxAgeSeconds)*int64(time.Second/time.Microsecond)
+	result, err := s.db.ExecContext(ctx, `DELETE FROM PasswordRecovery WHERE user_id IN (
+    SELECT user_id FROM PasswordRecovery WHERE used OR created_at < ? LIMIT ?
+)`, notBefore, arg.BatchSize)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// ListRoles implements Store
+func (s *SQLiteStore) ListRoles(ctx context.Context) ([]Role, error) {
+	return s.queryRoles(ctx, `SELECT role_name, description, max_sessions, created_at FROM Roles ORDER BY role_name`)
+}
+
+// CreateRole implements Store
+func (s *SQLiteStore) CreateRole(ctx context.Context, arg CreateRoleParams) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO Roles (role_name, description, max_sessions, created_at) VALUES (?, ?, ?, ?)`,
+		arg.RoleName, arg.Description, arg.MaxSessions, sqliteNow())
+	return sqliteError(err)
+}
+
+// DeleteRole implements Store
+func (s *SQLiteStore) DeleteRole(ctx context.Context, roleName string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM Roles WHERE role_name = ?`, roleName)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// ListPermissions implements Store
+func (s *SQLiteStore) ListPermissions(ctx context.Context) ([]Permission, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT permission_name, description, created_at FROM Permissions ORDER BY permission_name`)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var items []Permission
+	for rows.Next() {
+		var i Permission
+		var createdAt sql.NullInt64
+		if err := rows.Scan(&i.PermissionName, &i.Description, &createdAt); err != nil {
+			return nil, sqliteError(err)
+		}
+		i.CreatedAt = sqliteTimestamptz(createdAt)
+		items = append(items, i)
+	}
+	return items, sqliteError(rows.Err())
+}
+
+// CreatePermission implements Store
+func (s *SQLiteStore) CreatePermission(ctx context.Context, arg CreatePermissionParams) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO Permissions (permission_name, description, created_at) VALUES (?, ?, ?)`,
+		arg.PermissionName, arg.Description, sqliteNow())
+	return sqliteError(err)
+}
+
+// DeletePermission implements Store
+func (s *SQLiteStore) DeletePermission(ctx context.Context, permissionName string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM Permissions WHERE permission_name = ?`, permissionName)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// ListRolePermissions implements Store
+func (s *SQLiteStore) ListRolePermissions(ctx context.Context) ([]Rolepermission, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT role_name, permission_name FROM RolePermissions ORDER BY role_name, permission_name`)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var items []Rolepermission
+	for rows.Next() {
+		var i Rolepermission
+		if err := rows.Scan(&i.RoleName, &i.PermissionName); err != nil {
+			return nil, sqliteError(err)
+		}
+		items = append(items, i)
+	}
+	return items, sqliteError(rows.Err())
+}
+
+// GrantRolePermission implements Store
+func (s *SQLiteStore) GrantRolePermission(ctx context.Context, arg GrantRolePermissionParams) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO RolePermissions (role_name, permission_name) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+		arg.RoleName, arg.PermissionName)
+	return sqliteError(err)
+}
+
+// RevokeRolePermission implements Store
+func (s *SQLiteStore) RevokeRolePermission(ctx context.Context, arg RevokeRolePermissionParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM RolePermissions WHERE role_name = ? AND permission_name = ?`,
+		arg.RoleName, arg.PermissionName)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// CreateSession implements Store
+func (s *SQLiteStore) CreateSession(ctx context.Context, arg CreateSessionParams) (pgtype.UUID, error) {
+	sessionID := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+	var ipAddress sql.NullString
+	if arg.IpAddress != nil {
+		ipAddress = sql.NullString{String: arg.IpAddress.String(), Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO Sessions (session_id, user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID,
+		arg.UserID,
+		sqliteTime(arg.CreatedAt),
+		sqliteTime(arg.UpdatedAt),
+		sqliteTime(arg.ExpiresAt),
+		ipAddress,
+		arg.UserAgent,
+		arg.Device,
+		arg.LoginMethod,
+		sqliteTime(arg.AbsoluteExpiresAt),
+		arg.RememberMe,
+		arg.ClientID,
+		arg.Scope,
+		arg.RefreshTokenHash,
+	)
+	if err != nil {
+		return pgtype.UUID{}, sqliteError(err)
+	}
+	return sessionID, nil
+}
+
+// sqliteSessionColumns are the columns of the sessions scanned by
+// scanSQLiteSession
+const sqliteSessionColumns = `session_id, user_id, created_at, updated_at, expires_at, ip_address, user_agent, device, login_method, absolute_expires_at, remember_me, client_id, scope, refresh_token_hash`
+
+// scanSQLiteSession scans a row of the sessions selected with
+// sqliteSessionColumns
+func scanSQLiteSession(row sqliteScanner) (Session, error) {
+	var i Session
+	var createdAt, updatedAt, expiresAt, absoluteExpiresAt sql.NullInt64
+	var ipAddress sql.NullString
+	err := row.Scan(
+		&i.SessionID,
+		&i.UserID,
+		&createdAt,
+		&updatedAt,
+		&expiresAt,
+		&ipAddress,
+		&i.UserAgent,
+		&i.Device,
+		&i.LoginMethod,
+		&absoluteExpiresAt,
+		&i.RememberMe,
+		&i.ClientID,
+		&i.Scope,
+		&i.RefreshTokenHash,
+	)
+	if err != nil {
+		return Session{}, sqliteError(err)
+	}
+	i.CreatedAt = sqliteTimestamptz(createdAt)
+	i.UpdatedAt = sqliteTimestamptz(updatedAt)
+	i.ExpiresAt = sqliteTimestamptz(expiresAt)
+	i.AbsoluteExpiresAt = sqliteTimestamptz(absoluteExpiresAt)
+	if address, err := netip.ParseAddr(ipAddress.String); ipAddress.Valid && err == nil {
+		i.IpAddress = &address
+	}
+	return i, nil
+}
+
+// GetSessionById implements Store
+func (s *SQLiteStore) GetSessionById(ctx context.Context, sessionID pgtype.UUID) (Session, error) {
+	return scanSQLiteSession(s.db.QueryRowContext(ctx,
+		`SELECT `+sqliteSessionColumns+` FROM Sessions WHERE session_id = ?`, sessionID))
+}
+
+// GetSessionByRefreshTokenHash implements Store
+func (s *SQLiteStore) GetSessionByRefreshTokenHash(ctx context.Context, refreshTokenHash pgtype.Text) (Session, error) {
+	return scanSQLiteSession(s.db.QueryRowContext(ctx,
+		`SELECT `+sqliteSessionColumns+` FROM Sessions WHERE refresh_token_hash = ?`, refreshTokenHash))
+}
+
+// ListUserSessions implements Store
+func (s *SQLiteStore) ListUserSessions(ctx context.Context, userID pgtype.UUID) ([]Session, error) {
+	now := sqliteNow()
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteSessionColumns+` FROM Sessions
+WHERE user_id = ? AND expires_at > ? AND absolute_expires_at > ?
+ORDER BY created_at`, userID, now, now)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		i, err := scanSQLiteSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, sqliteError(rows.Err())
+}
+
+// TouchSession implements Store
+func (s *SQLiteStore) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE Sessions SET updated_at = ?, expires_at = ? WHERE session_id = ?`,
+		sqliteTime(arg.UpdatedAt), sqliteTime(arg.ExpiresAt), arg.SessionID)
+	return sqliteError(err)
+}
+
+// DeleteSession implements Store
+func (s *SQLiteStore) DeleteSession(ctx context.Context, sessionID pgtype.UUID) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM Sessions WHERE session_id = ?`, sessionID)
+	return sqliteError(err)
+}
+
+// DeleteUserSessions implements Store
+func (s *SQLiteStore) DeleteUserSessions(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM Sessions WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// SessionExists implements Store
+func (s *SQLiteStore) SessionExists(ctx context.Context, sessionID pgtype.UUID) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM Sessions WHERE session_id = ?)`, sessionID).Scan(&exists)
+	return exists, sqliteError(err)
+}
+
+// CountActiveSessions implements Store
+func (s *SQLiteStore) CountActiveSessions(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM Sessions WHERE user_id = ? AND expires_at > ?`,
+		userID, sqliteNow()).Scan(&count)
+	return count, sqliteError(err)
+}
+
+// DeleteOldestActiveSessions implements Store
+func (s *SQLiteStore) DeleteOldestActiveSessions(ctx context.Context, arg DeleteOldestActiveSessionsParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM Sessions WHERE session_id IN (
+    SELECT session_id FROM Sessions
+    WHERE user_id = ? AND expires_at > ?
+    ORDER BY created_at ASC
+    LIMIT ?
+)`, arg.UserID, sqliteNow(), arg.Limit)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpiredSessions implements Store
+func (s *SQLiteStore) DeleteExpiredSessions(ctx context.Context, limit int32) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM Sessions WHERE session_id IN (
+    SELECT session_id FROM Sessions WHERE expires_at < ? LIMIT ?
+)`, sqliteNow(), limit)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// RevokeAccessToken implements Store
+func (s *SQLiteStore) RevokeAccessToken(ctx context.Context, arg RevokeAccessTokenParams) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO RevokedAccessTokens (token_id, expires_at) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+		arg.TokenID, sqliteTime(arg.ExpiresAt))
+	return sqliteError(err)
+}
+
+// IsAccessTokenRevoked implements Store
+func (s *SQLiteStore) IsAccessTokenRevoked(ctx context.Context, tokenID pgtype.UUID) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM RevokedAccessTokens WHERE token_id = ?)`, tokenID).Scan(&revoked)
+	return revoked, sqliteError(err)
+}
+
+// DeleteExpiredRevokedAccessTokens implements Store
+func (s *SQLiteStore) DeleteExpiredRevokedAccessTokens(ctx context.Context, limit int32) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM RevokedAccessTokens WHERE token_id IN (
+    SELECT token_id FROM RevokedAccessTokens WHERE expires_at < ? LIMIT ?
+)`, sqliteNow(), limit)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
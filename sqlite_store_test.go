@@ -0,0 +1,114 @@
+package betalinkauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	betalinklogger "github.com/BragdonD/betalink-logger"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSQLiteStore returns a store on a new migrated SQLite database
+func newTestSQLiteStore(t *testing.T) *betalinkauth.SQLiteStore {
+	t.Helper()
+	db, err := betalinkauth.OpenSQLite(filepath.Join(t.TempDir(), "betalink-auth.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := betalinkauth.NewSQLiteMigrations(db)
+	require.NoError(t, err)
+	_, err = migrations.Up(context.Background())
+	require.NoError(t, err)
+	return betalinkauth.NewSQLiteStore(db)
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, newTestSQLiteStore(t))
+}
+
+func TestUsecases_SQLiteStore(t *testing.T) {
+	testStoreUsecases(t, newTestSQLiteStore(t))
+}
+
+func TestUsecases_SQLiteStoreSessionLimit(t *testing.T) {
+	testStoreSessionLimit(t, newTestSQLiteStore(t))
+}
+
+func TestRouter_SQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	usecases := newStoreUsecases(t, newTestSQLiteStore(t))
+	gin.SetMode(gin.TestMode)
+	ginRouter := gin.New()
+	logger := betalinklogger.NewLogger("betalink-auth", false, false, io.Discard)
+	betalinkauth.NewRouter(logger, ginRouter, usecases)
+
+	adminEmail := "sqlite.admin@example.com"
+	adminPassword := "AdminPassword123!"
+	err := usecases.RegisterUser(ctx, "SQLite", "Admin", adminEmail, adminPassword, betalinkauth.AdminRole)
+	require.NoError(t, err)
+	tokens, err := usecases.LoginUser(ctx, adminEmail, adminPassword, false, storeTestMetadata)
+	require.NoError(t, err)
+
+	tests := []struct {
+		method       string
+		path         string
+		expectedCode int
+	}{
+		{http.MethodGet, "/admin/users", http.StatusOK},
+		{http.MethodGet, "/admin/roles", http.StatusOK},
+		{http.MethodGet, "/admin/clients", http.StatusNotImplemented},
+		{http.MethodGet, "/admin/policies", http.StatusNotImplemented},
+		{http.MethodGet, "/oauth/authorize", http.StatusNotImplemented},
+		{http.MethodPost, "/oauth/token", http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+			w := httptest.NewRecorder()
+			ginRouter.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+
+	t.Run("force and complete a password reset", func(t *testing.T) {
+		userEmail := "sqlite.user@example.com"
+		err := usecases.RegisterUser(ctx, "SQLite", "User", userEmail, adminPassword)
+		require.NoError(t, err)
+		userID, err := usecases.FindUserByEmail(ctx, userEmail)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/users/"+userID.String()+"/password-reset", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		w := httptest.NewRecorder()
+		ginRouter.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var response struct {
+			Data betalinkauth.PasswordResetData `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.NotEmpty(t, response.Data.RecoveryToken)
+
+		completeReset := func() int {
+			body := strings.NewReader(`{"password": "NewPassword123!"}`)
+			req := httptest.NewRequest(http.MethodPatch, "/recovery/password?recovery_token="+url.QueryEscape(response.Data.RecoveryToken), body)
+			w := httptest.NewRecorder()
+			ginRouter.ServeHTTP(w, req)
+			return w.Code
+		}
+		assert.Equal(t, http.StatusOK, completeReset())
+		assert.Equal(t, http.StatusUnauthorized, completeReset())
+		_, err = usecases.LoginUser(ctx, userEmail, "NewPassword123!", false, storeTestMetadata)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,103 @@
+package betalinkauth
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Store holds the users, their login data and history, roles, sessions
+// and revoked access tokens, i.e. what the login, refresh, validation
+// and revocation flows and the administration of the accounts and roles
+// read and write.
+// Queries is the Postgres store, SQLiteStore a single-node one and
+// MemoryStore an in-memory one, for tests and embedding. The other
+// features, such as the OAuth clients or the external logins, need the
+// Postgres queries.
+//
+// A store behaves like the queries it is named after: the lookups of
+// missing rows return pgx.ErrNoRows and the constraint violations a
+// *pgconn.PgError with the Postgres error code.
+type Store interface {
+	// users
+	CreateUser(ctx context.Context, arg CreateUserParams) (pgtype.UUID, error)
+	GetUserById(ctx context.Context, userID pgtype.UUID) (GetUserByIdRow, error)
+	GetUserAccount(ctx context.Context, userID pgtype.UUID) (GetUserAccountRow, error)
+	ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error)
+	UpdateUserNames(ctx context.Context, arg UpdateUserNamesParams) error
+	SetUserDisabledAt(ctx context.Context, arg SetUserDisabledAtParams) (int64, error)
+	GetUserRoles(ctx context.Context, userID pgtype.UUID) ([]Role, error)
+	GetUserPermissions(ctx context.Context, userID pgtype.UUID) ([]string, error)
+	AssignUserRole(ctx context.Context, arg AssignUserRoleParams) error
+	UnassignUserRole(ctx context.Context, arg UnassignUserRoleParams) (int64, error)
+	// LockUserForSession returns the session limit of a user and whether
+	// it is disabled, serializing the transactions opening its sessions
+	LockUserForSession(ctx context.Context, userID pgtype.UUID) (LockUserForSessionRow, error)
+
+	// login data
+	CreateUserLoginData(ctx context.Context, arg CreateUserLoginDataParams) error
+	GetLoginDataByEmail(ctx context.Context, email string) (Userslogindatum, error)
+	UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) (int64, error)
+	UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error
+
+	// login events
+	CreateLoginEvent(ctx context.Context, arg CreateLoginEventParams) error
+	ListUserLoginEvents(ctx context.Context, arg ListUserLoginEventsParams) ([]Loginevent, error)
+	DeleteOldLoginEvents(ctx context.Context, arg DeleteOldLoginEventsParams) (int64, error)
+
+	// password recoveries, the resets forced by an administrator
+	UpsertPasswordRecovery(ctx context.Context, arg UpsertPasswordRecoveryParams) error
+	ConsumePasswordRecovery(ctx context.Context, arg ConsumePasswordRecoveryParams) (pgtype.UUID, error)
+	DeleteStalePasswordRecoveries(ctx context.Context, arg DeleteStalePasswordRecoveriesParams) (int64, error)
+
+	// roles and permissions
+	ListRoles(ctx context.Context) ([]Role, error)
+	CreateRole(ctx context.Context, arg CreateRoleParams) error
+	DeleteRole(ctx context.Context, roleName string) (int64, error)
+	ListPermissions(ctx context.Context) ([]Permission, error)
+	CreatePermission(ctx context.Context, arg CreatePermissionParams) error
+	DeletePermission(ctx context.Context, permissionName string) (int64, error)
+	ListRolePermissions(ctx context.Context) ([]Rolepermission, error)
+	GrantRolePermission(ctx context.Context, arg GrantRolePermissionParams) error
+	RevokeRolePermission(ctx context.Context, arg RevokeRolePermissionParams) (int64, error)
+
+	// sessions
+	CreateSession(ctx context.Context, arg CreateSessionParams) (pgtype.UUID, error)
+	GetSessionById(ctx context.Context, sessionID pgtype.UUID) (Session, error)
+	GetSessionByRefreshTokenHash(ctx context.Context, refreshTokenHash pgtype.Text) (Session, error)
+	ListUserSessions(ctx context.Context, userID pgtype.UUID) ([]Session, error)
+	TouchSession(ctx context.Context, arg TouchSessionParams) error
+	DeleteSession(ctx context.Context, sessionID pgtype.UUID) error
+	DeleteUserSessions(ctx context.Context, userID pgtype.UUID) (int64, error)
+	SessionExists(ctx context.Context, sessionID pgtype.UUID) (bool, error)
+	CountActiveSessions(ctx context.Context, userID pgtype.UUID) (int64, error)
+	DeleteOldestActiveSessions(ctx context.Context, arg DeleteOldestActiveSessionsParams) (int64, error)
+	DeleteExpiredSessions(ctx context.Context, limit int32) (int64, error)
+
+	// access tokens
+	RevokeAccessToken(ctx context.Context, arg RevokeAccessTokenParams) error
+	IsAccessTokenRevoked(ctx context.Context, tokenID pgtype.UUID) (bool, error)
+	DeleteExpiredRevokedAccessTokens(ctx context.Context, limit int32) (int64, error)
+
+	// InTx runs fn in a transaction of the store, committing it if fn
+	// succeeds and rolling it back otherwise
+	InTx(ctx context.Context, fn func(Store) error) error
+}
+
+var (
+	_ Store = (*Queries)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
+
+// constraintViolation returns the error of Postgres when a constraint
+// is violated, returned by the other stores to behave like the queries
+func constraintViolation(code, constraint string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           code,
+		Message:        "constraint violated: " + constraint,
+		ConstraintName: constraint,
+	}
+}
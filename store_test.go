@@ -0,0 +1,814 @@
+package betalinkauth_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	betalinkauth "github.com/BragdonD/betalink-auth"
+	betalinklogger "github.com/BragdonD/betalink-logger"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeTestMetadata is the client of the sessions opened by the tests
+// of the stores
+var storeTestMetadata = betalinkauth.SessionMetadata{
+	IPAddress: "198.51.100.4",
+	UserAgent: "betalink-auth-tests",
+}
+
+// testStore checks that a store behaves like the Postgres queries. It
+// runs against both stores so that they cannot drift apart.
+func testStore(t *testing.T, store betalinkauth.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	pgNow := func(offset time.Duration) pgtype.Timestamptz {
+		return pgtype.Timestamptz{Time: now.Add(offset), Valid: true}
+	}
+	pgErrorCode := func(err error) string {
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		return pgErr.Code
+	}
+	email := uuid.NewString() + "@store.betalink.test"
+
+	userID, err := store.CreateUser(ctx, betalinkauth.CreateUserParams{FirstName: "Store", LastName: "Test"})
+	require.NoError(t, err)
+
+	t.Run("users", func(t *testing.T) {
+		user, err := store.GetUserById(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, "Store", user.FirstName)
+		assert.False(t, user.Email.Valid)
+
+		err = store.UpdateUserNames(ctx, betalinkauth.UpdateUserNamesParams{UserID: userID, FirstName: "Renamed", LastName: "User"})
+		require.NoError(t, err)
+		user, err = store.GetUserById(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, "Renamed", user.FirstName)
+		assert.Equal(t, "User", user.LastName)
+
+		locked, err := store.LockUserForSession(ctx, userID)
+		require.NoError(t, err)
+		assert.False(t, locked.MaxSessions.Valid)
+		assert.False(t, locked.DisabledAt.Valid)
+
+		_, err = store.GetUserById(ctx, pgtype.UUID{Bytes: uuid.New(), Valid: true})
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		for _, role := range []string{betalinkauth.DefaultRole, betalinkauth.AdminRole, betalinkauth.DefaultRole} {
+			err := store.AssignUserRole(ctx, betalinkauth.AssignUserRoleParams{UserID: userID, RoleName: role})
+			require.NoError(t, err)
+		}
+		roles, err := store.GetUserRoles(ctx, userID)
+		require.NoError(t, err)
+		require.Len(t, roles, 2)
+		assert.Equal(t, betalinkauth.AdminRole, roles[0].RoleName)
+		assert.Equal(t, betalinkauth.DefaultRole, roles[1].RoleName)
+
+		err = store.AssignUserRole(ctx, betalinkauth.AssignUserRoleParams{UserID: userID, RoleName: "unknown"})
+		assert.Equal(t, "23503", pgErrorCode(err))
+	})
+
+	t.Run("login data", func(t *testing.T) {
+		err := store.CreateUserLoginData(ctx, betalinkauth.CreateUserLoginDataParams{
+			UserID:        userID,
+			Email:         email,
+			Passwordhash:  "hash",
+			Hashalgorithm: betalinkauth.HashAlgorithmBcrypt,
+		})
+		require.NoError(t, err)
+
+		loginData, err := store.GetLoginDataByEmail(ctx, email)
+		require.NoError(t, err)
+		assert.Equal(t, userID, loginData.UserID)
+		assert.Equal(t, "hash", loginData.Passwordhash)
+		user, err := store.GetUserById(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, email, user.Email.String)
+
+		otherUserID, err := store.CreateUser(ctx, betalinkauth.CreateUserParams{FirstName: "Other", LastName: "User"})
+		require.NoError(t, err)
+		err = store.CreateUserLoginData(ctx, betalinkauth.CreateUserLoginDataParams{
+			UserID:        otherUserID,
+			Email:         email,
+			Hashalgorithm: betalinkauth.HashAlgorithmBcrypt,
+		})
+		assert.Equal(t, "23505", pgErrorCode(err))
+
+		_, err = store.GetLoginDataByEmail(ctx, "unknown."+email)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	t.Run("accounts", func(t *testing.T) {
+		account, err := store.GetUserAccount(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, email, account.Email.String)
+		assert.Equal(t, betalinkauth.HashAlgorithmBcrypt, account.Hashalgorithm.String)
+		assert.False(t, account.DisabledAt.Valid)
+
+		updated, err := store.UpdateUserEmail(ctx, betalinkauth.UpdateUserEmailParams{UserID: userID, Email: "renamed." + email})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), updated)
+		_, err = store.GetLoginDataByEmail(ctx, email)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+		_, err = store.UpdateUserEmail(ctx, betalinkauth.UpdateUserEmailParams{UserID: userID, Email: email})
+		require.NoError(t, err)
+
+		takenEmail := "taken." + email
+		takenUserID, err := store.CreateUser(ctx, betalinkauth.CreateUserParams{FirstName: "Taken", LastName: "User"})
+		require.NoError(t, err)
+		err = store.CreateUserLoginData(ctx, betalinkauth.CreateUserLoginDataParams{
+			UserID:        takenUserID,
+			Email:         takenEmail,
+			Hashalgorithm: betalinkauth.HashAlgorithmBcrypt,
+		})
+		require.NoError(t, err)
+		_, err = store.UpdateUserEmail(ctx, betalinkauth.UpdateUserEmailParams{UserID: userID, Email: takenEmail})
+		assert.Equal(t, "23505", pgErrorCode(err))
+
+		err = store.UpdateUserPassword(ctx, betalinkauth.UpdateUserPasswordParams{UserID: userID, Passwordhash: "new-hash"})
+		require.NoError(t, err)
+		loginData, err := store.GetLoginDataByEmail(ctx, email)
+		require.NoError(t, err)
+		assert.Equal(t, "new-hash", loginData.Passwordhash)
+
+		updated, err = store.SetUserDisabledAt(ctx, betalinkauth.SetUserDisabledAtParams{UserID: userID, DisabledAt: pgNow(0)})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), updated)
+		account, err = store.GetUserAccount(ctx, userID)
+		require.NoError(t, err)
+		assert.WithinDuration(t, now, account.DisabledAt.Time, time.Millisecond)
+		_, err = store.SetUserDisabledAt(ctx, betalinkauth.SetUserDisabledAtParams{UserID: userID})
+		require.NoError(t, err)
+		updated, err = store.SetUserDisabledAt(ctx, betalinkauth.SetUserDisabledAtParams{
+			UserID: pgtype.UUID{Bytes: uuid.New(), Valid: true},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), updated)
+
+		_, err = store.GetUserAccount(ctx, pgtype.UUID{Bytes: uuid.New(), Valid: true})
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	t.Run("list users", func(t *testing.T) {
+		// the names of the listed users are unique to the test, and
+		// searched in another case
+		name := "Listed" + uuid.NewString()[:8]
+		search := pgtype.Text{String: strings.ToLower(name), Valid: true}
+		userIDs := make([]pgtype.UUID, 3)
+		for i := range userIDs {
+			var err error
+			userIDs[i], err = store.CreateUser(ctx, betalinkauth.CreateUserParams{FirstName: name, LastName: fmt.Sprint(i)})
+			require.NoError(t, err)
+		}
+
+		firstPage, err := store.ListUsers(ctx, betalinkauth.ListUsersParams{Search: search, PageSize: 2})
+		require.NoError(t, err)
+		require.Len(t, firstPage, 2)
+		last := firstPage[1]
+		secondPage, err := store.ListUsers(ctx, betalinkauth.ListUsersParams{
+			Search:         search,
+			AfterCreatedAt: last.CreatedAt,
+			AfterUserID:    last.UserID,
+			PageSize:       2,
+		})
+		require.NoError(t, err)
+		require.Len(t, secondPage, 1)
+		assert.ElementsMatch(t, userIDs, []pgtype.UUID{firstPage[0].UserID, firstPage[1].UserID, secondPage[0].UserID})
+
+		err = store.AssignUserRole(ctx, betalinkauth.AssignUserRoleParams{UserID: userIDs[0], RoleName: betalinkauth.AdminRole})
+		require.NoError(t, err)
+		users, err := store.ListUsers(ctx, betalinkauth.ListUsersParams{
+			Search:   search,
+			RoleName: pgtype.Text{String: betalinkauth.AdminRole, Valid: true},
+			PageSize: 10,
+		})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, userIDs[0], users[0].UserID)
+
+		_, err = store.SetUserDisabledAt(ctx, betalinkauth.SetUserDisabledAtParams{UserID: userIDs[1], DisabledAt: pgNow(0)})
+		require.NoError(t, err)
+		for disabled, expected := range map[bool]int{true: 1, false: 2} {
+			users, err := store.ListUsers(ctx, betalinkauth.ListUsersParams{
+				Search:   search,
+				Disabled: pgtype.Bool{Bool: disabled, Valid: true},
+				PageSize: 10,
+			})
+			require.NoError(t, err)
+			assert.Len(t, users, expected)
+		}
+	})
+
+	t.Run("roles and permissions", func(t *testing.T) {
+		role := "store-tester"
+		permission := "store:read"
+		err := store.CreateRole(ctx, betalinkauth.CreateRoleParams{
+			RoleName:    role,
+			MaxSessions: pgtype.Int4{Int32: 2, Valid: true},
+		})
+		require.NoError(t, err)
+		err = store.CreateRole(ctx, betalinkauth.CreateRoleParams{RoleName: role})
+		assert.Equal(t, "23505", pgErrorCode(err))
+		roles, err := store.ListRoles(ctx)
+		require.NoError(t, err)
+		index := slices.IndexFunc(roles, func(r betalinkauth.Role) bool { return r.RoleName == role })
+		require.NotEqual(t, -1, index)
+		assert.Equal(t, pgtype.Int4{Int32: 2, Valid: true}, roles[index].MaxSessions)
+
+		err = store.CreatePermission(ctx, betalinkauth.CreatePermissionParams{PermissionName: permission})
+		require.NoError(t, err)
+		err = store.CreatePermission(ctx, betalinkauth.CreatePermissionParams{PermissionName: permission})
+		assert.Equal(t, "23505", pgErrorCode(err))
+		for range 2 {
+			err = store.GrantRolePermission(ctx, betalinkauth.GrantRolePermissionParams{RoleName: role, PermissionName: permission})
+			require.NoError(t, err)
+		}
+		err = store.GrantRolePermission(ctx, betalinkauth.GrantRolePermissionParams{RoleName: role, PermissionName: "unknown"})
+		assert.Equal(t, "23503", pgErrorCode(err))
+		rolePermissions, err := store.ListRolePermissions(ctx)
+		require.NoError(t, err)
+		assert.Contains(t, rolePermissions, betalinkauth.Rolepermission{RoleName: role, PermissionName: permission})
+
+		err = store.AssignUserRole(ctx, betalinkauth.AssignUserRoleParams{UserID: userID, RoleName: role})
+		require.NoError(t, err)
+		permissions, err := store.GetUserPermissions(ctx, userID)
+		require.NoError(t, err)
+		assert.Contains(t, permissions, permission)
+
+		revoked, err := store.RevokeRolePermission(ctx, betalinkauth.RevokeRolePermissionParams{RoleName: role, PermissionName: permission})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), revoked)
+		permissions, err = store.GetUserPermissions(ctx, userID)
+		require.NoError(t, err)
+		assert.NotContains(t, permissions, permission)
+
+		for _, expected := range []int64{1, 0} {
+			unassigned, err := store.UnassignUserRole(ctx, betalinkauth.UnassignUserRoleParams{UserID: userID, RoleName: role})
+			require.NoError(t, err)
+			assert.Equal(t, expected, unassigned)
+			deleted, err := store.DeletePermission(ctx, permission)
+			require.NoError(t, err)
+			assert.Equal(t, expected, deleted)
+			deleted, err = store.DeleteRole(ctx, role)
+			require.NoError(t, err)
+			assert.Equal(t, expected, deleted)
+		}
+		permissionList, err := store.ListPermissions(ctx)
+		require.NoError(t, err)
+		for _, p := range permissionList {
+			assert.NotEqual(t, permission, p.PermissionName)
+		}
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		createSession := func(createdAt, expiresIn time.Duration) pgtype.UUID {
+			sessionID, err := store.CreateSession(ctx, betalinkauth.CreateSessionParams{
+				UserID:            userID,
+				CreatedAt:         pgNow(createdAt),
+				UpdatedAt:         pgNow(createdAt),
+				ExpiresAt:         pgNow(expiresIn),
+				AbsoluteExpiresAt: pgNow(time.Hour * 24),
+				LoginMethod:       betalinkauth.LoginMethodPassword,
+			})
+			require.NoError(t, err)
+			return sessionID
+		}
+		oldest := createSession(-time.Hour*3, time.Hour)
+		older := createSession(-time.Hour*2, time.Hour)
+		newest := createSession(-time.Hour, time.Hour)
+		expired := createSession(-time.Hour*4, -time.Minute)
+
+		count, err := store.CountActiveSessions(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), count)
+
+		err = store.TouchSession(ctx, betalinkauth.TouchSessionParams{
+			UpdatedAt: pgNow(0),
+			ExpiresAt: pgNow(time.Hour * 2),
+			SessionID: newest,
+		})
+		require.NoError(t, err)
+		session, err := store.GetSessionById(ctx, newest)
+		require.NoError(t, err)
+		assert.WithinDuration(t, now.Add(time.Hour*2), session.ExpiresAt.Time, time.Millisecond)
+		assert.Equal(t, betalinkauth.LoginMethodPassword, session.LoginMethod)
+
+		// the expired session is not among the oldest active ones
+		deleted, err := store.DeleteOldestActiveSessions(ctx, betalinkauth.DeleteOldestActiveSessionsParams{UserID: userID, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), deleted)
+		for sessionID, exists := range map[pgtype.UUID]bool{oldest: false, older: false, newest: true, expired: true} {
+			found, err := store.SessionExists(ctx, sessionID)
+			require.NoError(t, err)
+			assert.Equal(t, exists, found)
+		}
+
+		err = store.DeleteSession(ctx, newest)
+		require.NoError(t, err)
+		_, err = store.GetSessionById(ctx, newest)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+		refreshTokenHash := pgtype.Text{String: betalinkauth.HashRefreshToken("refresh-token"), Valid: true}
+		sessionID, err := store.CreateSession(ctx, betalinkauth.CreateSessionParams{
+			UserID:            userID,
+			CreatedAt:         pgNow(0),
+			UpdatedAt:         pgNow(0),
+			ExpiresAt:         pgNow(time.Hour),
+			AbsoluteExpiresAt: pgNow(time.Hour * 24),
+			LoginMethod:       betalinkauth.LoginMethodPassword,
+			RefreshTokenHash:  refreshTokenHash,
+		})
+		require.NoError(t, err)
+		session, err = store.GetSessionByRefreshTokenHash(ctx, refreshTokenHash)
+		require.NoError(t, err)
+		assert.Equal(t, sessionID, session.SessionID)
+		_, err = store.GetSessionByRefreshTokenHash(ctx, pgtype.Text{String: betalinkauth.HashRefreshToken("other"), Valid: true})
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+		// only the active sessions are listed, every session is deleted
+		sessions, err := store.ListUserSessions(ctx, userID)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, sessionID, sessions[0].SessionID)
+		deleted, err = store.DeleteUserSessions(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), deleted)
+		for _, sessionID := range []pgtype.UUID{sessionID, expired} {
+			found, err := store.SessionExists(ctx, sessionID)
+			require.NoError(t, err)
+			assert.False(t, found)
+		}
+	})
+
+	t.Run("access tokens", func(t *testing.T) {
+		tokenID := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+		revoked, err := store.IsAccessTokenRevoked(ctx, tokenID)
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		for range 2 {
+			err = store.RevokeAccessToken(ctx, betalinkauth.RevokeAccessTokenParams{TokenID: tokenID, ExpiresAt: pgNow(time.Hour)})
+			require.NoError(t, err)
+		}
+		revoked, err = store.IsAccessTokenRevoked(ctx, tokenID)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("login events", func(t *testing.T) {
+		ipAddress := netip.MustParseAddr("198.51.100.4")
+		for _, offset := range []time.Duration{-time.Hour * 2, 0, -time.Hour} {
+			params := betalinkauth.CreateLoginEventParams{
+				UserID:      userID,
+				OccurredAt:  pgNow(offset),
+				LoginMethod: betalinkauth.LoginMethodPassword,
+				Succeeded:   offset != 0,
+				IpAddress:   &ipAddress,
+				UserAgent:   "betalink-auth-tests",
+			}
+			if !params.Succeeded {
+				params.FailureReason = betalinkauth.ErrInvalidPassword.Error()
+			}
+			require.NoError(t, store.CreateLoginEvent(ctx, params))
+		}
+
+		// the most recent first
+		events, err := store.ListUserLoginEvents(ctx, betalinkauth.ListUserLoginEventsParams{UserID: userID, Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.WithinDuration(t, now, events[0].OccurredAt.Time, time.Second)
+		assert.False(t, events[0].Succeeded)
+		assert.Equal(t, betalinkauth.ErrInvalidPassword.Error(), events[0].FailureReason)
+		require.NotNil(t, events[0].IpAddress)
+		assert.Equal(t, ipAddress, *events[0].IpAddress)
+		assert.Equal(t, "betalink-auth-tests", events[0].UserAgent)
+		assert.WithinDuration(t, now.Add(-time.Hour), events[1].OccurredAt.Time, time.Second)
+		assert.True(t, events[1].Succeeded)
+		assert.Empty(t, events[1].FailureReason)
+
+		err = store.CreateLoginEvent(ctx, betalinkauth.CreateLoginEventParams{
+			UserID:      pgtype.UUID{Bytes: uuid.New(), Valid: true},
+			OccurredAt:  pgNow(0),
+			LoginMethod: betalinkauth.LoginMethodPassword,
+		})
+		assert.Equal(t, "23503", pgErrorCode(err))
+	})
+
+	t.Run("password recoveries", func(t *testing.T) {
+		consume := func(recoveryToken string, maxAge time.Duration) (pgtype.UUID, error) {
+			return store.ConsumePasswordRecovery(ctx, betalinkauth.ConsumePasswordRecoveryParams{
+				RecoveryToken: recoveryToken,
+				MaxAgeSeconds: int32(maxAge / time.Second),
+			})
+		}
+		replacedToken, recoveryToken := uuid.NewString(), uuid.NewString()
+		for _, token := range []string{replacedToken, recoveryToken} {
+			err := store.UpsertPasswordRecovery(ctx, betalinkauth.UpsertPasswordRecoveryParams{UserID: userID, RecoveryToken: token})
+			require.NoError(t, err)
+		}
+		_, err := consume(replacedToken, time.Hour)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+		// a recovery older than the max age has expired
+		_, err = consume(recoveryToken, -time.Minute)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+		recoveredID, err := consume(recoveryToken, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, userID, recoveredID)
+		_, err = consume(recoveryToken, time.Hour)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+		deleted, err := store.DeleteStalePasswordRecoveries(ctx, betalinkauth.DeleteStalePasswordRecoveriesParams{
+			MaxAgeSeconds: int32(time.Hour / time.Second),
+			BatchSize:     10,
+		})
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, deleted, int64(1))
+
+		err = store.UpsertPasswordRecovery(ctx, betalinkauth.UpsertPasswordRecoveryParams{
+			UserID:        pgtype.UUID{Bytes: uuid.New(), Valid: true},
+			RecoveryToken: uuid.NewString(),
+		})
+		assert.Equal(t, "23503", pgErrorCode(err))
+	})
+
+	t.Run("janitor", func(t *testing.T) {
+		sessionIDs := make([]pgtype.UUID, 3)
+		for i := range sessionIDs {
+			var err error
+			sessionIDs[i], err = store.CreateSession(ctx, betalinkauth.CreateSessionParams{
+				UserID:            userID,
+				CreatedAt:         pgNow(-time.Hour * 2),
+				UpdatedAt:         pgNow(-time.Hour * 2),
+				ExpiresAt:         pgNow(-time.Hour),
+				AbsoluteExpiresAt: pgNow(time.Hour * 24),
+				LoginMethod:       betalinkauth.LoginMethodPassword,
+			})
+			require.NoError(t, err)
+		}
+		activeID, err := store.CreateSession(ctx, betalinkauth.CreateSessionParams{
+			UserID:            userID,
+			CreatedAt:         pgNow(0),
+			UpdatedAt:         pgNow(0),
+			ExpiresAt:         pgNow(time.Hour),
+			AbsoluteExpiresAt: pgNow(time.Hour * 24),
+			LoginMethod:       betalinkauth.LoginMethodPassword,
+		})
+		require.NoError(t, err)
+		expiredTokenID := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+		activeTokenID := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+		err = store.RevokeAccessToken(ctx, betalinkauth.RevokeAccessTokenParams{TokenID: expiredTokenID, ExpiresAt: pgNow(-time.Minute)})
+		require.NoError(t, err)
+		err = store.RevokeAccessToken(ctx, betalinkauth.RevokeAccessTokenParams{TokenID: activeTokenID, ExpiresAt: pgNow(time.Hour)})
+		require.NoError(t, err)
+		loginEventMaxAge := time.Hour * 24 * 90
+		for range 3 {
+			err = store.CreateLoginEvent(ctx, betalinkauth.CreateLoginEventParams{
+				UserID:      userID,
+				OccurredAt:  pgNow(-loginEventMaxAge - time.Hour),
+				LoginMethod: betalinkauth.LoginMethodPassword,
+				Succeeded:   true,
+			})
+			require.NoError(t, err)
+		}
+
+		// more expired sessions than a single batch deletes
+		logger := betalinklogger.NewLogger("betalink-auth", false, false, io.Discard)
+		janitor, err := betalinkauth.NewStoreJanitor(logger, store, betalinkauth.JanitorConfig{
+			Interval:         time.Minute,
+			BatchSize:        2,
+			LoginEventMaxAge: loginEventMaxAge,
+		})
+		require.NoError(t, err)
+		require.NoError(t, janitor.Cleanup(ctx))
+
+		for _, sessionID := range sessionIDs {
+			found, err := store.SessionExists(ctx, sessionID)
+			require.NoError(t, err)
+			assert.False(t, found)
+		}
+		found, err := store.SessionExists(ctx, activeID)
+		require.NoError(t, err)
+		assert.True(t, found)
+		revoked, err := store.IsAccessTokenRevoked(ctx, expiredTokenID)
+		require.NoError(t, err)
+		assert.False(t, revoked)
+		revoked, err = store.IsAccessTokenRevoked(ctx, activeTokenID)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+		events, err := store.ListUserLoginEvents(ctx, betalinkauth.ListUserLoginEventsParams{UserID: userID, Limit: 100})
+		require.NoError(t, err)
+		assert.NotEmpty(t, events)
+		for _, event := range events {
+			assert.True(t, event.OccurredAt.Time.After(now.Add(-loginEventMaxAge)))
+		}
+	})
+
+	t.Run("transactions", func(t *testing.T) {
+		var createdID pgtype.UUID
+		rollback := errors.New("rollback")
+		err := store.InTx(ctx, func(tx betalinkauth.Store) error {
+			var err error
+			createdID, err = tx.CreateUser(ctx, betalinkauth.CreateUserParams{FirstName: "Rolled", LastName: "Back"})
+			require.NoError(t, err)
+			_, err = tx.GetUserById(ctx, createdID)
+			require.NoError(t, err)
+			return rollback
+		})
+		require.ErrorIs(t, err, rollback)
+		_, err = store.GetUserById(ctx, createdID)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+		err = store.InTx(ctx, func(tx betalinkauth.Store) error {
+			var err error
+			createdID, err = tx.CreateUser(ctx, betalinkauth.CreateUserParams{FirstName: "Committed", LastName: "User"})
+			return err
+		})
+		require.NoError(t, err)
+		_, err = store.GetUserById(ctx, createdID)
+		assert.NoError(t, err)
+
+		// a nested transaction rolls back on its own
+		var nestedID pgtype.UUID
+		err = store.InTx(ctx, func(tx betalinkauth.Store) error {
+			err := tx.UpdateUserNames(ctx, betalinkauth.UpdateUserNamesParams{UserID: createdID, FirstName: "Outer", LastName: "User"})
+			require.NoError(t, err)
+			err = tx.InTx(ctx, func(nested betalinkauth.Store) error {
+				var err error
+				nestedID, err = nested.CreateUser(ctx, betalinkauth.CreateUserParams{FirstName: "Nested", LastName: "User"})
+				require.NoError(t, err)
+				return rollback
+			})
+			require.ErrorIs(t, err, rollback)
+			return nil
+		})
+		require.NoError(t, err)
+		_, err = store.GetUserById(ctx, nestedID)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+		user, err := store.GetUserById(ctx, createdID)
+		require.NoError(t, err)
+		assert.Equal(t, "Outer", user.FirstName)
+	})
+}
+
+func TestNewStoreJanitor(t *testing.T) {
+	logger := betalinklogger.NewLogger("betalink-auth", false, false, io.Discard)
+	tests := []struct {
+		name   string
+		config betalinkauth.JanitorConfig
+	}{
+		{"zero interval", betalinkauth.JanitorConfig{BatchSize: 10}},
+		{"negative interval", betalinkauth.JanitorConfig{Interval: -time.Minute, BatchSize: 10}},
+		{"zero batch size", betalinkauth.JanitorConfig{Interval: time.Minute}},
+		{"negative token max age", betalinkauth.JanitorConfig{Interval: time.Minute, BatchSize: 10, TokenMaxAge: -time.Hour}},
+		{"negative login event max age", betalinkauth.JanitorConfig{Interval: time.Minute, BatchSize: 10, LoginEventMaxAge: -time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := betalinkauth.NewStoreJanitor(logger, betalinkauth.NewMemoryStore(), tt.config)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := betalinkauth.NewStoreJanitor(logger, betalinkauth.NewMemoryStore(), betalinkauth.DefaultJanitorConfig)
+	assert.NoError(t, err)
+}
+
+// newStoreUsecases returns usecases backed by a store, without the
+// queries
+func newStoreUsecases(t *testing.T, store betalinkauth.Store, opts ...betalinkauth.UsecaseOption) *betalinkauth.Usecases {
+	logger := betalinklogger.NewLogger("betalink-auth", false, false, io.Discard)
+	opts = append(opts, betalinkauth.WithStore(store))
+	usecases, err := betalinkauth.NewUsecase(logger, nil, opts...)
+	require.NoError(t, err)
+	return usecases
+}
+
+// testStoreUsecases checks that the usecases log users in, refresh and
+// revoke their tokens and administer the accounts and roles with a store
+func testStoreUsecases(t *testing.T, store betalinkauth.Store) {
+	ctx := context.Background()
+	usecases := newStoreUsecases(t, store)
+
+	testEmail := "store.test@example.com"
+	testPassword := "TestPassword123!"
+	err := usecases.RegisterUser(ctx, "Store", "Test", testEmail, testPassword)
+	require.NoError(t, err)
+
+	t.Run("email taken", func(t *testing.T) {
+		err := usecases.RegisterUser(ctx, "Store", "Again", testEmail, testPassword)
+		var validationErr *betalinkauth.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+
+	t.Run("initial roles", func(t *testing.T) {
+		adminEmail := "store.admin@example.com"
+		err := usecases.RegisterUser(ctx, "Store", "Admin", adminEmail, testPassword, betalinkauth.AdminRole)
+		require.NoError(t, err)
+		tokens, err := usecases.LoginUser(ctx, adminEmail, testPassword, false, storeTestMetadata)
+		require.NoError(t, err)
+		user, err := usecases.ValidateAccessToken(ctx, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{betalinkauth.DefaultRole, betalinkauth.AdminRole}, user.Roles)
+
+		// the user is not created without its roles
+		unknownRoleEmail := "store.unknown.role@example.com"
+		err = usecases.RegisterUser(ctx, "Store", "Unknown", unknownRoleEmail, testPassword, "unknown")
+		var notFoundErr *betalinkauth.NotFoundError
+		require.ErrorAs(t, err, &notFoundErr)
+		_, err = store.GetLoginDataByEmail(ctx, unknownRoleEmail)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	t.Run("invalid password", func(t *testing.T) {
+		_, err := usecases.LoginUser(ctx, testEmail, "WrongPassword", false, storeTestMetadata)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "could not compare password")
+
+		userID, err := usecases.FindUserByEmail(ctx, testEmail)
+		require.NoError(t, err)
+		events, err := usecases.ListUserLoginEvents(ctx, userID)
+		require.NoError(t, err)
+		require.NotEmpty(t, events)
+		assert.False(t, events[0].Succeeded)
+		assert.Equal(t, betalinkauth.ErrInvalidPassword.Error(), events[0].FailureReason)
+		assert.Equal(t, storeTestMetadata.IPAddress, events[0].IPAddress)
+	})
+
+	t.Run("login, refresh and revoke", func(t *testing.T) {
+		tokens, err := usecases.LoginUser(ctx, testEmail, testPassword, false, storeTestMetadata)
+		require.NoError(t, err)
+
+		user, err := usecases.ValidateAccessToken(ctx, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "Store", user.FirstName)
+		assert.Equal(t, []string{betalinkauth.DefaultRole}, user.Roles)
+
+		refreshed, err := usecases.RefreshAccessToken(ctx, tokens.RefreshToken)
+		require.NoError(t, err)
+
+		// the refresh tokens are random, a well-formed one does not
+		// refresh any session
+		forged, err := betalinkauth.GenerateRefreshToken()
+		require.NoError(t, err)
+		_, err = usecases.RefreshAccessToken(ctx, forged)
+		assert.Equal(t, betalinkauth.RevokedTokenError, err)
+
+		err = usecases.RevokeToken(ctx, nil, refreshed.AccessToken, "")
+		require.NoError(t, err)
+		_, err = usecases.ValidateAccessToken(ctx, refreshed.AccessToken)
+		assert.Equal(t, betalinkauth.RevokedTokenError, err)
+
+		// ending the session revokes its other access tokens
+		err = usecases.RevokeToken(ctx, nil, tokens.RefreshToken, betalinkauth.TokenTypeHintRefreshToken)
+		require.NoError(t, err)
+		_, err = usecases.ValidateAccessToken(ctx, tokens.AccessToken)
+		assert.Equal(t, betalinkauth.RevokedTokenError, err)
+		_, err = usecases.RefreshAccessToken(ctx, tokens.RefreshToken)
+		assert.Equal(t, betalinkauth.RevokedTokenError, err)
+	})
+
+	t.Run("administration", func(t *testing.T) {
+		userID, err := usecases.FindUserByEmail(ctx, testEmail)
+		require.NoError(t, err)
+
+		err = usecases.CreatePermission(ctx, "reports:read", "Read the reports")
+		require.NoError(t, err)
+		err = usecases.CreateRole(ctx, "analyst", "Analysts", nil)
+		require.NoError(t, err)
+		err = usecases.GrantPermission(ctx, "analyst", "reports:read")
+		require.NoError(t, err)
+		err = usecases.AssignRole(ctx, userID, "analyst")
+		require.NoError(t, err)
+		tokens, err := usecases.LoginUser(ctx, testEmail, testPassword, false, storeTestMetadata)
+		require.NoError(t, err)
+		user, err := usecases.ValidateAccessToken(ctx, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{betalinkauth.DefaultRole, "analyst"}, user.Roles)
+		assert.Equal(t, []string{"reports:read"}, user.Permissions)
+
+		page, err := usecases.ListUsers(ctx, betalinkauth.UserQuery{Role: "analyst"})
+		require.NoError(t, err)
+		require.Len(t, page.Users, 1)
+		assert.Equal(t, userID.String(), page.Users[0].UserID)
+		// the wildcards of the searches are matched literally
+		page, err = usecases.ListUsers(ctx, betalinkauth.UserQuery{Search: "store_test"})
+		require.NoError(t, err)
+		assert.Empty(t, page.Users)
+
+		details, err := usecases.GetUserDetails(ctx, userID)
+		require.NoError(t, err)
+		assert.Empty(t, details.ExternalIdentities)
+		assert.NotEmpty(t, details.Sessions)
+
+		firstName := "Renamed"
+		account, err := usecases.UpdateUserAccount(ctx, userID, betalinkauth.UserAccountUpdate{FirstName: &firstName})
+		require.NoError(t, err)
+		assert.Equal(t, firstName, account.FirstName)
+
+		err = usecases.DisableUser(ctx, userID)
+		require.NoError(t, err)
+		_, err = usecases.LoginUser(ctx, testEmail, testPassword, false, storeTestMetadata)
+		assert.Equal(t, betalinkauth.AccountDisabledError, err)
+		_, err = usecases.ValidateAccessToken(ctx, tokens.AccessToken)
+		assert.Equal(t, betalinkauth.RevokedTokenError, err)
+		err = usecases.EnableUser(ctx, userID)
+		require.NoError(t, err)
+
+		// the user forced to reset its password completes the reset
+		// with the recovery token handed by the administrator
+		tokens, err = usecases.LoginUser(ctx, testEmail, testPassword, false, storeTestMetadata)
+		require.NoError(t, err)
+		reset, err := usecases.ForcePasswordReset(ctx, userID)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(betalinkauth.PasswordResetLifetime), reset.ExpiresAt, time.Minute)
+		_, err = usecases.ValidateAccessToken(ctx, tokens.AccessToken)
+		assert.Equal(t, betalinkauth.RevokedTokenError, err)
+		_, err = usecases.LoginUser(ctx, testEmail, testPassword, false, storeTestMetadata)
+		assert.Equal(t, betalinkauth.PasswordResetRequiredError, err)
+
+		newPassword := "NewPassword123!"
+		err = usecases.CompletePasswordReset(ctx, "unknown", newPassword)
+		assert.Equal(t, betalinkauth.InvalidPasswordResetError, err)
+		err = usecases.CompletePasswordReset(ctx, reset.RecoveryToken, "short")
+		assert.IsType(t, &betalinkauth.ValidationError{}, err)
+		err = usecases.CompletePasswordReset(ctx, reset.RecoveryToken, newPassword)
+		require.NoError(t, err)
+		err = usecases.CompletePasswordReset(ctx, reset.RecoveryToken, newPassword)
+		assert.Equal(t, betalinkauth.InvalidPasswordResetError, err)
+		_, err = usecases.LoginUser(ctx, testEmail, newPassword, false, storeTestMetadata)
+		require.NoError(t, err)
+
+		details, err = usecases.GetUserDetails(ctx, userID)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, len(details.LoginHistory), 4)
+		assert.True(t, details.LoginHistory[0].Succeeded)
+		assert.Equal(t, betalinkauth.PasswordResetRequiredError.Message, details.LoginHistory[1].FailureReason)
+		assert.True(t, details.LoginHistory[2].Succeeded)
+		assert.Equal(t, betalinkauth.AccountDisabledError.Message, details.LoginHistory[3].FailureReason)
+		assert.Equal(t, betalinkauth.LoginMethodPassword, details.LoginHistory[3].LoginMethod)
+	})
+
+	t.Run("postgres only features", func(t *testing.T) {
+		var unsupportedErr *betalinkauth.UnsupportedError
+		_, err := usecases.ListClients(ctx)
+		assert.ErrorAs(t, err, &unsupportedErr)
+		_, err = usecases.CreateOrganization(ctx, "acme", "Acme")
+		assert.ErrorAs(t, err, &unsupportedErr)
+		err = usecases.CreatePolicy(ctx, betalinkauth.PolicyData{Name: "policy"})
+		assert.ErrorAs(t, err, &unsupportedErr)
+	})
+}
+
+// testStoreSessionLimit checks that a store serializes the transactions
+// opening the sessions of a user, so that its session limit holds
+func testStoreSessionLimit(t *testing.T, store betalinkauth.Store) {
+	ctx := context.Background()
+	sessionConfig := betalinkauth.DefaultSessionConfig
+	sessionConfig.MaxSessions = 3
+	usecases := newStoreUsecases(t, store, betalinkauth.WithSessionConfig(sessionConfig))
+
+	testEmail := "store.limit@example.com"
+	testPassword := "TestPassword123!"
+	err := usecases.RegisterUser(ctx, "Store", "Limit", testEmail, testPassword)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := usecases.LoginUser(ctx, testEmail, testPassword, false, storeTestMetadata)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	opened := 0
+	for err := range errs {
+		if err == nil {
+			opened++
+			continue
+		}
+		assert.Equal(t, betalinkauth.SessionLimitReachedError, err)
+	}
+	assert.Equal(t, 3, opened)
+}
@@ -0,0 +1,34 @@
+package betalinkauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// txBeginner is implemented by the database handles able to start
+// a transaction, i.e. pgx connections, pools and transactions
+type txBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// ExecTx runs fn in a transaction, committing it if fn succeeds and
+// rolling it back otherwise. When the queries already run in a
+// transaction, fn runs in a nested one.
+func (q *Queries) ExecTx(ctx context.Context, fn func(*Queries) error) error {
+	db, ok := q.db.(txBeginner)
+	if !ok {
+		return fmt.Errorf("database handle %T does not support transactions", q.db)
+	}
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		return fn(q.WithTx(tx))
+	})
+}
+
+// InTx implements Store, running fn with ExecTx
+func (q *Queries) InTx(ctx context.Context, fn func(Store) error) error {
+	return q.ExecTx(ctx, func(queries *Queries) error {
+		return fn(queries)
+	})
+}
@@ -2,10 +2,15 @@ package betalinkauth
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/netip"
 	"reflect"
+	"strings"
 	"time"
 
+	"github.com/BragdonD/betalink-auth/internal/claim"
 	betalinklogger "github.com/BragdonD/betalink-logger"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -14,33 +19,137 @@ import (
 
 // UserData represents the user information retrieved from the auth server
 type UserData struct {
-	UserID    pgtype.UUID
-	FirstName string
-	LastName  string
+	UserID      pgtype.UUID
+	FirstName   string
+	LastName    string
+	Roles       []string
+	Permissions []string
+	// ClientID is the OAuth client the access token was issued to,
+	// empty for the first-party apps
+	ClientID string
+	// Scopes are the scopes granted to the OAuth client the access token
+	// was issued to, empty for the first-party apps
+	Scopes []string
 }
 
-// IDTokens is a struct containing the access and refresh tokens
+// IDTokens is a struct containing the access, refresh and ID tokens
 type IDTokens struct {
 	AccessToken  string
 	RefreshToken string
+	// IDToken is the OpenID Connect ID token of the user, empty when
+	// an OAuth client was not granted the openid scope
+	IDToken string
+	// Scopes are the scopes granted to the OAuth client the tokens were
+	// issued to, empty for the first-party apps
+	Scopes []string
+	// RefreshExpiresAt is the time after which the refresh token
+	// can no longer be used, whatever the session activity
+	RefreshExpiresAt time.Time
+	// RememberMe reports whether the session was opened with the
+	// "remember me" option
+	RememberMe bool
+}
+
+// LoginMethodPassword is the login method of sessions opened
+// with an email and password
+const LoginMethodPassword = "PASSWORD"
+
+// accessTokenValidity is how long an access token is valid
+const accessTokenValidity = time.Hour
+
+// SessionMetadata describes the client a session is opened from
+type SessionMetadata struct {
+	// IPAddress is the client IP address, as resolved by the http router
+	IPAddress string
+	// UserAgent is the raw User-Agent header sent by the client
+	UserAgent string
 }
 
 // Usecases is the usecases for the auth service
 type Usecases struct {
 	logger  *betalinklogger.Logger
 	queries *Queries
+	// store holds the users, login data, roles, sessions and revoked
+	// access tokens, the queries unless set with WithStore
+	store    Store
+	sessions SessionConfig
+	keys     *KeySet
+	policies *PolicyEngine
+	issuer   string
+	// relyingParty talks to the external providers users log in with
+	relyingParty *relyingParty
+	// keyring encrypts the secrets stored in the database
+	keyring *Keyring
+	// credentialBackends verify the passwords of the accounts, by the
+	// hash algorithm of their login data
+	credentialBackends map[string]CredentialBackend
 }
 
 // NewUsecase creates a new Usecases instance
-func NewUsecase(logger *betalinklogger.Logger, queries *Queries) *Usecases {
-	return &Usecases{
-		logger:  logger,
-		queries: queries,
+func NewUsecase(logger *betalinklogger.Logger, queries *Queries, opts ...UsecaseOption) (*Usecases, error) {
+	usecases := &Usecases{
+		logger:   logger,
+		queries:  queries,
+		sessions: DefaultSessionConfig,
+		issuer:   DefaultIssuer,
+		credentialBackends: map[string]CredentialBackend{
+			HashAlgorithmBcrypt: bcryptBackend{},
+		},
+	}
+	for _, opt := range opts {
+		opt(usecases)
+	}
+	if usecases.store == nil {
+		usecases.store = queries
+	}
+	if usecases.relyingParty == nil {
+		usecases.relyingParty = newRelyingParty(&http.Client{Timeout: providerRequestTimeout})
+	}
+	if usecases.keys == nil {
+		logger.Warning("No signing key configured, generating an ephemeral one. " +
+			"Access tokens will not survive a restart.")
+		key, err := GenerateSigningKey()
+		if err != nil {
+			return nil, fmt.Errorf("could not generate signing key: %w", err)
+		}
+		usecases.keys = NewKeySet(key)
 	}
+	if usecases.keyring == nil {
+		logger.Warning("No keyring configured, generating an ephemeral one. " +
+			"Encrypted secrets will not survive a restart.")
+		key, err := GenerateEncryptionKey()
+		if err != nil {
+			return nil, fmt.Errorf("could not generate encryption key: %w", err)
+		}
+		usecases.keyring = NewKeyring(key)
+	}
+	policies, err := NewPolicyEngine(logger, queries)
+	if err != nil {
+		return nil, fmt.Errorf("could not create policy engine: %w", err)
+	}
+	usecases.policies = policies
+	return usecases, nil
 }
 
-// RegisterUser registers a new user in the database
-func (u *Usecases) RegisterUser(ctx context.Context, firstname, lastname, email, password string) error {
+// postgresQueries returns the Postgres queries holding the features
+// beyond the Store interface, or an UnsupportedError naming the feature
+// when the usecases run on another store
+func (u *Usecases) postgresQueries(feature string) (*Queries, error) {
+	if u.queries == nil {
+		return nil, &UnsupportedError{Message: feature + " require the Postgres store"}
+	}
+	return u.queries, nil
+}
+
+// JWKS returns the public keys verifying the access tokens
+func (u *Usecases) JWKS() JSONWebKeySet {
+	return u.keys.JWKS()
+}
+
+// RegisterUser registers a new user in the database, with the default
+// role and the given roles. The user is created with all of its roles or
+// not at all.
+func (u *Usecases) RegisterUser(ctx context.Context, firstname, lastname, email, password string, roles ...string) error {
 	u.logger.Info("Registering user")
 	// validate user data
 	if ok, err := ValidateEmail(email); !ok {
@@ -54,42 +163,65 @@ func (u *Usecases) RegisterUser(ctx context.Context, firstname, lastname, email,
 		}
 	}
 
-	err := checkEmailUniqueness(ctx, u.queries, email)
+	err := checkEmailUniqueness(ctx, u.store, email)
 	if err != nil {
 		return err
 	}
 
-	// create user
-	userParams := CreateUserParams{
-		FirstName: firstname,
-		LastName:  lastname,
-	}
-	userID, err := u.queries.CreateUser(ctx, userParams)
-	if err != nil {
-		return &ServerError{
-			Message: fmt.Errorf("could not create user: %w", err).Error(),
-		}
-	}
-
-	// create user login data
+	// hash the password out of the transaction, it is slow on purpose
 	passwordHash, err := HashPassword(password)
 	if err != nil {
 		return &ServerError{
 			Message: fmt.Errorf("could not hash password: %w", err).Error(),
 		}
 	}
-	userLoginDataParams := CreateUserLoginDataParams{
-		UserID:        userID,
-		Email:         email,
-		Passwordhash:  passwordHash,
-		Passwordsalt:  "",
-		Hashalgorithm: "BCRYPT",
-	}
-	err = u.queries.CreateUserLoginData(ctx, userLoginDataParams)
-	if err != nil {
-		return &ServerError{
-			Message: fmt.Errorf("could not create user login data: %w", err).Error(),
+
+	err = u.store.InTx(ctx, func(store Store) error {
+		// create user
+		userParams := CreateUserParams{
+			FirstName: firstname,
+			LastName:  lastname,
+		}
+		userID, err := store.CreateUser(ctx, userParams)
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not create user: %w", err).Error(),
+			}
+		}
+
+		// create user login data
+		userLoginDataParams := CreateUserLoginDataParams{
+			UserID:        userID,
+			Email:         email,
+			Passwordhash:  passwordHash,
+			Passwordsalt:  "",
+			Hashalgorithm: HashAlgorithmBcrypt,
 		}
+		err = store.CreateUserLoginData(ctx, userLoginDataParams)
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not create user login data: %w", err).Error(),
+			}
+		}
+
+		for _, role := range append([]string{DefaultRole}, roles...) {
+			err = store.AssignUserRole(ctx, AssignUserRoleParams{
+				UserID:   userID,
+				RoleName: role,
+			})
+			if err != nil {
+				if pgErrorCode(err) == pgForeignKeyViolation {
+					return &NotFoundError{Message: fmt.Sprintf("role [%s] not found", role)}
+				}
+				return &ServerError{
+					Message: fmt.Errorf("could not assign role [%s]: %w", role, err).Error(),
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// create email verification
@@ -99,9 +231,9 @@ func (u *Usecases) RegisterUser(ctx context.Context, firstname, lastname, email,
 }
 
 // checkEmailUniqueness checks if an email is unique in the database
-func checkEmailUniqueness(ctx context.Context, queries *Queries, email string) error {
+func checkEmailUniqueness(ctx context.Context, store Store, email string) error {
 	// Attempt to get login data by email
-	_, err := queries.GetLoginDataByEmail(ctx, email)
+	_, err := store.GetLoginDataByEmail(ctx, email)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			// Email does not exist; it's unique
@@ -120,77 +252,358 @@ func checkEmailUniqueness(ctx context.Context, queries *Queries, email string) e
 	}
 }
 
-// LoginUser checks the user credentials
-func (u *Usecases) LoginUser(ctx context.Context, email, password string) (*IDTokens, error) {
+// tokenGrant is what the tokens of a session are issued for
+type tokenGrant struct {
+	UserID    pgtype.UUID
+	SessionID pgtype.UUID
+	// ClientID is the OAuth client the session was opened through,
+	// empty for the first-party apps logging in directly
+	ClientID string
+	// Scopes are the scopes granted to the OAuth client, empty for the
+	// first-party apps which are granted every scope
+	Scopes []string
+	// Nonce is the value sent by the client in its authentication
+	// request, if any
+	Nonce string
+	// AuthTime is when the user authenticated
+	AuthTime time.Time
+}
+
+// sessionGrant returns the grant of the tokens issued for a session
+func sessionGrant(session Session) tokenGrant {
+	grant := tokenGrant{
+		UserID:    session.UserID,
+		SessionID: session.SessionID,
+		AuthTime:  session.CreatedAt.Time,
+	}
+	if session.ClientID.Valid {
+		grant.ClientID = session.ClientID.String
+		grant.Scopes = strings.Fields(session.Scope)
+	}
+	return grant
+}
+
+// generateAccessToken generates an access token for a user, bound
+// to one of its sessions
+func (u *Usecases) generateAccessToken(ctx context.Context, grant tokenGrant) (string, error) {
+	user, err := u.store.GetUserById(ctx, grant.UserID)
+	if err != nil {
+		return "", &ServerError{
+			Message: fmt.Errorf("could not get user by ID: %w", err).Error(),
+		}
+	}
+
+	roles, err := u.store.GetUserRoles(ctx, grant.UserID)
+	if err != nil {
+		return "", &ServerError{
+			Message: fmt.Errorf("could not get user roles: %w", err).Error(),
+		}
+	}
+	permissions, err := u.store.GetUserPermissions(ctx, grant.UserID)
+	if err != nil {
+		return "", &ServerError{
+			Message: fmt.Errorf("could not get user permissions: %w", err).Error(),
+		}
+	}
+
+	accessToken, err := u.keys.GenerateAccessToken(AccessTokenClaims{
+		UserID:      user.UserID.String(),
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Roles:       roleNames(roles),
+		Permissions: permissions,
+		SessionID:   grant.SessionID.String(),
+		ClientID:    grant.ClientID,
+		Scopes:      grant.Scopes,
+	}, accessTokenValidity)
+	if err != nil {
+		return "", &ServerError{
+			Message: fmt.Errorf("could not generate access token: %w", err).Error(),
+		}
+	}
+	return accessToken, nil
+}
+
+// LoginUser checks the user credentials and opens a new session
+// described by the given metadata. Sessions opened with rememberMe
+// follow the longer "remember me" session policy.
+func (u *Usecases) LoginUser(ctx context.Context, email, password string, rememberMe bool, metadata SessionMetadata) (*IDTokens, error) {
 	u.logger.Info("Logging in user")
+	userID, err := u.checkPassword(ctx, email, password, metadata)
+	if err != nil {
+		return nil, err
+	}
+	createSessionParams := u.newCreateSessionParams(userID, LoginMethodPassword, rememberMe, metadata)
+	return u.openSession(ctx, createSessionParams, "")
+}
+
+// checkPassword checks the credentials of a user logging in with an
+// email and password, and returns its ID. The wrong passwords of the
+// known users are recorded in their login history.
+func (u *Usecases) checkPassword(ctx context.Context, email, password string, metadata SessionMetadata) (pgtype.UUID, error) {
 	// get login data
-	loginData, err := u.queries.GetLoginDataByEmail(ctx, email)
+	loginData, err := u.store.GetLoginDataByEmail(ctx, email)
 	if err != nil {
-		return nil, &ServerError{
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pgtype.UUID{}, InvalidCredentialsError
+		}
+		return pgtype.UUID{}, &ServerError{
 			Message: fmt.Errorf("could not get login data: %w", err).Error(),
 		}
 	}
+	attempt := u.newCreateSessionParams(loginData.UserID, LoginMethodPassword, false, metadata)
+	// the password of a user forced to reset it has been cleared, it
+	// must choose a new one first
+	if loginData.Hashalgorithm == HashAlgorithmBcrypt && loginData.Passwordhash == "" {
+		u.recordLoginEvent(ctx, attempt, PasswordResetRequiredError)
+		return pgtype.UUID{}, PasswordResetRequiredError
+	}
 
-	// check password
-	if err := ComparePassword(password, loginData.Passwordhash); err != nil {
-		return nil, &ValidationError{
-			Message: fmt.Errorf("could not compare password: %w", err).Error(),
+	// check password with the backend of the account
+	backend, ok := u.credentialBackends[loginData.Hashalgorithm]
+	if !ok {
+		return pgtype.UUID{}, &ServerError{
+			Message: fmt.Sprintf("no credential backend for hash algorithm [%s]", loginData.Hashalgorithm),
 		}
 	}
+	profile, err := backend.Authenticate(ctx, loginData, password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPassword) {
+			u.recordLoginEvent(ctx, attempt, ErrInvalidPassword)
+			return pgtype.UUID{}, &ValidationError{
+				Message: fmt.Errorf("could not compare password: %w", err).Error(),
+			}
+		}
+		return pgtype.UUID{}, &ServerError{
+			Message: fmt.Errorf("could not check password with [%s] backend: %w", loginData.Hashalgorithm, err).Error(),
+		}
+	}
+	// the backend is the source of truth of the names of its users
+	if profile != nil && (profile.FirstName != "" || profile.LastName != "") {
+		err := u.store.UpdateUserNames(ctx, UpdateUserNamesParams{
+			UserID:    loginData.UserID,
+			FirstName: profile.FirstName,
+			LastName:  profile.LastName,
+		})
+		if err != nil {
+			return pgtype.UUID{}, &ServerError{
+				Message: fmt.Errorf("could not update user names: %w", err).Error(),
+			}
+		}
+	}
+	return loginData.UserID, nil
+}
 
-	// create refresh and access tokens
-	// TODO: implement roles
-	// TODO: implement secret
-	accessToken, err := GenerateAccessToken(loginData.UserID.String(), []string{"user"}, "mysecret", time.Hour)
+// openSession opens a new session and issues its refresh, access and
+// ID tokens. The nonce, if any, is echoed in the ID token.
+func (u *Usecases) openSession(ctx context.Context, params CreateSessionParams, nonce string) (*IDTokens, error) {
+	// the refresh token lives as long as the session may live, the idle
+	// timeout is enforced by the session itself
+	refreshToken, err := GenerateRefreshToken()
 	if err != nil {
 		return nil, &ServerError{
-			Message: fmt.Errorf("could not generate access token: %w", err).Error(),
+			Message: fmt.Errorf("could not generate refresh token: %w", err).Error(),
 		}
 	}
+	params.RefreshTokenHash = pgtype.Text{String: HashRefreshToken(refreshToken), Valid: true}
+	sessionID, err := u.createSession(ctx, params)
+	if err != nil {
+		return nil, err
+	}
 
-	createSessionParams := CreateSessionParams{
-		UserID: loginData.UserID,
-		CreatedAt: pgtype.Timestamptz{
+	grant := sessionGrant(Session{
+		SessionID: sessionID,
+		UserID:    params.UserID,
+		CreatedAt: params.CreatedAt,
+		ClientID:  params.ClientID,
+		Scope:     params.Scope,
+	})
+	grant.Nonce = nonce
+	accessToken, idToken, err := u.generateTokens(ctx, grant)
+	if err != nil {
+		return nil, err
+	}
+	return &IDTokens{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		IDToken:          idToken,
+		Scopes:           grant.Scopes,
+		RefreshExpiresAt: params.AbsoluteExpiresAt.Time,
+		RememberMe:       params.RememberMe,
+	}, nil
+}
+
+// generateTokens generates the access and ID tokens of a grant. OAuth
+// clients only get an ID token when granted the openid scope.
+func (u *Usecases) generateTokens(ctx context.Context, grant tokenGrant) (string, string, error) {
+	accessToken, err := u.generateAccessToken(ctx, grant)
+	if err != nil {
+		return "", "", err
+	}
+	if !grantsScope(grant.ClientID, grant.Scopes, ScopeOpenID) {
+		return accessToken, "", nil
+	}
+	idToken, err := u.generateIDToken(ctx, grant)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, idToken, nil
+}
+
+// createSession opens a new session, enforcing the session limit of
+// the user and refusing the disabled users. The attempt is recorded in
+// the login history of the user. Concurrent logins of a same user are
+// serialized by locking the user row, so the limit holds across replicas.
+func (u *Usecases) createSession(ctx context.Context, params CreateSessionParams) (pgtype.UUID, error) {
+	var sessionID pgtype.UUID
+	err := u.store.InTx(ctx, func(store Store) error {
+		user, err := store.LockUserForSession(ctx, params.UserID)
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not lock user: %w", err).Error(),
+			}
+		}
+		if user.DisabledAt.Valid {
+			return AccountDisabledError
+		}
+		maxSessions := u.sessions.MaxSessions
+		if user.MaxSessions.Valid {
+			maxSessions = user.MaxSessions.Int32
+		} else {
+			roles, err := store.GetUserRoles(ctx, params.UserID)
+			if err != nil {
+				return &ServerError{
+					Message: fmt.Errorf("could not get user roles: %w", err).Error(),
+				}
+			}
+			if roleMaxSessions, ok := rolesMaxSessions(roles); ok {
+				maxSessions = roleMaxSessions
+			}
+		}
+
+		if maxSessions > 0 {
+			activeSessions, err := store.CountActiveSessions(ctx, params.UserID)
+			if err != nil {
+				return &ServerError{
+					Message: fmt.Errorf("could not count active sessions: %w", err).Error(),
+				}
+			}
+			if activeSessions >= int64(maxSessions) {
+				if u.sessions.LimitPolicy != SessionLimitEvictOldest {
+					return SessionLimitReachedError
+				}
+				_, err := store.DeleteOldestActiveSessions(ctx, DeleteOldestActiveSessionsParams{
+					UserID: params.UserID,
+					Limit:  int32(activeSessions-int64(maxSessions)) + 1,
+				})
+				if err != nil {
+					return &ServerError{
+						Message: fmt.Errorf("could not evict oldest sessions: %w", err).Error(),
+					}
+				}
+			}
+		}
+
+		sessionID, err = store.CreateSession(ctx, params)
+		if err != nil {
+			return &ServerError{
+				Message: fmt.Errorf("could not create session: %w", err).Error(),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		switch err.(type) {
+		case *ValidationError, *SessionLimitError:
+			u.recordLoginEvent(ctx, params, err)
+			return sessionID, err
+		case *ServerError:
+			return sessionID, err
+		default:
+			return sessionID, &ServerError{
+				Message: fmt.Errorf("could not open session: %w", err).Error(),
+			}
+		}
+	}
+	u.recordLoginEvent(ctx, params, nil)
+	return sessionID, nil
+}
+
+// recordLoginEvent records a login attempt of a user in its login
+// history, failed with the given error if any. The attempt does not fail
+// when it cannot be recorded.
+func (u *Usecases) recordLoginEvent(ctx context.Context, session CreateSessionParams, failure error) {
+	params := CreateLoginEventParams{
+		UserID: session.UserID,
+		OccurredAt: pgtype.Timestamptz{
 			Time:  time.Now(),
 			Valid: true,
 		},
+		LoginMethod: session.LoginMethod,
+		Succeeded:   failure == nil,
+		IpAddress:   session.IpAddress,
+		UserAgent:   session.UserAgent,
+	}
+	if failure != nil {
+		params.FailureReason = failure.Error()
+	}
+	if err := u.store.CreateLoginEvent(ctx, params); err != nil {
+		u.logger.Error(fmt.Errorf("could not record login event: %w", err))
+	}
+}
+
+// newCreateSessionParams builds the parameters of a new session
+// opened by the given user with the given login method
+func (u *Usecases) newCreateSessionParams(userID pgtype.UUID, loginMethod string, rememberMe bool, metadata SessionMetadata) CreateSessionParams {
+	now := time.Now()
+	policy := u.sessions.policy(rememberMe)
+	absoluteExpiresAt := now.Add(policy.AbsoluteLifetime)
+	params := CreateSessionParams{
+		UserID: userID,
+		CreatedAt: pgtype.Timestamptz{
+			Time:  now,
+			Valid: true,
+		},
 		UpdatedAt: pgtype.Timestamptz{
-			Time:  time.Now(),
+			Time:  now,
 			Valid: true,
 		},
 		ExpiresAt: pgtype.Timestamptz{
-			Time:  time.Now().Add(time.Hour * 24), // TODO: make this configurable
+			Time:  idleExpiresAt(now, policy, absoluteExpiresAt),
 			Valid: true,
 		},
+		AbsoluteExpiresAt: pgtype.Timestamptz{
+			Time:  absoluteExpiresAt,
+			Valid: true,
+		},
+		RememberMe:  rememberMe,
+		UserAgent:   metadata.UserAgent,
+		Device:      ParseDeviceLabel(metadata.UserAgent),
+		LoginMethod: loginMethod,
 	}
-	sessionID, err := u.queries.CreateSession(ctx, createSessionParams)
-	if err != nil {
-		return nil, &ServerError{
-			Message: fmt.Errorf("could not create session: %w", err).Error(),
-		}
-	}
-	refreshToken, err := GenerateRefreshToken(
-		sessionID.String(),
-		createSessionParams.CreatedAt.Time,
-		createSessionParams.ExpiresAt.Time,
-		"mysecret",
-	)
-	if err != nil {
-		return nil, &ServerError{
-			Message: fmt.Errorf("could not generate refresh token: %w", err).Error(),
-		}
+	// an unparsable address is not worth failing the login for,
+	// the session is simply stored without it
+	if ipAddress, err := netip.ParseAddr(metadata.IPAddress); err == nil {
+		ipAddress = ipAddress.Unmap()
+		params.IpAddress = &ipAddress
 	}
+	return params
+}
 
-	return &IDTokens{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-	}, nil
+// idleExpiresAt returns when a session used at the given time expires
+// if it is not used again, never going past its absolute expiry
+func idleExpiresAt(usedAt time.Time, policy SessionPolicy, absoluteExpiresAt time.Time) time.Time {
+	expiresAt := usedAt.Add(policy.IdleTimeout)
+	if expiresAt.After(absoluteExpiresAt) {
+		return absoluteExpiresAt
+	}
+	return expiresAt
 }
 
 // ValidateAccessToken validates an access token
 func (u *Usecases) ValidateAccessToken(ctx context.Context, accessToken string) (*UserData, error) {
 	// validate access token
-	claims, err := ValidateAccessToken(accessToken, "mysecret")
+	claims, err := u.keys.ValidateAccessToken(accessToken)
 	if err != nil {
 		return nil, &ValidationError{
 			Message: fmt.Errorf("could not validate access token: %w", err).Error(),
@@ -209,6 +622,11 @@ func (u *Usecases) ValidateAccessToken(ctx context.Context, accessToken string)
 		return nil, ExpiredTokenError
 	}
 
+	// check if token or its session has been revoked
+	if err := u.checkAccessTokenRevocation(ctx, claims); err != nil {
+		return nil, err
+	}
+
 	// get user ID
 	userID, ok := claims["user_id"].(string)
 	if !ok {
@@ -227,72 +645,107 @@ func (u *Usecases) ValidateAccessToken(ctx context.Context, accessToken string)
 		Valid: true,
 	}
 
-	user, err := u.queries.GetUserById(ctx, pgUUID)
+	user, err := u.store.GetUserById(ctx, pgUUID)
 	if err != nil {
 		return nil, fmt.Errorf("could not get user by ID: %w", err)
 	}
+	clientID, _ := claims["client_id"].(string)
 
 	return &UserData{
-		UserID:    user.UserID,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
+		UserID:      user.UserID,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Roles:       claim.Strings(claims, "roles"),
+		Permissions: claim.Strings(claims, "permissions"),
+		ClientID:    clientID,
+		Scopes:      claim.Scope(claims),
 	}, nil
 }
 
+// RefreshAccessToken issues a new access token for the session of the
+// refresh token and extends the idle timeout of that session. Refresh
+// tokens issued to OAuth clients are refreshed with RefreshClientToken.
 func (u *Usecases) RefreshAccessToken(ctx context.Context, refreshToken string) (*IDTokens, error) {
-	// validate refresh token
-	claims, err := ValidateRefreshToken(refreshToken, "mysecret")
+	return u.refreshSession(ctx, refreshToken, "")
+}
+
+// refreshSession refreshes the session of a refresh token issued to the
+// given OAuth client, or to the first-party apps if clientID is empty
+func (u *Usecases) refreshSession(ctx context.Context, refreshToken, clientID string) (*IDTokens, error) {
+	session, err := u.getRefreshTokenSession(ctx, refreshToken)
 	if err != nil {
-		return nil, &ValidationError{
-			Message: fmt.Errorf("could not validate refresh token: %w", err).Error(),
-		}
+		return nil, err
 	}
 
-	// get session ID
-	sessionID, ok := claims["session_id"].(string)
-	if !ok {
+	// a refresh token can only be used by the client it was issued to
+	if session.ClientID.String != clientID {
 		return nil, &ValidationError{
-			Message: "could not get session ID from claims",
+			Message: "refresh token was not issued to this client",
 		}
 	}
-	parsedUUID, err := uuid.Parse(sessionID)
-	if err != nil {
-		return nil, &ValidationError{
-			Message: "invalid UUID format",
-		}
+
+	// check if session is expired, either because it was idle
+	// for too long or because it reached its maximum lifetime
+	now := time.Now()
+	if session.ExpiresAt.Time.Before(now) || session.AbsoluteExpiresAt.Time.Before(now) {
+		return nil, ExpiredTokenError
 	}
 
-	// get session
-	session, err := u.queries.GetSessionById(ctx, pgtype.UUID{
-		Bytes: parsedUUID,
-		Valid: true,
+	// slide the idle timeout of the session
+	policy := u.sessions.policy(session.RememberMe)
+	err = u.store.TouchSession(ctx, TouchSessionParams{
+		UpdatedAt: pgtype.Timestamptz{
+			Time:  now,
+			Valid: true,
+		},
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  idleExpiresAt(now, policy, session.AbsoluteExpiresAt.Time),
+			Valid: true,
+		},
+		SessionID: session.SessionID,
 	})
 	if err != nil {
 		return nil, &ServerError{
-			Message: fmt.Errorf("could not get session by ID: %w", err).Error(),
+			Message: fmt.Errorf("could not update session: %w", err).Error(),
 		}
 	}
 
-	// check if session is expired
-	if session.ExpiresAt.Time.Before(time.Now()) {
-		return nil, ExpiredTokenError
-	}
-
-	// create new access token
-	accessToken, err := GenerateAccessToken(
-		session.UserID.String(),
-		[]string{"user"},
-		"mysecret",
-		time.Hour,
-	)
+	// create new access and ID tokens
+	grant := sessionGrant(session)
+	accessToken, idToken, err := u.generateTokens(ctx, grant)
 	if err != nil {
-		return nil, &ServerError{
-			Message: fmt.Errorf("could not generate access token: %w", err).Error(),
-		}
+		return nil, err
 	}
 
 	return &IDTokens{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		IDToken:          idToken,
+		Scopes:           grant.Scopes,
+		RefreshExpiresAt: session.AbsoluteExpiresAt.Time,
+		RememberMe:       session.RememberMe,
 	}, nil
 }
+
+// getRefreshTokenSession returns the session a refresh token was issued
+// for, RevokedTokenError if the session has ended
+func (u *Usecases) getRefreshTokenSession(ctx context.Context, refreshToken string) (Session, error) {
+	if !isRefreshToken(refreshToken) {
+		return Session{}, &ValidationError{
+			Message: "could not validate refresh token: malformed token",
+		}
+	}
+	session, err := u.store.GetSessionByRefreshTokenHash(ctx, pgtype.Text{
+		String: HashRefreshToken(refreshToken),
+		Valid:  true,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Session{}, RevokedTokenError
+		}
+		return Session{}, &ServerError{
+			Message: fmt.Errorf("could not get session of refresh token: %w", err).Error(),
+		}
+	}
+	return session, nil
+}
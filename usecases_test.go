@@ -1,17 +1,18 @@
+//go:build integration
+
 package betalinkauth_test
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"testing"
 	"time"
 
 	betalinkauth "github.com/BragdonD/betalink-auth"
 	betalinklogger "github.com/BragdonD/betalink-logger"
-	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -33,6 +34,11 @@ var (
 
 const logPath = "./logs/betalink-auth-tests.log"
 
+var testSessionMetadata = betalinkauth.SessionMetadata{
+	IPAddress: "203.0.113.7",
+	UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+}
+
 func TestMain(m *testing.M) {
 	// Global setup
 	testCtx = context.Background()
@@ -65,8 +71,7 @@ func TestMain(m *testing.M) {
 		log.Fatalf("could not get connection string: %v", err)
 		return
 	}
-	migrationDir := "./migrations"
-	if err := runGooseMigrations(migrationDir, dbURL); err != nil {
+	if err := runMigrations(dbURL); err != nil {
 		log.Fatalf("failed to run migrations: %v", err)
 	}
 
@@ -83,14 +88,20 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-func runGooseMigrations(migrationDir, dsn string) error {
-	log.Println("Running goose migrations")
-	cmd := exec.Command("/home/bragdon/.goose/bin/goose", "-dir", migrationDir, "postgres", dsn, "up")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func runMigrations(dsn string) error {
+	log.Println("Running migrations")
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return fmt.Errorf("could not open database: %w", err)
+	}
+	migrations, err := betalinkauth.NewPostgresMigrations(db)
+	if err != nil {
+		return err
+	}
+	defer migrations.Close()
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to run goose migrations: %w", err)
+	if _, err := migrations.Up(testCtx); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 	log.Println("Migrations completed successfully")
 	return nil
@@ -109,6 +120,17 @@ func createPgxConn() (*pgx.Conn, error) {
 	return conn, nil
 }
 
+// getTestSession returns the session of a refresh token
+func getTestSession(t *testing.T, queries *betalinkauth.Queries, refreshToken string) betalinkauth.Session {
+	t.Helper()
+	session, err := queries.GetSessionByRefreshTokenHash(testCtx, pgtype.Text{
+		String: betalinkauth.HashRefreshToken(refreshToken),
+		Valid:  true,
+	})
+	require.NoError(t, err)
+	return session
+}
+
 func createLogger() (*betalinklogger.Logger, error) {
 	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0660)
 	if err != nil {
@@ -128,10 +150,22 @@ func TestNewUsecase(t *testing.T) {
 	if err != nil {
 		t.Fatalf("could not create logger: %v", err)
 	}
-	usecases := betalinkauth.NewUsecase(logger, queries)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
 	require.NotNil(t, usecases)
 }
 
+func TestQueries_Store(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+
+	testStore(t, betalinkauth.New(conn))
+}
+
 func TestUsecases_RegisterUser(t *testing.T) {
 	err := dbContainer.Restore(testCtx)
 	require.NoError(t, err)
@@ -144,7 +178,8 @@ func TestUsecases_RegisterUser(t *testing.T) {
 	logger, err := createLogger()
 	require.NoError(t, err)
 
-	usecases := betalinkauth.NewUsecase(logger, queries)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
 
 	t.Run("valid registration", func(t *testing.T) {
 		firstName := "John"
@@ -180,7 +215,8 @@ func TestUsecases_LoginUser(t *testing.T) {
 	logger, err := createLogger()
 	require.NoError(t, err)
 
-	usecases := betalinkauth.NewUsecase(logger, queries)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
 
 	// Set up a test user
 	testEmail := "login.test@example.com"
@@ -189,20 +225,106 @@ func TestUsecases_LoginUser(t *testing.T) {
 	require.NoError(t, err)
 
 	t.Run("valid login", func(t *testing.T) {
-		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword)
+		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
 		require.NoError(t, err)
 		require.NotNil(t, tokens)
 		require.NotEmpty(t, tokens.AccessToken)
 		require.NotEmpty(t, tokens.RefreshToken)
 	})
 
+	t.Run("session metadata", func(t *testing.T) {
+		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+
+		session := getTestSession(t, queries, tokens.RefreshToken)
+		require.NotNil(t, session.IpAddress)
+		require.Equal(t, testSessionMetadata.IPAddress, session.IpAddress.String())
+		require.Equal(t, testSessionMetadata.UserAgent, session.UserAgent)
+		require.Equal(t, "Chrome on Windows 10", session.Device)
+		require.Equal(t, betalinkauth.LoginMethodPassword, session.LoginMethod)
+	})
+
 	t.Run("invalid password", func(t *testing.T) {
-		_, err := usecases.LoginUser(testCtx, testEmail, "WrongPassword")
+		_, err := usecases.LoginUser(testCtx, testEmail, "WrongPassword", false, testSessionMetadata)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "could not compare password")
 	})
 }
 
+func TestUsecases_LoginUser_LDAP(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+
+	usecases, err := betalinkauth.NewUsecase(logger, queries,
+		betalinkauth.WithCredentialBackend(betalinkauth.HashAlgorithmLDAP, newTestLDAPBackend(t)))
+	require.NoError(t, err)
+
+	testEmail := "ada@qa.betalink.test"
+	err = usecases.CreateLDAPUser(testCtx, testEmail)
+	require.NoError(t, err)
+
+	t.Run("created from directory", func(t *testing.T) {
+		loginData, err := queries.GetLoginDataByEmail(testCtx, testEmail)
+		require.NoError(t, err)
+		require.Equal(t, betalinkauth.HashAlgorithmLDAP, loginData.Hashalgorithm)
+		require.Empty(t, loginData.Passwordhash)
+
+		err = usecases.CreateLDAPUser(testCtx, testEmail)
+		require.Error(t, err)
+
+		err = usecases.CreateLDAPUser(testCtx, "grace@qa.betalink.test")
+		var notFoundErr *betalinkauth.NotFoundError
+		require.ErrorAs(t, err, &notFoundErr)
+
+		err = usecases.CreateLDAPUser(testCtx, "*")
+		var validationErr *betalinkauth.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+
+	t.Run("valid login", func(t *testing.T) {
+		loginData, err := queries.GetLoginDataByEmail(testCtx, testEmail)
+		require.NoError(t, err)
+		err = queries.UpdateUserNames(testCtx, betalinkauth.UpdateUserNamesParams{
+			UserID:    loginData.UserID,
+			FirstName: "Renamed",
+			LastName:  "Locally",
+		})
+		require.NoError(t, err)
+
+		tokens, err := usecases.LoginUser(testCtx, testEmail, "Directory-Pa55", false, testSessionMetadata)
+		require.NoError(t, err)
+		require.NotEmpty(t, tokens.AccessToken)
+
+		// the names follow the directory
+		user, err := queries.GetUserById(testCtx, loginData.UserID)
+		require.NoError(t, err)
+		require.Equal(t, "Ada", user.FirstName)
+		require.Equal(t, "Lovelace", user.LastName)
+	})
+
+	t.Run("invalid password", func(t *testing.T) {
+		_, err := usecases.LoginUser(testCtx, testEmail, "WrongPassword", false, testSessionMetadata)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "could not compare password")
+	})
+
+	t.Run("no backend", func(t *testing.T) {
+		usecases, err := betalinkauth.NewUsecase(logger, queries)
+		require.NoError(t, err)
+		_, err = usecases.LoginUser(testCtx, testEmail, "Directory-Pa55", false, testSessionMetadata)
+		var serverErr *betalinkauth.ServerError
+		require.ErrorAs(t, err, &serverErr)
+	})
+}
+
 func TestUsecases_ValidateAccessToken(t *testing.T) {
 	err := dbContainer.Restore(testCtx)
 	require.NoError(t, err)
@@ -215,7 +337,11 @@ func TestUsecases_ValidateAccessToken(t *testing.T) {
 	logger, err := createLogger()
 	require.NoError(t, err)
 
-	usecases := betalinkauth.NewUsecase(logger, queries)
+	signingKey, err := betalinkauth.GenerateSigningKey()
+	require.NoError(t, err)
+	keys := betalinkauth.NewKeySet(signingKey)
+	usecases, err := betalinkauth.NewUsecase(logger, queries, betalinkauth.WithKeySet(keys))
+	require.NoError(t, err)
 
 	// Set up a test user and login to get a token
 	testEmail := "validate.token@example.com"
@@ -223,7 +349,7 @@ func TestUsecases_ValidateAccessToken(t *testing.T) {
 	err = usecases.RegisterUser(testCtx, "Token", "Validate", testEmail, testPassword)
 	require.NoError(t, err)
 
-	tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword)
+	tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
 	require.NoError(t, err)
 	require.NotNil(t, tokens)
 
@@ -232,16 +358,33 @@ func TestUsecases_ValidateAccessToken(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, userData)
 		require.Equal(t, "Token", userData.FirstName)
+		require.Equal(t, []string{"user"}, userData.Roles)
 	})
 
 	t.Run("expired token", func(t *testing.T) {
 		// Generate an expired token
-		expiredToken, err := betalinkauth.GenerateAccessToken("12345", []string{"user"}, "mysecret", -1*time.Hour)
+		expiredToken, err := keys.GenerateAccessToken(betalinkauth.AccessTokenClaims{
+			UserID: "12345",
+			Roles:  []string{"user"},
+		}, -1*time.Hour)
 		require.NoError(t, err)
 		_, err = usecases.ValidateAccessToken(testCtx, expiredToken)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "token is expired")
 	})
+
+	t.Run("token signed by an unknown key", func(t *testing.T) {
+		otherKey, err := betalinkauth.GenerateSigningKey()
+		require.NoError(t, err)
+		forgedToken, err := betalinkauth.NewKeySet(otherKey).GenerateAccessToken(betalinkauth.AccessTokenClaims{
+			UserID: "12345",
+			Roles:  []string{"user"},
+		}, time.Hour)
+		require.NoError(t, err)
+		_, err = usecases.ValidateAccessToken(testCtx, forgedToken)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown signing key")
+	})
 }
 
 func TestUsecases_RefreshAccessToken(t *testing.T) {
@@ -256,7 +399,8 @@ func TestUsecases_RefreshAccessToken(t *testing.T) {
 	logger, err := createLogger()
 	require.NoError(t, err)
 
-	usecases := betalinkauth.NewUsecase(logger, queries)
+	usecases, err := betalinkauth.NewUsecase(logger, queries)
+	require.NoError(t, err)
 
 	// Set up a test user and login to get tokens
 	testEmail := "refresh.token@example.com"
@@ -264,7 +408,7 @@ func TestUsecases_RefreshAccessToken(t *testing.T) {
 	err = usecases.RegisterUser(testCtx, "Refresh", "Token", testEmail, testPassword)
 	require.NoError(t, err)
 
-	tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword)
+	tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
 	require.NoError(t, err)
 	require.NotNil(t, tokens)
 
@@ -276,6 +420,18 @@ func TestUsecases_RefreshAccessToken(t *testing.T) {
 		require.Equal(t, tokens.RefreshToken, newTokens.RefreshToken)
 	})
 
+	t.Run("sliding expiry", func(t *testing.T) {
+		session := getTestSession(t, queries, tokens.RefreshToken)
+
+		_, err := usecases.RefreshAccessToken(testCtx, tokens.RefreshToken)
+		require.NoError(t, err)
+
+		refreshedSession := getTestSession(t, queries, tokens.RefreshToken)
+		require.True(t, refreshedSession.UpdatedAt.Time.After(session.UpdatedAt.Time))
+		require.True(t, refreshedSession.ExpiresAt.Time.After(session.ExpiresAt.Time))
+		require.Equal(t, session.AbsoluteExpiresAt.Time, refreshedSession.AbsoluteExpiresAt.Time)
+	})
+
 	t.Run("invalid refresh token", func(t *testing.T) {
 		_, err := usecases.RefreshAccessToken(testCtx, "invalid-refresh-token")
 		require.Error(t, err)
@@ -284,17 +440,7 @@ func TestUsecases_RefreshAccessToken(t *testing.T) {
 
 	t.Run("expired session", func(t *testing.T) {
 		// Manually expire the session in the database
-		sessionClaims, err := betalinkauth.ValidateRefreshToken(
-			tokens.RefreshToken,
-			"mysecret",
-		)
-		require.NoError(t, err)
-		session, err := queries.GetSessionById(testCtx, pgtype.UUID{
-			Bytes: uuid.MustParse(sessionClaims["session_id"].(string)),
-			Valid: true,
-		})
-		require.NoError(t, err)
-		require.NotNil(t, session)
+		session := getTestSession(t, queries, tokens.RefreshToken)
 
 		// Expire the session
 		session.ExpiresAt.Time = time.Now().Add(-1 * time.Hour)
@@ -305,7 +451,7 @@ func TestUsecases_RefreshAccessToken(t *testing.T) {
 			},
 			SessionID: session.SessionID,
 		}
-		err = queries.Test_UpdateSessionExpiresAt(testCtx, updateSessionParams)
+		err := queries.Test_UpdateSessionExpiresAt(testCtx, updateSessionParams)
 		require.NoError(t, err)
 
 		_, err = usecases.RefreshAccessToken(testCtx, tokens.RefreshToken)
@@ -313,3 +459,185 @@ func TestUsecases_RefreshAccessToken(t *testing.T) {
 		require.Equal(t, err, betalinkauth.ExpiredTokenError)
 	})
 }
+
+func TestUsecases_SessionLifetime(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	conn, err := createPgxConn()
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+
+	queries := betalinkauth.New(conn)
+	logger, err := createLogger()
+	require.NoError(t, err)
+
+	sessionConfig := betalinkauth.SessionConfig{
+		Default: betalinkauth.SessionPolicy{
+			IdleTimeout:      time.Hour,
+			AbsoluteLifetime: time.Hour * 2,
+		},
+		RememberMe: betalinkauth.SessionPolicy{
+			IdleTimeout:      time.Hour * 24,
+			AbsoluteLifetime: time.Hour * 24 * 30,
+		},
+	}
+	usecases, err := betalinkauth.NewUsecase(logger, queries, betalinkauth.WithSessionConfig(sessionConfig))
+	require.NoError(t, err)
+
+	testEmail := "session.lifetime@example.com"
+	testPassword := "SessionLifetime123!"
+	err = usecases.RegisterUser(testCtx, "Session", "Lifetime", testEmail, testPassword)
+	require.NoError(t, err)
+
+	t.Run("default policy", func(t *testing.T) {
+		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+
+		session := getTestSession(t, queries, tokens.RefreshToken)
+		require.False(t, session.RememberMe)
+		require.WithinDuration(t, time.Now().Add(time.Hour), session.ExpiresAt.Time, time.Minute)
+		require.WithinDuration(t, time.Now().Add(time.Hour*2), session.AbsoluteExpiresAt.Time, time.Minute)
+		require.WithinDuration(t, session.AbsoluteExpiresAt.Time, tokens.RefreshExpiresAt, time.Second)
+	})
+
+	t.Run("remember me policy", func(t *testing.T) {
+		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, true, testSessionMetadata)
+		require.NoError(t, err)
+
+		session := getTestSession(t, queries, tokens.RefreshToken)
+		require.True(t, session.RememberMe)
+		require.WithinDuration(t, time.Now().Add(time.Hour*24), session.ExpiresAt.Time, time.Minute)
+		require.WithinDuration(t, time.Now().Add(time.Hour*24*30), session.AbsoluteExpiresAt.Time, time.Minute)
+	})
+
+	t.Run("absolute lifetime reached", func(t *testing.T) {
+		tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, true, testSessionMetadata)
+		require.NoError(t, err)
+
+		// the session is still active but reached its maximum lifetime
+		session := getTestSession(t, queries, tokens.RefreshToken)
+		err = queries.Test_UpdateSessionAbsoluteExpiresAt(testCtx, betalinkauth.Test_UpdateSessionAbsoluteExpiresAtParams{
+			AbsoluteExpiresAt: pgtype.Timestamptz{
+				Time:  time.Now().Add(-1 * time.Minute),
+				Valid: true,
+			},
+			SessionID: session.SessionID,
+		})
+		require.NoError(t, err)
+
+		_, err = usecases.RefreshAccessToken(testCtx, tokens.RefreshToken)
+		require.Error(t, err)
+		require.Equal(t, err, betalinkauth.ExpiredTokenError)
+	})
+}
+
+func TestUsecases_SessionLimit(t *testing.T) {
+	err := dbContainer.Restore(testCtx)
+	require.NoError(t, err)
+
+	pool, err := createPgxPool()
+	require.NoError(t, err)
+	defer pool.Close()
+
+	queries := betalinkauth.New(pool)
+	logger, err := createLogger()
+	require.NoError(t, err)
+
+	newUsecases := func(t *testing.T, maxSessions int32, policy betalinkauth.SessionLimitPolicy) *betalinkauth.Usecases {
+		sessionConfig := betalinkauth.DefaultSessionConfig
+		sessionConfig.MaxSessions = maxSessions
+		sessionConfig.LimitPolicy = policy
+		usecases, err := betalinkauth.NewUsecase(logger, queries, betalinkauth.WithSessionConfig(sessionConfig))
+		require.NoError(t, err)
+		return usecases
+	}
+	registerUser := func(t *testing.T, usecases *betalinkauth.Usecases, email, password string) pgtype.UUID {
+		err := usecases.RegisterUser(testCtx, "Session", "Limit", email, password)
+		require.NoError(t, err)
+		loginData, err := queries.GetLoginDataByEmail(testCtx, email)
+		require.NoError(t, err)
+		return loginData.UserID
+	}
+	testPassword := "SessionLimit123!"
+
+	t.Run("reject new logins", func(t *testing.T) {
+		usecases := newUsecases(t, 2, betalinkauth.SessionLimitReject)
+		testEmail := "session.limit.reject@example.com"
+		userID := registerUser(t, usecases, testEmail, testPassword)
+
+		for i := 0; i < 2; i++ {
+			_, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+			require.NoError(t, err)
+		}
+		_, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.Equal(t, betalinkauth.SessionLimitReachedError, err)
+
+		activeSessions, err := queries.CountActiveSessions(testCtx, userID)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, activeSessions)
+	})
+
+	t.Run("evict oldest sessions", func(t *testing.T) {
+		usecases := newUsecases(t, 2, betalinkauth.SessionLimitEvictOldest)
+		testEmail := "session.limit.evict@example.com"
+		userID := registerUser(t, usecases, testEmail, testPassword)
+
+		var sessions []betalinkauth.Session
+		for i := 0; i < 3; i++ {
+			tokens, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+			require.NoError(t, err)
+			sessions = append(sessions, getTestSession(t, queries, tokens.RefreshToken))
+		}
+
+		_, err := queries.GetSessionById(testCtx, sessions[0].SessionID)
+		require.ErrorIs(t, err, pgx.ErrNoRows)
+		activeSessions, err := queries.CountActiveSessions(testCtx, userID)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, activeSessions)
+	})
+
+	t.Run("per user limit", func(t *testing.T) {
+		usecases := newUsecases(t, 0, betalinkauth.SessionLimitReject)
+		testEmail := "session.limit.user@example.com"
+		userID := registerUser(t, usecases, testEmail, testPassword)
+		err := queries.SetUserMaxSessions(testCtx, betalinkauth.SetUserMaxSessionsParams{
+			MaxSessions: pgtype.Int4{Int32: 1, Valid: true},
+			UserID:      userID,
+		})
+		require.NoError(t, err)
+
+		_, err = usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.NoError(t, err)
+		_, err = usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+		require.Equal(t, betalinkauth.SessionLimitReachedError, err)
+	})
+
+	t.Run("concurrent logins", func(t *testing.T) {
+		usecases := newUsecases(t, 1, betalinkauth.SessionLimitReject)
+		testEmail := "session.limit.concurrent@example.com"
+		userID := registerUser(t, usecases, testEmail, testPassword)
+
+		const logins = 5
+		errs := make(chan error, logins)
+		for i := 0; i < logins; i++ {
+			go func() {
+				_, err := usecases.LoginUser(testCtx, testEmail, testPassword, false, testSessionMetadata)
+				errs <- err
+			}()
+		}
+		var succeeded int
+		for i := 0; i < logins; i++ {
+			if err := <-errs; err == nil {
+				succeeded++
+			} else {
+				require.Equal(t, betalinkauth.SessionLimitReachedError, err)
+			}
+		}
+		require.Equal(t, 1, succeeded)
+
+		activeSessions, err := queries.CountActiveSessions(testCtx, userID)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, activeSessions)
+	})
+}